@@ -0,0 +1,311 @@
+// Package client is a typed Go client of the email API of notfy. Requests
+// that fail in a way that is safe to retry are retried with a backoff, and
+// emails are queued with an idempotency key so that a retry never sends an
+// email twice.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultBackoff    = 200 * time.Millisecond
+	maxBackoff        = 10 * time.Second
+
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// codeIdempotencyInProgress is the code of the conflicts that are retried
+	codeIdempotencyInProgress = 130
+
+	// codeVersionConflict is the code of the updates of a stale version
+	codeVersionConflict = 132
+)
+
+var (
+	// ErrNotFound is returned when the email or the batch does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is returned when the email was changed since the version
+	// it was read at
+	ErrConflict = errors.New("email was changed since it was read")
+)
+
+// Config is the configuration of Client
+type Config struct {
+	// BaseURL is where the email API is mounted, e.g. "https://notfy.example.com/emails"
+	BaseURL string
+
+	// APIKey is the API key of the tenant the requests are made on behalf of
+	APIKey string
+
+	// HTTPClient makes the requests. The default client is used if it is nil.
+	HTTPClient *http.Client
+
+	// MaxRetries is the number of times a request is retried. Zero is the
+	// default of 3 retries, and a negative number disables the retries.
+	MaxRetries int
+
+	// Backoff is the delay before the first retry, doubled for every retry
+	Backoff time.Duration
+}
+
+// Client is a client of the email API
+type Client struct {
+	base       *url.URL
+	apiKey     string
+	http       *http.Client
+	maxRetries int
+	backoff    time.Duration
+}
+
+// New creates a new instance of Client
+func New(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %v", err)
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid base url %q: scheme and host are required", cfg.BaseURL)
+	}
+	c := &Client{
+		base:       base,
+		apiKey:     cfg.APIKey,
+		http:       cfg.HTTPClient,
+		maxRetries: cfg.MaxRetries,
+		backoff:    cfg.Backoff,
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	} else if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+	if c.backoff <= 0 {
+		c.backoff = defaultBackoff
+	}
+	return c, nil
+}
+
+type idempotencyKeyCtxKey struct{}
+
+// WithIdempotencyKey returns a context that queues with the key. Queuing again
+// with the same key gets the result of the first request. Emails queued
+// without a key get a new one, which only protects the retries of the client.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtxKey{}, key)
+}
+
+func idempotencyKey(ctx context.Context) (string, error) {
+	if key, ok := ctx.Value(idempotencyKeyCtxKey{}).(string); ok && key != "" {
+		return key, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate idempotency key: %v", err)
+	}
+	return "idk_" + hex.EncodeToString(b), nil
+}
+
+// Queue queues the email
+func (c *Client) Queue(ctx context.Context, req SendRequest) (Email, error) {
+	var e Email
+	err := c.doIdempotent(ctx, http.MethodPost, "/", req, &e)
+	return e, err
+}
+
+// Get gets the email, or ErrNotFound
+func (c *Client) Get(ctx context.Context, id int) (Email, error) {
+	var e Email
+	err := c.do(ctx, http.MethodGet, "/"+strconv.Itoa(id), nil, nil, &e)
+	return e, err
+}
+
+// Update replaces the content of the email read at the version, and returns
+// the email with its next version. ErrConflict is returned if the email
+// changed since, including by a retry of this update whose response was lost,
+// so the email should be read again.
+func (c *Client) Update(ctx context.Context, id, version int, req SendRequest) (Email, error) {
+	body := struct {
+		SendRequest
+		Version int `json:"version"`
+	}{req, version}
+	var e Email
+	err := c.do(ctx, http.MethodPut, "/"+strconv.Itoa(id), nil, body, &e)
+	if apiErr, ok := err.(*Error); ok && apiErr.Code == codeVersionConflict {
+		return Email{}, ErrConflict
+	}
+	return e, err
+}
+
+// QueueBatch queues the emails as one batch. Emails that are refused do not
+// stop the others, and are reported in the results.
+func (c *Client) QueueBatch(ctx context.Context, reqs []SendRequest) (BatchResult, error) {
+	var res BatchResult
+	err := c.doIdempotent(ctx, http.MethodPost, "/batch", reqs, &res)
+	return res, err
+}
+
+// QueueTemplate queues the template for every recipient as one batch. The
+// subject and the body are Go templates executed with the data of the recipient.
+func (c *Client) QueueTemplate(ctx context.Context, template SendRequest, recipients []Recipient) (BatchResult, error) {
+	body := struct {
+		Template   SendRequest `json:"template"`
+		Recipients []Recipient `json:"recipients"`
+	}{template, recipients}
+	var res BatchResult
+	err := c.doIdempotent(ctx, http.MethodPost, "/batch", body, &res)
+	return res, err
+}
+
+// GetBatch counts the emails of the batch by their latest status, or returns ErrNotFound
+func (c *Client) GetBatch(ctx context.Context, id string) (BatchStatus, error) {
+	var s BatchStatus
+	err := c.do(ctx, http.MethodGet, "/batch/"+url.PathEscape(id), nil, nil, &s)
+	return s, err
+}
+
+func (c *Client) doIdempotent(ctx context.Context, method, path string, body, out interface{}) error {
+	key, err := idempotencyKey(ctx)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, path, http.Header{idempotencyKeyHeader: {key}}, body, out)
+}
+
+// do makes the request, retrying it while it fails in a way that is safe to
+// retry, and decodes the response into out
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body, out interface{}) error {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("cannot marshal request: %v", err)
+		}
+	}
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, method, path, header, b)
+		if err == nil {
+			err = decode(res, out)
+		}
+		if err == nil || attempt >= c.maxRetries || !retryable(err) {
+			return err
+		}
+		wait := delay
+		if apiErr, ok := err.(*Error); ok && apiErr.retryAfter > 0 {
+			wait = apiErr.retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > maxBackoff {
+			delay = maxBackoff
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.url(path), r)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &transportError{err}
+	}
+	return res, nil
+}
+
+func (c *Client) url(path string) string {
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	return u.String()
+}
+
+// decode decodes the response into out, or the error it reports
+func decode(res *http.Response, out interface{}) error {
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return &transportError{err}
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if res.StatusCode >= 300 {
+		apiErr := &Error{StatusCode: res.StatusCode}
+		if err := json.Unmarshal(b, apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = http.StatusText(res.StatusCode)
+		}
+		if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && s > 0 {
+			apiErr.retryAfter = time.Duration(s) * time.Second
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("cannot unmarshal response: %v", err)
+	}
+	return nil
+}
+
+// transportError is a failure to get a response, which is retried
+type transportError struct{ err error }
+
+func (e *transportError) Error() string { return e.err.Error() }
+
+// retryable reports whether the request may succeed if it is made again. The
+// requests are either reads or carry an idempotency key, so they are safe to
+// make again.
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *transportError:
+		return true
+	case *Error:
+		switch e.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		case http.StatusConflict:
+			return e.Code == codeIdempotencyInProgress
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/husainaloos/notfy/email"
+	"github.com/husainaloos/notfy/messaging"
+)
+
+// newServer serves the email API of a tenant that can send from example.com,
+// and gets a client of it. Requests go through wrap before the API.
+func newServer(t *testing.T, wrap func(http.Handler) http.Handler) *Client {
+	ctx := context.Background()
+	api := email.NewAPI(messaging.NilPublisher{}, email.NewMemoryStorage())
+	stream, err := email.NewStatusStream(messaging.NilPublisher{}, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create status stream: %v", err)
+	}
+	api.SetStatusStream(stream)
+	if _, err := api.AddSenderIdentity(ctx, "acme", "example.com"); err != nil {
+		t.Fatalf("failed to add sender identity: %v", err)
+	}
+	_, key, err := api.CreateAPIKey(ctx, "acme", "client")
+	if err != nil {
+		t.Fatalf("failed to create api key: %v", err)
+	}
+	r := chi.NewRouter()
+	if wrap != nil {
+		r.Use(wrap)
+	}
+	r.Route("/emails", email.NewHTTPHandler(api, api).Route)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	c, err := New(Config{BaseURL: srv.URL + "/emails", APIKey: key, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return c
+}
+
+var hello = SendRequest{
+	From:    "alerts@example.com",
+	To:      []string{"ada@example.com"},
+	Subject: "hello",
+	Body:    "body",
+}
+
+func TestClientQueueAndGet(t *testing.T) {
+	c := newServer(t, nil)
+	ctx := context.Background()
+	queued, err := c.Queue(ctx, hello)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	got, err := c.Get(ctx, queued.ID)
+	if err != nil {
+		t.Fatalf("failed to get email: %v", err)
+	}
+	if got.Subject != hello.Subject || got.Status() != "Queued" {
+		t.Fatalf("got email %+v, but expected the queued email", got)
+	}
+	if _, err := c.Get(ctx, queued.ID+1); err != ErrNotFound {
+		t.Fatalf("got error %v for a missing email, but expected %v", err, ErrNotFound)
+	}
+}
+
+func TestClientUpdate(t *testing.T) {
+	c := newServer(t, nil)
+	ctx := context.Background()
+	queued, err := c.Queue(ctx, hello)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	changed := hello
+	changed.Subject = "changed"
+	updated, err := c.Update(ctx, queued.ID, queued.Version, changed)
+	if err != nil {
+		t.Fatalf("failed to update email: %v", err)
+	}
+	if updated.Subject != "changed" || updated.Version != queued.Version+1 || updated.Status() != "Queued" {
+		t.Fatalf("got email %+v, but expected the changed email at the next version with its history", updated)
+	}
+
+	// the version read first is stale now
+	if _, err := c.Update(ctx, queued.ID, queued.Version, hello); err != ErrConflict {
+		t.Fatalf("got error %v, but expected %v", err, ErrConflict)
+	}
+	if _, err := c.Update(ctx, queued.ID+1, 1, hello); err != ErrNotFound {
+		t.Fatalf("got error %v, but expected %v", err, ErrNotFound)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	c := newServer(t, nil)
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		req        SendRequest
+		wantStatus int
+		wantCode   int
+		wantFields int
+	}{
+		{"should report every invalid field", SendRequest{From: "bad", To: []string{"bad"}}, http.StatusBadRequest, 121, 2},
+		{"should report an unverified sender", SendRequest{From: "a@other.com", To: []string{"b@example.com"}}, http.StatusForbidden, 112, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := c.Queue(ctx, test.req)
+			apiErr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("got error %v, but expected an API error", err)
+			}
+			if apiErr.StatusCode != test.wantStatus || apiErr.Code != test.wantCode || len(apiErr.Errors) != test.wantFields {
+				t.Fatalf("got error %+v, but expected status %d, code %d and %d field errors", apiErr, test.wantStatus, test.wantCode, test.wantFields)
+			}
+		})
+	}
+
+	unauthorized, _ := New(Config{BaseURL: c.base.String(), APIKey: "nope"})
+	if _, err := unauthorized.Get(ctx, 1); err == nil || err.(*Error).Code != 108 {
+		t.Fatalf("got error %v, but expected unauthorized", err)
+	}
+}
+
+func TestClientRetriesWithIdempotencyKey(t *testing.T) {
+	// the response to the first request is lost after the email is queued
+	var posts int32
+	lose := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && atomic.AddInt32(&posts, 1) == 1 {
+				next.ServeHTTP(httptest.NewRecorder(), r)
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	c := newServer(t, lose)
+	ctx := context.Background()
+	queued, err := c.Queue(ctx, hello)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	if posts != 2 {
+		t.Fatalf("got %d requests, but expected 2", posts)
+	}
+	if queued.ID != 1 {
+		t.Fatalf("got email %d, but expected the email of the first request", queued.ID)
+	}
+	if _, err := c.Get(ctx, 2); err != ErrNotFound {
+		t.Fatalf("got error %v, but expected the email to be queued once", err)
+	}
+
+	// the same key gets the same email, and cannot be used for another one
+	keyed := WithIdempotencyKey(ctx, "order-42")
+	first, err := c.Queue(keyed, hello)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	again, err := c.Queue(keyed, hello)
+	if err != nil || again.ID != first.ID {
+		t.Fatalf("got email %d and error %v, but expected email %d", again.ID, err, first.ID)
+	}
+	other := hello
+	other.Subject = "other"
+	if _, err := c.Queue(keyed, other); err == nil || err.(*Error).Code != 131 {
+		t.Fatalf("got error %v, but expected the key to be refused", err)
+	}
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	var requests int32
+	count := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			next.ServeHTTP(w, r)
+		})
+	}
+	c := newServer(t, count)
+	if _, err := c.Queue(context.Background(), SendRequest{From: "bad"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if requests != 1 {
+		t.Fatalf("got %d requests, but expected 1", requests)
+	}
+}
+
+func TestClientBatch(t *testing.T) {
+	c := newServer(t, nil)
+	ctx := context.Background()
+	bad := hello
+	bad.From = "a@other.com"
+	res, err := c.QueueBatch(ctx, []SendRequest{hello, bad})
+	if err != nil {
+		t.Fatalf("failed to queue batch: %v", err)
+	}
+	if len(res.Results) != 2 || res.Results[0].ID == 0 || res.Results[1].Error == nil || res.Results[1].Error.Code != 112 {
+		t.Fatalf("got results %+v, but expected the second email to be refused", res.Results)
+	}
+	status, err := c.GetBatch(ctx, res.BatchID)
+	if err != nil {
+		t.Fatalf("failed to get batch: %v", err)
+	}
+	if status.Total != 1 || status.Statuses["Queued"] != 1 {
+		t.Fatalf("got batch status %+v, but expected one queued email", status)
+	}
+
+	tmpl := SendRequest{From: "alerts@example.com", Subject: "Hi {{.name}}", Body: "hello"}
+	res, err = c.QueueTemplate(ctx, tmpl, []Recipient{{To: []string{"ada@example.com"}, Data: map[string]interface{}{"name": "Ada"}}})
+	if err != nil || len(res.Results) != 1 || res.Results[0].ID == 0 {
+		t.Fatalf("got results %+v and error %v, but expected one queued email", res, err)
+	}
+	e, err := c.Get(ctx, res.Results[0].ID)
+	if err != nil || e.Subject != "Hi Ada" || e.Batch != res.BatchID {
+		t.Fatalf("got email %+v and error %v, but expected the rendered template", e, err)
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	c := newServer(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queued, err := c.Queue(ctx, hello)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	updates, err := c.Events(ctx, queued.ID, "")
+	if err != nil {
+		t.Fatalf("failed to stream events: %v", err)
+	}
+	select {
+	case u := <-updates:
+		if u.EmailID != queued.ID || u.Status != "Queued" || u.ID == "" {
+			t.Fatalf("got update %+v, but expected the queued event", u)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the history to be replayed")
+	}
+	if _, err := c.Events(ctx, queued.ID+1, ""); err != ErrNotFound {
+		t.Fatalf("got error %v for a missing email, but expected %v", err, ErrNotFound)
+	}
+}
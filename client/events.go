@@ -0,0 +1,74 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Events streams the status updates of the email, or of every email of the
+// tenant if id is 0. The stream resumes after lastEventID if it is set. The
+// channel is closed when ctx is done or the server ends the stream.
+func (c *Client) Events(ctx context.Context, id int, lastEventID string) (<-chan StatusUpdate, error) {
+	path := "/events"
+	if id != 0 {
+		path = "/" + strconv.Itoa(id) + "/events"
+	}
+	header := http.Header{"Accept": {"text/event-stream"}}
+	if lastEventID != "" {
+		header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := c.send(ctx, http.MethodGet, path, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, decode(res, nil)
+	}
+	updates := make(chan StatusUpdate)
+	go func() {
+		defer close(updates)
+		defer res.Body.Close()
+		readEvents(ctx, bufio.NewScanner(res.Body), updates)
+	}()
+	return updates, nil
+}
+
+// readEvents sends the status events of the server-sent events stream
+func readEvents(ctx context.Context, sc *bufio.Scanner, updates chan<- StatusUpdate) {
+	var id, event string
+	var data []string
+	for sc.Scan() {
+		line := sc.Text()
+		if line != "" {
+			field, value := line, ""
+			if i := strings.IndexByte(line, ':'); i >= 0 {
+				field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+			}
+			switch field {
+			case "id":
+				id = value
+			case "event":
+				event = value
+			case "data":
+				data = append(data, value)
+			}
+			continue
+		}
+		if event == "status" && len(data) > 0 {
+			var u StatusUpdate
+			if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &u); err == nil {
+				u.ID = id
+				select {
+				case updates <- u:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		event, data = "", nil
+	}
+}
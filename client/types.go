@@ -0,0 +1,127 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SendRequest is an email to queue
+type SendRequest struct {
+	From    string   `json:"from"`
+	To      []string `json:"to,omitempty"`
+	CC      []string `json:"cc,omitempty"`
+	BCC     []string `json:"bcc,omitempty"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+
+	// Priority is "normal", "high" or "low". Empty is normal.
+	Priority string `json:"priority,omitempty"`
+}
+
+// Recipient is a recipient of a template, with the data the template is executed with
+type Recipient struct {
+	To   []string               `json:"to,omitempty"`
+	CC   []string               `json:"cc,omitempty"`
+	BCC  []string               `json:"bcc,omitempty"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// Email is a queued email
+type Email struct {
+	ID       int           `json:"id"`
+	Version  int           `json:"version"`
+	From     string        `json:"from"`
+	To       []string      `json:"to"`
+	CC       []string      `json:"cc"`
+	BCC      []string      `json:"bcc"`
+	Subject  string        `json:"subject"`
+	Body     string        `json:"body"`
+	Priority string        `json:"priority"`
+	Batch    string        `json:"batch,omitempty"`
+	History  []StatusEvent `json:"history"`
+}
+
+// Status gets the latest status of the email
+func (e Email) Status() string {
+	if len(e.History) == 0 {
+		return ""
+	}
+	return e.History[len(e.History)-1].Status
+}
+
+// StatusEvent is a status the email reached
+type StatusEvent struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// BatchResult is the result of queuing a batch
+type BatchResult struct {
+	BatchID string      `json:"batch_id"`
+	Results []BatchItem `json:"results"`
+}
+
+// BatchItem is the result of queuing one email of a batch, in the order of the request
+type BatchItem struct {
+	Index  int          `json:"index"`
+	ID     int          `json:"id,omitempty"`
+	Error  *Error       `json:"error,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// BatchStatus counts the emails of a batch by their latest status
+type BatchStatus struct {
+	ID       string         `json:"id"`
+	Total    int            `json:"total"`
+	Statuses map[string]int `json:"statuses"`
+}
+
+// FieldError is a problem with one field of a request. Index is the position
+// of the item in a list field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Index   *int   `json:"index,omitempty"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is an error reported by the API. Code is one of the error codes of
+// the OpenAPI document.
+type Error struct {
+	StatusCode int          `json:"-"`
+	Code       int          `json:"code"`
+	Message    string       `json:"message"`
+	Errors     []FieldError `json:"errors,omitempty"`
+
+	retryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("notfy: %s (status %d, code %d)", e.Message, e.StatusCode, e.Code)
+	if len(e.Errors) == 0 {
+		return msg
+	}
+	fields := make([]string, len(e.Errors))
+	for i, f := range e.Errors {
+		fields[i] = f.Field
+		if f.Index != nil {
+			fields[i] = fmt.Sprintf("%s[%d]", f.Field, *f.Index)
+		}
+		fields[i] += ": " + f.Message
+	}
+	return msg + ": " + strings.Join(fields, "; ")
+}
+
+// StatusUpdate is a status event of an email, as streamed. ID resumes the
+// stream after the update.
+type StatusUpdate struct {
+	ID      string    `json:"-"`
+	EmailID int       `json:"email_id"`
+	Tenant  string    `json:"tenant"`
+	Index   int       `json:"index"`
+	Status  string    `json:"status"`
+	At      time.Time `json:"at"`
+	Detail  string    `json:"detail,omitempty"`
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/husainaloos/notfy/email"
+	"github.com/husainaloos/notfy/messaging"
+)
+
+const keysUsage = `usage: notfy keys <create|list|revoke> [flags]`
+
+func keysCmd(args []string) error {
+	if len(args) < 1 {
+		return errors.New(keysUsage)
+	}
+	fs := flag.NewFlagSet("keys "+args[0], flag.ExitOnError)
+	db := fs.String("db", dbConnStr(), "postgres connection string, or sqlite:<path>")
+	tenant := fs.String("tenant", "", "tenant of the key")
+	name := fs.String("name", "", "name of the key")
+	id := fs.Int("id", 0, "id of the key")
+	fs.Parse(args[1:])
+
+	storage, err := openStorage(*db)
+	if err != nil {
+		return fmt.Errorf("cannot connect to db: %v", err)
+	}
+	api := email.NewAPI(messaging.NilPublisher{}, storage)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create":
+		k, key, err := api.CreateAPIKey(ctx, *tenant, *name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("id:     %d\ntenant: %s\nkey:    %s\n", k.ID, k.Tenant, key)
+		fmt.Fprintln(os.Stderr, "the key is only shown once, store it safely")
+	case "list":
+		keys, err := api.ListAPIKeys(ctx, *tenant)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTENANT\tNAME\tCREATED")
+		for _, k := range keys {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", k.ID, k.Tenant, k.Name, k.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		return tw.Flush()
+	case "revoke":
+		if err := api.RevokeAPIKey(ctx, *id); err != nil {
+			return err
+		}
+		fmt.Printf("key %d revoked\n", *id)
+	default:
+		return errors.New(keysUsage)
+	}
+	return nil
+}
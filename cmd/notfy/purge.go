@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/husainaloos/notfy/email"
+)
+
+const purgeUsage = `usage: notfy purge [flags]
+
+The rules file is a JSON array of rules, the most specific of which applies to
+an email. Rules without a tenant or statuses apply to every tenant or final
+status, and days that are zero or missing mean never:
+
+	[{"tenant": "acme", "statuses": ["Bounced"], "redact_after_days": 7, "delete_after_days": 30}]
+
+The -redact-days and -delete-days flags add a rule for every tenant and status.`
+
+// retentionRule is a rule of the rules file
+type retentionRule struct {
+	Tenant          string   `json:"tenant"`
+	Statuses        []string `json:"statuses"`
+	RedactAfterDays int      `json:"redact_after_days"`
+	DeleteAfterDays int      `json:"delete_after_days"`
+}
+
+func (r retentionRule) rule() (email.RetentionRule, error) {
+	if r.RedactAfterDays < 0 || r.DeleteAfterDays < 0 {
+		return email.RetentionRule{}, errors.New("days cannot be negative")
+	}
+	rule := email.RetentionRule{
+		Tenant:      r.Tenant,
+		RedactAfter: time.Duration(r.RedactAfterDays) * 24 * time.Hour,
+		DeleteAfter: time.Duration(r.DeleteAfterDays) * 24 * time.Hour,
+	}
+	for _, s := range r.Statuses {
+		status, err := email.ParseStatus(s)
+		if err != nil {
+			return email.RetentionRule{}, err
+		}
+		rule.Statuses = append(rule.Statuses, status)
+	}
+	return rule, nil
+}
+
+func purgeCmd(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), purgeUsage)
+		fs.PrintDefaults()
+	}
+	db := fs.String("db", dbConnStr(), "postgres connection string, or sqlite:<path>")
+	rules := fs.String("rules", "", "JSON file of the retention rules")
+	redactDays := fs.Int("redact-days", 0, "days after which the subject and the body of every email are redacted")
+	deleteDays := fs.Int("delete-days", 0, "days after which every email is deleted")
+	batch := fs.Int("batch", 500, "number of emails purged at once")
+	dryRun := fs.Bool("dry-run", false, "count the emails that would be purged without purging them")
+	fs.Parse(args)
+
+	var file []retentionRule
+	if *rules != "" {
+		b, err := ioutil.ReadFile(*rules)
+		if err != nil {
+			return fmt.Errorf("cannot read rules: %v", err)
+		}
+		if err := json.Unmarshal(b, &file); err != nil {
+			return fmt.Errorf("cannot parse rules: %v", err)
+		}
+	}
+	if *redactDays > 0 || *deleteDays > 0 {
+		file = append(file, retentionRule{RedactAfterDays: *redactDays, DeleteAfterDays: *deleteDays})
+	}
+	if len(file) == 0 {
+		return errors.New("no retention rule, set -rules, -redact-days or -delete-days")
+	}
+	var policy email.RetentionPolicy
+	for i, r := range file {
+		rule, err := r.rule()
+		if err != nil {
+			return fmt.Errorf("invalid rule %d: %v", i+1, err)
+		}
+		policy = append(policy, rule)
+	}
+
+	storage, err := openStorage(*db)
+	if err != nil {
+		return fmt.Errorf("cannot connect to db: %v", err)
+	}
+	p := email.NewPurger(storage, email.PurgerConfig{Policy: policy, BatchSize: *batch, DryRun: *dryRun})
+	result, err := p.Purge(context.Background())
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("would redact %d and delete %d emails\n", result.Redacted, result.Deleted)
+		return nil
+	}
+	fmt.Printf("redacted %d and deleted %d emails\n", result.Redacted, result.Deleted)
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+func reencryptCmd(args []string) error {
+	fs := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	db := fs.String("db", dbConnStr(), "postgres connection string, or sqlite:<path>")
+	batch := fs.Int("batch", 500, "number of emails encrypted at once")
+	fs.Parse(args)
+
+	storage, err := openStorage(*db)
+	if err != nil {
+		return fmt.Errorf("cannot connect to db: %v", err)
+	}
+	n, err := storage.Reencrypt(context.Background(), *batch)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("encrypted %d emails\n", n)
+	return nil
+}
@@ -0,0 +1,16 @@
+CREATE TABLE notfy.api_key
+(
+	    api_key_id bigserial NOT NULL,
+	    tenant character varying(100) NOT NULL,
+	    name character varying(100),
+	    key_hash character(64) NOT NULL,
+	    created_at timestamp with time zone NOT NULL,
+	    PRIMARY KEY (api_key_id),
+	    UNIQUE (key_hash)
+)
+WITH (
+	    OIDS = FALSE
+);
+
+ALTER TABLE notfy.api_key
+    OWNER to postgres;
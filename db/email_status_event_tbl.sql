@@ -0,0 +1,19 @@
+CREATE TABLE notfy.email_status_event
+(
+	    email_id bigint NOT NULL REFERENCES notfy.email (email_id) ON DELETE CASCADE,
+	    seq integer NOT NULL,
+	    status smallint NOT NULL,
+	    at timestamp with time zone NOT NULL,
+	    detail text NOT NULL DEFAULT '',
+	    PRIMARY KEY (email_id, seq)
+)
+WITH (
+	    OIDS = FALSE
+);
+
+ALTER TABLE notfy.email_status_event
+    OWNER to postgres;
+
+CREATE INDEX email_status_event_status_at_idx ON notfy.email_status_event (status, at);
+
+CREATE INDEX email_status_event_at_idx ON notfy.email_status_event (at);
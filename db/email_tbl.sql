@@ -2,12 +2,19 @@ CREATE TABLE notfy.email
 (
 	    email_id bigserial NOT NULL,
 	    "from" character varying(100),
-	    "to" character varying(100)[],
-	    cc character varying(100)[],
-	    bcc character varying(100)[],
-	    subject character varying(1000),
+	    "to" text[],
+	    cc text[],
+	    bcc text[],
+	    subject text,
 	    body text,
-	    status_events jsonb,
+	    priority smallint NOT NULL DEFAULT 0,
+	    tenant character varying(100) NOT NULL DEFAULT '',
+	    batch_id character varying(40) NOT NULL DEFAULT '',
+	    current_status smallint,
+	    current_status_at timestamp with time zone,
+	    version integer NOT NULL DEFAULT 1,
+	    key_id character varying(100) NOT NULL DEFAULT '',
+	    data_key bytea,
 	    PRIMARY KEY (email_id)
 )
 WITH (
@@ -16,3 +23,9 @@ WITH (
 
 ALTER TABLE notfy.email
     OWNER to postgres;
+
+CREATE INDEX email_batch_id_idx ON notfy.email (batch_id) WHERE batch_id <> '';
+
+CREATE INDEX email_tenant_id_idx ON notfy.email (tenant, email_id);
+
+CREATE INDEX email_current_status_idx ON notfy.email (current_status, current_status_at);
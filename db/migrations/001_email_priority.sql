@@ -0,0 +1,2 @@
+ALTER TABLE notfy.email
+    ADD COLUMN priority smallint NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+ALTER TABLE notfy.email
+    ADD COLUMN tenant character varying(100) NOT NULL DEFAULT '';
+
+CREATE TABLE notfy.api_key
+(
+	    api_key_id bigserial NOT NULL,
+	    tenant character varying(100) NOT NULL,
+	    name character varying(100),
+	    key_hash character(64) NOT NULL,
+	    created_at timestamp with time zone NOT NULL,
+	    PRIMARY KEY (api_key_id),
+	    UNIQUE (key_hash)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE notfy.sender_identity
+(
+	    sender_identity_id bigserial NOT NULL,
+	    tenant character varying(100) NOT NULL,
+	    identity character varying(100) NOT NULL,
+	    created_at timestamp with time zone NOT NULL,
+	    PRIMARY KEY (sender_identity_id),
+	    UNIQUE (tenant, identity)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE notfy.suppression
+(
+	    address character varying(100) NOT NULL,
+	    reason smallint NOT NULL,
+	    detail text,
+	    created_at timestamp with time zone NOT NULL,
+	    expires_at timestamp with time zone,
+	    PRIMARY KEY (address, reason)
+);
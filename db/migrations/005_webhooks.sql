@@ -0,0 +1,25 @@
+CREATE TABLE notfy.webhook
+(
+	    webhook_id bigserial NOT NULL,
+	    tenant character varying(100) NOT NULL,
+	    url text NOT NULL,
+	    secret character varying(100) NOT NULL,
+	    created_at timestamp with time zone NOT NULL,
+	    PRIMARY KEY (webhook_id)
+);
+
+CREATE TABLE notfy.webhook_delivery
+(
+	    webhook_delivery_id bigserial NOT NULL,
+	    webhook_id bigint NOT NULL REFERENCES notfy.webhook (webhook_id) ON DELETE CASCADE,
+	    email_id bigint NOT NULL,
+	    event character varying(50) NOT NULL,
+	    attempt integer NOT NULL,
+	    status_code integer NOT NULL,
+	    error text,
+	    test boolean NOT NULL,
+	    at timestamp with time zone NOT NULL,
+	    PRIMARY KEY (webhook_delivery_id)
+);
+
+CREATE INDEX webhook_delivery_webhook_id_idx ON notfy.webhook_delivery (webhook_id);
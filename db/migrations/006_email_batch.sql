@@ -0,0 +1,4 @@
+ALTER TABLE notfy.email
+    ADD COLUMN batch_id character varying(40) NOT NULL DEFAULT '';
+
+CREATE INDEX email_batch_id_idx ON notfy.email (batch_id) WHERE batch_id <> '';
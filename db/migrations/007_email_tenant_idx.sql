@@ -0,0 +1,2 @@
+-- emails are listed by tenant in the order of their ids
+CREATE INDEX email_tenant_id_idx ON notfy.email (tenant, email_id);
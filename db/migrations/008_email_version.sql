@@ -0,0 +1,2 @@
+ALTER TABLE notfy.email
+    ADD COLUMN version integer NOT NULL DEFAULT 1;
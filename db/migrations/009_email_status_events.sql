@@ -0,0 +1,29 @@
+CREATE TABLE notfy.email_status_event
+(
+	    email_id bigint NOT NULL REFERENCES notfy.email (email_id) ON DELETE CASCADE,
+	    seq integer NOT NULL,
+	    status smallint NOT NULL,
+	    at timestamp with time zone NOT NULL,
+	    detail text NOT NULL DEFAULT '',
+	    PRIMARY KEY (email_id, seq)
+);
+
+-- the position of an event in the jsonb array becomes its seq
+INSERT INTO notfy.email_status_event (email_id, seq, status, at, detail)
+SELECT e.email_id, ev.ordinality - 1, (ev.value->>'status')::smallint, (ev.value->>'at')::timestamptz, COALESCE(ev.value->>'detail', '')
+FROM notfy.email e, jsonb_array_elements(COALESCE(e.status_events, '[]'::jsonb)) WITH ORDINALITY AS ev (value, ordinality);
+
+ALTER TABLE notfy.email
+    ADD COLUMN current_status smallint;
+
+UPDATE notfy.email SET current_status = (status_events->-1->>'status')::smallint
+WHERE jsonb_array_length(COALESCE(status_events, '[]'::jsonb)) > 0;
+
+ALTER TABLE notfy.email
+    DROP COLUMN status_events;
+
+CREATE INDEX email_status_event_status_at_idx ON notfy.email_status_event (status, at);
+
+CREATE INDEX email_status_event_at_idx ON notfy.email_status_event (at);
+
+CREATE INDEX email_current_status_idx ON notfy.email (current_status);
@@ -0,0 +1,11 @@
+ALTER TABLE notfy.email
+    ADD COLUMN current_status_at timestamp with time zone;
+
+UPDATE notfy.email e SET current_status_at = (
+    SELECT ev.at FROM notfy.email_status_event ev WHERE ev.email_id = e.email_id ORDER BY ev.seq DESC LIMIT 1
+)
+WHERE current_status IS NOT NULL;
+
+DROP INDEX notfy.email_current_status_idx;
+
+CREATE INDEX email_current_status_idx ON notfy.email (current_status, current_status_at);
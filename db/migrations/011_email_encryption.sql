@@ -0,0 +1,8 @@
+-- the recipients, the subject and the body are stored encrypted, in base64
+ALTER TABLE notfy.email
+    ALTER COLUMN "to" TYPE text[],
+    ALTER COLUMN cc TYPE text[],
+    ALTER COLUMN bcc TYPE text[],
+    ALTER COLUMN subject TYPE text,
+    ADD COLUMN key_id character varying(100) NOT NULL DEFAULT '',
+    ADD COLUMN data_key bytea;
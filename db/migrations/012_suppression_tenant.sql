@@ -0,0 +1,6 @@
+ALTER TABLE notfy.suppression
+    ADD COLUMN tenant character varying(100) NOT NULL DEFAULT '';
+
+ALTER TABLE notfy.suppression
+    DROP CONSTRAINT suppression_pkey,
+    ADD PRIMARY KEY (tenant, address, reason);
@@ -0,0 +1,6 @@
+-- the failed deliveries keep their payload until they are retried
+ALTER TABLE notfy.webhook_delivery
+    ADD COLUMN payload bytea,
+    ADD COLUMN next_attempt_at timestamp with time zone;
+
+CREATE INDEX webhook_delivery_next_attempt_at_idx ON notfy.webhook_delivery (next_attempt_at);
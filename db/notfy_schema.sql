@@ -1,4 +1,4 @@
-CHEMA: notfy
+-- SCHEMA: notfy
 
 -- DROP SCHEMA notfy ;
 
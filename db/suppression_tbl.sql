@@ -0,0 +1,16 @@
+CREATE TABLE notfy.suppression
+(
+	    tenant character varying(100) NOT NULL DEFAULT '',
+	    address character varying(100) NOT NULL,
+	    reason smallint NOT NULL,
+	    detail text,
+	    created_at timestamp with time zone NOT NULL,
+	    expires_at timestamp with time zone,
+	    PRIMARY KEY (tenant, address, reason)
+)
+WITH (
+	    OIDS = FALSE
+);
+
+ALTER TABLE notfy.suppression
+    OWNER to postgres;
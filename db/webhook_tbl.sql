@@ -0,0 +1,40 @@
+CREATE TABLE notfy.webhook
+(
+	    webhook_id bigserial NOT NULL,
+	    tenant character varying(100) NOT NULL,
+	    url text NOT NULL,
+	    secret character varying(100) NOT NULL,
+	    created_at timestamp with time zone NOT NULL,
+	    PRIMARY KEY (webhook_id)
+)
+WITH (
+	    OIDS = FALSE
+);
+
+ALTER TABLE notfy.webhook
+    OWNER to postgres;
+
+CREATE TABLE notfy.webhook_delivery
+(
+	    webhook_delivery_id bigserial NOT NULL,
+	    webhook_id bigint NOT NULL REFERENCES notfy.webhook (webhook_id) ON DELETE CASCADE,
+	    email_id bigint NOT NULL,
+	    event character varying(50) NOT NULL,
+	    attempt integer NOT NULL,
+	    status_code integer NOT NULL,
+	    error text,
+	    test boolean NOT NULL,
+	    at timestamp with time zone NOT NULL,
+	    payload bytea,
+	    next_attempt_at timestamp with time zone,
+	    PRIMARY KEY (webhook_delivery_id)
+)
+WITH (
+	    OIDS = FALSE
+);
+
+CREATE INDEX webhook_delivery_webhook_id_idx ON notfy.webhook_delivery (webhook_id);
+CREATE INDEX webhook_delivery_next_attempt_at_idx ON notfy.webhook_delivery (next_attempt_at);
+
+ALTER TABLE notfy.webhook_delivery
+    OWNER to postgres;
@@ -1,3 +1,3 @@
 package dto
 
-//go:generate protoc --go_out=. queuedEmail.proto
+//go:generate protoc --go_out=plugins=grpc:. envelope.proto queuedEmail.proto emailService.proto
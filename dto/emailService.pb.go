@@ -0,0 +1,807 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: emailService.proto
+
+package dto
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type QueueEmailRequest struct {
+	From                 string   `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	To                   []string `protobuf:"bytes,2,rep,name=to,proto3" json:"to,omitempty"`
+	Cc                   []string `protobuf:"bytes,3,rep,name=cc,proto3" json:"cc,omitempty"`
+	Bcc                  []string `protobuf:"bytes,4,rep,name=bcc,proto3" json:"bcc,omitempty"`
+	Subject              string   `protobuf:"bytes,5,opt,name=subject,proto3" json:"subject,omitempty"`
+	Body                 string   `protobuf:"bytes,6,opt,name=body,proto3" json:"body,omitempty"`
+	Priority             string   `protobuf:"bytes,7,opt,name=priority,proto3" json:"priority,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *QueueEmailRequest) Reset()         { *m = QueueEmailRequest{} }
+func (m *QueueEmailRequest) String() string { return proto.CompactTextString(m) }
+func (*QueueEmailRequest) ProtoMessage()    {}
+func (*QueueEmailRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b86cb7f87abec0b4, []int{0}
+}
+
+func (m *QueueEmailRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QueueEmailRequest.Unmarshal(m, b)
+}
+func (m *QueueEmailRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QueueEmailRequest.Marshal(b, m, deterministic)
+}
+func (m *QueueEmailRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QueueEmailRequest.Merge(m, src)
+}
+func (m *QueueEmailRequest) XXX_Size() int {
+	return xxx_messageInfo_QueueEmailRequest.Size(m)
+}
+func (m *QueueEmailRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_QueueEmailRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QueueEmailRequest proto.InternalMessageInfo
+
+func (m *QueueEmailRequest) GetFrom() string {
+	if m != nil {
+		return m.From
+	}
+	return ""
+}
+
+func (m *QueueEmailRequest) GetTo() []string {
+	if m != nil {
+		return m.To
+	}
+	return nil
+}
+
+func (m *QueueEmailRequest) GetCc() []string {
+	if m != nil {
+		return m.Cc
+	}
+	return nil
+}
+
+func (m *QueueEmailRequest) GetBcc() []string {
+	if m != nil {
+		return m.Bcc
+	}
+	return nil
+}
+
+func (m *QueueEmailRequest) GetSubject() string {
+	if m != nil {
+		return m.Subject
+	}
+	return ""
+}
+
+func (m *QueueEmailRequest) GetBody() string {
+	if m != nil {
+		return m.Body
+	}
+	return ""
+}
+
+func (m *QueueEmailRequest) GetPriority() string {
+	if m != nil {
+		return m.Priority
+	}
+	return ""
+}
+
+type EmailStatus struct {
+	Status               string               `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	At                   *timestamp.Timestamp `protobuf:"bytes,2,opt,name=at,proto3" json:"at,omitempty"`
+	Detail               string               `protobuf:"bytes,3,opt,name=detail,proto3" json:"detail,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *EmailStatus) Reset()         { *m = EmailStatus{} }
+func (m *EmailStatus) String() string { return proto.CompactTextString(m) }
+func (*EmailStatus) ProtoMessage()    {}
+func (*EmailStatus) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b86cb7f87abec0b4, []int{1}
+}
+
+func (m *EmailStatus) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EmailStatus.Unmarshal(m, b)
+}
+func (m *EmailStatus) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EmailStatus.Marshal(b, m, deterministic)
+}
+func (m *EmailStatus) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EmailStatus.Merge(m, src)
+}
+func (m *EmailStatus) XXX_Size() int {
+	return xxx_messageInfo_EmailStatus.Size(m)
+}
+func (m *EmailStatus) XXX_DiscardUnknown() {
+	xxx_messageInfo_EmailStatus.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EmailStatus proto.InternalMessageInfo
+
+func (m *EmailStatus) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *EmailStatus) GetAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.At
+	}
+	return nil
+}
+
+func (m *EmailStatus) GetDetail() string {
+	if m != nil {
+		return m.Detail
+	}
+	return ""
+}
+
+type Email struct {
+	Id                   int64          `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	From                 string         `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`
+	To                   []string       `protobuf:"bytes,3,rep,name=to,proto3" json:"to,omitempty"`
+	Cc                   []string       `protobuf:"bytes,4,rep,name=cc,proto3" json:"cc,omitempty"`
+	Bcc                  []string       `protobuf:"bytes,5,rep,name=bcc,proto3" json:"bcc,omitempty"`
+	Subject              string         `protobuf:"bytes,6,opt,name=subject,proto3" json:"subject,omitempty"`
+	Body                 string         `protobuf:"bytes,7,opt,name=body,proto3" json:"body,omitempty"`
+	Priority             string         `protobuf:"bytes,8,opt,name=priority,proto3" json:"priority,omitempty"`
+	Batch                string         `protobuf:"bytes,9,opt,name=batch,proto3" json:"batch,omitempty"`
+	History              []*EmailStatus `protobuf:"bytes,10,rep,name=history,proto3" json:"history,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *Email) Reset()         { *m = Email{} }
+func (m *Email) String() string { return proto.CompactTextString(m) }
+func (*Email) ProtoMessage()    {}
+func (*Email) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b86cb7f87abec0b4, []int{2}
+}
+
+func (m *Email) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Email.Unmarshal(m, b)
+}
+func (m *Email) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Email.Marshal(b, m, deterministic)
+}
+func (m *Email) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Email.Merge(m, src)
+}
+func (m *Email) XXX_Size() int {
+	return xxx_messageInfo_Email.Size(m)
+}
+func (m *Email) XXX_DiscardUnknown() {
+	xxx_messageInfo_Email.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Email proto.InternalMessageInfo
+
+func (m *Email) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Email) GetFrom() string {
+	if m != nil {
+		return m.From
+	}
+	return ""
+}
+
+func (m *Email) GetTo() []string {
+	if m != nil {
+		return m.To
+	}
+	return nil
+}
+
+func (m *Email) GetCc() []string {
+	if m != nil {
+		return m.Cc
+	}
+	return nil
+}
+
+func (m *Email) GetBcc() []string {
+	if m != nil {
+		return m.Bcc
+	}
+	return nil
+}
+
+func (m *Email) GetSubject() string {
+	if m != nil {
+		return m.Subject
+	}
+	return ""
+}
+
+func (m *Email) GetBody() string {
+	if m != nil {
+		return m.Body
+	}
+	return ""
+}
+
+func (m *Email) GetPriority() string {
+	if m != nil {
+		return m.Priority
+	}
+	return ""
+}
+
+func (m *Email) GetBatch() string {
+	if m != nil {
+		return m.Batch
+	}
+	return ""
+}
+
+func (m *Email) GetHistory() []*EmailStatus {
+	if m != nil {
+		return m.History
+	}
+	return nil
+}
+
+type GetEmailRequest struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetEmailRequest) Reset()         { *m = GetEmailRequest{} }
+func (m *GetEmailRequest) String() string { return proto.CompactTextString(m) }
+func (*GetEmailRequest) ProtoMessage()    {}
+func (*GetEmailRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b86cb7f87abec0b4, []int{3}
+}
+
+func (m *GetEmailRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetEmailRequest.Unmarshal(m, b)
+}
+func (m *GetEmailRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetEmailRequest.Marshal(b, m, deterministic)
+}
+func (m *GetEmailRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetEmailRequest.Merge(m, src)
+}
+func (m *GetEmailRequest) XXX_Size() int {
+	return xxx_messageInfo_GetEmailRequest.Size(m)
+}
+func (m *GetEmailRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetEmailRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetEmailRequest proto.InternalMessageInfo
+
+func (m *GetEmailRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type ListEmailsRequest struct {
+	PageSize             int32    `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken            string   `protobuf:"bytes,2,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListEmailsRequest) Reset()         { *m = ListEmailsRequest{} }
+func (m *ListEmailsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListEmailsRequest) ProtoMessage()    {}
+func (*ListEmailsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b86cb7f87abec0b4, []int{4}
+}
+
+func (m *ListEmailsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListEmailsRequest.Unmarshal(m, b)
+}
+func (m *ListEmailsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListEmailsRequest.Marshal(b, m, deterministic)
+}
+func (m *ListEmailsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListEmailsRequest.Merge(m, src)
+}
+func (m *ListEmailsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListEmailsRequest.Size(m)
+}
+func (m *ListEmailsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListEmailsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListEmailsRequest proto.InternalMessageInfo
+
+func (m *ListEmailsRequest) GetPageSize() int32 {
+	if m != nil {
+		return m.PageSize
+	}
+	return 0
+}
+
+func (m *ListEmailsRequest) GetPageToken() string {
+	if m != nil {
+		return m.PageToken
+	}
+	return ""
+}
+
+type ListEmailsResponse struct {
+	Emails               []*Email `protobuf:"bytes,1,rep,name=emails,proto3" json:"emails,omitempty"`
+	NextPageToken        string   `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListEmailsResponse) Reset()         { *m = ListEmailsResponse{} }
+func (m *ListEmailsResponse) String() string { return proto.CompactTextString(m) }
+func (*ListEmailsResponse) ProtoMessage()    {}
+func (*ListEmailsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b86cb7f87abec0b4, []int{5}
+}
+
+func (m *ListEmailsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListEmailsResponse.Unmarshal(m, b)
+}
+func (m *ListEmailsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListEmailsResponse.Marshal(b, m, deterministic)
+}
+func (m *ListEmailsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListEmailsResponse.Merge(m, src)
+}
+func (m *ListEmailsResponse) XXX_Size() int {
+	return xxx_messageInfo_ListEmailsResponse.Size(m)
+}
+func (m *ListEmailsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListEmailsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListEmailsResponse proto.InternalMessageInfo
+
+func (m *ListEmailsResponse) GetEmails() []*Email {
+	if m != nil {
+		return m.Emails
+	}
+	return nil
+}
+
+func (m *ListEmailsResponse) GetNextPageToken() string {
+	if m != nil {
+		return m.NextPageToken
+	}
+	return ""
+}
+
+type WatchEmailRequest struct {
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	LastEventId          string   `protobuf:"bytes,2,opt,name=last_event_id,json=lastEventId,proto3" json:"last_event_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchEmailRequest) Reset()         { *m = WatchEmailRequest{} }
+func (m *WatchEmailRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchEmailRequest) ProtoMessage()    {}
+func (*WatchEmailRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b86cb7f87abec0b4, []int{6}
+}
+
+func (m *WatchEmailRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchEmailRequest.Unmarshal(m, b)
+}
+func (m *WatchEmailRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchEmailRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchEmailRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchEmailRequest.Merge(m, src)
+}
+func (m *WatchEmailRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchEmailRequest.Size(m)
+}
+func (m *WatchEmailRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchEmailRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchEmailRequest proto.InternalMessageInfo
+
+func (m *WatchEmailRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *WatchEmailRequest) GetLastEventId() string {
+	if m != nil {
+		return m.LastEventId
+	}
+	return ""
+}
+
+type StatusUpdate struct {
+	Id                   string               `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	EmailId              int64                `protobuf:"varint,2,opt,name=email_id,json=emailId,proto3" json:"email_id,omitempty"`
+	Index                int32                `protobuf:"varint,3,opt,name=index,proto3" json:"index,omitempty"`
+	Status               string               `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	At                   *timestamp.Timestamp `protobuf:"bytes,5,opt,name=at,proto3" json:"at,omitempty"`
+	Detail               string               `protobuf:"bytes,6,opt,name=detail,proto3" json:"detail,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *StatusUpdate) Reset()         { *m = StatusUpdate{} }
+func (m *StatusUpdate) String() string { return proto.CompactTextString(m) }
+func (*StatusUpdate) ProtoMessage()    {}
+func (*StatusUpdate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b86cb7f87abec0b4, []int{7}
+}
+
+func (m *StatusUpdate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StatusUpdate.Unmarshal(m, b)
+}
+func (m *StatusUpdate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StatusUpdate.Marshal(b, m, deterministic)
+}
+func (m *StatusUpdate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StatusUpdate.Merge(m, src)
+}
+func (m *StatusUpdate) XXX_Size() int {
+	return xxx_messageInfo_StatusUpdate.Size(m)
+}
+func (m *StatusUpdate) XXX_DiscardUnknown() {
+	xxx_messageInfo_StatusUpdate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StatusUpdate proto.InternalMessageInfo
+
+func (m *StatusUpdate) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *StatusUpdate) GetEmailId() int64 {
+	if m != nil {
+		return m.EmailId
+	}
+	return 0
+}
+
+func (m *StatusUpdate) GetIndex() int32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *StatusUpdate) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *StatusUpdate) GetAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.At
+	}
+	return nil
+}
+
+func (m *StatusUpdate) GetDetail() string {
+	if m != nil {
+		return m.Detail
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*QueueEmailRequest)(nil), "dto.QueueEmailRequest")
+	proto.RegisterType((*EmailStatus)(nil), "dto.EmailStatus")
+	proto.RegisterType((*Email)(nil), "dto.Email")
+	proto.RegisterType((*GetEmailRequest)(nil), "dto.GetEmailRequest")
+	proto.RegisterType((*ListEmailsRequest)(nil), "dto.ListEmailsRequest")
+	proto.RegisterType((*ListEmailsResponse)(nil), "dto.ListEmailsResponse")
+	proto.RegisterType((*WatchEmailRequest)(nil), "dto.WatchEmailRequest")
+	proto.RegisterType((*StatusUpdate)(nil), "dto.StatusUpdate")
+}
+
+func init() { proto.RegisterFile("emailService.proto", fileDescriptor_b86cb7f87abec0b4) }
+
+var fileDescriptor_b86cb7f87abec0b4 = []byte{
+	// 576 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x54, 0x4d, 0x6f, 0xd3, 0x40,
+	0x10, 0x95, 0xed, 0xd8, 0x49, 0x26, 0x2d, 0x6d, 0x56, 0x55, 0x59, 0x8c, 0x10, 0xc1, 0x07, 0x14,
+	0x55, 0xc8, 0xad, 0xc2, 0x89, 0x03, 0xc7, 0xa8, 0xaa, 0x84, 0x04, 0x38, 0x45, 0x1c, 0x83, 0x3f,
+	0xb6, 0xe9, 0x42, 0x92, 0x35, 0xde, 0x71, 0xd5, 0xf4, 0xef, 0x70, 0xe4, 0x8f, 0xc1, 0xbf, 0x40,
+	0xbb, 0xb6, 0x13, 0x27, 0x0e, 0x48, 0xdc, 0xf6, 0xbd, 0x9d, 0xbc, 0x9d, 0x79, 0xf3, 0x1c, 0x20,
+	0x6c, 0x11, 0xf2, 0xf9, 0x84, 0x65, 0x77, 0x3c, 0x66, 0x7e, 0x9a, 0x09, 0x14, 0xc4, 0x4a, 0x50,
+	0xb8, 0xcf, 0x67, 0x42, 0xcc, 0xe6, 0xec, 0x5c, 0x53, 0x51, 0x7e, 0x73, 0x8e, 0x7c, 0xc1, 0x24,
+	0x86, 0x8b, 0xb4, 0xa8, 0xf2, 0x7e, 0x18, 0xd0, 0xff, 0x98, 0xb3, 0x9c, 0x8d, 0x95, 0x42, 0xc0,
+	0xbe, 0xe7, 0x4c, 0x22, 0x21, 0xd0, 0xba, 0xc9, 0xc4, 0x82, 0x1a, 0x03, 0x63, 0xd8, 0x0d, 0xf4,
+	0x99, 0x3c, 0x02, 0x13, 0x05, 0x35, 0x07, 0xd6, 0xb0, 0x1b, 0x98, 0x28, 0x14, 0x8e, 0x63, 0x6a,
+	0x15, 0x38, 0x8e, 0xc9, 0x31, 0x58, 0x51, 0x1c, 0xd3, 0x96, 0x26, 0xd4, 0x91, 0x50, 0x68, 0xcb,
+	0x3c, 0xfa, 0xca, 0x62, 0xa4, 0xb6, 0x16, 0xaa, 0xa0, 0xd2, 0x8f, 0x44, 0xb2, 0xa2, 0x4e, 0xa1,
+	0xaf, 0xce, 0xc4, 0x85, 0x4e, 0x9a, 0x71, 0x91, 0x71, 0x5c, 0xd1, 0xb6, 0xe6, 0xd7, 0xd8, 0xe3,
+	0xd0, 0xd3, 0xfd, 0x4d, 0x30, 0xc4, 0x5c, 0x92, 0x53, 0x70, 0xa4, 0x3e, 0x95, 0x0d, 0x96, 0x88,
+	0x9c, 0x81, 0x19, 0x22, 0x35, 0x07, 0xc6, 0xb0, 0x37, 0x72, 0xfd, 0x62, 0x74, 0xbf, 0x1a, 0xdd,
+	0xbf, 0xae, 0x46, 0x0f, 0xcc, 0x10, 0x95, 0x46, 0xc2, 0x30, 0xe4, 0x73, 0x6a, 0x15, 0x1a, 0x05,
+	0xf2, 0x7e, 0x1b, 0x60, 0xeb, 0xb7, 0xd4, 0x80, 0x3c, 0xd1, 0x2f, 0x58, 0x81, 0xc9, 0x93, 0xb5,
+	0x29, 0x66, 0xc3, 0x14, 0x6b, 0xc7, 0x94, 0xd6, 0xae, 0x29, 0xf6, 0x5e, 0x53, 0x9c, 0xfd, 0xa6,
+	0xb4, 0xff, 0x62, 0x4a, 0x67, 0xdb, 0x14, 0x72, 0x02, 0x76, 0x14, 0x62, 0x7c, 0x4b, 0xbb, 0xfa,
+	0xa2, 0x00, 0xe4, 0x0c, 0xda, 0xb7, 0x5c, 0xa2, 0xc8, 0x56, 0x14, 0x06, 0xd6, 0xb0, 0x37, 0x3a,
+	0xf6, 0x13, 0x14, 0x7e, 0xcd, 0xbe, 0xa0, 0x2a, 0xf0, 0x5e, 0xc0, 0xd1, 0x25, 0xc3, 0xad, 0xcd,
+	0xef, 0x0c, 0xed, 0xbd, 0x87, 0xfe, 0x3b, 0x2e, 0x8b, 0x1a, 0x59, 0x15, 0x3d, 0x85, 0x6e, 0x1a,
+	0xce, 0xd8, 0x54, 0xf2, 0x07, 0xa6, 0x6b, 0xed, 0xa0, 0xa3, 0x88, 0x09, 0x7f, 0x60, 0xe4, 0x19,
+	0x80, 0xbe, 0x44, 0xf1, 0x8d, 0x2d, 0x4b, 0xb3, 0x74, 0xf9, 0xb5, 0x22, 0xbc, 0x2f, 0x40, 0xea,
+	0x82, 0x32, 0x15, 0x4b, 0xc9, 0x88, 0x07, 0x8e, 0x8e, 0xb0, 0xda, 0xa8, 0x6a, 0x1a, 0x36, 0x4d,
+	0x07, 0xe5, 0x0d, 0x79, 0x09, 0x47, 0x4b, 0x76, 0x8f, 0xd3, 0x86, 0xfa, 0xa1, 0xa2, 0x3f, 0xac,
+	0x5f, 0xb8, 0x84, 0xfe, 0x67, 0x65, 0xc5, 0xbf, 0xe6, 0x22, 0x1e, 0x1c, 0xce, 0x43, 0x89, 0x53,
+	0x76, 0xc7, 0x96, 0x38, 0xe5, 0x49, 0x29, 0xd5, 0x53, 0xe4, 0x58, 0x71, 0x57, 0x89, 0xf7, 0xd3,
+	0x80, 0x83, 0xc2, 0xb2, 0x4f, 0x69, 0x12, 0x22, 0xab, 0x89, 0x74, 0xb5, 0xc8, 0x13, 0xe8, 0xe8,
+	0xde, 0xaa, 0xdf, 0x5b, 0x41, 0x5b, 0xe3, 0xab, 0x44, 0x2d, 0x87, 0x2f, 0x13, 0x76, 0xaf, 0xd3,
+	0x65, 0x07, 0x05, 0xa8, 0x05, 0xb7, 0xb5, 0x27, 0xb8, 0xf6, 0x7f, 0x06, 0xd7, 0xa9, 0x07, 0x77,
+	0xf4, 0xcb, 0x80, 0x83, 0x71, 0xed, 0x6f, 0x80, 0x5c, 0x00, 0x6c, 0xbe, 0x6c, 0x72, 0xaa, 0x1d,
+	0x6d, 0x7c, 0xea, 0x6e, 0xcd, 0x69, 0xf2, 0x0a, 0x3a, 0x55, 0x1e, 0xc8, 0x89, 0xe6, 0x77, 0xe2,
+	0xb1, 0x55, 0xfd, 0x16, 0x60, 0xb3, 0xc9, 0x52, 0xbf, 0x91, 0x15, 0xf7, 0x71, 0x83, 0x2f, 0x57,
+	0xfe, 0x06, 0x60, 0xb3, 0xa6, 0xf2, 0xe7, 0x8d, 0xbd, 0xb9, 0x7d, 0xcd, 0xd7, 0xb7, 0x70, 0x61,
+	0x44, 0x8e, 0xb6, 0xe6, 0xf5, 0x9f, 0x01, 0x00, 0xa0, 0xd5, 0xef, 0x2d, 0xf7, 0x04, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// EmailServiceClient is the client API for EmailService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type EmailServiceClient interface {
+	QueueEmail(ctx context.Context, in *QueueEmailRequest, opts ...grpc.CallOption) (*Email, error)
+	GetEmail(ctx context.Context, in *GetEmailRequest, opts ...grpc.CallOption) (*Email, error)
+	ListEmails(ctx context.Context, in *ListEmailsRequest, opts ...grpc.CallOption) (*ListEmailsResponse, error)
+	WatchEmail(ctx context.Context, in *WatchEmailRequest, opts ...grpc.CallOption) (EmailService_WatchEmailClient, error)
+}
+
+type emailServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewEmailServiceClient(cc *grpc.ClientConn) EmailServiceClient {
+	return &emailServiceClient{cc}
+}
+
+func (c *emailServiceClient) QueueEmail(ctx context.Context, in *QueueEmailRequest, opts ...grpc.CallOption) (*Email, error) {
+	out := new(Email)
+	err := c.cc.Invoke(ctx, "/dto.EmailService/QueueEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *emailServiceClient) GetEmail(ctx context.Context, in *GetEmailRequest, opts ...grpc.CallOption) (*Email, error) {
+	out := new(Email)
+	err := c.cc.Invoke(ctx, "/dto.EmailService/GetEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *emailServiceClient) ListEmails(ctx context.Context, in *ListEmailsRequest, opts ...grpc.CallOption) (*ListEmailsResponse, error) {
+	out := new(ListEmailsResponse)
+	err := c.cc.Invoke(ctx, "/dto.EmailService/ListEmails", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *emailServiceClient) WatchEmail(ctx context.Context, in *WatchEmailRequest, opts ...grpc.CallOption) (EmailService_WatchEmailClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_EmailService_serviceDesc.Streams[0], "/dto.EmailService/WatchEmail", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &emailServiceWatchEmailClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EmailService_WatchEmailClient interface {
+	Recv() (*StatusUpdate, error)
+	grpc.ClientStream
+}
+
+type emailServiceWatchEmailClient struct {
+	grpc.ClientStream
+}
+
+func (x *emailServiceWatchEmailClient) Recv() (*StatusUpdate, error) {
+	m := new(StatusUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// EmailServiceServer is the server API for EmailService service.
+type EmailServiceServer interface {
+	QueueEmail(context.Context, *QueueEmailRequest) (*Email, error)
+	GetEmail(context.Context, *GetEmailRequest) (*Email, error)
+	ListEmails(context.Context, *ListEmailsRequest) (*ListEmailsResponse, error)
+	WatchEmail(*WatchEmailRequest, EmailService_WatchEmailServer) error
+}
+
+// UnimplementedEmailServiceServer can be embedded to have forward compatible implementations.
+type UnimplementedEmailServiceServer struct {
+}
+
+func (*UnimplementedEmailServiceServer) QueueEmail(ctx context.Context, req *QueueEmailRequest) (*Email, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueueEmail not implemented")
+}
+func (*UnimplementedEmailServiceServer) GetEmail(ctx context.Context, req *GetEmailRequest) (*Email, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEmail not implemented")
+}
+func (*UnimplementedEmailServiceServer) ListEmails(ctx context.Context, req *ListEmailsRequest) (*ListEmailsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEmails not implemented")
+}
+func (*UnimplementedEmailServiceServer) WatchEmail(req *WatchEmailRequest, srv EmailService_WatchEmailServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEmail not implemented")
+}
+
+func RegisterEmailServiceServer(s *grpc.Server, srv EmailServiceServer) {
+	s.RegisterService(&_EmailService_serviceDesc, srv)
+}
+
+func _EmailService_QueueEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueueEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EmailServiceServer).QueueEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dto.EmailService/QueueEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EmailServiceServer).QueueEmail(ctx, req.(*QueueEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EmailService_GetEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EmailServiceServer).GetEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dto.EmailService/GetEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EmailServiceServer).GetEmail(ctx, req.(*GetEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EmailService_ListEmails_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListEmailsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EmailServiceServer).ListEmails(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dto.EmailService/ListEmails",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EmailServiceServer).ListEmails(ctx, req.(*ListEmailsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EmailService_WatchEmail_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEmailRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EmailServiceServer).WatchEmail(m, &emailServiceWatchEmailServer{stream})
+}
+
+type EmailService_WatchEmailServer interface {
+	Send(*StatusUpdate) error
+	grpc.ServerStream
+}
+
+type emailServiceWatchEmailServer struct {
+	grpc.ServerStream
+}
+
+func (x *emailServiceWatchEmailServer) Send(m *StatusUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _EmailService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "dto.EmailService",
+	HandlerType: (*EmailServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "QueueEmail",
+			Handler:    _EmailService_QueueEmail_Handler,
+		},
+		{
+			MethodName: "GetEmail",
+			Handler:    _EmailService_GetEmail_Handler,
+		},
+		{
+			MethodName: "ListEmails",
+			Handler:    _EmailService_ListEmails_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEmail",
+			Handler:       _EmailService_WatchEmail_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "emailService.proto",
+}
@@ -0,0 +1,153 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: envelope.proto
+
+package dto
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type Envelope struct {
+	SchemaVersion        uint32            `protobuf:"varint,16,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	MessageId            string            `protobuf:"bytes,17,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	MessageType          string            `protobuf:"bytes,18,opt,name=message_type,json=messageType,proto3" json:"message_type,omitempty"`
+	ContentType          string            `protobuf:"bytes,19,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Tenant               string            `protobuf:"bytes,20,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	Headers              map[string]string `protobuf:"bytes,21,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	CreatedAt            uint64            `protobuf:"varint,22,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Attempt              uint32            `protobuf:"varint,23,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	Payload              []byte            `protobuf:"bytes,24,opt,name=payload,proto3" json:"payload,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *Envelope) Reset()         { *m = Envelope{} }
+func (m *Envelope) String() string { return proto.CompactTextString(m) }
+func (*Envelope) ProtoMessage()    {}
+func (*Envelope) Descriptor() ([]byte, []int) {
+	return fileDescriptor_ee266e8c558e9dc5, []int{0}
+}
+
+func (m *Envelope) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Envelope.Unmarshal(m, b)
+}
+func (m *Envelope) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Envelope.Marshal(b, m, deterministic)
+}
+func (m *Envelope) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Envelope.Merge(m, src)
+}
+func (m *Envelope) XXX_Size() int {
+	return xxx_messageInfo_Envelope.Size(m)
+}
+func (m *Envelope) XXX_DiscardUnknown() {
+	xxx_messageInfo_Envelope.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Envelope proto.InternalMessageInfo
+
+func (m *Envelope) GetSchemaVersion() uint32 {
+	if m != nil {
+		return m.SchemaVersion
+	}
+	return 0
+}
+
+func (m *Envelope) GetMessageId() string {
+	if m != nil {
+		return m.MessageId
+	}
+	return ""
+}
+
+func (m *Envelope) GetMessageType() string {
+	if m != nil {
+		return m.MessageType
+	}
+	return ""
+}
+
+func (m *Envelope) GetContentType() string {
+	if m != nil {
+		return m.ContentType
+	}
+	return ""
+}
+
+func (m *Envelope) GetTenant() string {
+	if m != nil {
+		return m.Tenant
+	}
+	return ""
+}
+
+func (m *Envelope) GetHeaders() map[string]string {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *Envelope) GetCreatedAt() uint64 {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return 0
+}
+
+func (m *Envelope) GetAttempt() uint32 {
+	if m != nil {
+		return m.Attempt
+	}
+	return 0
+}
+
+func (m *Envelope) GetPayload() []byte {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Envelope)(nil), "dto.Envelope")
+	proto.RegisterMapType((map[string]string)(nil), "dto.Envelope.HeadersEntry")
+}
+
+func init() { proto.RegisterFile("envelope.proto", fileDescriptor_ee266e8c558e9dc5) }
+
+var fileDescriptor_ee266e8c558e9dc5 = []byte{
+	// 282 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x91, 0x4d, 0x4b, 0x03, 0x31,
+	0x10, 0x86, 0x49, 0x5b, 0xfb, 0x31, 0xfd, 0x60, 0x8d, 0xb5, 0x0e, 0x05, 0x61, 0x15, 0x84, 0x3d,
+	0xed, 0x41, 0x3d, 0x48, 0x6f, 0x1e, 0x0a, 0xea, 0x71, 0x11, 0xaf, 0x25, 0x36, 0x83, 0x2d, 0xb6,
+	0x49, 0xd8, 0x1d, 0x0b, 0xfb, 0xd3, 0xbd, 0xc9, 0x26, 0x59, 0xf0, 0x96, 0xf7, 0x79, 0x1f, 0xc8,
+	0x0c, 0x03, 0x33, 0x32, 0x27, 0x3a, 0x58, 0x47, 0xb9, 0x2b, 0x2d, 0x5b, 0xd9, 0xd5, 0x6c, 0x6f,
+	0x7f, 0x3b, 0x30, 0x5c, 0x47, 0x2e, 0xef, 0x60, 0x56, 0x6d, 0x77, 0x74, 0x54, 0x9b, 0x13, 0x95,
+	0xd5, 0xde, 0x1a, 0x4c, 0x52, 0x91, 0x4d, 0x8b, 0x69, 0xa0, 0x1f, 0x01, 0xca, 0x6b, 0x80, 0x23,
+	0x55, 0x95, 0xfa, 0xa2, 0xcd, 0x5e, 0xe3, 0x79, 0x2a, 0xb2, 0x51, 0x31, 0x8a, 0xe4, 0x55, 0xcb,
+	0x1b, 0x98, 0xb4, 0x35, 0xd7, 0x8e, 0x50, 0x7a, 0x61, 0x1c, 0xd9, 0x7b, 0xed, 0xa8, 0x51, 0xb6,
+	0xd6, 0x30, 0x19, 0x0e, 0xca, 0x45, 0x50, 0x22, 0xf3, 0xca, 0x02, 0xfa, 0x4c, 0x46, 0x19, 0xc6,
+	0xb9, 0x2f, 0x63, 0x92, 0x8f, 0x30, 0xd8, 0x91, 0xd2, 0x54, 0x56, 0x78, 0x99, 0x76, 0xb3, 0xf1,
+	0xfd, 0x32, 0xd7, 0x6c, 0xf3, 0x76, 0x87, 0xfc, 0x25, 0x94, 0x6b, 0xc3, 0x65, 0x5d, 0xb4, 0x6a,
+	0x33, 0xf2, 0xb6, 0x24, 0xc5, 0xa4, 0x37, 0x8a, 0x71, 0x91, 0x8a, 0xac, 0x57, 0x8c, 0x22, 0x79,
+	0x66, 0x89, 0x30, 0x50, 0xcc, 0x74, 0x74, 0x8c, 0x57, 0x7e, 0xe3, 0x36, 0x36, 0x8d, 0x53, 0xf5,
+	0xc1, 0x2a, 0x8d, 0x98, 0x8a, 0x6c, 0x52, 0xb4, 0x71, 0xb9, 0x82, 0xc9, 0xff, 0xbf, 0x64, 0x02,
+	0xdd, 0x6f, 0xaa, 0x51, 0xf8, 0x69, 0x9b, 0xa7, 0x9c, 0xc3, 0xd9, 0x49, 0x1d, 0x7e, 0x08, 0x3b,
+	0x9e, 0x85, 0xb0, 0xea, 0x3c, 0x89, 0xb7, 0xde, 0x50, 0x24, 0xc9, 0x67, 0xdf, 0xdf, 0xe1, 0xe1,
+	0x6f, 0x00, 0x50, 0x54, 0x59, 0x7b, 0x99, 0x01, 0x00, 0x00,
+}
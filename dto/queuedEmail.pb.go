@@ -23,6 +23,7 @@ const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 type StatusEvent struct {
 	Status               uint32   `protobuf:"varint,1,opt,name=status,proto3" json:"status,omitempty"`
 	At                   uint64   `protobuf:"varint,2,opt,name=at,proto3" json:"at,omitempty"`
+	Detail               string   `protobuf:"bytes,3,opt,name=detail,proto3" json:"detail,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -67,18 +68,29 @@ func (m *StatusEvent) GetAt() uint64 {
 	return 0
 }
 
+func (m *StatusEvent) GetDetail() string {
+	if m != nil {
+		return m.Detail
+	}
+	return ""
+}
+
 type QueuedEmail struct {
-	Id                   uint64         `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
-	From                 string         `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`
-	To                   []string       `protobuf:"bytes,3,rep,name=to,proto3" json:"to,omitempty"`
-	Cc                   []string       `protobuf:"bytes,4,rep,name=cc,proto3" json:"cc,omitempty"`
-	Bcc                  []string       `protobuf:"bytes,5,rep,name=bcc,proto3" json:"bcc,omitempty"`
-	Subject              string         `protobuf:"bytes,6,opt,name=subject,proto3" json:"subject,omitempty"`
-	Body                 string         `protobuf:"bytes,7,opt,name=body,proto3" json:"body,omitempty"`
-	Status               []*StatusEvent `protobuf:"bytes,8,rep,name=status,proto3" json:"status,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
-	XXX_unrecognized     []byte         `json:"-"`
-	XXX_sizecache        int32          `json:"-"`
+	Id                   uint64            `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	From                 string            `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`
+	To                   []string          `protobuf:"bytes,3,rep,name=to,proto3" json:"to,omitempty"`
+	Cc                   []string          `protobuf:"bytes,4,rep,name=cc,proto3" json:"cc,omitempty"`
+	Bcc                  []string          `protobuf:"bytes,5,rep,name=bcc,proto3" json:"bcc,omitempty"`
+	Subject              string            `protobuf:"bytes,6,opt,name=subject,proto3" json:"subject,omitempty"`
+	Body                 string            `protobuf:"bytes,7,opt,name=body,proto3" json:"body,omitempty"`
+	Status               []*StatusEvent    `protobuf:"bytes,8,rep,name=status,proto3" json:"status,omitempty"`
+	Priority             uint32            `protobuf:"varint,9,opt,name=priority,proto3" json:"priority,omitempty"`
+	Tenant               string            `protobuf:"bytes,10,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	Batch                string            `protobuf:"bytes,11,opt,name=batch,proto3" json:"batch,omitempty"`
+	TraceContext         map[string]string `protobuf:"bytes,12,rep,name=trace_context,json=traceContext,proto3" json:"trace_context,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *QueuedEmail) Reset()         { *m = QueuedEmail{} }
@@ -162,27 +174,63 @@ func (m *QueuedEmail) GetStatus() []*StatusEvent {
 	return nil
 }
 
+func (m *QueuedEmail) GetPriority() uint32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
+func (m *QueuedEmail) GetTenant() string {
+	if m != nil {
+		return m.Tenant
+	}
+	return ""
+}
+
+func (m *QueuedEmail) GetBatch() string {
+	if m != nil {
+		return m.Batch
+	}
+	return ""
+}
+
+func (m *QueuedEmail) GetTraceContext() map[string]string {
+	if m != nil {
+		return m.TraceContext
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*StatusEvent)(nil), "dto.StatusEvent")
 	proto.RegisterType((*QueuedEmail)(nil), "dto.QueuedEmail")
+	proto.RegisterMapType((map[string]string)(nil), "dto.QueuedEmail.TraceContextEntry")
 }
 
 func init() { proto.RegisterFile("queuedEmail.proto", fileDescriptor_21d0a80e5c012a88) }
 
 var fileDescriptor_21d0a80e5c012a88 = []byte{
-	// 211 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x90, 0x4d, 0x4e, 0xc3, 0x30,
-	0x10, 0x85, 0x15, 0x3b, 0xa4, 0x74, 0x22, 0x50, 0x99, 0x05, 0x9a, 0xa5, 0xd5, 0x95, 0x57, 0x59,
-	0x80, 0x38, 0x42, 0x0f, 0x80, 0x39, 0x81, 0xff, 0x90, 0x82, 0x28, 0x03, 0xcd, 0x18, 0x89, 0xb3,
-	0x71, 0x39, 0x64, 0x97, 0x42, 0x77, 0xef, 0xb3, 0x3d, 0x9f, 0xfc, 0x06, 0x6e, 0x3e, 0x4a, 0x2e,
-	0x39, 0xed, 0xf6, 0x7e, 0x7e, 0x9d, 0xde, 0x0f, 0x2c, 0x8c, 0x3a, 0x09, 0x6f, 0x1f, 0x60, 0x7c,
-	0x12, 0x2f, 0x65, 0xd9, 0x7d, 0xe6, 0x37, 0xc1, 0x5b, 0x18, 0x96, 0x86, 0xd4, 0x99, 0xce, 0x5e,
-	0xb9, 0x5f, 0xc2, 0x6b, 0x50, 0x5e, 0x48, 0x99, 0xce, 0xf6, 0x4e, 0x79, 0xd9, 0x7e, 0x77, 0x30,
-	0x3e, 0xfe, 0x1b, 0xeb, 0xfd, 0x9c, 0xda, 0x4c, 0xef, 0xd4, 0x9c, 0x10, 0xa1, 0x7f, 0x3e, 0xf0,
-	0xbe, 0x4d, 0xac, 0x5d, 0xcb, 0xf5, 0x8d, 0x30, 0x69, 0xa3, 0xed, 0xda, 0x29, 0xe1, 0xca, 0x31,
-	0x52, 0x7f, 0xe4, 0x18, 0x71, 0x03, 0x3a, 0xc4, 0x48, 0x17, 0xed, 0xa0, 0x46, 0x24, 0x58, 0x2d,
-	0x25, 0xbc, 0xe4, 0x28, 0x34, 0x34, 0xd1, 0x09, 0xab, 0x3f, 0x70, 0xfa, 0xa2, 0xd5, 0xd1, 0x5f,
-	0x33, 0xda, 0xbf, 0xbf, 0x5f, 0x1a, 0x6d, 0xc7, 0xbb, 0xcd, 0x94, 0x84, 0xa7, 0xb3, 0x76, 0xa7,
-	0x36, 0x61, 0x68, 0x0b, 0xb8, 0xff, 0x09, 0x00, 0x00, 0xff, 0xff, 0x96, 0xbf, 0x4e, 0xef, 0x15,
-	0x01, 0x00, 0x00,
+	// 322 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x64, 0x91, 0x3f, 0x4f, 0xc3, 0x30,
+	0x10, 0xc5, 0x95, 0x38, 0xfd, 0x13, 0xa7, 0x45, 0xad, 0x85, 0xd0, 0xa9, 0x53, 0xd4, 0x29, 0x53,
+	0x06, 0x58, 0x10, 0x0b, 0x03, 0xaa, 0x98, 0x18, 0x30, 0xec, 0xc8, 0xb1, 0x8d, 0x08, 0xb4, 0x71,
+	0x49, 0x2f, 0x15, 0xf9, 0x14, 0x7c, 0x65, 0xe4, 0x73, 0x29, 0x95, 0xd8, 0xee, 0x77, 0x7a, 0x79,
+	0x97, 0xf7, 0xcc, 0xe7, 0x9f, 0x9d, 0xed, 0xac, 0x59, 0x6d, 0x54, 0xbd, 0x2e, 0xb7, 0xad, 0x43,
+	0x27, 0x98, 0x41, 0xb7, 0x7c, 0xe0, 0xd9, 0x13, 0x2a, 0xec, 0x76, 0xab, 0xbd, 0x6d, 0x50, 0x5c,
+	0xf0, 0xe1, 0x8e, 0x10, 0xa2, 0x3c, 0x2a, 0xa6, 0xf2, 0x40, 0xe2, 0x8c, 0xc7, 0x0a, 0x21, 0xce,
+	0xa3, 0x22, 0x91, 0xb1, 0x22, 0x9d, 0xb1, 0xa8, 0xea, 0x35, 0xb0, 0x3c, 0x2a, 0x52, 0x79, 0xa0,
+	0xe5, 0x37, 0xe3, 0xd9, 0xe3, 0xdf, 0x25, 0xff, 0x5d, 0x6d, 0xc8, 0x2b, 0x91, 0x71, 0x6d, 0x84,
+	0xe0, 0xc9, 0x6b, 0xeb, 0x36, 0xe4, 0x94, 0x4a, 0x9a, 0xbd, 0x06, 0x1d, 0xb0, 0x9c, 0x15, 0xa9,
+	0x8c, 0xd1, 0x79, 0xd6, 0x1a, 0x92, 0xc0, 0x5a, 0x8b, 0x19, 0x67, 0x95, 0xd6, 0x30, 0xa0, 0x85,
+	0x1f, 0x05, 0xf0, 0xd1, 0xae, 0xab, 0xde, 0xad, 0x46, 0x18, 0x92, 0xd1, 0x2f, 0x7a, 0xff, 0xca,
+	0x99, 0x1e, 0x46, 0xc1, 0xdf, 0xcf, 0xa2, 0x38, 0x66, 0x1a, 0xe7, 0xac, 0xc8, 0x2e, 0x67, 0xa5,
+	0x41, 0x57, 0x9e, 0xa4, 0x3e, 0xa6, 0x5c, 0xf0, 0xf1, 0xb6, 0xad, 0x5d, 0x5b, 0x63, 0x0f, 0x29,
+	0xe5, 0x3f, 0xb2, 0x4f, 0x8c, 0xb6, 0x51, 0x0d, 0x02, 0x0f, 0x89, 0x03, 0x89, 0x73, 0x3e, 0xa8,
+	0x14, 0xea, 0x37, 0xc8, 0x68, 0x1d, 0x40, 0xdc, 0xf3, 0x29, 0xb6, 0x4a, 0xdb, 0x17, 0xed, 0x1a,
+	0xb4, 0x5f, 0x08, 0x13, 0x3a, 0xbd, 0xa4, 0xd3, 0x27, 0x05, 0x95, 0xcf, 0x5e, 0x75, 0x17, 0x44,
+	0xab, 0x06, 0xdb, 0x5e, 0x4e, 0xf0, 0x64, 0xb5, 0xb8, 0xe5, 0xf3, 0x7f, 0x12, 0xdf, 0xc8, 0x87,
+	0xed, 0xa9, 0xd6, 0x54, 0xfa, 0xd1, 0xff, 0xc5, 0x5e, 0xad, 0x3b, 0x7b, 0x28, 0x36, 0xc0, 0x4d,
+	0x7c, 0x1d, 0x55, 0x43, 0x7a, 0xec, 0xab, 0x9f, 0x01, 0x00, 0x34, 0xa4, 0x24, 0x7d, 0x01, 0x02,
+	0x00, 0x00,
 }
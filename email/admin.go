@@ -0,0 +1,362 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/husainaloos/notfy/logger"
+	"github.com/sirupsen/logrus"
+)
+
+type postAPIKeyModel struct {
+	Tenant string `json:"tenant"`
+	Name   string `json:"name"`
+}
+
+type postSenderIdentityModel struct {
+	Tenant   string `json:"tenant"`
+	Identity string `json:"identity"`
+}
+
+type postSuppressionModel struct {
+	Tenant    string     `json:"tenant"`
+	Address   string     `json:"address"`
+	Reason    string     `json:"reason"`
+	Detail    string     `json:"detail"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type getSuppressionModel struct {
+	Tenant    string     `json:"tenant,omitempty"`
+	Address   string     `json:"address"`
+	Reason    string     `json:"reason"`
+	Detail    string     `json:"detail,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type getBounceModel struct {
+	MessageID  string            `json:"message_id,omitempty"`
+	Recipients []RecipientStatus `json:"recipients"`
+}
+
+type getAPIKeyModel struct {
+	ID        int       `json:"id"`
+	Tenant    string    `json:"tenant"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Key       string    `json:"key,omitempty"`
+}
+
+// AdminAPIInterface is the API the admin handlers manage notfy with
+type AdminAPIInterface interface {
+	CreateAPIKey(ctx context.Context, tenant, name string) (APIKey, string, error)
+	ListAPIKeys(ctx context.Context, tenant string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+	AddSenderIdentity(ctx context.Context, tenant, identity string) (SenderIdentity, error)
+	ListSenderIdentities(ctx context.Context, tenant string) ([]SenderIdentity, error)
+	RemoveSenderIdentity(ctx context.Context, id int) error
+	AddSuppression(ctx context.Context, tenant, addr string, reason SuppressionReason, detail string, expiresAt time.Time) (Suppression, error)
+	ListSuppressions(ctx context.Context) ([]Suppression, error)
+	RemoveSuppression(ctx context.Context, tenant, addr string, reasons ...SuppressionReason) error
+	ProcessBounce(ctx context.Context, r io.Reader) (DSN, error)
+}
+
+// AdminHTTPHandler is the handler of the admin requests. It should be mounted
+// behind NewAdminAuth.
+type AdminHTTPHandler struct {
+	api AdminAPIInterface
+}
+
+// NewAdminHTTPHandler creates a new handler for admin requests
+func NewAdminHTTPHandler(api AdminAPIInterface) *AdminHTTPHandler {
+	return &AdminHTTPHandler{api}
+}
+
+// Route builds the routing for the admin handlers
+func (h *AdminHTTPHandler) Route(r chi.Router) {
+	r.Post("/keys", h.createKeyHandler)
+	r.Get("/keys", h.listKeysHandler)
+	r.Delete("/keys/{id}", h.revokeKeyHandler)
+	r.Post("/identities", h.addIdentityHandler)
+	r.Get("/identities", h.listIdentitiesHandler)
+	r.Delete("/identities/{id}", h.removeIdentityHandler)
+	r.Post("/suppressions", h.addSuppressionHandler)
+	r.Get("/suppressions", h.listSuppressionsHandler)
+	r.Delete("/suppressions/{address}", h.removeSuppressionHandler)
+	r.Post("/bounces", h.bounceHandler)
+}
+
+func (h *AdminHTTPHandler) createKeyHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeErr(w, r, errCannotReadBody, http.StatusInternalServerError)
+		log.Errorf("failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+	var model postAPIKeyModel
+	if err := json.Unmarshal(body, &model); err != nil {
+		writeErr(w, r, errMalformedJSON, http.StatusBadRequest)
+		log.Debugf("failed to unmarshal json: %v", err)
+		return
+	}
+	if model.Tenant == "" {
+		writeErr(w, r, errBadRequest(errEmptyTenant), http.StatusBadRequest)
+		return
+	}
+	k, key, err := h.api.CreateAPIKey(r.Context(), model.Tenant, model.Name)
+	if err != nil {
+		writeErr(w, r, errCreateKeyFailed, http.StatusInternalServerError)
+		log.Errorf("failed to create api key: %v", err)
+		return
+	}
+	log.WithField("api_key_id", k.ID).Info("api key created")
+	m := buildGetAPIKeyDto(k)
+	m.Key = key
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(m)
+}
+
+func (h *AdminHTTPHandler) listKeysHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	keys, err := h.api.ListAPIKeys(r.Context(), r.URL.Query().Get("tenant"))
+	if err != nil {
+		writeErr(w, r, errListKeysFailed, http.StatusInternalServerError)
+		log.Errorf("failed to list api keys: %v", err)
+		return
+	}
+	models := make([]getAPIKeyModel, 0)
+	for _, k := range keys {
+		models = append(models, buildGetAPIKeyDto(k))
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models)
+}
+
+func (h *AdminHTTPHandler) revokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := h.api.RevokeAPIKey(r.Context(), id); err != nil {
+		if err == ErrItemNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeErr(w, r, errRevokeKeyFailed, http.StatusInternalServerError)
+		log.Errorf("failed to revoke api key: %v", err)
+		return
+	}
+	log.WithField("api_key_id", id).Info("api key revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHTTPHandler) addIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeErr(w, r, errCannotReadBody, http.StatusInternalServerError)
+		log.Errorf("failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+	var model postSenderIdentityModel
+	if err := json.Unmarshal(body, &model); err != nil {
+		writeErr(w, r, errMalformedJSON, http.StatusBadRequest)
+		log.Debugf("failed to unmarshal json: %v", err)
+		return
+	}
+	if model.Tenant == "" {
+		writeErr(w, r, errBadRequest(errEmptyTenant), http.StatusBadRequest)
+		return
+	}
+	if _, err := normalizeIdentity(model.Identity); err != nil {
+		writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
+		return
+	}
+	i, err := h.api.AddSenderIdentity(r.Context(), model.Tenant, model.Identity)
+	if err != nil {
+		writeErr(w, r, errIdentityFailed, http.StatusInternalServerError)
+		log.Errorf("failed to add sender identity: %v", err)
+		return
+	}
+	log.WithField("sender_identity_id", i.ID).Info("sender identity added")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(i)
+}
+
+func (h *AdminHTTPHandler) listIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	identities, err := h.api.ListSenderIdentities(r.Context(), r.URL.Query().Get("tenant"))
+	if err != nil {
+		writeErr(w, r, errIdentityFailed, http.StatusInternalServerError)
+		log.Errorf("failed to list sender identities: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(identities)
+}
+
+func (h *AdminHTTPHandler) removeIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := h.api.RemoveSenderIdentity(r.Context(), id); err != nil {
+		if err == ErrItemNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeErr(w, r, errIdentityFailed, http.StatusInternalServerError)
+		log.Errorf("failed to remove sender identity: %v", err)
+		return
+	}
+	log.WithField("sender_identity_id", id).Info("sender identity removed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHTTPHandler) addSuppressionHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeErr(w, r, errCannotReadBody, http.StatusInternalServerError)
+		log.Errorf("failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+	var model postSuppressionModel
+	if err := json.Unmarshal(body, &model); err != nil {
+		writeErr(w, r, errMalformedJSON, http.StatusBadRequest)
+		log.Debugf("failed to unmarshal json: %v", err)
+		return
+	}
+	reason := ReasonManual
+	if model.Reason != "" {
+		if reason, err = ParseSuppressionReason(model.Reason); err != nil {
+			writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := mail.ParseAddress(model.Address); err != nil {
+		writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
+		return
+	}
+	var expiresAt time.Time
+	if model.ExpiresAt != nil {
+		expiresAt = model.ExpiresAt.UTC()
+	}
+	sup, err := h.api.AddSuppression(r.Context(), model.Tenant, model.Address, reason, model.Detail, expiresAt)
+	if err != nil {
+		writeErr(w, r, errSuppressionFailed, http.StatusInternalServerError)
+		log.Errorf("failed to add suppression: %v", err)
+		return
+	}
+	log.WithField("address", sup.Address).Info("address suppressed")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(buildGetSuppressionDto(sup))
+}
+
+func (h *AdminHTTPHandler) listSuppressionsHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	sups, err := h.api.ListSuppressions(r.Context())
+	if err != nil {
+		writeErr(w, r, errSuppressionFailed, http.StatusInternalServerError)
+		log.Errorf("failed to list suppressions: %v", err)
+		return
+	}
+	models := make([]getSuppressionModel, 0)
+	for _, sup := range sups {
+		models = append(models, buildGetSuppressionDto(sup))
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models)
+}
+
+// removeSuppressionHandler lifts the suppression of the tenant and reason
+// query parameters, or every suppression of the address of the tenant without
+// a reason. The suppressions of every tenant are lifted without a tenant.
+func (h *AdminHTTPHandler) removeSuppressionHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	addr := chi.URLParam(r, "address")
+	reasons := make([]SuppressionReason, 0)
+	if s := r.URL.Query().Get("reason"); s != "" {
+		reason, err := ParseSuppressionReason(s)
+		if err != nil {
+			writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
+			return
+		}
+		reasons = append(reasons, reason)
+	}
+	if err := h.api.RemoveSuppression(r.Context(), r.URL.Query().Get("tenant"), addr, reasons...); err != nil {
+		if err == ErrItemNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeErr(w, r, errSuppressionFailed, http.StatusInternalServerError)
+		log.Errorf("failed to remove suppression: %v", err)
+		return
+	}
+	log.WithField("address", addr).Info("suppression removed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bounceHandler takes a raw MIME delivery status notification, e.g. piped
+// from the mailbox the bounces are returned to
+func (h *AdminHTTPHandler) bounceHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	defer r.Body.Close()
+	dsn, err := h.api.ProcessBounce(r.Context(), r.Body)
+	if err != nil {
+		if err == ErrNotDSN {
+			writeErr(w, r, errNotDSN, http.StatusUnprocessableEntity)
+			return
+		}
+		writeErr(w, r, errBounceFailed, http.StatusInternalServerError)
+		log.Errorf("failed to process bounce: %v", err)
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"message_id": dsn.MessageID,
+		"recipients": len(dsn.Recipients),
+	}).Info("bounce processed")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(getBounceModel{dsn.MessageID, dsn.Recipients})
+}
+
+func buildGetSuppressionDto(sup Suppression) getSuppressionModel {
+	m := getSuppressionModel{
+		Tenant:    sup.Tenant,
+		Address:   sup.Address,
+		Reason:    strings.ToLower(sup.Reason.String()),
+		Detail:    sup.Detail,
+		CreatedAt: sup.CreatedAt,
+	}
+	if !sup.ExpiresAt.IsZero() {
+		expiresAt := sup.ExpiresAt
+		m.ExpiresAt = &expiresAt
+	}
+	return m
+}
+
+func buildGetAPIKeyDto(k APIKey) getAPIKeyModel {
+	return getAPIKeyModel{
+		ID:        k.ID,
+		Tenant:    k.Tenant,
+		Name:      k.Name,
+		CreatedAt: k.CreatedAt,
+	}
+}
@@ -4,58 +4,669 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/mail"
+	"strings"
 	"time"
 
 	"github.com/husainaloos/notfy/messaging"
+	"github.com/husainaloos/notfy/metrics"
+	"github.com/husainaloos/notfy/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerName = "github.com/husainaloos/notfy/email"
+
 var (
-	ErrItemNotFound = errors.New("item not found")
+	ErrItemNotFound  = errors.New("item not found")
+	ErrInvalidAPIKey = errors.New("invalid api key")
+
+	// ErrSenderNotAllowed is returned when a tenant sends from an address it is not verified for
+	ErrSenderNotAllowed = errors.New("sender is not a verified identity of the tenant")
+
+	// ErrRecipientSuppressed is returned when a recipient is suppressed and the policy rejects the email
+	ErrRecipientSuppressed = errors.New("recipient is suppressed")
+
+	// ErrStreamDisabled is returned when events are streamed without a status stream
+	ErrStreamDisabled = errors.New("status stream is not configured")
+
+	// ErrVersionConflict is returned when an email is updated from a version
+	// that was changed since it was read
+	ErrVersionConflict = errors.New("email was changed by another update")
+
+	errEmptyTenant = errors.New("tenant cannot be empty")
 )
 
 type API struct {
-	publisher messaging.Publisher
-	storage   Storage
+	publisher         messaging.Publisher
+	lanePublishers    map[Priority]messaging.Publisher
+	storage           Storage
+	suppressionPolicy SuppressionPolicy
+	webhooks          *WebhookNotifier
+	stream            *StatusStream
+	messageIDKey      []byte
+	metrics           metrics.Metrics
+	tracer            trace.Tracer
 }
 
 func NewAPI(p messaging.Publisher, s Storage) *API {
-	return &API{p, s}
+	return &API{
+		publisher:      p,
+		lanePublishers: make(map[Priority]messaging.Publisher),
+		storage:        s,
+		metrics:        metrics.Nop{},
+		tracer:         otel.Tracer(tracerName),
+	}
+}
+
+// SetLanePublisher publishes the emails of the priority to p instead of the
+// default publisher, so that every priority has its own queue and a backlog
+// of one priority does not hold back the others
+func (api *API) SetLanePublisher(pr Priority, p messaging.Publisher) {
+	api.lanePublishers[pr] = p
 }
 
+// SetSuppressionPolicy sets what happens to emails with suppressed recipients
+func (api *API) SetSuppressionPolicy(p SuppressionPolicy) {
+	api.suppressionPolicy = p
+}
+
+// SetWebhookNotifier notifies the webhooks of the tenants when the API adds
+// status events to their emails
+func (api *API) SetWebhookNotifier(n *WebhookNotifier) {
+	api.webhooks = n
+}
+
+// SetMessageIDKey sets the key the Message-IDs of the emails are signed with,
+// so that ProcessBounce can match bounces back to the emails. It must be the
+// MessageIDKey of the deamons; without it no bounce is matched.
+func (api *API) SetMessageIDKey(key []byte) {
+	api.messageIDKey = key
+}
+
+// SetStatusStream publishes the status events the API adds to the stream, and
+// streams the events of the stream to the subscribers of Events
+func (api *API) SetStatusStream(st *StatusStream) {
+	api.stream = st
+}
+
+// SetMetrics counts the status events the API adds to the emails
+func (api *API) SetMetrics(m metrics.Metrics) {
+	api.metrics = m
+}
+
+// SetTracerProvider traces the emails queued by the API with the provider
+// instead of the global one
+func (api *API) SetTracerProvider(tp trace.TracerProvider) {
+	api.tracer = tp.Tracer(tracerName)
+}
+
+func (api *API) publisherFor(pr Priority) messaging.Publisher {
+	if p, ok := api.lanePublishers[pr.lane()]; ok {
+		return p
+	}
+	return api.publisher
+}
+
+// Queue stores and publishes the email. The email is stamped with the tenant
+// of the context. Suppressed recipients are dropped or the email is rejected,
+// depending on the suppression policy.
 func (api *API) Queue(ctx context.Context, e Email) (Email, error) {
-	e.AddStatusEvent(MakeStatusEvent(Queued, time.Now()))
+	ctx, span := api.tracer.Start(ctx, "email.Queue")
+	email, err := api.queue(ctx, e)
+	endSpan(span, err)
+	return email, err
+}
+
+func (api *API) queue(ctx context.Context, e Email) (Email, error) {
+	policy, err := api.loadQueuePolicy(ctx, []Email{e})
+	if err != nil {
+		return Email{}, err
+	}
+	e, publish, err := policy.prepare(e, api.suppressionPolicy)
+	if err != nil {
+		return Email{}, err
+	}
 	email, err := api.storage.insert(ctx, e)
 	if err != nil {
 		return Email{}, err
 	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("email.id", email.ID()))
+	api.statusChanged(email, 0)
+	if !publish {
+		// nothing is left to send, so the email is only stored
+		return email, nil
+	}
+	ctx, span := api.tracer.Start(ctx, "email.publish", trace.WithSpanKind(trace.SpanKindProducer))
+	email.traceContext = tracing.Inject(ctx)
 	b, err := Marshal(email)
 	if err != nil {
+		endSpan(span, err)
 		return Email{}, fmt.Errorf("failed to marshal email to protobuffer: %v", err)
 	}
-	if err := api.publisher.Publish(b); err != nil {
+	err = api.publisherFor(email.Priority()).Publish(b)
+	endSpan(span, err)
+	if err != nil {
 		return Email{}, fmt.Errorf("failed to publish email: %v", err)
 	}
+	email.traceContext = nil
 	return email, nil
 }
 
+// List lists up to limit emails of the tenant of the context with an id
+// greater than after, by id. Admins list the emails of every tenant.
+func (api *API) List(ctx context.Context, after, limit int) ([]Email, error) {
+	tenant, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	emails, err := api.storage.list(ctx, tenant, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list from db: %v", err)
+	}
+	return emails, nil
+}
+
+// Get gets the email. Only the emails of the tenant of the context are found,
+// or of every tenant for admins.
 func (api *API) Get(ctx context.Context, id int) (Email, error) {
 	e, ok, err := api.storage.get(ctx, id)
 	if err != nil {
 		return Email{}, fmt.Errorf("failed to get from db: %v", err)
 	}
-	if !ok {
+	if !ok || !ownedBy(ctx, e) {
 		return Email{}, ErrItemNotFound
 	}
 	return e, nil
 }
 
+// Update replaces the content of the email of the tenant of the context. The
+// tenant, batch and history of the email are kept. ErrVersionConflict is
+// returned if the email changed since the version of e was read.
 func (api *API) Update(ctx context.Context, e Email) (Email, error) {
-	e, ok, err := api.storage.update(ctx, e)
+	stored, err := api.Get(ctx, e.ID())
 	if err != nil {
 		return Email{}, err
 	}
+	if tenant, ok := TenantFromContext(ctx); ok {
+		identities, err := api.storage.listSenderIdentities(ctx, tenant)
+		if err != nil {
+			return Email{}, fmt.Errorf("failed to list sender identities: %v", err)
+		}
+		if !(queuePolicy{identities: identities}).allows(e) {
+			return Email{}, ErrSenderNotAllowed
+		}
+	}
+	e.SetTenant(stored.Tenant())
+	e.SetBatch(stored.Batch())
+	e.statusHistory = stored.StatusHistory()
+	e, ok, err := api.storage.update(ctx, e)
+	if err == ErrVersionConflict {
+		return Email{}, err
+	}
+	if err != nil {
+		return Email{}, fmt.Errorf("failed to update email: %v", err)
+	}
 	if !ok {
 		return Email{}, ErrItemNotFound
 	}
 	return e, nil
 }
+
+// CreateAPIKey creates a key for the tenant. The key is only ever returned here.
+func (api *API) CreateAPIKey(ctx context.Context, tenant, name string) (APIKey, string, error) {
+	if tenant == "" {
+		return APIKey{}, "", errEmptyTenant
+	}
+	key, hash, err := generateAPIKey()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	k, err := api.storage.insertAPIKey(ctx, APIKey{
+		Tenant:    tenant,
+		Name:      name,
+		Hash:      hash,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("failed to insert api key: %v", err)
+	}
+	return k, key, nil
+}
+
+// Authenticate gets the API key of the raw key
+func (api *API) Authenticate(ctx context.Context, key string) (APIKey, error) {
+	k, ok, err := api.storage.getAPIKeyByHash(ctx, hashAPIKey(key))
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to get api key: %v", err)
+	}
+	if !ok {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	return k, nil
+}
+
+// ListAPIKeys lists the keys of the tenant, or every key if the tenant is empty
+func (api *API) ListAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+	return api.storage.listAPIKeys(ctx, tenant)
+}
+
+// RevokeAPIKey deletes the key so it can no longer authenticate
+func (api *API) RevokeAPIKey(ctx context.Context, id int) error {
+	ok, err := api.storage.deleteAPIKey(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete api key: %v", err)
+	}
+	if !ok {
+		return ErrItemNotFound
+	}
+	return nil
+}
+
+// AddSenderIdentity verifies the address or domain for the tenant
+func (api *API) AddSenderIdentity(ctx context.Context, tenant, identity string) (SenderIdentity, error) {
+	if tenant == "" {
+		return SenderIdentity{}, errEmptyTenant
+	}
+	identity, err := normalizeIdentity(identity)
+	if err != nil {
+		return SenderIdentity{}, err
+	}
+	i, err := api.storage.insertSenderIdentity(ctx, SenderIdentity{
+		Tenant:    tenant,
+		Identity:  identity,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return SenderIdentity{}, fmt.Errorf("failed to insert sender identity: %v", err)
+	}
+	return i, nil
+}
+
+// ListSenderIdentities lists the identities of the tenant, or every identity if the tenant is empty
+func (api *API) ListSenderIdentities(ctx context.Context, tenant string) ([]SenderIdentity, error) {
+	return api.storage.listSenderIdentities(ctx, tenant)
+}
+
+// RemoveSenderIdentity deletes the identity
+func (api *API) RemoveSenderIdentity(ctx context.Context, id int) error {
+	ok, err := api.storage.deleteSenderIdentity(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete sender identity: %v", err)
+	}
+	if !ok {
+		return ErrItemNotFound
+	}
+	return nil
+}
+
+// queuePolicy is what deciding whether emails may be queued depends on,
+// loaded once for every email queued together
+type queuePolicy struct {
+	tenant     string
+	hasTenant  bool
+	identities []SenderIdentity
+	suppressed map[string]bool
+}
+
+// loadQueuePolicy loads the identities of the tenant of the context and the
+// active suppressions of the recipients of the emails that apply to the tenant
+func (api *API) loadQueuePolicy(ctx context.Context, emails []Email) (queuePolicy, error) {
+	var p queuePolicy
+	p.tenant, p.hasTenant = TenantFromContext(ctx)
+	if p.hasTenant {
+		identities, err := api.storage.listSenderIdentities(ctx, p.tenant)
+		if err != nil {
+			return queuePolicy{}, fmt.Errorf("failed to list sender identities: %v", err)
+		}
+		p.identities = identities
+	}
+	addrs := make([]string, 0)
+	for _, e := range emails {
+		for _, a := range e.recipients() {
+			addrs = append(addrs, normalizeAddress(a))
+		}
+	}
+	sups, err := api.storage.getSuppressions(ctx, p.tenant, addrs)
+	if err != nil {
+		return queuePolicy{}, fmt.Errorf("failed to get suppressions: %v", err)
+	}
+	now := time.Now()
+	p.suppressed = make(map[string]bool)
+	for _, sup := range sups {
+		if sup.active(now) {
+			p.suppressed[sup.Address] = true
+		}
+	}
+	return p, nil
+}
+
+// prepare stamps the email with the tenant and its first status events. The
+// email is refused if the tenant may not send from its sender. Suppressed
+// recipients are dropped or the email is refused, depending on the
+// suppression policy. It reports whether any recipient is left to send to.
+func (p queuePolicy) prepare(e Email, sp SuppressionPolicy) (Email, bool, error) {
+	if p.hasTenant {
+		if !p.allows(e) {
+			return Email{}, false, ErrSenderNotAllowed
+		}
+		e.SetTenant(p.tenant)
+	}
+	seen := make(map[string]bool)
+	suppressed := make([]string, 0)
+	for _, a := range e.recipients() {
+		addr := normalizeAddress(a)
+		if p.suppressed[addr] && !seen[addr] {
+			seen[addr] = true
+			suppressed = append(suppressed, addr)
+		}
+	}
+	if len(suppressed) > 0 {
+		if sp == RejectSuppressed {
+			return Email{}, false, ErrRecipientSuppressed
+		}
+		detail := fmt.Sprintf("suppressed %d of %d recipients", len(suppressed), len(e.recipients()))
+		e = e.withoutRecipients(suppressed)
+		e.AddStatusEvent(MakeStatusEventWithDetail(Suppressed, time.Now(), detail))
+		if len(e.recipients()) == 0 {
+			return e, false, nil
+		}
+	}
+	e.AddStatusEvent(MakeStatusEvent(Queued, time.Now()))
+	return e, true, nil
+}
+
+// allows reports whether the from of the email matches one of the identities
+// of the tenant
+func (p queuePolicy) allows(e Email) bool {
+	from := e.From()
+	for _, i := range p.identities {
+		if i.allows(from.Address) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddSuppression stops emails of the tenant from being sent to the address
+// until it expires, or emails of every tenant if the tenant is empty. The zero
+// expiresAt never expires.
+func (api *API) AddSuppression(ctx context.Context, tenant, addr string, reason SuppressionReason, detail string, expiresAt time.Time) (Suppression, error) {
+	a, err := mail.ParseAddress(addr)
+	if err != nil {
+		return Suppression{}, err
+	}
+	sup := Suppression{
+		Tenant:    tenant,
+		Address:   normalizeAddress(a.Address),
+		Reason:    reason,
+		Detail:    detail,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+	if err := api.storage.upsertSuppression(ctx, sup); err != nil {
+		return Suppression{}, fmt.Errorf("failed to insert suppression: %v", err)
+	}
+	return sup, nil
+}
+
+// ListSuppressions lists every suppression, including the expired ones
+func (api *API) ListSuppressions(ctx context.Context) ([]Suppression, error) {
+	return api.storage.listSuppressions(ctx)
+}
+
+// RemoveSuppression lifts the suppressions of the address of the tenant for
+// the reasons, or for every reason if none is given. The suppressions of
+// every tenant are the ones of the empty tenant.
+func (api *API) RemoveSuppression(ctx context.Context, tenant, addr string, reasons ...SuppressionReason) error {
+	if len(reasons) == 0 {
+		reasons = suppressionReasons
+	}
+	found := false
+	for _, r := range reasons {
+		ok, err := api.storage.deleteSuppression(ctx, tenant, normalizeAddress(addr), r)
+		if err != nil {
+			return fmt.Errorf("failed to delete suppression: %v", err)
+		}
+		found = found || ok
+	}
+	if !found {
+		return ErrItemNotFound
+	}
+	return nil
+}
+
+// ProcessBounce records a delivery status notification. Failed recipients
+// get a Bounced status event on the email the DSN refers to, and permanent
+// failures are suppressed for the tenant of the email. The DSN is only matched
+// to an email through a Message-ID signed with the key of SetMessageIDKey, and
+// only the recipients of the email are taken from it, so that a forged DSN
+// cannot suppress other addresses.
+func (api *API) ProcessBounce(ctx context.Context, r io.Reader) (DSN, error) {
+	dsn, err := ParseDSN(r)
+	if err != nil {
+		return DSN{}, err
+	}
+	id, ok := emailIDOf(dsn.MessageID, api.messageIDKey)
+	if !ok {
+		return dsn, nil
+	}
+	e, found, err := api.storage.get(ctx, id)
+	if err != nil {
+		return DSN{}, fmt.Errorf("failed to get email: %v", err)
+	}
+	if !found {
+		return dsn, nil
+	}
+	isRecipient := make(map[string]bool)
+	for _, a := range e.recipients() {
+		isRecipient[normalizeAddress(a)] = true
+	}
+	failed := make([]RecipientStatus, 0)
+	for _, rs := range dsn.Recipients {
+		if rs.failed() && isRecipient[normalizeAddress(rs.Recipient)] {
+			failed = append(failed, rs)
+		}
+	}
+	if len(failed) == 0 {
+		return dsn, nil
+	}
+	events := make([]StatusEvent, 0, len(failed))
+	for _, rs := range failed {
+		events = append(events, MakeStatusEventWithDetail(Bounced, time.Now(), rs.detail()))
+	}
+	e, found, err = api.storage.appendStatusEvents(ctx, id, events)
+	if err != nil {
+		return DSN{}, fmt.Errorf("failed to update email: %v", err)
+	}
+	if !found {
+		return dsn, nil
+	}
+	api.statusChanged(e, len(e.StatusHistory())-len(events))
+	for _, rs := range failed {
+		if !rs.permanent() {
+			continue
+		}
+		if _, err := api.AddSuppression(ctx, e.Tenant(), rs.Recipient, ReasonBounce, strings.TrimSpace(rs.Status+" "+rs.Diagnostic), time.Time{}); err != nil {
+			return DSN{}, err
+		}
+	}
+	return dsn, nil
+}
+
+// AddWebhook registers the URL to be notified of the status changes of the
+// emails of the tenant of the context. The secret is only ever returned here.
+// URLs of hosts that are not public fail with ErrWebhookAddressNotAllowed,
+// unless the webhook notifier allows private networks.
+func (api *API) AddWebhook(ctx context.Context, rawurl string) (Webhook, string, error) {
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return Webhook{}, "", errEmptyTenant
+	}
+	u, err := api.webhooks.checkURL(ctx, rawurl)
+	if err != nil {
+		return Webhook{}, "", err
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return Webhook{}, "", err
+	}
+	w, err := api.storage.insertWebhook(ctx, Webhook{
+		Tenant:    tenant,
+		URL:       u,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return Webhook{}, "", fmt.Errorf("failed to insert webhook: %v", err)
+	}
+	return w, secret, nil
+}
+
+// ListWebhooks lists the webhooks of the tenant of the context
+func (api *API) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, errEmptyTenant
+	}
+	return api.storage.listWebhooks(ctx, tenant)
+}
+
+// RemoveWebhook removes the webhook, along with its delivery log
+func (api *API) RemoveWebhook(ctx context.Context, id int) error {
+	if _, err := api.getWebhook(ctx, id); err != nil {
+		return err
+	}
+	if _, err := api.storage.deleteWebhook(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries lists every delivery attempt of the webhook
+func (api *API) ListWebhookDeliveries(ctx context.Context, id int) ([]WebhookDelivery, error) {
+	if _, err := api.getWebhook(ctx, id); err != nil {
+		return nil, err
+	}
+	return api.storage.listWebhookDeliveries(ctx, id)
+}
+
+// TestWebhook posts a test event to the webhook and returns the attempt
+func (api *API) TestWebhook(ctx context.Context, id int) (WebhookDelivery, error) {
+	w, err := api.getWebhook(ctx, id)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	n := api.webhooks
+	if n == nil {
+		n = NewWebhookNotifier(api.storage, WebhookConfig{})
+	}
+	return n.test(ctx, w), nil
+}
+
+// getWebhook gets the webhook if it belongs to the tenant of the context
+func (api *API) getWebhook(ctx context.Context, id int) (Webhook, error) {
+	w, ok, err := api.storage.getWebhook(ctx, id)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("failed to get webhook: %v", err)
+	}
+	if tenant, err := tenantScope(ctx); !ok || err != nil || (!isAdmin(ctx) && w.Tenant != tenant) {
+		return Webhook{}, ErrItemNotFound
+	}
+	return w, nil
+}
+
+// Events streams the status updates of the email with the id, or of every
+// email of the tenant of the context if id is 0, or of every tenant for admins.
+// The updates after lastEventID
+// are replayed first; the whole history of an email is replayed without one.
+// The channel is closed once ctx is done, or when the subscriber falls behind
+// and should resume from its last update.
+func (api *API) Events(ctx context.Context, id int, lastEventID string) (<-chan StatusUpdate, error) {
+	if api.stream == nil {
+		return nil, ErrStreamDisabled
+	}
+	tenant, err := tenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter := func(u StatusUpdate) bool { return isAdmin(ctx) || u.Tenant == tenant }
+	if id != 0 {
+		filter = func(u StatusUpdate) bool { return u.EmailID == id }
+	}
+	// subscribe before reading the history, so no update falls in between
+	sub, replay := api.stream.subscribe(filter, lastEventID)
+	if id != 0 {
+		e, err := api.Get(ctx, id)
+		if err != nil {
+			api.stream.unsubscribe(sub)
+			return nil, err
+		}
+		replay = make([]StatusUpdate, 0)
+		for i := range e.StatusHistory() {
+			if u := makeStatusUpdate(e, i); lastEventID == "" || u.after(lastEventID) {
+				replay = append(replay, u)
+			}
+		}
+	}
+	c := make(chan StatusUpdate)
+	go func() {
+		defer close(c)
+		defer api.stream.unsubscribe(sub)
+		replayed := make(map[string]bool)
+		for _, u := range replay {
+			replayed[u.ID()] = true
+			select {
+			case c <- u:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			select {
+			case u, ok := <-sub.c:
+				if !ok {
+					return
+				}
+				if replayed[u.ID()] {
+					continue
+				}
+				select {
+				case c <- u:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// statusChanged counts and notifies the webhooks and the stream of the status
+// events of the email from index from onwards
+func (api *API) statusChanged(e Email, from int) {
+	countStatuses(api.metrics, e, from)
+	api.webhooks.notify(e, from)
+	api.stream.publish(e, from)
+}
+
+// ownedBy reports whether the email belongs to the tenant of the context.
+// Admins own every email, and contexts without a tenant none.
+func ownedBy(ctx context.Context, e Email) bool {
+	tenant, err := tenantScope(ctx)
+	return err == nil && (isAdmin(ctx) || e.Tenant() == tenant)
+}
+
+// endSpan records the error, if any, on the span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
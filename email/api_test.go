@@ -1 +1,207 @@
 package email
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/husainaloos/notfy/messaging"
+)
+
+func TestAPIQueueStampsTenant(t *testing.T) {
+	api := NewAPI(messaging.NilPublisher{}, NewMemoryStorage())
+	api.AddSenderIdentity(context.Background(), "acme", "example.com")
+	e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	got, err := api.Queue(WithTenant(context.Background(), "acme"), e)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	if got.Tenant() != "acme" {
+		t.Fatalf("got tenant %q, but expected %q", got.Tenant(), "acme")
+	}
+}
+
+func TestAPIGetIsScopedToTenant(t *testing.T) {
+	api := NewAPI(messaging.NilPublisher{}, NewMemoryStorage())
+	api.AddSenderIdentity(context.Background(), "acme", "example.com")
+	e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	queued, err := api.Queue(WithTenant(context.Background(), "acme"), e)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		wantErr error
+	}{
+		{"should get the email of the tenant", WithTenant(context.Background(), "acme"), nil},
+		{"should not get the email of another tenant", WithTenant(context.Background(), "globex"), ErrItemNotFound},
+		{"should not get any email without a tenant", context.Background(), ErrItemNotFound},
+		{"should get any email as an admin", WithAdmin(context.Background()), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := api.Get(test.ctx, queued.ID())
+			if err != test.wantErr {
+				t.Fatalf("got error %v, but expected %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIQueueChecksSenderIdentities(t *testing.T) {
+	api := NewAPI(messaging.NilPublisher{}, NewMemoryStorage())
+	ctx := context.Background()
+	if _, err := api.AddSenderIdentity(ctx, "acme", "Alerts@Acme.com"); err != nil {
+		t.Fatalf("failed to add sender identity: %v", err)
+	}
+	if _, err := api.AddSenderIdentity(ctx, "acme", "mail.acme.com"); err != nil {
+		t.Fatalf("failed to add sender identity: %v", err)
+	}
+	if _, err := api.AddSenderIdentity(ctx, "globex", "acme.com"); err != nil {
+		t.Fatalf("failed to add sender identity: %v", err)
+	}
+	tests := []struct {
+		name    string
+		tenant  string
+		from    string
+		wantErr error
+	}{
+		{"should allow a verified address", "acme", "alerts@acme.com", nil},
+		{"should match addresses case insensitively", "acme", "ALERTS@acme.com", nil},
+		{"should allow any address of a verified domain", "acme", "Noreply <noreply@mail.acme.com>", nil},
+		{"should refuse other addresses of the domain of a verified address", "acme", "ceo@acme.com", ErrSenderNotAllowed},
+		{"should refuse subdomains of a verified domain", "acme", "x@eu.mail.acme.com", ErrSenderNotAllowed},
+		{"should refuse tenants without identities", "initech", "alerts@acme.com", ErrSenderNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := New(0, test.from, []string{"to@example.com"}, nil, nil, "subject", "body")
+			if err != nil {
+				t.Fatalf("failed to create email: %v", err)
+			}
+			_, err = api.Queue(WithTenant(ctx, test.tenant), e)
+			if err != test.wantErr {
+				t.Fatalf("got error %v, but expected %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	api := NewAPI(messaging.NilPublisher{}, NewMemoryStorage())
+	ctx := context.Background()
+	k, key, err := api.CreateAPIKey(ctx, "acme", "ci")
+	if err != nil {
+		t.Fatalf("failed to create api key: %v", err)
+	}
+	if k.Hash == key || k.Hash != hashAPIKey(key) {
+		t.Fatalf("got hash %q, but expected the hash of the key", k.Hash)
+	}
+
+	got, err := api.Authenticate(ctx, key)
+	if err != nil {
+		t.Fatalf("failed to authenticate: %v", err)
+	}
+	if got.Tenant != "acme" {
+		t.Fatalf("got tenant %q, but expected %q", got.Tenant, "acme")
+	}
+	if _, err := api.Authenticate(ctx, key+"x"); err != ErrInvalidAPIKey {
+		t.Fatalf("got error %v, but expected %v", err, ErrInvalidAPIKey)
+	}
+
+	if err := api.RevokeAPIKey(ctx, k.ID); err != nil {
+		t.Fatalf("failed to revoke api key: %v", err)
+	}
+	if _, err := api.Authenticate(ctx, key); err != ErrInvalidAPIKey {
+		t.Fatalf("got error %v after revoke, but expected %v", err, ErrInvalidAPIKey)
+	}
+	if err := api.RevokeAPIKey(ctx, k.ID); err != ErrItemNotFound {
+		t.Fatalf("got error %v, but expected %v", err, ErrItemNotFound)
+	}
+}
+
+func TestAPIQueueSuppressedRecipients(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name          string
+		policy        SuppressionPolicy
+		to            []string
+		wantErr       error
+		wantTo        []string
+		wantPublished bool
+		wantStatus    Status
+	}{
+		{"should send emails without suppressions", DropSuppressed, []string{"ok@example.com"}, nil, []string{"<ok@example.com>"}, true, Queued},
+		{"should drop suppressed recipients", DropSuppressed, []string{"ok@example.com", "Bounced@Example.com"}, nil, []string{"<ok@example.com>"}, true, Queued},
+		{"should ignore expired suppressions", DropSuppressed, []string{"expired@example.com"}, nil, []string{"<expired@example.com>"}, true, Queued},
+		{"should ignore the suppressions of other tenants", DropSuppressed, []string{"globex@example.com"}, nil, []string{"<globex@example.com>"}, true, Queued},
+		{"should not publish emails without recipients left", DropSuppressed, []string{"bounced@example.com"}, nil, []string{}, false, Suppressed},
+		{"should reject emails with suppressed recipients", RejectSuppressed, []string{"ok@example.com", "bounced@example.com"}, ErrRecipientSuppressed, nil, false, Queued},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker := messaging.NewInMemoryBroker()
+			api := NewAPI(broker, NewMemoryStorage())
+			api.SetSuppressionPolicy(test.policy)
+			if _, err := api.AddSuppression(ctx, "", "bounced@example.com", ReasonBounce, "550 no such user", time.Time{}); err != nil {
+				t.Fatalf("failed to add suppression: %v", err)
+			}
+			if _, err := api.AddSuppression(ctx, "", "expired@example.com", ReasonManual, "", time.Now().Add(-time.Minute)); err != nil {
+				t.Fatalf("failed to add suppression: %v", err)
+			}
+			if _, err := api.AddSuppression(ctx, "globex", "globex@example.com", ReasonBounce, "", time.Time{}); err != nil {
+				t.Fatalf("failed to add suppression: %v", err)
+			}
+			e, _ := New(0, "from@example.com", test.to, nil, nil, "subject", "body")
+			got, err := api.Queue(ctx, e)
+			if err != test.wantErr {
+				t.Fatalf("got error %v, but expected %v", err, test.wantErr)
+			}
+			if published := len(broker.C) > 0; published != test.wantPublished {
+				t.Fatalf("got published %v, but expected %v", published, test.wantPublished)
+			}
+			if err != nil {
+				return
+			}
+			if to := got.StringTo(); !reflect.DeepEqual(to, test.wantTo) {
+				t.Fatalf("got recipients %v, but expected %v", to, test.wantTo)
+			}
+			h := got.StatusHistory()
+			if last := h[len(h)-1].Status(); last != test.wantStatus {
+				t.Fatalf("got status %v, but expected %v", last, test.wantStatus)
+			}
+			for _, se := range h {
+				if strings.Contains(se.Detail(), "@") {
+					t.Fatalf("got detail %q, but expected no address", se.Detail())
+				}
+			}
+		})
+	}
+}
+
+func TestAPIRemoveSuppression(t *testing.T) {
+	ctx := context.Background()
+	api := NewAPI(messaging.NilPublisher{}, NewMemoryStorage())
+	api.AddSuppression(ctx, "", "to@example.com", ReasonBounce, "", time.Time{})
+	api.AddSuppression(ctx, "", "to@example.com", ReasonUnsubscribe, "", time.Time{})
+
+	if err := api.RemoveSuppression(ctx, "", "to@example.com", ReasonComplaint); err != ErrItemNotFound {
+		t.Fatalf("got error %v, but expected %v", err, ErrItemNotFound)
+	}
+	if err := api.RemoveSuppression(ctx, "", "TO@example.com", ReasonBounce); err != nil {
+		t.Fatalf("failed to remove suppression: %v", err)
+	}
+	sups, _ := api.ListSuppressions(ctx)
+	if len(sups) != 1 || sups[0].Reason != ReasonUnsubscribe {
+		t.Fatalf("got suppressions %v, but expected only the unsubscribe", sups)
+	}
+	if err := api.RemoveSuppression(ctx, "", "to@example.com"); err != nil {
+		t.Fatalf("failed to remove suppression: %v", err)
+	}
+	if sups, _ := api.ListSuppressions(ctx); len(sups) != 0 {
+		t.Fatalf("got suppressions %v, but expected none", sups)
+	}
+}
@@ -0,0 +1,36 @@
+package email
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const apiKeyPrefix = "nfy_"
+
+// APIKey is a key a tenant authenticates with. Only the hash of the key is stored.
+type APIKey struct {
+	ID        int       `json:"id"`
+	Tenant    string    `json:"tenant"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// generateAPIKey creates a random key and its hash
+func generateAPIKey() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("cannot read random bytes: %v", err)
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, hashAPIKey(key), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
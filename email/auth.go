@@ -0,0 +1,73 @@
+package email
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/husainaloos/notfy/logger"
+)
+
+// Authenticator authenticates API keys
+type Authenticator interface {
+	Authenticate(context.Context, string) (APIKey, error)
+}
+
+// NewAPIKeyAuth creates a middleware that authenticates the API key of the
+// request and puts the tenant of the key in the request context. The key is
+// read from the Authorization bearer token or the X-API-Key header.
+func NewAPIKeyAuth(auth Authenticator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.GetLogEntry(r)
+			key := requestAPIKey(r)
+			if key == "" {
+				writeUnauthorized(w, r)
+				log.Debug("request has no api key")
+				return
+			}
+			k, err := auth.Authenticate(r.Context(), key)
+			if err != nil {
+				writeUnauthorized(w, r)
+				if err != ErrInvalidAPIKey {
+					log.Errorf("failed to authenticate api key: %v", err)
+				}
+				return
+			}
+			logger.LogEntrySetFields(r, map[string]interface{}{
+				"tenant":     k.Tenant,
+				"api_key_id": k.ID,
+			})
+			next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), k.Tenant)))
+		})
+	}
+}
+
+// NewAdminAuth creates a middleware that only lets through requests with the
+// admin token as their bearer token, and marks them as made by an admin in the
+// request context. Every request is refused if the token is empty.
+func NewAdminAuth(token string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := requestAPIKey(r)
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				writeUnauthorized(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithAdmin(r.Context())))
+		})
+	}
+}
+
+func requestAPIKey(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+func writeUnauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeErr(w, r, errUnauthorized, http.StatusUnauthorized)
+}
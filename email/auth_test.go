@@ -0,0 +1,74 @@
+package email
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/husainaloos/notfy/messaging"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	api := NewAPI(messaging.NilPublisher{}, NewMemoryStorage())
+	_, key, err := api.CreateAPIKey(context.Background(), "acme", "ci")
+	if err != nil {
+		t.Fatalf("failed to create api key: %v", err)
+	}
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"should refuse requests without a key", "", "", http.StatusUnauthorized},
+		{"should refuse unknown keys", "Authorization", "Bearer nfy_unknown", http.StatusUnauthorized},
+		{"should accept a bearer key", "Authorization", "Bearer " + key, http.StatusOK},
+		{"should accept an X-API-Key header", "X-API-Key", key, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var tenant string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenant, _ = TenantFromContext(r.Context())
+			})
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://localhost/1", nil)
+			if test.header != "" {
+				r.Header.Set(test.header, test.value)
+			}
+			NewAPIKeyAuth(api)(next).ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Fatalf("got status %d, but expected %d", w.Code, test.status)
+			}
+			if w.Code == http.StatusOK && tenant != "acme" {
+				t.Fatalf("got tenant %q, but expected %q", tenant, "acme")
+			}
+		})
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name   string
+		token  string
+		bearer string
+		status int
+	}{
+		{"should refuse everything without a configured token", "", "", http.StatusUnauthorized},
+		{"should refuse a wrong token", "secret", "wrong", http.StatusUnauthorized},
+		{"should accept the token", "secret", "secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://localhost/keys", nil)
+			r.Header.Set("Authorization", "Bearer "+test.bearer)
+			NewAdminAuth(test.token)(next).ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Fatalf("got status %d, but expected %d", w.Code, test.status)
+			}
+		})
+	}
+}
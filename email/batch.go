@@ -0,0 +1,150 @@
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/husainaloos/notfy/messaging"
+	"github.com/husainaloos/notfy/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	batchIDPrefix = "bat_"
+
+	// MaxBatchSize is the most emails a batch can have
+	MaxBatchSize = 5000
+
+	// MaxBatchBytes is the largest batch request in bytes
+	MaxBatchBytes = 32 << 20
+)
+
+// BatchResult is the outcome of queuing one email of a batch. Err is nil if
+// the email was queued.
+type BatchResult struct {
+	Email Email
+	Err   error
+}
+
+// BatchStatus is the number of emails of a batch by their latest status
+type BatchStatus struct {
+	ID       string
+	Total    int
+	Statuses map[Status]int
+}
+
+// QueueBatch stores and publishes the emails as one batch, checking each the
+// same way Queue does. Emails that are refused do not stop the others. The
+// results are in the order of the emails.
+func (api *API) QueueBatch(ctx context.Context, emails []Email) (string, []BatchResult, error) {
+	ctx, span := api.tracer.Start(ctx, "email.QueueBatch", trace.WithAttributes(attribute.Int("batch.size", len(emails))))
+	batch, results, err := api.queueBatch(ctx, emails)
+	span.SetAttributes(attribute.String("batch.id", batch))
+	endSpan(span, err)
+	return batch, results, err
+}
+
+func (api *API) queueBatch(ctx context.Context, emails []Email) (string, []BatchResult, error) {
+	if len(emails) > MaxBatchSize {
+		return "", nil, fmt.Errorf("batch of %d emails is over the limit of %d", len(emails), MaxBatchSize)
+	}
+	batch, err := generateBatchID()
+	if err != nil {
+		return "", nil, err
+	}
+	policy, err := api.loadQueuePolicy(ctx, emails)
+	if err != nil {
+		return "", nil, err
+	}
+	results := make([]BatchResult, len(emails))
+	prepared := make([]Email, 0, len(emails))
+	indexes := make([]int, 0, len(emails))
+	publish := make(map[int]bool)
+	for i, e := range emails {
+		e.SetBatch(batch)
+		e, ok, err := policy.prepare(e, api.suppressionPolicy)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		publish[len(prepared)] = ok
+		prepared = append(prepared, e)
+		indexes = append(indexes, i)
+	}
+	stored, err := api.storage.insertBatch(ctx, prepared)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to insert batch: %v", err)
+	}
+
+	// the emails are published in one batch per priority, to the publisher of
+	// its lane, and carry the trace of the batch to the deamon
+	tc := tracing.Inject(ctx)
+	idx := make(map[Priority][]int)
+	msgs := make(map[Priority][][]byte)
+	for j, e := range stored {
+		i := indexes[j]
+		results[i].Email = e
+		api.statusChanged(e, 0)
+		if !publish[j] {
+			continue
+		}
+		e.traceContext = tc
+		b, err := Marshal(e)
+		if err != nil {
+			results[i].Err = fmt.Errorf("failed to marshal email to protobuffer: %v", err)
+			continue
+		}
+		pr := e.Priority().lane()
+		idx[pr] = append(idx[pr], i)
+		msgs[pr] = append(msgs[pr], b)
+	}
+	for _, pr := range priorities {
+		if len(msgs[pr]) == 0 {
+			continue
+		}
+		_, span := api.tracer.Start(ctx, "email.publish",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(attribute.Int("batch.published", len(idx[pr]))))
+		err := messaging.PublishAll(api.publisherFor(pr), msgs[pr])
+		endSpan(span, err)
+		if err != nil {
+			for _, i := range idx[pr] {
+				results[i].Err = fmt.Errorf("failed to publish email: %v", err)
+			}
+		}
+	}
+	return batch, results, nil
+}
+
+// GetBatchStatus counts the emails of the batch by their latest status. Only
+// the emails of the tenant of the context are counted, or of every tenant for
+// admins.
+func (api *API) GetBatchStatus(ctx context.Context, batch string) (BatchStatus, error) {
+	tenant, err := tenantScope(ctx)
+	if err != nil {
+		return BatchStatus{}, err
+	}
+	counts, err := api.storage.countBatch(ctx, tenant, batch)
+	if err != nil {
+		return BatchStatus{}, fmt.Errorf("failed to count batch: %v", err)
+	}
+	status := BatchStatus{ID: batch, Statuses: counts}
+	for _, n := range counts {
+		status.Total += n
+	}
+	if status.Total == 0 {
+		return BatchStatus{}, ErrItemNotFound
+	}
+	return status, nil
+}
+
+func generateBatchID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot read random bytes: %v", err)
+	}
+	return batchIDPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
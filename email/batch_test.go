@@ -0,0 +1,85 @@
+package email
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/husainaloos/notfy/messaging"
+)
+
+func TestAPIQueueBatch(t *testing.T) {
+	broker := messaging.NewInMemoryBroker()
+	api := NewAPI(broker, NewMemoryStorage())
+	ctx := WithTenant(context.Background(), "acme")
+	api.AddSenderIdentity(ctx, "acme", "example.com")
+	api.AddSuppression(ctx, "acme", "gone@example.net", ReasonBounce, "", time.Time{})
+
+	newEmail := func(from, to string) Email {
+		e, _ := New(0, from, []string{to}, nil, nil, "subject", "body")
+		return e
+	}
+	emails := []Email{
+		newEmail("a@example.com", "one@example.net"),
+		newEmail("ceo@other.com", "two@example.net"),
+		newEmail("a@example.com", "gone@example.net"),
+		newEmail("a@example.com", "three@example.net"),
+	}
+	batch, results, err := api.QueueBatch(ctx, emails)
+	if err != nil {
+		t.Fatalf("failed to queue batch: %v", err)
+	}
+	wantErrs := []error{nil, ErrSenderNotAllowed, nil, nil}
+	for i, res := range results {
+		if res.Err != wantErrs[i] {
+			t.Fatalf("got error %v for email %d, but expected %v", res.Err, i, wantErrs[i])
+		}
+		if res.Err == nil && (res.Email.ID() == 0 || res.Email.Batch() != batch) {
+			t.Fatalf("got email %d with id %d and batch %q, but expected it stored in %q", i, res.Email.ID(), res.Email.Batch(), batch)
+		}
+	}
+	if len(broker.C) != 2 {
+		t.Fatalf("got %d published emails, but expected 2", len(broker.C))
+	}
+
+	status, err := api.GetBatchStatus(ctx, batch)
+	if err != nil {
+		t.Fatalf("failed to get batch status: %v", err)
+	}
+	if status.Total != 3 || status.Statuses[Queued] != 2 || status.Statuses[Suppressed] != 1 {
+		t.Fatalf("got batch status %+v, but expected 2 queued and 1 suppressed", status)
+	}
+	if _, err := api.GetBatchStatus(WithTenant(context.Background(), "globex"), batch); err != ErrItemNotFound {
+		t.Fatalf("got error %v for another tenant, but expected %v", err, ErrItemNotFound)
+	}
+}
+
+func TestAPIQueueBatchLanePublishers(t *testing.T) {
+	broker, high := messaging.NewInMemoryBroker(), messaging.NewInMemoryBroker()
+	api := NewAPI(broker, NewMemoryStorage())
+	api.SetLanePublisher(PriorityHigh, high)
+	var emails []Email
+	for _, p := range []Priority{PriorityLow, PriorityHigh, PriorityNormal, PriorityHigh} {
+		e, _ := New(0, "a@example.com", []string{"b@example.net"}, nil, nil, "subject", "body")
+		e.SetPriority(p)
+		emails = append(emails, e)
+	}
+	if _, _, err := api.QueueBatch(context.Background(), emails); err != nil {
+		t.Fatalf("failed to queue batch: %v", err)
+	}
+	if len(high.C) != 2 || len(broker.C) != 2 {
+		t.Fatalf("got %d emails in the high queue and %d in the default one, but expected 2 and 2", len(high.C), len(broker.C))
+	}
+	for len(high.C) > 0 {
+		if e, _ := Unmarshal(<-high.C); e.Priority() != PriorityHigh {
+			t.Fatalf("got a %v email in the high queue", e.Priority())
+		}
+	}
+}
+
+func TestAPIQueueBatchRefusesLargeBatches(t *testing.T) {
+	api := NewAPI(messaging.NilPublisher{}, NewMemoryStorage())
+	if _, _, err := api.QueueBatch(context.Background(), make([]Email, MaxBatchSize+1)); err == nil {
+		t.Fatal("expected an error for a batch over the limit")
+	}
+}
@@ -0,0 +1,220 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/go-chi/chi"
+	"github.com/husainaloos/notfy/logger"
+)
+
+// postBatchModel is either an array of emails, or a template with the
+// recipients it is sent to
+type postBatchModel struct {
+	Emails     []postEmailModel
+	Template   *postEmailModel       `json:"template"`
+	Recipients []batchRecipientModel `json:"recipients"`
+}
+
+type batchRecipientModel struct {
+	To   []string               `json:"to"`
+	CC   []string               `json:"cc"`
+	BCC  []string               `json:"bcc"`
+	Data map[string]interface{} `json:"data"`
+}
+
+type batchItemModel struct {
+	Index  int          `json:"index"`
+	ID     int          `json:"id,omitempty"`
+	Error  *errModel    `json:"error,omitempty"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+type postBatchResultModel struct {
+	BatchID string           `json:"batch_id"`
+	Results []batchItemModel `json:"results"`
+}
+
+type getBatchModel struct {
+	ID       string         `json:"id"`
+	Total    int            `json:"total"`
+	Statuses map[string]int `json:"statuses"`
+}
+
+func (m *postBatchModel) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		return json.Unmarshal(b, &m.Emails)
+	}
+	type plain postBatchModel
+	return json.Unmarshal(b, (*plain)(m))
+}
+
+// len is the number of emails of the batch
+func (m postBatchModel) len() int {
+	if m.Template != nil {
+		return len(m.Recipients)
+	}
+	return len(m.Emails)
+}
+
+// emails gets the models of the emails of the batch, with the template
+// rendered for every recipient. Items that cannot be rendered get an error.
+func (m postBatchModel) emails() ([]postEmailModel, []error, error) {
+	if m.Template == nil {
+		return m.Emails, make([]error, len(m.Emails)), nil
+	}
+	subject, err := template.New("subject").Option("missingkey=error").Parse(m.Template.Subject)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid subject template: %v", err)
+	}
+	body, err := template.New("body").Option("missingkey=error").Parse(m.Template.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid body template: %v", err)
+	}
+	models := make([]postEmailModel, len(m.Recipients))
+	errs := make([]error, len(m.Recipients))
+	for i, rcpt := range m.Recipients {
+		var sb, bb strings.Builder
+		if err := subject.Execute(&sb, rcpt.Data); err != nil {
+			errs[i] = err
+			continue
+		}
+		if err := body.Execute(&bb, rcpt.Data); err != nil {
+			errs[i] = err
+			continue
+		}
+		models[i] = postEmailModel{
+			From:     m.Template.From,
+			To:       rcpt.To,
+			CC:       rcpt.CC,
+			BCC:      rcpt.BCC,
+			Subject:  sb.String(),
+			Body:     bb.String(),
+			Priority: m.Template.Priority,
+		}
+	}
+	return models, errs, nil
+}
+
+// sendBatchHandler queues every valid email of the batch and answers with the
+// result of each, in the order of the request
+func (h *HTTPHandler) sendBatchHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxBatchBytes))
+	if isTooLarge(err) {
+		writeErr(w, r, errBatchTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		writeErr(w, r, errCannotReadBody, http.StatusInternalServerError)
+		log.Errorf("failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+	var model postBatchModel
+	if err := json.Unmarshal(body, &model); err != nil {
+		writeErr(w, r, errMalformedJSON, http.StatusBadRequest)
+		log.Debugf("failed to unmarshal json: %v", err)
+		return
+	}
+	if model.len() == 0 {
+		writeErr(w, r, errBadRequest(errors.New("batch is empty")), http.StatusBadRequest)
+		return
+	}
+	if model.len() > MaxBatchSize {
+		writeErr(w, r, errBatchTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	models, errs, err := model.emails()
+	if err != nil {
+		writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]batchItemModel, len(models))
+	emails := make([]Email, 0, len(models))
+	indexes := make([]int, 0, len(models))
+	for i, m := range models {
+		results[i].Index = i
+		if errs[i] != nil {
+			results[i].Error = errModelOf(errBadRequest(errs[i]))
+			continue
+		}
+		if verrs := m.validate(); len(verrs) > 0 {
+			results[i].Error = errModelOf(errValidationFailed)
+			results[i].Errors = verrs
+			continue
+		}
+		e, err := New(0, m.From, m.To, m.CC, m.BCC, m.Subject, m.Body)
+		if err != nil {
+			results[i].Error = errModelOf(errBadRequest(err))
+			continue
+		}
+		priority, err := ParsePriority(m.Priority)
+		if err != nil {
+			results[i].Error = errModelOf(errBadRequest(err))
+			continue
+		}
+		e.SetPriority(priority)
+		emails = append(emails, e)
+		indexes = append(indexes, i)
+	}
+
+	batch, queued, err := h.api.QueueBatch(r.Context(), emails)
+	if err != nil {
+		writeErr(w, r, errFailedToQueueEmail, http.StatusInternalServerError)
+		log.Errorf("failed to queue batch: %v", err)
+		return
+	}
+	for j, res := range queued {
+		i := indexes[j]
+		switch res.Err {
+		case nil:
+			results[i].ID = res.Email.ID()
+		case ErrSenderNotAllowed:
+			results[i].Error = errModelOf(errSenderNotAllowed)
+		case ErrRecipientSuppressed:
+			results[i].Error = errModelOf(errRecipientSuppressed)
+		default:
+			results[i].Error = errModelOf(errFailedToQueueEmail)
+			log.WithField("index", i).Errorf("failed to queue email: %v", res.Err)
+		}
+	}
+	log.WithField("batch_id", batch).WithField("batch_size", len(results)).Info("batch queued")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(postBatchResultModel{batch, results})
+}
+
+func (h *HTTPHandler) getBatchHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	status, err := h.api.GetBatchStatus(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if err == ErrItemNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeErr(w, r, errGetEmailFailed, http.StatusInternalServerError)
+		log.Errorf("failed to get batch status: %v", err)
+		return
+	}
+	model := getBatchModel{
+		ID:       status.ID,
+		Total:    status.Total,
+		Statuses: make(map[string]int),
+	}
+	for s, n := range status.Statuses {
+		model.Statuses[s.String()] = n
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(model)
+}
+
+func errModelOf(e errModel) *errModel {
+	return &e
+}
@@ -0,0 +1,183 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ErrNotDSN is returned when a message is not a delivery status notification
+var ErrNotDSN = errors.New("message is not a delivery status notification")
+
+const messageIDPrefix = "notfy."
+
+// DSN is a delivery status notification (RFC 3464)
+type DSN struct {
+	// MessageID is the Message-ID of the original message, if the report has its headers
+	MessageID  string
+	Recipients []RecipientStatus
+}
+
+// RecipientStatus is the delivery status of one recipient of a DSN
+type RecipientStatus struct {
+	Recipient  string `json:"recipient"`
+	Action     string `json:"action"`
+	Status     string `json:"status"`
+	Diagnostic string `json:"diagnostic,omitempty"`
+}
+
+// failed reports whether the message could not be delivered to the recipient
+func (rs RecipientStatus) failed() bool {
+	return strings.EqualFold(rs.Action, "failed")
+}
+
+// permanent reports whether the failure has a 5.x.x status
+func (rs RecipientStatus) permanent() bool {
+	return rs.failed() && strings.HasPrefix(rs.Status, "5")
+}
+
+func (rs RecipientStatus) detail() string {
+	d := "recipient bounced: " + rs.Status
+	if rs.Diagnostic != "" {
+		d += " " + withoutAddress(rs.Diagnostic, rs.Recipient)
+	}
+	return d
+}
+
+// ParseDSN parses a multipart/report message with a delivery-status report
+func ParseDSN(r io.Reader) (DSN, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return DSN{}, fmt.Errorf("cannot read message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return DSN{}, fmt.Errorf("cannot parse content type: %v", err)
+	}
+	if mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "delivery-status") {
+		return DSN{}, ErrNotDSN
+	}
+	var dsn DSN
+	found := false
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return DSN{}, fmt.Errorf("cannot read part: %v", err)
+		}
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status":
+			if dsn.Recipients, err = parseDeliveryStatus(p); err != nil {
+				return DSN{}, err
+			}
+			found = true
+		case "message/rfc822", "text/rfc822-headers":
+			orig, err := textproto.NewReader(bufio.NewReader(p)).ReadMIMEHeader()
+			if err != nil && len(orig) == 0 {
+				return DSN{}, fmt.Errorf("cannot read original headers: %v", err)
+			}
+			dsn.MessageID = strings.TrimSpace(orig.Get("Message-ID"))
+		}
+	}
+	if !found {
+		return DSN{}, ErrNotDSN
+	}
+	return dsn, nil
+}
+
+// parseDeliveryStatus parses the per-message fields followed by the groups of
+// per-recipient fields, separated by blank lines
+func parseDeliveryStatus(r io.Reader) ([]RecipientStatus, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read delivery status: %v", err)
+	}
+	tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+	if _, err := tr.ReadMIMEHeader(); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("cannot read per-message fields: %v", err)
+	}
+	arr := make([]RecipientStatus, 0)
+	for {
+		h, err := tr.ReadMIMEHeader()
+		if len(h) > 0 {
+			arr = append(arr, RecipientStatus{
+				Recipient:  addressOfField(h.Get("Final-Recipient")),
+				Action:     strings.ToLower(strings.TrimSpace(h.Get("Action"))),
+				Status:     strings.TrimSpace(h.Get("Status")),
+				Diagnostic: strings.TrimSpace(h.Get("Diagnostic-Code")),
+			})
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read per-recipient fields: %v", err)
+		}
+	}
+	return arr, nil
+}
+
+// addressOfField gets the address of a field like "rfc822; user@example.com"
+func addressOfField(v string) string {
+	if i := strings.Index(v, ";"); i >= 0 {
+		v = v[i+1:]
+	}
+	return normalizeAddress(strings.Trim(strings.TrimSpace(v), "<>"))
+}
+
+// newMessageID makes a Message-ID that encodes the id of the email, so that
+// bounces can be matched back to it. The id is tagged with an HMAC keyed with
+// key, so that a Message-ID naming another email cannot be made up
+func newMessageID(e Email, key []byte) string {
+	from := e.From()
+	return fmt.Sprintf("<%s%d.%s@%s>", messageIDPrefix, e.ID(), messageIDTag(e.ID(), key), domainOf(from.Address))
+}
+
+// messageIDTag is the HMAC of the id of an email in its Message-ID
+func messageIDTag(id int, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.Itoa(id)))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// emailIDOf gets the id of the email from a Message-ID made by newMessageID
+// with the same key. Nothing is matched without a key.
+func emailIDOf(messageID string, key []byte) (int, bool) {
+	if len(key) == 0 {
+		return 0, false
+	}
+	s := strings.Trim(strings.TrimSpace(messageID), "<>")
+	if !strings.HasPrefix(s, messageIDPrefix) {
+		return 0, false
+	}
+	s = s[len(messageIDPrefix):]
+	i := strings.Index(s, ".")
+	j := strings.Index(s, "@")
+	if i < 0 || j < i {
+		return 0, false
+	}
+	id, err := strconv.Atoi(s[:i])
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	if !hmac.Equal([]byte(s[i+1:j]), []byte(messageIDTag(id, key))) {
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,148 @@
+package email
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/husainaloos/notfy/messaging"
+)
+
+func TestParseDSN(t *testing.T) {
+	f, err := os.Open(filepath.Join(testFolder, "dsn.eml"))
+	if err != nil {
+		t.Fatalf("failed to open fixture: %v", err)
+	}
+	defer f.Close()
+	dsn, err := ParseDSN(f)
+	if err != nil {
+		t.Fatalf("failed to parse dsn: %v", err)
+	}
+	if dsn.MessageID != "<notfy.1.f91263c3113d9824@acme.com>" {
+		t.Fatalf("got message id %q", dsn.MessageID)
+	}
+	want := []struct {
+		recipient string
+		action    string
+		status    string
+		permanent bool
+	}{
+		{"gone@example.net", "failed", "5.1.1", true},
+		{"full@example.net", "failed", "4.2.2", false},
+		{"late@example.net", "delayed", "4.4.1", false},
+	}
+	if len(dsn.Recipients) != len(want) {
+		t.Fatalf("got %d recipients, but expected %d", len(dsn.Recipients), len(want))
+	}
+	for i, w := range want {
+		got := dsn.Recipients[i]
+		if got.Recipient != w.recipient || got.Action != w.action || got.Status != w.status || got.permanent() != w.permanent {
+			t.Fatalf("got recipient %+v, but expected %+v", got, w)
+		}
+	}
+	if !strings.Contains(dsn.Recipients[0].Diagnostic, "User unknown") {
+		t.Fatalf("got diagnostic %q, but expected the folded line to be kept", dsn.Recipients[0].Diagnostic)
+	}
+}
+
+func TestParseDSNRejectsOtherMessages(t *testing.T) {
+	msg := "From: a@example.com\r\nContent-Type: text/plain\r\n\r\nhello\r\n"
+	if _, err := ParseDSN(strings.NewReader(msg)); err != ErrNotDSN {
+		t.Fatalf("got error %v, but expected %v", err, ErrNotDSN)
+	}
+}
+
+var bounceKey = []byte("bounce-key")
+
+func TestEmailIDOf(t *testing.T) {
+	e, _ := New(42, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	forged, _ := New(43, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	tests := []struct {
+		name      string
+		messageID string
+		key       []byte
+		wantID    int
+		wantOK    bool
+	}{
+		{"should read the id of a generated message id", newMessageID(e, bounceKey), bounceKey, 42, true},
+		{"should ignore message ids signed with another key", newMessageID(forged, []byte("other")), bounceKey, 0, false},
+		{"should ignore message ids with another id", strings.Replace(newMessageID(e, bounceKey), "42", "43", 1), bounceKey, 0, false},
+		{"should ignore message ids without a key", newMessageID(e, nil), nil, 0, false},
+		{"should ignore foreign message ids", "<CAF1234@mail.gmail.com>", bounceKey, 0, false},
+		{"should ignore malformed ids", "<notfy.x.abc@example.com>", bounceKey, 0, false},
+		{"should ignore empty message ids", "", bounceKey, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, ok := emailIDOf(test.messageID, test.key)
+			if id != test.wantID || ok != test.wantOK {
+				t.Fatalf("got (%d, %v), but expected (%d, %v)", id, ok, test.wantID, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestAPIProcessBounce(t *testing.T) {
+	tests := []struct {
+		name           string
+		to             []string
+		wantBounced    int
+		wantSuppressed []string
+	}{
+		{"should record the failed recipients", []string{"gone@example.net", "full@example.net", "late@example.net"}, 2, []string{"gone@example.net"}},
+		{"should ignore the recipients that are not of the email", []string{"full@example.net", "other@example.net"}, 1, nil},
+		{"should ignore a dsn of another email", nil, 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := WithAdmin(context.Background())
+			api := NewAPI(messaging.NilPublisher{}, NewMemoryStorage())
+			api.SetMessageIDKey(bounceKey)
+			api.AddSenderIdentity(ctx, "acme", "acme.com")
+			var queued Email
+			if test.to != nil {
+				e, _ := New(0, "alerts@acme.com", test.to, nil, nil, "subject", "body")
+				var err error
+				queued, err = api.Queue(WithTenant(context.Background(), "acme"), e)
+				if err != nil {
+					t.Fatalf("failed to queue email: %v", err)
+				}
+			}
+			f, err := os.Open(filepath.Join(testFolder, "dsn.eml"))
+			if err != nil {
+				t.Fatalf("failed to open fixture: %v", err)
+			}
+			defer f.Close()
+			if _, err := api.ProcessBounce(ctx, f); err != nil {
+				t.Fatalf("failed to process bounce: %v", err)
+			}
+
+			if test.to != nil {
+				got, _ := api.Get(ctx, queued.ID())
+				bounced := 0
+				for _, se := range got.StatusHistory() {
+					if se.Status() == Bounced {
+						bounced++
+						if strings.Contains(se.Detail(), "@") {
+							t.Fatalf("got detail %q, but expected no address", se.Detail())
+						}
+					}
+				}
+				if bounced != test.wantBounced {
+					t.Fatalf("got %d bounced events, but expected %d", bounced, test.wantBounced)
+				}
+			}
+			sups, _ := api.ListSuppressions(ctx)
+			if len(sups) != len(test.wantSuppressed) {
+				t.Fatalf("got suppressions %v, but expected %v", sups, test.wantSuppressed)
+			}
+			for i, sup := range sups {
+				if sup.Address != test.wantSuppressed[i] || sup.Reason != ReasonBounce || sup.Tenant != "acme" {
+					t.Fatalf("got suppression %+v, but expected %s suppressed for acme", sup, test.wantSuppressed[i])
+				}
+			}
+		})
+	}
+}
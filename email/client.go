@@ -1,6 +1,7 @@
 package email
 
 import (
+	"bytes"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -11,7 +12,9 @@ import (
 )
 
 type Client struct {
-	smtpc *smtp.Client
+	smtpc        *smtp.Client
+	dkim         *dkimSigner
+	messageIDKey []byte
 }
 
 func NewClient(addr string, username, password string) (*Client, error) {
@@ -53,7 +56,7 @@ func (c *Client) Send(e Email) error {
 		maddr := r.String()
 		to = append(to, maddr)
 		if err := c.smtpc.Rcpt(maddr); err != nil {
-			return err
+			return &RecipientError{r.Address, err}
 		}
 	}
 	cc := make([]string, 0)
@@ -62,7 +65,7 @@ func (c *Client) Send(e Email) error {
 		maddr := r.String()
 		cc = append(cc, maddr)
 		if err := c.smtpc.Rcpt(maddr); err != nil {
-			return err
+			return &RecipientError{r.Address, err}
 		}
 	}
 	bcc := make([]string, 0)
@@ -71,15 +74,29 @@ func (c *Client) Send(e Email) error {
 		maddr := r.String()
 		bcc = append(bcc, maddr)
 		if err := c.smtpc.Rcpt(maddr); err != nil {
-			return err
+			return &RecipientError{r.Address, err}
 		}
 	}
 	logrus.Debug("building message body")
+	msg, err := c.compose(e, from, to, cc, bcc)
+	if err != nil {
+		return err
+	}
 	wc, err := c.smtpc.Data()
 	if err != nil {
 		return err
 	}
+	wc.Write(msg)
+	return wc.Close()
+}
+
+// compose builds the message of the email and signs it if a DKIM key is
+// configured for the domain of the sender
+func (c *Client) compose(e Email, from string, to, cc, bcc []string) ([]byte, error) {
 	sb := strings.Builder{}
+	sb.WriteString("Message-ID: ")
+	sb.WriteString(newMessageID(e, c.messageIDKey))
+	sb.WriteString("\r\n")
 	sb.WriteString("From: ")
 	sb.WriteString(from)
 	sb.WriteString("\r\n")
@@ -96,8 +113,34 @@ func (c *Client) Send(e Email) error {
 	sb.WriteString(e.Subject())
 	sb.WriteString("\r\n\r\n")
 	sb.WriteString(e.Body())
-	wc.Write([]byte(sb.String()))
-	return wc.Close()
+	// the message is sent with CRLF line endings, so it is signed with them
+	msg := toCRLF([]byte(sb.String()))
+	if c.dkim == nil {
+		return msg, nil
+	}
+	maddr := e.From()
+	signed, err := c.dkim.sign(domainOf(maddr.Address), msg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign message with dkim: %v", err)
+	}
+	return signed, nil
+}
+
+// toCRLF ends every line of the message with CRLF, as the DATA writer does
+func toCRLF(msg []byte) []byte {
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+}
+
+// Noop checks that the connection to the server is alive
+func (c *Client) Noop() error {
+	return c.smtpc.Noop()
+}
+
+// Reset aborts the transaction in progress, so that the connection can send
+// the next email
+func (c *Client) Reset() error {
+	return c.smtpc.Reset()
 }
 
 func (c *Client) Close() error {
@@ -0,0 +1,441 @@
+package email
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStorageConformance runs the tests every Storage has to pass. newStorage
+// returns an empty storage.
+func testStorageConformance(t *testing.T, newStorage func(t *testing.T) Storage) {
+	// the times are kept to the microsecond, which every backend can store
+	at := time.Date(2006, 1, 2, 15, 4, 5, 123456000, time.UTC)
+	newEmail := func(t *testing.T) Email {
+		e, err := New(0, "myself@myself.com", []string{"to@to.com"}, []string{"cc@cc.com", "cc2@cc.com"}, nil, "subject", "body")
+		if err != nil {
+			t.Fatalf("failed to create email: %v", err)
+		}
+		e.SetTenant("acme")
+		e.SetBatch("batch")
+		e.SetPriority(PriorityHigh)
+		e.AddStatusEvent(MakeStatusEvent(Queued, at))
+		return e
+	}
+	insert := func(t *testing.T, s Storage, e Email) Email {
+		e, err := s.insert(context.Background(), e)
+		if err != nil {
+			t.Fatalf("failed to insert email: %v", err)
+		}
+		return e
+	}
+	get := func(t *testing.T, s Storage, id int) Email {
+		e, ok, err := s.get(context.Background(), id)
+		if err != nil || !ok {
+			t.Fatalf("failed to get email %d: ok=%t, err=%v", id, ok, err)
+		}
+		return e
+	}
+
+	t.Run("insert", func(t *testing.T) {
+		s := newStorage(t)
+		e := newEmail(t)
+		got := insert(t, s, e)
+		if got.ID() <= 0 {
+			t.Fatalf("email id is %d, but expected it to be greater than 0", got.ID())
+		}
+		e.SetID(got.ID())
+		e.version = 1
+		if !reflect.DeepEqual(got, e) {
+			t.Fatalf("got %v, but expected %v", got, e)
+		}
+		if other := insert(t, s, newEmail(t)); other.ID() <= got.ID() {
+			t.Fatalf("got id %d after id %d, but expected the ids to increase", other.ID(), got.ID())
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		s := newStorage(t)
+		e := insert(t, s, newEmail(t))
+		if got := get(t, s, e.ID()); !reflect.DeepEqual(got, e) {
+			t.Fatalf("got %v, but expected %v", got, e)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s := newStorage(t)
+		e := insert(t, s, newEmail(t))
+		ctx := context.Background()
+		if _, ok, err := s.get(ctx, e.ID()+1); ok || err != nil {
+			t.Fatalf("get: ok=%t, err=%v, but expected the email to be missing", ok, err)
+		}
+		missing := e
+		missing.SetID(e.ID() + 1)
+		if _, ok, err := s.update(ctx, missing); ok || err != nil {
+			t.Fatalf("update: ok=%t, err=%v, but expected the email to be missing", ok, err)
+		}
+		if _, ok, err := s.appendStatusEvents(ctx, e.ID()+1, []StatusEvent{MakeStatusEvent(Dead, at)}); ok || err != nil {
+			t.Fatalf("appendStatusEvents: ok=%t, err=%v, but expected the email to be missing", ok, err)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		s := newStorage(t)
+		stored := insert(t, s, newEmail(t))
+		e := stored
+		e.SetTenant("other")
+		e.SetPriority(PriorityLow)
+		e.AddStatusEvent(MakeStatusEventWithDetail(SentSuccessfully, at.Add(time.Second), "250 ok"))
+		got, ok, err := s.update(context.Background(), e)
+		if err != nil || !ok {
+			t.Fatalf("failed to update email: ok=%t, err=%v", ok, err)
+		}
+		e.version = stored.version + 1
+		if !reflect.DeepEqual(got, e) {
+			t.Fatalf("got %v, but expected %v", got, e)
+		}
+		if got := get(t, s, e.ID()); !reflect.DeepEqual(got, e) {
+			t.Fatalf("got %v, but expected %v", got, e)
+		}
+
+		// the version read first is stale now
+		stored.AddStatusEvent(MakeStatusEvent(Dead, at))
+		if _, ok, err := s.update(context.Background(), stored); !ok || err != ErrVersionConflict {
+			t.Fatalf("got ok=%t, err=%v, but expected %v", ok, err, ErrVersionConflict)
+		}
+		if got := get(t, s, e.ID()); !reflect.DeepEqual(got, e) {
+			t.Fatalf("got %v after the conflict, but expected %v", got, e)
+		}
+	})
+
+	t.Run("concurrent updates", func(t *testing.T) {
+		s := newStorage(t)
+		e := insert(t, s, newEmail(t))
+		const workers, each = 4, 10
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx := context.Background()
+				for i := 0; i < each; {
+					v, _, err := s.get(ctx, e.ID())
+					if err != nil {
+						errs <- err
+						return
+					}
+					v.AddStatusEvent(MakeStatusEvent(FailedAttemptToSend, at))
+					_, _, err = s.update(ctx, v)
+					if err == ErrVersionConflict {
+						continue
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+					i++
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("failed to update email: %v", err)
+		}
+		got := get(t, s, e.ID())
+		// every update is kept, none overwrites another
+		if n := len(got.StatusHistory()); n != 1+workers*each || got.Version() != 1+workers*each {
+			t.Fatalf("got %d events at version %d, but expected %d", n, got.Version(), 1+workers*each)
+		}
+	})
+
+	t.Run("concurrent appends", func(t *testing.T) {
+		s := newStorage(t)
+		e := insert(t, s, newEmail(t))
+		const workers, each = 4, 10
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*each)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < each; i++ {
+					if _, _, err := s.appendStatusEvents(context.Background(), e.ID(), []StatusEvent{MakeStatusEvent(FailedAttemptToSend, at)}); err != nil {
+						errs <- err
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("failed to append status events: %v", err)
+		}
+		got := get(t, s, e.ID())
+		if n := len(got.StatusHistory()); n != 1+workers*each || got.Version() != 1+workers*each {
+			t.Fatalf("got %d events at version %d, but expected %d", n, got.Version(), 1+workers*each)
+		}
+	})
+
+	t.Run("status history", func(t *testing.T) {
+		s := newStorage(t)
+		e := newEmail(t)
+		// the events keep their order, even when they are at the same time
+		e.AddStatusEvent(MakeStatusEventWithDetail(FailedAttemptToSend, at, "421 try later"))
+		e.AddStatusEvent(MakeStatusEvent(FailedAttemptToSend, at.Add(time.Microsecond)))
+		e = insert(t, s, e)
+		want := e.StatusHistory()
+		if got := get(t, s, e.ID()).StatusHistory(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v after insert, but expected %v", got, want)
+		}
+
+		e.AddStatusEvent(MakeStatusEvent(SentSuccessfully, at.Add(time.Hour)))
+		e, _, err := s.update(context.Background(), e)
+		if err != nil {
+			t.Fatalf("failed to update email: %v", err)
+		}
+		want = e.StatusHistory()
+		if got := get(t, s, e.ID()).StatusHistory(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v after update, but expected %v", got, want)
+		}
+
+		events := []StatusEvent{
+			MakeStatusEventWithDetail(Bounced, at.Add(48*time.Hour+time.Microsecond), "550 5.1.1 no such user"),
+			MakeStatusEvent(Suppressed, time.Date(2038, 1, 19, 3, 14, 8, 999999000, time.UTC)),
+		}
+		if _, _, err := s.appendStatusEvents(context.Background(), e.ID(), events); err != nil {
+			t.Fatalf("failed to append status events: %v", err)
+		}
+		want = append(want, events...)
+		if got := get(t, s, e.ID()).StatusHistory(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v after appendStatusEvents, but expected %v", got, want)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		s := newStorage(t)
+		var ids []int
+		for _, tenant := range []string{"acme", "other", "acme", "acme"} {
+			e := newEmail(t)
+			e.SetTenant(tenant)
+			ids = append(ids, insert(t, s, e).ID())
+		}
+		got, err := s.list(context.Background(), "acme", ids[0], 10)
+		if err != nil {
+			t.Fatalf("failed to list emails: %v", err)
+		}
+		var gotIDs []int
+		for _, e := range got {
+			gotIDs = append(gotIDs, e.ID())
+		}
+		if want := []int{ids[2], ids[3]}; !reflect.DeepEqual(gotIDs, want) {
+			t.Fatalf("got emails %v, but expected %v", gotIDs, want)
+		}
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		s := newStorage(t)
+		ctx := context.Background()
+		var ids []int
+		for _, status := range []Status{SentSuccessfully, Queued, Bounced, FailedAttemptToSend, Dead} {
+			e := newEmail(t)
+			e.AddStatusEvent(MakeStatusEvent(status, at.Add(time.Hour)))
+			ids = append(ids, insert(t, s, e).ID())
+		}
+		late := newEmail(t)
+		late.AddStatusEvent(MakeStatusEventWithDetail(FailedAttemptToSend, at.Add(2*time.Hour), "451 try again"))
+		late.AddStatusEvent(MakeStatusEvent(SentSuccessfully, at.Add(3*time.Hour)))
+		late = insert(t, s, late)
+		// the status appended is the current one
+		if _, _, err := s.appendStatusEvents(ctx, ids[3], []StatusEvent{MakeStatusEvent(Dead, at.Add(time.Hour))}); err != nil {
+			t.Fatalf("failed to append status events: %v", err)
+		}
+
+		listExpired := func(t *testing.T, policy RetentionPolicy, after, limit int) []expiredEmail {
+			got, err := s.listExpired(ctx, policy.cutoffs(at.Add(2*time.Hour)), after, limit)
+			if err != nil {
+				t.Fatalf("failed to list emails: %v", err)
+			}
+			return got
+		}
+		expiredIDs := func(emails []expiredEmail) []int {
+			var arr []int
+			for _, e := range emails {
+				arr = append(arr, e.id)
+			}
+			return arr
+		}
+		got := listExpired(t, RetentionPolicy{{DeleteAfter: time.Hour}}, ids[0], 2)
+		if want := []int{ids[2], ids[3]}; !reflect.DeepEqual(expiredIDs(got), want) {
+			t.Fatalf("got emails %v, but expected %v", expiredIDs(got), want)
+		}
+		if e := got[0]; e.tenant != "acme" || e.status != Bounced || !e.at.Equal(at.Add(time.Hour)) || e.redacted {
+			t.Fatalf("got %+v, but expected the bounced email", e)
+		}
+		// the emails of a more specific rule are left to it
+		got = listExpired(t, RetentionPolicy{{DeleteAfter: time.Hour}, {Statuses: []Status{Bounced}}}, 0, 10)
+		if want := []int{ids[0], ids[3], ids[4]}; !reflect.DeepEqual(expiredIDs(got), want) {
+			t.Fatalf("got emails %v, but expected %v", expiredIDs(got), want)
+		}
+
+		if n, err := s.redact(ctx, []int{ids[0], ids[1]}); n != 2 || err != nil {
+			t.Fatalf("redacted %d emails (%v), but expected 2", n, err)
+		}
+		// the emails redacted already are not counted again
+		if n, err := s.redact(ctx, []int{ids[0], late.ID()}); n != 1 || err != nil {
+			t.Fatalf("redacted %d emails (%v), but expected 1", n, err)
+		}
+		// the emails redacted already are not due for a redaction
+		got = listExpired(t, RetentionPolicy{{RedactAfter: time.Hour}}, 0, 10)
+		if want := []int{ids[2], ids[3], ids[4]}; !reflect.DeepEqual(expiredIDs(got), want) {
+			t.Fatalf("got emails %v, but expected %v", expiredIDs(got), want)
+		}
+		if h := get(t, s, late.ID()).StatusHistory(); len(h) != 3 || h[1].Detail() != "" || h[1].Status() != FailedAttemptToSend {
+			t.Fatalf("got history %v, but expected the details to be redacted", h)
+		}
+		redacted := get(t, s, ids[0])
+		if redacted.Subject() != "" || redacted.Body() != "" || redacted.Version() != 2 {
+			t.Fatalf("got %q, %q at version %d, but expected the email to be redacted", redacted.Subject(), redacted.Body(), redacted.Version())
+		}
+		if len(redacted.To()) != 1 || len(redacted.StatusHistory()) != 2 {
+			t.Fatalf("got %v, but expected only the subject and the body to be redacted", redacted)
+		}
+
+		if n, err := s.deleteEmails(ctx, []int{ids[0], ids[2], ids[2] + 1000}); n != 2 || err != nil {
+			t.Fatalf("deleted %d emails (%v), but expected 2", n, err)
+		}
+		for _, id := range []int{ids[0], ids[2]} {
+			if _, ok, err := s.get(ctx, id); ok || err != nil {
+				t.Fatalf("got ok=%t, err=%v for deleted email %d", ok, err, id)
+			}
+		}
+		if got, _ := s.list(ctx, "", 0, 10); len(got) != 4 {
+			t.Fatalf("got %d emails after the delete, but expected 4", len(got))
+		}
+	})
+
+	t.Run("suppressions", func(t *testing.T) {
+		s := newStorage(t)
+		ctx := context.Background()
+		global := Suppression{Address: "a@example.com", Reason: ReasonBounce, CreatedAt: at}
+		acme := Suppression{Tenant: "acme", Address: "b@example.com", Reason: ReasonBounce, CreatedAt: at}
+		globex := Suppression{Tenant: "globex", Address: "b@example.com", Reason: ReasonBounce, CreatedAt: at}
+		for _, sup := range []Suppression{global, acme, globex} {
+			if err := s.upsertSuppression(ctx, sup); err != nil {
+				t.Fatalf("failed to upsert suppression: %v", err)
+			}
+		}
+		got, err := s.getSuppressions(ctx, "acme", []string{"a@example.com", "b@example.com"})
+		if err != nil {
+			t.Fatalf("failed to get suppressions: %v", err)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i].Address < got[j].Address })
+		if want := []Suppression{global, acme}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, but expected %v", got, want)
+		}
+		if ok, err := s.deleteSuppression(ctx, "acme", "a@example.com", ReasonBounce); ok || err != nil {
+			t.Fatalf("got %v, %v, but expected the suppression of every tenant to be kept", ok, err)
+		}
+		if ok, err := s.deleteSuppression(ctx, "acme", "b@example.com", ReasonBounce); !ok || err != nil {
+			t.Fatalf("got %v, %v, but expected the suppression to be deleted", ok, err)
+		}
+		if got, _ := s.getSuppressions(ctx, "globex", []string{"b@example.com"}); !reflect.DeepEqual(got, []Suppression{globex}) {
+			t.Fatalf("got %v, but expected %v", got, []Suppression{globex})
+		}
+	})
+
+	t.Run("webhook retries", func(t *testing.T) {
+		s := newStorage(t)
+		ctx := context.Background()
+		hook, err := s.insertWebhook(ctx, Webhook{Tenant: "acme", URL: "https://example.com", Secret: "secret", CreatedAt: at})
+		if err != nil {
+			t.Fatalf("failed to insert webhook: %v", err)
+		}
+		failed := WebhookDelivery{WebhookID: hook.ID, EmailID: 1, Event: "Queued", Attempt: 1, StatusCode: 500, Error: "failed", At: at,
+			NextAttemptAt: at.Add(time.Minute), payload: []byte(`{"email_id":1}`)}
+		later := failed
+		later.NextAttemptAt = at.Add(time.Hour)
+		delivered := WebhookDelivery{WebhookID: hook.ID, EmailID: 1, Event: "Sent", Attempt: 1, StatusCode: 204, At: at}
+		for i, d := range []WebhookDelivery{later, failed, delivered} {
+			d, err := s.insertWebhookDelivery(ctx, d)
+			if err != nil {
+				t.Fatalf("failed to insert delivery: %v", err)
+			}
+			if i == 1 {
+				failed = d
+			}
+		}
+		due, err := s.listDueWebhookDeliveries(ctx, at.Add(time.Minute), 10)
+		if err != nil {
+			t.Fatalf("failed to list due deliveries: %v", err)
+		}
+		if !reflect.DeepEqual(due, []WebhookDelivery{failed}) {
+			t.Fatalf("got %+v, but expected %+v", due, []WebhookDelivery{failed})
+		}
+		if ok, err := s.claimWebhookDelivery(ctx, failed.ID); !ok || err != nil {
+			t.Fatalf("got %v, %v, but expected the delivery to be claimed", ok, err)
+		}
+		if ok, err := s.claimWebhookDelivery(ctx, failed.ID); ok || err != nil {
+			t.Fatalf("got %v, %v, but expected the delivery to be claimed once", ok, err)
+		}
+		if due, _ := s.listDueWebhookDeliveries(ctx, at.Add(time.Minute), 10); len(due) != 0 {
+			t.Fatalf("got %+v, but expected no due delivery after the claim", due)
+		}
+	})
+
+	t.Run("delete webhook", func(t *testing.T) {
+		s := newStorage(t)
+		ctx := context.Background()
+		hook, _ := s.insertWebhook(ctx, Webhook{Tenant: "acme", URL: "https://example.com", Secret: "secret", CreatedAt: at})
+		other, _ := s.insertWebhook(ctx, Webhook{Tenant: "acme", URL: "https://example.org", Secret: "secret", CreatedAt: at})
+		for _, id := range []int{hook.ID, other.ID} {
+			d := WebhookDelivery{WebhookID: id, EmailID: 1, Event: "Queued", Attempt: 1, StatusCode: 500, At: at,
+				NextAttemptAt: at, payload: []byte(`{"email_id":1}`)}
+			if _, err := s.insertWebhookDelivery(ctx, d); err != nil {
+				t.Fatalf("failed to insert delivery: %v", err)
+			}
+		}
+		if ok, err := s.deleteWebhook(ctx, hook.ID); !ok || err != nil {
+			t.Fatalf("got %v, %v, but expected the webhook to be deleted", ok, err)
+		}
+		if got, _ := s.listWebhookDeliveries(ctx, hook.ID); len(got) != 0 {
+			t.Fatalf("got deliveries %+v, but expected them deleted with their webhook", got)
+		}
+		due, _ := s.listDueWebhookDeliveries(ctx, at, 10)
+		if len(due) != 1 || due[0].WebhookID != other.ID {
+			t.Fatalf("got due deliveries %+v, but expected only the one of webhook %d", due, other.ID)
+		}
+	})
+
+	t.Run("count batch", func(t *testing.T) {
+		s := newStorage(t)
+		for _, status := range []Status{Queued, SentSuccessfully, SentSuccessfully} {
+			e := newEmail(t)
+			e.AddStatusEvent(MakeStatusEvent(status, at))
+			insert(t, s, e)
+		}
+		got, err := s.countBatch(context.Background(), "acme", "batch")
+		if err != nil {
+			t.Fatalf("failed to count batch: %v", err)
+		}
+		if want := map[Status]int{Queued: 1, SentSuccessfully: 2}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, but expected %v", got, want)
+		}
+	})
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) Storage { return NewMemoryStorage() })
+}
+
+func TestSQLiteStorageConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) Storage { return newTestSQLiteStorage(t) })
+}
+
+func TestPostgresStorageConformance(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) Storage { return newTestPostgresStorage(t) })
+}
@@ -1,18 +1,140 @@
 package email
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 	"github.com/husainaloos/notfy/dto"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// SchemaVersion is the version of the envelope written by Marshal. Version
+	// 0 is the bare QueuedEmail written before the envelope.
+	SchemaVersion = 1
+
+	// the encodings of the payload of an envelope
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+
+	messageTypeQueuedEmail = "dto.QueuedEmail"
+)
+
+// ErrUnsupportedMessage is returned when a message was written by a newer
+// version, or is not a queued email
+var ErrUnsupportedMessage = errors.New("unsupported message")
+
+// Marshal wraps the email in an envelope with a protobuf payload
 func Marshal(e Email) ([]byte, error) {
+	return MarshalAs(e, ContentTypeProtobuf)
+}
+
+// MarshalAs wraps the email in an envelope with a payload of the content type
+func MarshalAs(e Email, contentType string) ([]byte, error) {
+	p := toQueuedEmail(e)
+	logrus.Debug("email to queue", p)
+	var payload []byte
+	var err error
+	switch contentType {
+	case ContentTypeProtobuf:
+		payload, err = proto.Marshal(p)
+	case ContentTypeJSON:
+		var buf bytes.Buffer
+		err = (&jsonpb.Marshaler{}).Marshal(&buf, p)
+		payload = buf.Bytes()
+	default:
+		return nil, fmt.Errorf("unknown content type %q", contentType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode email: %v", err)
+	}
+
+	// an email that was received before keeps its message id, so that the
+	// consumers can tell it is a new attempt of the same message
+	id := e.messageID
+	if id == "" {
+		if id, err = generateMessageID(); err != nil {
+			return nil, err
+		}
+	}
+	return proto.Marshal(&dto.Envelope{
+		SchemaVersion: SchemaVersion,
+		MessageId:     id,
+		MessageType:   messageTypeQueuedEmail,
+		ContentType:   contentType,
+		Tenant:        e.Tenant(),
+		Headers:       e.traceContext,
+		CreatedAt:     uint64(time.Now().UnixNano()),
+		Attempt:       uint32(e.attempt + 1),
+		Payload:       payload,
+	})
+}
+
+// Unmarshal reads an email of any schema version up to SchemaVersion, so
+// that the versions can run side by side during a deploy
+func Unmarshal(b []byte) (Email, error) {
+	env := &dto.Envelope{}
+	if err := proto.Unmarshal(b, env); err != nil {
+		return Email{}, err
+	}
+	switch {
+	case env.SchemaVersion == 0:
+		// the fields of a bare email are reserved in the envelope
+		p := &dto.QueuedEmail{}
+		if err := proto.Unmarshal(b, p); err != nil {
+			return Email{}, err
+		}
+		return fromQueuedEmail(p)
+	case env.SchemaVersion > SchemaVersion:
+		return Email{}, fmt.Errorf("%v: schema version %d", ErrUnsupportedMessage, env.SchemaVersion)
+	case env.MessageType != messageTypeQueuedEmail:
+		return Email{}, fmt.Errorf("%v: message type %q", ErrUnsupportedMessage, env.MessageType)
+	}
+
+	p := &dto.QueuedEmail{}
+	switch env.ContentType {
+	case ContentTypeProtobuf:
+		if err := proto.Unmarshal(env.Payload, p); err != nil {
+			return Email{}, err
+		}
+	case ContentTypeJSON:
+		// newer writers may add fields
+		u := jsonpb.Unmarshaler{AllowUnknownFields: true}
+		if err := u.Unmarshal(bytes.NewReader(env.Payload), p); err != nil {
+			return Email{}, err
+		}
+	default:
+		return Email{}, fmt.Errorf("%v: content type %q", ErrUnsupportedMessage, env.ContentType)
+	}
+	e, err := fromQueuedEmail(p)
+	if err != nil {
+		return Email{}, err
+	}
+	if env.Tenant != "" {
+		e.SetTenant(env.Tenant)
+	}
+	if len(env.Headers) > 0 {
+		e.traceContext = env.Headers
+	}
+	e.messageID = env.MessageId
+	e.attempt = int(env.Attempt)
+	return e, nil
+}
+
+func toQueuedEmail(e Email) *dto.QueuedEmail {
 	p := &dto.QueuedEmail{
-		Id:      uint64(e.ID()),
-		Subject: e.Subject(),
-		Body:    e.Body(),
+		Id:       uint64(e.ID()),
+		Subject:  e.Subject(),
+		Body:     e.Body(),
+		Priority: uint32(e.Priority()),
+		Tenant:   e.Tenant(),
+		Batch:    e.Batch(),
 	}
 	from := e.From()
 	to := []string{}
@@ -32,6 +154,7 @@ func Marshal(e Email) ([]byte, error) {
 		s := &dto.StatusEvent{
 			Status: uint32(v.Status()),
 			At:     uint64(v.At().UnixNano()),
+			Detail: v.Detail(),
 		}
 		se = append(se, s)
 	}
@@ -41,26 +164,31 @@ func Marshal(e Email) ([]byte, error) {
 	p.Bcc = bcc
 	p.From = from.String()
 	p.Status = se
-
-	logrus.Debug("email to queue", p)
-	return proto.Marshal(p)
+	return p
 }
 
-func Unmarshal(b []byte) (Email, error) {
-	p := &dto.QueuedEmail{}
-	err := proto.Unmarshal(b, p)
-	if err != nil {
-		return Email{}, err
-	}
+func fromQueuedEmail(p *dto.QueuedEmail) (Email, error) {
 	e, err := New(int(p.Id), p.From, p.To, p.Cc, p.Bcc, p.Subject, p.Body)
 	if err != nil {
 		return Email{}, err
 	}
+	e.SetPriority(Priority(p.Priority))
+	e.SetTenant(p.Tenant)
+	e.SetBatch(p.Batch)
+	e.traceContext = p.TraceContext
 	for _, v := range p.Status {
 		s := Status(v.Status)
 		t := time.Unix(0, int64(v.At))
-		se := MakeStatusEvent(s, t)
+		se := MakeStatusEventWithDetail(s, t, v.Detail)
 		e.AddStatusEvent(se)
 	}
 	return e, nil
 }
+
+func generateMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot read random bytes: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,183 @@
+package email
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/husainaloos/notfy/dto"
+)
+
+var traceparent = map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+
+// fixtureEmail is the email recorded in the message fixtures
+func fixtureEmail() Email {
+	e, _ := New(7, "alerts@example.com", []string{"ada@example.com"}, []string{"cc@example.com"}, nil, "subject", "body")
+	e.SetPriority(PriorityHigh)
+	e.SetTenant("acme")
+	e.SetBatch("bat_fixture")
+	e.AddStatusEvent(MakeStatusEvent(Queued, time.Unix(1700000000, 0)))
+	return e
+}
+
+// recordFixture builds the enveloped message of the schema version and content
+// type as it was written by that version. Messages of version 0 are the bare
+// emails of the first Marshal, which the current code cannot write, so their
+// fixture is never recorded again.
+func recordFixture(t *testing.T, version int, contentType string) []byte {
+	p := toQueuedEmail(fixtureEmail())
+	var payload []byte
+	if contentType == ContentTypeJSON {
+		var buf bytes.Buffer
+		(&jsonpb.Marshaler{}).Marshal(&buf, p)
+		payload = buf.Bytes()
+	} else {
+		payload, _ = proto.Marshal(p)
+	}
+	b, err := proto.Marshal(&dto.Envelope{
+		SchemaVersion: uint32(version),
+		MessageId:     "0123456789abcdef0123456789abcdef",
+		MessageType:   messageTypeQueuedEmail,
+		ContentType:   contentType,
+		Tenant:        "acme",
+		Headers:       traceparent,
+		CreatedAt:     uint64(time.Unix(1700000001, 0).UnixNano()),
+		Attempt:       1,
+		Payload:       payload,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal envelope: %v", err)
+	}
+	return b
+}
+
+func TestUnmarshalFixtures(t *testing.T) {
+	// version 0 only had the fields 1 to 8 of the email
+	v0 := fixtureEmail()
+	v0.SetPriority(PriorityNormal)
+	v0.SetTenant("")
+	v0.SetBatch("")
+	tests := []struct {
+		file         string
+		version      int
+		contentType  string
+		want         Email
+		traceContext map[string]string
+		messageID    string
+	}{
+		{"queued_email_v0.pb", 0, "", v0, nil, ""},
+		{"queued_email_v1.pb", 1, ContentTypeProtobuf, fixtureEmail(), traceparent, "0123456789abcdef0123456789abcdef"},
+		{"queued_email_v1_json.pb", 1, ContentTypeJSON, fixtureEmail(), traceparent, "0123456789abcdef0123456789abcdef"},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			file := path.Join(testFolder, test.file)
+			if *update && test.version > 0 {
+				if err := ioutil.WriteFile(file, recordFixture(t, test.version, test.contentType), 0644); err != nil {
+					t.Fatalf("failed to update fixture: %v", err)
+				}
+			}
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatalf("failed to read fixture: %v", err)
+			}
+			got, err := Unmarshal(b)
+			if err != nil {
+				t.Fatalf("failed to unmarshal: %v", err)
+			}
+			want := test.want
+			if got.ID() != want.ID() || got.Subject() != want.Subject() || got.Priority() != want.Priority() ||
+				got.Tenant() != want.Tenant() || got.Batch() != want.Batch() ||
+				!reflect.DeepEqual(got.StringCC(), want.StringCC()) {
+				t.Fatalf("got email %+v, but expected %+v", got, want)
+			}
+			if h := got.StatusHistory(); len(h) != 1 || h[0].Status() != Queued || !h[0].At().Equal(time.Unix(1700000000, 0)) {
+				t.Fatalf("got history %v, but expected the queued event", h)
+			}
+			if len(got.traceContext) != 0 || len(test.traceContext) != 0 {
+				if !reflect.DeepEqual(got.traceContext, test.traceContext) {
+					t.Fatalf("got trace context %v, but expected %v", got.traceContext, test.traceContext)
+				}
+			}
+			if got.messageID != test.messageID {
+				t.Fatalf("got message id %q, but expected %q", got.messageID, test.messageID)
+			}
+		})
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, contentType := range []string{ContentTypeProtobuf, ContentTypeJSON} {
+		t.Run(contentType, func(t *testing.T) {
+			e := fixtureEmail()
+			e.traceContext = traceparent
+			b, err := MarshalAs(e, contentType)
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+			env := &dto.Envelope{}
+			proto.Unmarshal(b, env)
+			if env.SchemaVersion != SchemaVersion || env.ContentType != contentType || env.Tenant != "acme" ||
+				env.MessageId == "" || env.Attempt != 1 || env.CreatedAt == 0 {
+				t.Fatalf("got envelope %v, but expected the metadata of a first attempt", env)
+			}
+			got, err := Unmarshal(b)
+			if err != nil {
+				t.Fatalf("failed to unmarshal: %v", err)
+			}
+			if got.Subject() != e.Subject() || !reflect.DeepEqual(got.traceContext, traceparent) {
+				t.Fatalf("got email %+v, but expected %+v", got, e)
+			}
+
+			// sending the received email again is another attempt of the message
+			b, _ = Marshal(got)
+			again, _ := Unmarshal(b)
+			if again.messageID != got.messageID || again.attempt != 2 {
+				t.Fatalf("got message %q attempt %d, but expected %q attempt 2", again.messageID, again.attempt, got.messageID)
+			}
+		})
+	}
+}
+
+func TestUnmarshalUnsupported(t *testing.T) {
+	payload, _ := proto.Marshal(toQueuedEmail(fixtureEmail()))
+	tests := []struct {
+		name string
+		env  *dto.Envelope
+	}{
+		{"should refuse a newer schema version", &dto.Envelope{SchemaVersion: SchemaVersion + 1, MessageType: messageTypeQueuedEmail, ContentType: ContentTypeProtobuf, Payload: payload}},
+		{"should refuse another message type", &dto.Envelope{SchemaVersion: SchemaVersion, MessageType: "dto.Other", ContentType: ContentTypeProtobuf, Payload: payload}},
+		{"should refuse an unknown content type", &dto.Envelope{SchemaVersion: SchemaVersion, MessageType: messageTypeQueuedEmail, ContentType: "text/xml", Payload: payload}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, _ := proto.Marshal(test.env)
+			if _, err := Unmarshal(b); err == nil || !strings.HasPrefix(err.Error(), ErrUnsupportedMessage.Error()) {
+				t.Fatalf("got error %v, but expected %v", err, ErrUnsupportedMessage)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONWithNewerFields(t *testing.T) {
+	payload := `{"id":"7","from":"<alerts@example.com>","to":["<ada@example.com>"],"subject":"subject","addedLater":true}`
+	b, _ := proto.Marshal(&dto.Envelope{
+		SchemaVersion: SchemaVersion,
+		MessageType:   messageTypeQueuedEmail,
+		ContentType:   ContentTypeJSON,
+		Payload:       []byte(payload),
+	})
+	e, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if e.ID() != 7 || e.Subject() != "subject" {
+		t.Fatalf("got email %+v, but expected email 7", e)
+	}
+}
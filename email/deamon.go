@@ -2,11 +2,20 @@ package email
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/husainaloos/notfy/messaging"
+	"github.com/husainaloos/notfy/metrics"
+	"github.com/husainaloos/notfy/tracing"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type DeamonConfig struct {
@@ -14,39 +23,104 @@ type DeamonConfig struct {
 	SMTPUsername        string
 	SMTPPassword        string
 	SMTPConnectionCount int
+
+	// LaneWeights is the share of the connections each priority gets while
+	// lanes compete. Priorities without a weight use the default weights.
+	LaneWeights map[Priority]int
+
+	// ReservedConnections is the number of SMTP connections that only high
+	// priority emails may use
+	ReservedConnections int
+
+	// LaneCapacity is the number of emails each lane holds before the deamon
+	// stops consuming the emails of its priority. The consumers do not
+	// acknowledge the emails, so the emails held in the lanes are lost if the
+	// deamon stops before it sends them. It defaults to 1000.
+	LaneCapacity int
+
+	// RateLimits delays emails that would exceed the outbound limits
+	RateLimits RateLimitConfig
+
+	// DKIMKeys signs the emails of the sender domains they are configured for
+	DKIMKeys []DKIMKey
+
+	// MessageIDKey signs the ids of the emails in their Message-IDs, so that
+	// bounces can be matched back to them. The API needs the same key.
+	MessageIDKey []byte
 }
 
+// maxSendAttempts is the number of times an email is tried before it is dead
+const maxSendAttempts = 5
+
+// clientRetryDelay is the time between the attempts at creating a client
+const clientRetryDelay = time.Second
+
 type Deamon struct {
 	consumers                []messaging.Subscriber
 	storage                  Storage
 	addr, username, password string
 	nclients                 int
 	clients                  chan *Client
+	lanes                    *laneScheduler
+	shared, reserved         chan struct{}
+	limiter                  *rateLimiter
+	dkim                     *dkimSigner
+	messageIDKey             []byte
+	webhooks                 *WebhookNotifier
+	stream                   *StatusStream
+	metrics                  metrics.Metrics
+	tracer                   trace.Tracer
+	inUse, waiters           int64
+	connected                int64
 }
 
+// NewDeamon creates a deamon sending the emails of the consumers. Each consumer
+// is consumed on its own, so that the queue of every priority can have its own
+// consumer.
 func NewDeamon(consumers []messaging.Subscriber, storage Storage, cfg DeamonConfig) *Deamon {
 	clients := make(chan *Client, cfg.SMTPConnectionCount)
+
+	// at least one connection is left for the shared lanes
+	reserved := cfg.ReservedConnections
+	if reserved > cfg.SMTPConnectionCount-1 {
+		reserved = cfg.SMTPConnectionCount - 1
+	}
+	if reserved < 0 {
+		reserved = 0
+	}
 	d := &Deamon{
-		consumers: consumers,
-		storage:   storage,
-		addr:      cfg.SMTPAddr,
-		username:  cfg.SMTPUsername,
-		password:  cfg.SMTPPassword,
-		nclients:  cfg.SMTPConnectionCount,
-		clients:   clients,
+		consumers:    consumers,
+		storage:      storage,
+		addr:         cfg.SMTPAddr,
+		username:     cfg.SMTPUsername,
+		password:     cfg.SMTPPassword,
+		nclients:     cfg.SMTPConnectionCount,
+		clients:      clients,
+		lanes:        newLaneScheduler(cfg.LaneWeights, cfg.LaneCapacity),
+		shared:       make(chan struct{}, cfg.SMTPConnectionCount-reserved),
+		reserved:     make(chan struct{}, reserved),
+		limiter:      newRateLimiter(cfg.RateLimits, cfg.SMTPAddr),
+		messageIDKey: cfg.MessageIDKey,
+		metrics:      metrics.Nop{},
+		tracer:       otel.Tracer(tracerName),
+	}
+	if len(cfg.DKIMKeys) > 0 {
+		d.dkim = newDKIMSigner(cfg.DKIMKeys)
 	}
 
 	// generate the smtp clients
 	go func(d *Deamon) {
 		created := 0
 		for created < d.nclients {
-			c, err := NewClient(d.addr, d.username, d.password)
+			c, err := d.newClient()
 			if err != nil {
 				logrus.Errorf("cannot create client: %v", err)
 				logrus.Info("retry creating client")
+				time.Sleep(clientRetryDelay)
 				continue
 			}
 			logrus.Debugf("client %d created", created)
+			atomic.AddInt64(&d.connected, 1)
 			d.clients <- c
 			created++
 		}
@@ -57,96 +131,335 @@ func NewDeamon(consumers []messaging.Subscriber, storage Storage, cfg DeamonConf
 
 func (d *Deamon) Start(ctx context.Context) {
 	logrus.Debug("deamon starting")
-	msgC := d.consume(ctx)
-	d.processMessages(ctx, msgC)
+	d.consume()
+	d.processMessages(ctx)
 }
 
-func (d *Deamon) consume(ctx context.Context) chan []byte {
-	msgC := make(chan []byte)
-	sf := func(b []byte) {
-		msgC <- b
+// consume subscribes to every consumer. The emails of a consumer are put in
+// their lanes as they are delivered, so a full lane only holds back the
+// consumers it is fed by: with a queue per priority (see SetLanePublisher of
+// API), a backlog of one priority does not hold back the others.
+func (d *Deamon) consume() {
+	for _, c := range d.consumers {
+		broker := messaging.BrokerOf(c)
+		if err := c.Subscribe(func(b []byte) { d.classify(broker, b) }); err != nil {
+			logrus.Errorf("cannot subscribe: %v", err)
+		}
 	}
+}
+
+// SetWebhookNotifier notifies the webhooks of the tenants when the deamon adds
+// status events to their emails
+func (d *Deamon) SetWebhookNotifier(n *WebhookNotifier) {
+	d.webhooks = n
+}
+
+// SetStatusStream publishes the status events the deamon records to the stream
+func (d *Deamon) SetStatusStream(st *StatusStream) {
+	d.stream = st
+}
+
+// SetMetrics records the sending of the emails and the use of the SMTP pool
+func (d *Deamon) SetMetrics(m metrics.Metrics) {
+	d.metrics = m
+}
+
+// SetTracerProvider traces the sending of the emails with the provider instead
+// of the global one
+func (d *Deamon) SetTracerProvider(tp trace.TracerProvider) {
+	d.tracer = tp.Tracer(tracerName)
+}
+
+// ErrNoSMTPClients is returned by Check while the deamon has no SMTP client to send with
+var ErrNoSMTPClients = errors.New("no smtp client is connected")
+
+// Check checks that the deamon is connected to the SMTP server, so that it
+// can send the emails it consumes. An idle client of the pool is sent a NOOP,
+// and is replaced if its connection is lost.
+func (d *Deamon) Check(ctx context.Context) error {
+	if atomic.LoadInt64(&d.connected) == 0 {
+		return ErrNoSMTPClients
+	}
+	var c *Client
+	select {
+	case c = <-d.clients:
+	default:
+		// every client is sending
+		return nil
+	}
+	done := make(chan error, 1)
 	go func() {
-		for _, c := range d.consumers {
-			c.Subscribe(sf)
+		err := c.Noop()
+		if err != nil {
+			d.replaceClient(c)
+		} else {
+			d.clients <- c
 		}
+		done <- err
 	}()
-	return msgC
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("smtp client is not connected: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RateLimits gets the current state of the outbound rate limits
+func (d *Deamon) RateLimits() []LimiterState {
+	return d.limiter.state()
+}
+
+func (d *Deamon) newClient() (*Client, error) {
+	c, err := NewClient(d.addr, d.username, d.password)
+	if err != nil {
+		return nil, err
+	}
+	c.dkim = d.dkim
+	c.messageIDKey = d.messageIDKey
+	return c, nil
+}
+
+// suppress adds the recipient the server permanently refused to the
+// suppression list of the tenant of the email
+func (d *Deamon) suppress(ctx context.Context, email Email, rerr *RecipientError) {
+	sup := Suppression{
+		Tenant:    email.Tenant(),
+		Address:   normalizeAddress(rerr.Address),
+		Reason:    ReasonBounce,
+		Detail:    rerr.Err.Error(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := d.storage.upsertSuppression(ctx, sup); err != nil {
+		logrus.WithField("address", sup.Address).Errorf("failed to suppress address: %v", err)
+		return
+	}
+	logrus.WithField("address", sup.Address).Info("address suppressed after permanent failure")
 }
 
 // get a client from the client pool
 func (d *Deamon) getClient() *Client {
-	return <-d.clients
+	atomic.AddInt64(&d.waiters, 1)
+	d.observePool()
+	c := <-d.clients
+	atomic.AddInt64(&d.waiters, -1)
+	atomic.AddInt64(&d.inUse, 1)
+	d.observePool()
+	return c
 }
 
 // put the client back to the pool
 func (d *Deamon) putClient(c *Client) {
+	atomic.AddInt64(&d.inUse, -1)
+	d.observePool()
 	d.clients <- c
 }
 
-// rebuild the client
+func (d *Deamon) observePool() {
+	d.metrics.SetPool(int(atomic.LoadInt64(&d.inUse)), int(atomic.LoadInt64(&d.waiters)))
+}
+
+// rebuild the client. The client is replaced by a new one in the pool, and
+// the next client of the pool is returned.
 func (d *Deamon) recycleClient(c *Client) *Client {
+	d.replaceClient(c)
+	return <-d.clients
+}
+
+// replaceClient closes the client, and puts a new one in the pool once it is
+// connected
+func (d *Deamon) replaceClient(c *Client) {
+	c.Close()
+	atomic.AddInt64(&d.connected, -1)
 	go func() {
 		for {
-			newC, err := NewClient(d.addr, d.username, d.password)
+			newC, err := d.newClient()
 			if err != nil {
 				logrus.Errorf("cannot create client: %v", err)
+				time.Sleep(clientRetryDelay)
 				continue
 			}
+			atomic.AddInt64(&d.connected, 1)
 			d.clients <- newC
+			return
 		}
 	}()
-	c.Close()
-	return <-d.clients
 }
 
-func (d *Deamon) processMessages(ctx context.Context, msgC chan []byte) {
+// classify puts the email of the message in the lane of its priority, and
+// blocks while the lane is full
+func (d *Deamon) classify(broker string, b []byte) {
+	logrus.WithField("msg_size", len(b)).Debug("message about to be send")
+	email, err := Unmarshal(b)
+	if err != nil {
+		logrus.WithField("broker", broker).Errorf("cannot parse email: %v", err)
+		d.metrics.CountBrokerError(broker, "consume")
+		return
+	}
+	d.lanes.push(email)
+}
+
+func (d *Deamon) processMessages(ctx context.Context) {
 	logrus.Debug("email sending routine started")
 	var wg sync.WaitGroup
-	for msg := range msgC {
-		logrus.WithField("msg_size", len(msg)).Debug("message about to be send")
-		wg.Add(1)
+	var dispatchers sync.WaitGroup
+	dispatchers.Add(2)
+	go func() {
+		defer dispatchers.Done()
+		d.dispatch(ctx, &wg, d.shared, d.lanes.pop)
+	}()
+	go func() {
+		defer dispatchers.Done()
+		if cap(d.reserved) == 0 {
+			return
+		}
+		d.dispatch(ctx, &wg, d.reserved, func() (Email, bool) {
+			return d.lanes.popPriority(PriorityHigh)
+		})
+	}()
+	dispatchers.Wait()
+	wg.Wait()
+}
+
+// dispatch sends the emails returned by next, using at most as many clients at
+// once as the capacity of slots. A slot is taken before the next email is
+// picked, so the lanes are weighed when a client can actually serve them.
+func (d *Deamon) dispatch(ctx context.Context, wg *sync.WaitGroup, slots chan struct{}, next func() (Email, bool)) {
+	for {
+		slots <- struct{}{}
+		email, ok := next()
+		if !ok {
+			<-slots
+			return
+		}
+		if delay := d.limiter.reserve(email); delay > 0 {
+			// throttled emails go back to their lane instead of holding a client
+			logrus.WithFields(logrus.Fields{
+				"email_id": email.ID(),
+				"delay":    delay,
+			}).Debug("email throttled")
+			d.lanes.pushAfter(email, delay)
+			<-slots
+			continue
+		}
 		c := d.getClient()
-		go func(msg []byte, c *Client) {
+		wg.Add(1)
+		go func(email Email, c *Client) {
 			defer wg.Done()
-			email, err := Unmarshal(msg)
-			if err != nil {
-				logrus.Errorf("cannot parse email: %v", err)
-				return
-			}
-			logger := logrus.WithField("email_id", email.ID())
-			logger.Info("email received")
-			emailSent := false
-			for i := 0; i < 5 && !emailSent; i++ {
-				countLogger := logger.WithField("retry_count", i+1)
-				countLogger.Debug("trying to send email")
-				if err := c.Send(email); err != nil {
-					countLogger.Errorf("failed to send email: %v", err)
-					email.AddStatusEvent(MakeStatusEvent(FailedAttemptToSend, time.Now()))
-					time.Sleep(5 * time.Second)
-					c = d.recycleClient(c)
-					continue
-				}
-				countLogger.Info("email sent")
-				emailSent = true
+			defer func() { <-slots }()
+			d.send(ctx, email, c)
+		}(email, c)
+	}
+}
 
+func (d *Deamon) send(ctx context.Context, email Email, c *Client) {
+	logger := logrus.WithFields(logrus.Fields{
+		"email_id":   email.ID(),
+		"priority":   email.Priority(),
+		"message_id": email.messageID,
+		"attempt":    email.attempt,
+	})
+	logger.Info("email received")
+	// the span continues the trace of the request that queued the email
+	ctx, span := d.tracer.Start(tracing.Extract(ctx, email.traceContext), "email.send",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.Int("email.id", email.ID()),
+			attribute.String("email.priority", email.Priority().String()),
+		))
+	defer span.End()
+	from := len(email.StatusHistory())
+	emailSent := false
+	// the email was let through by the rate limiter when it was dispatched
+	reserved := true
+	var throttled time.Duration
+	for email.sendAttempts < maxSendAttempts && !emailSent {
+		countLogger := logger.WithField("retry_count", email.sendAttempts+1)
+		if !reserved {
+			if throttled = d.limiter.reserve(email); throttled > 0 {
+				countLogger.WithField("delay", throttled).Debug("retry throttled")
+				break
 			}
-			if emailSent {
-				email.AddStatusEvent(MakeStatusEvent(SentSuccessfully, time.Now()))
-			} else {
-				logger.Error("email is dead")
-				email.AddStatusEvent(MakeStatusEvent(Dead, time.Now()))
+		}
+		reserved = false
+		email.sendAttempts++
+		countLogger.Debug("trying to send email")
+		start := time.Now()
+		_, attempt := d.tracer.Start(ctx, "smtp.send", trace.WithAttributes(attribute.Int("smtp.attempt", email.sendAttempts)))
+		err := c.Send(email)
+		endSpan(attempt, err)
+		d.metrics.ObserveSend(time.Since(start), err)
+		if err != nil {
+			countLogger.Errorf("failed to send email: %v", err)
+			detail := err.Error()
+			rerr, isRecipientErr := err.(*RecipientError)
+			if isRecipientErr {
+				detail = rerr.detail()
 			}
-			d.putClient(c)
-			_, ok, err := d.storage.update(ctx, email)
-			if err != nil {
-				logger.Errorf("failed to update email: %v", err)
-			} else if !ok {
-				logger.Errorf("email to update does not exist")
-			} else {
-				logger.Debug("email updated successfully")
+			email.AddStatusEvent(MakeStatusEventWithDetail(FailedAttemptToSend, time.Now(), detail))
+			if isRecipientErr && rerr.permanent() {
+				// the recipient will never be accepted, so it is suppressed
+				// and the email is retried right away without it. The
+				// connection is still good, only the transaction is aborted.
+				d.suppress(ctx, email, rerr)
+				email = email.withoutRecipients([]string{rerr.Address})
+				if err := c.Reset(); err != nil {
+					countLogger.Errorf("failed to reset client: %v", err)
+					c = d.recycleClient(c)
+				}
+				if len(email.recipients()) == 0 {
+					break
+				}
+				continue
 			}
-		}(msg, c)
+			time.Sleep(5 * time.Second)
+			c = d.recycleClient(c)
+			continue
+		}
+		countLogger.Info("email sent")
+		emailSent = true
+
+	}
+	d.putClient(c)
+	if throttled > 0 {
+		// the throttled retry goes back to its lane instead of holding a
+		// client, and the failed attempts are recorded meanwhile
+		span.SetAttributes(attribute.Bool("email.throttled", true))
+		d.record(ctx, logger, email, from)
+		d.lanes.pushAfter(email, throttled)
+		return
+	}
+	if emailSent {
+		email.AddStatusEvent(MakeStatusEvent(SentSuccessfully, time.Now()))
+	} else {
+		logger.Error("email is dead")
+		span.SetStatus(codes.Error, "email is dead")
+		email.AddStatusEvent(MakeStatusEvent(Dead, time.Now()))
+	}
+	span.SetAttributes(attribute.Int("smtp.attempts", email.sendAttempts))
+	d.metrics.ObserveAttempts(email.sendAttempts)
+	d.record(ctx, logger, email, from)
+}
+
+// record appends the status events the email got since from to the stored
+// email, and notifies the observers of the email
+func (d *Deamon) record(ctx context.Context, logger *logrus.Entry, email Email, from int) {
+	// only the events are written, so that the events added to the email
+	// meanwhile, e.g. by a bounce, are kept
+	events := email.StatusHistory()[from:]
+	stored, ok, err := d.storage.appendStatusEvents(ctx, email.ID(), events)
+	if err != nil {
+		logger.Errorf("failed to update email: %v", err)
+	} else if !ok {
+		logger.Errorf("email to update does not exist")
+	} else {
+		logger.Debug("email updated successfully")
+		from = len(stored.StatusHistory()) - len(events)
+		countStatuses(d.metrics, stored, from)
+		d.webhooks.notify(stored, from)
+		d.stream.publish(stored, from)
 	}
-	wg.Wait()
 }
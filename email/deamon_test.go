@@ -0,0 +1,130 @@
+package email
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/husainaloos/notfy/messaging"
+	"github.com/husainaloos/notfy/metrics"
+	"go.opentelemetry.io/otel"
+)
+
+func TestDeamonSendRefusedRecipient(t *testing.T) {
+	storage := NewMemoryStorage()
+	api := NewAPI(messaging.NilPublisher{}, storage)
+	ctx := WithTenant(context.Background(), "acme")
+	api.AddSenderIdentity(ctx, "acme", "example.com")
+	e, _ := New(0, "from@example.com", []string{"gone@example.com", "to@example.com"}, nil, nil, "subject", "body")
+	queued, err := api.Queue(ctx, e)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	d := &Deamon{
+		storage: storage,
+		clients: make(chan *Client, 1),
+		limiter: newRateLimiter(RateLimitConfig{}, ""),
+		metrics: metrics.Nop{},
+		tracer:  otel.Tracer(tracerName),
+	}
+	c := fakeSMTPClient(t, "gone@example.com")
+	d.send(context.Background(), queued, c)
+
+	// the connection is reset and sends the email without the recipient,
+	// instead of being replaced
+	if got := <-d.clients; got != c {
+		t.Fatal("got another client back in the pool, but expected the client to be reused")
+	}
+	stored, _, _ := storage.get(context.Background(), queued.ID())
+	h := stored.StatusHistory()
+	if last := h[len(h)-1].Status(); last != SentSuccessfully {
+		t.Fatalf("got status %v, but expected %v", last, SentSuccessfully)
+	}
+	sups, _ := storage.getSuppressions(context.Background(), "acme", []string{"gone@example.com"})
+	if len(sups) != 1 || sups[0].Tenant != "acme" {
+		t.Fatalf("got suppressions %v, but expected gone@example.com suppressed for acme", sups)
+	}
+	if sups, _ := storage.getSuppressions(context.Background(), "globex", []string{"gone@example.com"}); len(sups) != 0 {
+		t.Fatalf("got suppressions %v for globex, but expected none", sups)
+	}
+}
+
+func TestDeamonCheck(t *testing.T) {
+	d := &Deamon{clients: make(chan *Client, 1)}
+	if err := d.Check(context.Background()); err != ErrNoSMTPClients {
+		t.Fatalf("got error %v before connecting, but expected %v", err, ErrNoSMTPClients)
+	}
+
+	c := fakeSMTPClient(t)
+	d.connected = 1
+	d.clients <- c
+	if err := d.Check(context.Background()); err != nil {
+		t.Fatalf("got error %v, but expected no error", err)
+	}
+	if got := <-d.clients; got != c {
+		t.Fatal("got another client back in the pool, but expected the idle client")
+	}
+
+	// every client is sending
+	if err := d.Check(context.Background()); err != nil {
+		t.Fatalf("got error %v while the clients are in use, but expected no error", err)
+	}
+
+	// the connection of the idle client is lost
+	c.smtpc.Quit()
+	d.clients <- c
+	if err := d.Check(context.Background()); err == nil {
+		t.Fatal("got no error after the connection is lost")
+	}
+	if err := d.Check(context.Background()); err != ErrNoSMTPClients {
+		t.Fatalf("got error %v after the client is closed, but expected %v", err, ErrNoSMTPClients)
+	}
+}
+
+func TestDeamonConsumesLanesIndependently(t *testing.T) {
+	low, high := messaging.NewInMemoryPubSub(), messaging.NewInMemoryPubSub()
+	d := &Deamon{
+		consumers: []messaging.Subscriber{low, high},
+		lanes:     newLaneScheduler(nil, 1),
+		metrics:   metrics.Nop{},
+	}
+	d.consume()
+	api := NewAPI(messaging.NilPublisher{}, NewMemoryStorage())
+	api.SetLanePublisher(PriorityLow, low)
+	api.SetLanePublisher(PriorityHigh, high)
+	queue := func(p Priority) <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+			e.SetPriority(p)
+			if _, err := api.Queue(context.Background(), e); err != nil {
+				t.Errorf("failed to queue email: %v", err)
+			}
+		}()
+		return done
+	}
+
+	// the low lane is full, so its consumer waits for room
+	<-queue(PriorityLow)
+	blocked := queue(PriorityLow)
+	select {
+	case <-queue(PriorityHigh):
+	case <-time.After(time.Second):
+		t.Fatal("got the high priority email held back by the full low lane")
+	}
+	if e, ok := d.lanes.popPriority(PriorityHigh); !ok || e.Priority() != PriorityHigh {
+		t.Fatalf("got %v (%t), but expected the high priority email", e, ok)
+	}
+	select {
+	case <-blocked:
+		t.Fatal("got a low priority email past the capacity of its lane")
+	default:
+	}
+	d.lanes.pop()
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("got the low priority email still waiting once its lane has room")
+	}
+}
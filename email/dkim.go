@@ -0,0 +1,290 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var defaultDKIMHeaders = []string{"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID", "MIME-Version", "Content-Type"}
+
+// DKIMKey is the key the emails of a sender domain are signed with
+type DKIMKey struct {
+	Domain   string
+	Selector string
+
+	// Signer is either an *rsa.PrivateKey or an ed25519.PrivateKey
+	Signer crypto.Signer
+
+	// Headers are the headers to sign, if the message has them. They default
+	// to From, Reply-To, Subject, Date, To, Cc, Message-ID, MIME-Version and Content-Type.
+	Headers []string
+}
+
+// dkimSigner signs messages with the key of their sender domain, using
+// relaxed/relaxed canonicalization (RFC 6376) and RSA-SHA256 or Ed25519-SHA256 (RFC 8463)
+type dkimSigner struct {
+	keys map[string]DKIMKey
+	now  func() time.Time
+}
+
+func newDKIMSigner(keys []DKIMKey) *dkimSigner {
+	s := &dkimSigner{
+		keys: make(map[string]DKIMKey),
+		now:  time.Now,
+	}
+	for _, k := range keys {
+		s.keys[strings.ToLower(k.Domain)] = k
+	}
+	return s
+}
+
+// sign returns the message with a DKIM-Signature header prepended. Messages
+// of domains without a key are returned as is.
+func (s *dkimSigner) sign(domain string, msg []byte) ([]byte, error) {
+	k, ok := s.keys[strings.ToLower(domain)]
+	if !ok {
+		return msg, nil
+	}
+	var algo string
+	switch k.Signer.(type) {
+	case *rsa.PrivateKey:
+		algo = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algo = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("unsupported dkim key type %T", k.Signer)
+	}
+	headers, body := splitMessage(msg)
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	names := k.Headers
+	if len(names) == 0 {
+		names = defaultDKIMHeaders
+	}
+	signed := make([]string, 0)
+	for _, name := range names {
+		if len(findHeaders(headers, name)) > 0 {
+			signed = append(signed, strings.ToLower(name))
+		}
+	}
+
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		algo, k.Domain, k.Selector, s.now().Unix(), strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+	digest := headerHash(headers, signed, "DKIM-Signature: "+value)
+
+	var sig []byte
+	var err error
+	if algo == "rsa-sha256" {
+		sig, err = k.Signer.Sign(rand.Reader, digest, crypto.SHA256)
+	} else {
+		sig, err = k.Signer.Sign(rand.Reader, digest, crypto.Hash(0))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign message: %v", err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("DKIM-Signature: ")
+	b.WriteString(value)
+	b.WriteString(base64.StdEncoding.EncodeToString(sig))
+	b.WriteString("\r\n")
+	b.Write(msg)
+	return b.Bytes(), nil
+}
+
+// verifyDKIM verifies the first DKIM-Signature of the message, getting the
+// public key of the signing domain and selector with lookup
+func verifyDKIM(msg []byte, lookup func(domain, selector string) (crypto.PublicKey, error)) error {
+	headers, body := splitMessage(msg)
+	sigHeaders := findHeaders(headers, "DKIM-Signature")
+	if len(sigHeaders) == 0 {
+		return errors.New("message has no DKIM-Signature")
+	}
+	raw := sigHeaders[0]
+	tags, err := parseDKIMTags(raw[strings.Index(raw, ":")+1:])
+	if err != nil {
+		return err
+	}
+	if tags["v"] != "1" || tags["c"] != "relaxed/relaxed" {
+		return fmt.Errorf("unsupported dkim version %q or canonicalization %q", tags["v"], tags["c"])
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return errors.New("body hash does not match")
+	}
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("cannot decode signature: %v", err)
+	}
+	key, err := lookup(tags["d"], tags["s"])
+	if err != nil {
+		return fmt.Errorf("cannot get public key: %v", err)
+	}
+
+	// the signature header is hashed without the signature itself
+	signed := strings.Split(tags["h"], ":")
+	digest := headerHash(headers, signed, strings.TrimRight(stripDKIMSignature(raw), "\r\n"))
+
+	switch tags["a"] {
+	case "rsa-sha256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key of type %T cannot verify %s", key, tags["a"])
+		}
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig)
+	case "ed25519-sha256":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("key of type %T cannot verify %s", key, tags["a"])
+		}
+		if !ed25519.Verify(k, digest, sig) {
+			return errors.New("ed25519 signature does not match")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported dkim algorithm %q", tags["a"])
+}
+
+// headerHash hashes the signed headers, picking duplicates from the bottom up,
+// followed by the DKIM-Signature header with an empty signature
+func headerHash(headers []string, signed []string, sigHeader string) []byte {
+	h := sha256.New()
+	used := make(map[string]int)
+	for _, name := range signed {
+		found := findHeaders(headers, name)
+		n := used[strings.ToLower(name)]
+		if n >= len(found) {
+			continue
+		}
+		used[strings.ToLower(name)] = n + 1
+		h.Write([]byte(relaxedHeader(found[len(found)-1-n]) + "\r\n"))
+	}
+	h.Write([]byte(relaxedHeader(sigHeader)))
+	return h.Sum(nil)
+}
+
+// splitMessage splits the message into its headers, each with its folded
+// lines and trailing CRLF, and its body
+func splitMessage(msg []byte) ([]string, []byte) {
+	s := string(msg)
+	var body []byte
+	if i := strings.Index(s, "\r\n\r\n"); i >= 0 {
+		body = msg[i+4:]
+		s = s[:i+2]
+	}
+	headers := make([]string, 0)
+	for _, line := range strings.SplitAfter(s, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1] += line
+			continue
+		}
+		headers = append(headers, line)
+	}
+	return headers, body
+}
+
+// findHeaders gets the headers with the name, in the order of the message
+func findHeaders(headers []string, name string) []string {
+	arr := make([]string, 0)
+	for _, h := range headers {
+		i := strings.Index(h, ":")
+		if i >= 0 && strings.EqualFold(strings.TrimSpace(h[:i]), name) {
+			arr = append(arr, h)
+		}
+	}
+	return arr
+}
+
+// relaxedHeader canonicalizes a header with the relaxed algorithm, without the trailing CRLF
+func relaxedHeader(h string) string {
+	i := strings.Index(h, ":")
+	name := strings.ToLower(strings.TrimSpace(h[:i]))
+	value := strings.NewReplacer("\r\n", "").Replace(h[i+1:])
+	return name + ":" + strings.TrimSpace(compactWSP(value))
+}
+
+// relaxedBody canonicalizes a body with the relaxed algorithm
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(compactWSP(l), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// compactWSP replaces runs of spaces and tabs with a single space
+func compactWSP(s string) string {
+	var b strings.Builder
+	inWSP := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			if !inWSP {
+				b.WriteByte(' ')
+			}
+			inWSP = true
+			continue
+		}
+		inWSP = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func parseDKIMTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.Index(part, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("malformed dkim tag %q", part)
+		}
+		value := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, part[i+1:])
+		tags[strings.TrimSpace(part[:i])] = value
+	}
+	if _, err := strconv.Atoi(tags["v"]); err != nil {
+		return nil, fmt.Errorf("malformed dkim version %q", tags["v"])
+	}
+	return tags, nil
+}
+
+// stripDKIMSignature removes the value of the b= tag of a DKIM-Signature header
+func stripDKIMSignature(h string) string {
+	i := strings.Index(h, ":")
+	parts := strings.Split(h[i+1:], ";")
+	for j, part := range parts {
+		if strings.HasPrefix(strings.TrimLeft(part, " \t\r\n"), "b=") {
+			k := strings.Index(part, "b=")
+			parts[j] = part[:k+2]
+		}
+	}
+	return h[:i+1] + strings.Join(parts, ";")
+}
@@ -0,0 +1,152 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+func TestDKIMSignAndVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+	keys := []DKIMKey{
+		{Domain: "example.com", Selector: "rsa", Signer: rsaKey},
+		{Domain: "example.org", Selector: "ed", Signer: edKey},
+	}
+	lookup := func(domain, selector string) (crypto.PublicKey, error) {
+		switch domain + "/" + selector {
+		case "example.com/rsa":
+			return &rsaKey.PublicKey, nil
+		case "example.org/ed":
+			return edPub, nil
+		}
+		return nil, fmt.Errorf("no key for %s/%s", domain, selector)
+	}
+
+	tests := []struct {
+		name    string
+		from    string
+		tamper  func([]byte) []byte
+		wantErr bool
+	}{
+		{
+			name: "should verify rsa-sha256 signatures",
+			from: "sam@example.com",
+		},
+		{
+			name: "should verify ed25519-sha256 signatures",
+			from: "sam@example.org",
+		},
+		{
+			name: "should verify after whitespace changes in the headers and body",
+			from: "sam@example.com",
+			tamper: func(b []byte) []byte {
+				b = bytes.Replace(b, []byte("Subject:subject"), []byte("Subject:  \tsubject "), 1)
+				return bytes.Replace(b, []byte("line one"), []byte("line   one  "), 1)
+			},
+		},
+		{
+			name: "should verify after blank lines are added at the end of the body",
+			from: "sam@example.org",
+			tamper: func(b []byte) []byte {
+				return append(b, []byte("\r\n\r\n")...)
+			},
+		},
+		{
+			name: "should fail if the body is changed",
+			from: "sam@example.com",
+			tamper: func(b []byte) []byte {
+				return bytes.Replace(b, []byte("line two"), []byte("line 2"), 1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "should fail if a signed header is changed",
+			from: "sam@example.org",
+			tamper: func(b []byte) []byte {
+				return bytes.Replace(b, []byte("Subject:subject"), []byte("Subject:urgent"), 1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "should fail if the sender is changed",
+			from: "sam@example.com",
+			tamper: func(b []byte) []byte {
+				return bytes.Replace(b, []byte("From: <sam@example.com>"), []byte("From: <ceo@example.com>"), 1)
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Client{dkim: newDKIMSigner(keys)}
+			c.dkim.now = func() time.Time { return time.Date(2018, 12, 3, 19, 32, 55, 0, time.UTC) }
+			e, _ := New(1, test.from, []string{"to@example.net"}, nil, nil, "subject", "line one\r\nline two")
+			msg, err := c.compose(e, e.StringFrom(), e.StringTo(), nil, nil)
+			if err != nil {
+				t.Fatalf("failed to compose message: %v", err)
+			}
+			if !bytes.HasPrefix(msg, []byte("DKIM-Signature: ")) {
+				t.Fatalf("message is not signed: %s", msg)
+			}
+			if test.tamper != nil {
+				msg = test.tamper(msg)
+			}
+			err = verifyDKIM(msg, lookup)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("verifyDKIM() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestDKIMVerifiesAfterDATA(t *testing.T) {
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+	lookup := func(domain, selector string) (crypto.PublicKey, error) { return edPub, nil }
+	c := &Client{dkim: newDKIMSigner([]DKIMKey{{Domain: "example.org", Selector: "ed", Signer: edKey}})}
+	e, _ := New(1, "sam@example.org", []string{"to@example.net"}, nil, nil, "subject", "line one\nline two\n")
+	msg, err := c.compose(e, e.StringFrom(), e.StringTo(), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to compose message: %v", err)
+	}
+
+	// the message is written as the DATA of smtp.Client is
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	wc := textproto.NewWriter(bw).DotWriter()
+	wc.Write(msg)
+	wc.Close()
+	bw.Flush()
+	sent := bytes.TrimSuffix(buf.Bytes(), []byte(".\r\n"))
+	if err := verifyDKIM(sent, lookup); err != nil {
+		t.Fatalf("failed to verify the message sent: %v", err)
+	}
+}
+
+func TestDKIMSkipsDomainsWithoutKeys(t *testing.T) {
+	c := &Client{dkim: newDKIMSigner(nil)}
+	e, _ := New(1, "sam@example.com", []string{"to@example.net"}, nil, nil, "subject", "body")
+	msg, err := c.compose(e, e.StringFrom(), e.StringTo(), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to compose message: %v", err)
+	}
+	if bytes.Contains(msg, []byte("DKIM-Signature")) {
+		t.Fatalf("message of a domain without a key is signed: %s", msg)
+	}
+}
@@ -16,7 +16,25 @@ type Email struct {
 	bcc           []*mail.Address
 	subject       string
 	body          string
+	priority      Priority
+	tenant        string
+	batch         string
 	statusHistory StatusHistory
+
+	// version is incremented by the storage on every change of the email
+	version int
+
+	// traceContext carries the trace of the request that queued the email to
+	// the deamon that sends it. It is not stored.
+	traceContext map[string]string
+
+	// messageID and attempt identify the message the email was received in
+	messageID string
+	attempt   int
+
+	// sendAttempts is the number of times the deamon tried to send the email.
+	// It is not stored.
+	sendAttempts int
 }
 
 // ID gets the id of the email
@@ -92,6 +110,22 @@ func (m Email) Body() string {
 	return m.body
 }
 
+// Priority gets the priority of the email
+func (m Email) Priority() Priority             { return m.priority }
+func (m *Email) SetPriority(priority Priority) { m.priority = priority }
+
+// Tenant gets the tenant that owns the email
+func (m Email) Tenant() string           { return m.tenant }
+func (m *Email) SetTenant(tenant string) { m.tenant = tenant }
+
+// Batch gets the id of the batch the email was queued in, if any
+func (m Email) Batch() string          { return m.batch }
+func (m *Email) SetBatch(batch string) { m.batch = batch }
+
+// Version gets the version of the email in the storage. Updates of an older
+// version fail with ErrVersionConflict.
+func (m Email) Version() int { return m.version }
+
 // StatusHistory gets the status history of the email
 func (m Email) StatusHistory() StatusHistory {
 	sh := make(StatusHistory, 0)
@@ -106,6 +140,72 @@ func (m *Email) AddStatusEvent(se StatusEvent) {
 	m.statusHistory = append(m.statusHistory, se)
 }
 
+// clone gets a deep copy of the email, so that changing either one does not
+// change the other
+func (m Email) clone() Email {
+	c := m
+	if m.from != nil {
+		from := *m.from
+		c.from = &from
+	}
+	c.to = cloneAddresses(m.to)
+	c.cc = cloneAddresses(m.cc)
+	c.bcc = cloneAddresses(m.bcc)
+	c.statusHistory = m.StatusHistory()
+	if m.traceContext != nil {
+		c.traceContext = make(map[string]string, len(m.traceContext))
+		for k, v := range m.traceContext {
+			c.traceContext[k] = v
+		}
+	}
+	return c
+}
+
+func cloneAddresses(list []*mail.Address) []*mail.Address {
+	if list == nil {
+		return nil
+	}
+	arr := make([]*mail.Address, len(list))
+	for i, v := range list {
+		a := *v
+		arr[i] = &a
+	}
+	return arr
+}
+
+// withoutRecipients gets a copy of the email without the given addresses in
+// to, cc and bcc
+func (m Email) withoutRecipients(addrs []string) Email {
+	drop := make(map[string]bool)
+	for _, a := range addrs {
+		drop[normalizeAddress(a)] = true
+	}
+	filter := func(list []*mail.Address) []*mail.Address {
+		arr := make([]*mail.Address, 0)
+		for _, v := range list {
+			if !drop[normalizeAddress(v.Address)] {
+				arr = append(arr, v)
+			}
+		}
+		return arr
+	}
+	m.to = filter(m.to)
+	m.cc = filter(m.cc)
+	m.bcc = filter(m.bcc)
+	return m
+}
+
+// recipients gets the addresses of to, cc and bcc
+func (m Email) recipients() []string {
+	arr := make([]string, 0)
+	for _, list := range [][]*mail.Address{m.to, m.cc, m.bcc} {
+		for _, v := range list {
+			arr = append(arr, v.Address)
+		}
+	}
+	return arr
+}
+
 // New creates an email
 func New(id int, from string, to, cc, bcc []string, subject, body string) (Email, error) {
 	if from == "" {
@@ -139,7 +239,16 @@ func New(id int, from string, to, cc, bcc []string, subject, body string) (Email
 	if err != nil {
 		return Email{}, err
 	}
-	return Email{id, f, tos, ccs, bccs, subject, body, make(StatusHistory, 0)}, nil
+	return Email{
+		id:            id,
+		from:          f,
+		to:            tos,
+		cc:            ccs,
+		bcc:           bccs,
+		subject:       subject,
+		body:          body,
+		statusHistory: make(StatusHistory, 0),
+	}, nil
 }
 
 func (e Email) testString() string {
@@ -155,6 +264,8 @@ func (e Email) testString() string {
 		BCC          []string `json:"bcc"`
 		Subject      string   `json:"subject"`
 		Body         string   `json:"body"`
+		Priority     string   `json:"priority"`
+		Tenant       string   `json:"tenant"`
 		StatusEvents []se     `json:"status_events"`
 	}
 
@@ -166,6 +277,8 @@ func (e Email) testString() string {
 		BCC:          e.StringBCC(),
 		Subject:      e.Subject(),
 		Body:         e.Body(),
+		Priority:     e.Priority().String(),
+		Tenant:       e.Tenant(),
 		StatusEvents: []se{},
 	}
 
@@ -0,0 +1,435 @@
+package email
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/husainaloos/notfy/dto"
+	"github.com/husainaloos/notfy/messaging"
+	"github.com/sirupsen/logrus"
+)
+
+// ContentTypeEncrypted is the content type of the envelopes whose payload is
+// encrypted by EncryptPublisher
+const ContentTypeEncrypted = "application/vnd.notfy.encrypted"
+
+// the headers of an encrypted envelope
+const (
+	headerKeyID       = "notfy-key-id"
+	headerDataKey     = "notfy-data-key"
+	headerContentType = "notfy-content-type"
+)
+
+var (
+	// ErrUnknownKey is returned when a data key was encrypted with a key that
+	// is not in the keyring
+	ErrUnknownKey = errors.New("unknown key encryption key")
+
+	// ErrNoKeyring is returned when an encrypted email is read without a keyring
+	ErrNoKeyring = errors.New("the email is encrypted, but there is no keyring")
+)
+
+// KeyEncryptionKey encrypts the data keys that encrypt the emails. Its id is
+// kept with every data key it encrypts, so that it can be rotated.
+type KeyEncryptionKey struct {
+	ID string
+
+	// Key is an AES key of 16, 24 or 32 bytes
+	Key []byte
+}
+
+// Keyring encrypts the data keys with its primary key, and decrypts them with
+// any of its keys, so that the emails encrypted before a rotation can still be
+// read until they are encrypted again
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring of the keys, the first of which is the primary
+func NewKeyring(keys []KeyEncryptionKey) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("the keyring has no key")
+	}
+	k := &Keyring{primary: keys[0].ID, keys: make(map[string]cipher.AEAD)}
+	for _, v := range keys {
+		if v.ID == "" || strings.ContainsAny(v.ID, ":,\n") {
+			return nil, fmt.Errorf("invalid key id %q", v.ID)
+		}
+		if _, ok := k.keys[v.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", v.ID)
+		}
+		aead, err := newAEAD(v.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %v", v.ID, err)
+		}
+		k.keys[v.ID] = aead
+	}
+	return k, nil
+}
+
+// ParseKeyring parses keys written as "id:key", where the key is in base64,
+// separated by commas or lines. The first key is the primary.
+func ParseKeyring(s string) (*Keyring, error) {
+	var keys []KeyEncryptionKey
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid key %q, expected id:key", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(line[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode key %q: %v", line[:i], err)
+		}
+		keys = append(keys, KeyEncryptionKey{line[:i], key})
+	}
+	return NewKeyring(keys)
+}
+
+// LoadKeyring reads the keys of ParseKeyring from a file, one per line
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read keys: %v", err)
+	}
+	return ParseKeyring(string(b))
+}
+
+// PrimaryKeyID gets the id of the key that encrypts the new data keys
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primary
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext after a random nonce
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("cannot read random bytes: %v", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, ciphertext[:n], ciphertext[n:], additionalData)
+}
+
+// newDataKey generates a data key, and encrypts it with the primary key
+func (k *Keyring) newDataKey() (cipher.AEAD, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, fmt.Errorf("cannot read random bytes: %v", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	sealed, err := seal(k.keys[k.primary], key, []byte(k.primary))
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, sealed, nil
+}
+
+// openDataKey decrypts a data key with the key of the id
+func (k *Keyring) openDataKey(keyID string, sealed []byte) (cipher.AEAD, []byte, error) {
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("%v: %q", ErrUnknownKey, keyID)
+	}
+	key, err := open(kek, sealed, []byte(keyID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot decrypt data key: %v", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, key, nil
+}
+
+// sensitiveFields are the fields of an email that are encrypted at rest. The
+// fields are encrypted with the data key, which is encrypted with the key of
+// the key id. They are not encrypted if the key id is empty.
+type sensitiveFields struct {
+	to, cc, bcc   []string
+	subject, body string
+	keyID         string
+	dataKey       []byte
+}
+
+func emailFields(e Email) sensitiveFields {
+	return sensitiveFields{to: e.StringTo(), cc: e.StringCC(), bcc: e.StringBCC(), subject: e.Subject(), body: e.Body()}
+}
+
+// seal encrypts the fields with a new data key. A nil keyring does not
+// encrypt them.
+func (k *Keyring) seal(f sensitiveFields) (sensitiveFields, error) {
+	if k == nil {
+		return f, nil
+	}
+	aead, dataKey, err := k.newDataKey()
+	if err != nil {
+		return sensitiveFields{}, err
+	}
+	return sealFields(aead, f, k.primary, dataKey)
+}
+
+// open decrypts the fields if they are encrypted
+func (k *Keyring) open(f sensitiveFields) (sensitiveFields, error) {
+	if f.keyID == "" {
+		return f, nil
+	}
+	if k == nil {
+		return sensitiveFields{}, ErrNoKeyring
+	}
+	aead, _, err := k.openDataKey(f.keyID, f.dataKey)
+	if err != nil {
+		return sensitiveFields{}, err
+	}
+	fn := func(field, s string) (string, error) {
+		if s == "" {
+			return "", nil
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return "", fmt.Errorf("cannot decode %s: %v", field, err)
+		}
+		plaintext, err := open(aead, b, []byte(field))
+		if err != nil {
+			return "", fmt.Errorf("cannot decrypt %s: %v", field, err)
+		}
+		return string(plaintext), nil
+	}
+	return mapFields(f, fn, "", nil)
+}
+
+// reencrypt encrypts the fields with the primary key. Only the data key is
+// encrypted again if the fields are encrypted with another key. It reports
+// whether the fields changed.
+func (k *Keyring) reencrypt(f sensitiveFields) (sensitiveFields, bool, error) {
+	switch f.keyID {
+	case k.primary:
+		return f, false, nil
+	case "":
+		f, err := k.seal(f)
+		return f, err == nil, err
+	}
+	_, key, err := k.openDataKey(f.keyID, f.dataKey)
+	if err != nil {
+		return sensitiveFields{}, false, err
+	}
+	sealed, err := seal(k.keys[k.primary], key, []byte(k.primary))
+	if err != nil {
+		return sensitiveFields{}, false, err
+	}
+	f.keyID, f.dataKey = k.primary, sealed
+	return f, true, nil
+}
+
+func sealFields(aead cipher.AEAD, f sensitiveFields, keyID string, dataKey []byte) (sensitiveFields, error) {
+	fn := func(field, s string) (string, error) {
+		// the fields redacted stay empty
+		if s == "" {
+			return "", nil
+		}
+		b, err := seal(aead, []byte(s), []byte(field))
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	}
+	return mapFields(f, fn, keyID, dataKey)
+}
+
+// mapFields applies fn to every field, with the name of the field, and sets
+// the key of the result
+func mapFields(f sensitiveFields, fn func(field, s string) (string, error), keyID string, dataKey []byte) (sensitiveFields, error) {
+	r := sensitiveFields{keyID: keyID, dataKey: dataKey}
+	var err error
+	for _, v := range []struct {
+		field string
+		src   []string
+		dst   *[]string
+	}{{"to", f.to, &r.to}, {"cc", f.cc, &r.cc}, {"bcc", f.bcc, &r.bcc}} {
+		*v.dst = make([]string, len(v.src))
+		for i, s := range v.src {
+			if (*v.dst)[i], err = fn(v.field, s); err != nil {
+				return sensitiveFields{}, err
+			}
+		}
+	}
+	if r.subject, err = fn("subject", f.subject); err != nil {
+		return sensitiveFields{}, err
+	}
+	if r.body, err = fn("body", f.body); err != nil {
+		return sensitiveFields{}, err
+	}
+	return r, nil
+}
+
+// reencryptRow is an email read to be encrypted again
+type reencryptRow struct {
+	id, version int
+	fields      sensitiveFields
+}
+
+// EncryptedStorage is a storage that encrypts the emails at rest
+type EncryptedStorage interface {
+	Storage
+
+	// SetKeyring encrypts the emails written from now on with the keyring,
+	// and decrypts the emails read with it
+	SetKeyring(*Keyring)
+
+	// Reencrypt encrypts the emails that are not encrypted with the primary
+	// key, batch by batch, and returns how many it encrypted
+	Reencrypt(ctx context.Context, batchSize int) (int, error)
+}
+
+type encryptPublisher struct {
+	messaging.Publisher
+	keyring *Keyring
+}
+
+// EncryptPublisher encrypts the payload of the envelopes before publishing
+// them, with a new data key each, so that the broker only has the metadata of
+// the emails. The returned publisher is a BatchPublisher.
+func EncryptPublisher(p messaging.Publisher, k *Keyring) messaging.Publisher {
+	return &encryptPublisher{p, k}
+}
+
+func (p *encryptPublisher) Publish(b []byte) error {
+	b, err := p.encrypt(b)
+	if err != nil {
+		return err
+	}
+	return p.Publisher.Publish(b)
+}
+
+// PublishBatch encrypts every envelope, and publishes them in one batch if
+// the publisher it wraps supports it
+func (p *encryptPublisher) PublishBatch(bs [][]byte) error {
+	encrypted := make([][]byte, len(bs))
+	for i, b := range bs {
+		b, err := p.encrypt(b)
+		if err != nil {
+			return err
+		}
+		encrypted[i] = b
+	}
+	return messaging.PublishAll(p.Publisher, encrypted)
+}
+
+// encrypt encrypts the payload of the envelope. Bare emails and envelopes
+// that are already encrypted are returned as they are.
+func (p *encryptPublisher) encrypt(b []byte) ([]byte, error) {
+	env := &dto.Envelope{}
+	if err := proto.Unmarshal(b, env); err != nil {
+		return nil, fmt.Errorf("cannot read envelope: %v", err)
+	}
+	if env.SchemaVersion == 0 || env.ContentType == ContentTypeEncrypted {
+		return b, nil
+	}
+	aead, dataKey, err := p.keyring.newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	// the payload cannot be moved to another envelope
+	payload, err := seal(aead, env.Payload, []byte(env.MessageId))
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string, len(env.Headers)+3)
+	for k, v := range env.Headers {
+		headers[k] = v
+	}
+	headers[headerKeyID] = p.keyring.primary
+	headers[headerDataKey] = base64.StdEncoding.EncodeToString(dataKey)
+	headers[headerContentType] = env.ContentType
+	env.Headers = headers
+	env.ContentType = ContentTypeEncrypted
+	env.Payload = payload
+	b, err = proto.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write envelope: %v", err)
+	}
+	return b, nil
+}
+
+type decryptSubscriber struct {
+	messaging.Subscriber
+	keyring *Keyring
+}
+
+// DecryptSubscriber decrypts the payload of the envelopes encrypted by
+// EncryptPublisher. The other messages are passed as they are.
+func DecryptSubscriber(s messaging.Subscriber, k *Keyring) messaging.Subscriber {
+	return &decryptSubscriber{s, k}
+}
+
+func (s *decryptSubscriber) Subscribe(f messaging.SubscribeFunc) error {
+	return s.Subscriber.Subscribe(func(b []byte) {
+		decrypted, err := s.decrypt(b)
+		if err != nil {
+			// the message is passed on, and is rejected as encrypted
+			logrus.Errorf("cannot decrypt message: %v", err)
+			f(b)
+			return
+		}
+		f(decrypted)
+	})
+}
+
+// Broker gets the name of the broker of the subscriber it wraps
+func (s *decryptSubscriber) Broker() string {
+	return messaging.BrokerOf(s.Subscriber)
+}
+
+func (s *decryptSubscriber) decrypt(b []byte) ([]byte, error) {
+	env := &dto.Envelope{}
+	if err := proto.Unmarshal(b, env); err != nil || env.ContentType != ContentTypeEncrypted {
+		return b, nil
+	}
+	dataKey, err := base64.StdEncoding.DecodeString(env.Headers[headerDataKey])
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode data key: %v", err)
+	}
+	aead, _, err := s.keyring.openDataKey(env.Headers[headerKeyID], dataKey)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := open(aead, env.Payload, []byte(env.MessageId))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt payload: %v", err)
+	}
+	env.ContentType = env.Headers[headerContentType]
+	env.Payload = payload
+	delete(env.Headers, headerKeyID)
+	delete(env.Headers, headerDataKey)
+	delete(env.Headers, headerContentType)
+	return proto.Marshal(env)
+}
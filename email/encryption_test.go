@@ -0,0 +1,288 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/husainaloos/notfy/messaging"
+)
+
+// testKeyring creates a keyring of the ids, with a key derived from each id.
+// The first id is the primary.
+func testKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+	var keys []KeyEncryptionKey
+	for _, id := range ids {
+		keys = append(keys, KeyEncryptionKey{id, bytes.Repeat([]byte(id[:1]), 32)})
+	}
+	k, err := NewKeyring(keys)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+	return k
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+	tests := []struct {
+		desc    string
+		s       string
+		primary string
+		wantErr bool
+	}{
+		{"should parse keys separated by commas", "k2:" + key + ",k1:" + key, "k2", false},
+		{"should parse keys separated by lines", "# keys\nk2:" + key + "\n\nk1:" + key + "\n", "k2", false},
+		{"should fail without a key", "", "", true},
+		{"should fail without an id", key, "", true},
+		{"should fail with a key that is not base64", "k1:not base64", "", true},
+		{"should fail with a key of an invalid size", "k1:" + short, "", true},
+		{"should fail with duplicate ids", "k1:" + key + ",k1:" + key, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			k, err := ParseKeyring(test.s)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("%s: expected error, but got no error", test.desc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s: got error %v, but expected no error", test.desc, err)
+			}
+			if k.PrimaryKeyID() != test.primary {
+				t.Errorf("%s: got primary key %q, but expected %q", test.desc, k.PrimaryKeyID(), test.primary)
+			}
+		})
+	}
+}
+
+func TestKeyringSealOpen(t *testing.T) {
+	k := testKeyring(t, "k1")
+	f := sensitiveFields{to: []string{"<ada@example.com>"}, cc: []string{}, bcc: []string{"<bcc@example.com>"}, subject: "subject"}
+	sealed, err := k.seal(f)
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+	if sealed.keyID != "k1" || sealed.to[0] == f.to[0] || sealed.subject == f.subject {
+		t.Fatalf("got %+v, but expected the fields to be encrypted with k1", sealed)
+	}
+	if sealed.body != "" {
+		t.Fatalf("got body %q, but expected the empty body to stay empty", sealed.body)
+	}
+	opened, err := k.open(sealed)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	if !reflect.DeepEqual(opened, f) {
+		t.Fatalf("got %+v, but expected %+v", opened, f)
+	}
+
+	// a field cannot be moved to another
+	swapped := sealed
+	swapped.body = sealed.subject
+	if _, err := k.open(swapped); err == nil {
+		t.Fatal("opened a subject moved to the body")
+	}
+	if _, err := (*Keyring)(nil).open(sealed); err != ErrNoKeyring {
+		t.Fatalf("got error %v without keyring, but expected %v", err, ErrNoKeyring)
+	}
+	if _, err := testKeyring(t, "k2").open(sealed); err == nil || !strings.Contains(err.Error(), ErrUnknownKey.Error()) {
+		t.Fatalf("got error %v with another keyring, but expected %v", err, ErrUnknownKey)
+	}
+}
+
+func TestEncryptedStorageConformance(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		testStorageConformance(t, func(t *testing.T) Storage {
+			s := newTestSQLiteStorage(t)
+			s.SetKeyring(testKeyring(t, "k1"))
+			return s
+		})
+	})
+	t.Run("postgres", func(t *testing.T) {
+		testStorageConformance(t, func(t *testing.T) Storage {
+			s := newTestPostgresStorage(t)
+			s.SetKeyring(testKeyring(t, "k1"))
+			return s
+		})
+	})
+}
+
+func TestSQLiteStorageEncryption(t *testing.T) {
+	s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "notfy.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	e, _ := New(0, "alerts@example.com", []string{"ada@example.com"}, nil, []string{"bcc@example.com"}, "reset your password", "secret")
+	plain, err := s.insert(ctx, e)
+	if err != nil {
+		t.Fatalf("failed to insert email: %v", err)
+	}
+	s.SetKeyring(testKeyring(t, "k1"))
+	old, err := s.insert(ctx, e)
+	if err != nil {
+		t.Fatalf("failed to insert email: %v", err)
+	}
+
+	var to, subject, body, keyID string
+	row := s.db.QueryRow(`SELECT "to", subject, body, key_id FROM email WHERE email_id = ?`, old.ID())
+	if err := row.Scan(&to, &subject, &body, &keyID); err != nil {
+		t.Fatalf("failed to read email: %v", err)
+	}
+	if strings.Contains(to, "ada@example.com") || subject == e.Subject() || body == e.Body() || keyID != "k1" {
+		t.Fatalf("got to=%s, subject=%q, body=%q, key_id=%q, but expected them to be encrypted with k1", to, subject, body, keyID)
+	}
+	if got, _, err := s.get(ctx, old.ID()); err != nil || !reflect.DeepEqual(got, old) {
+		t.Fatalf("got %v (%v), but expected %v", got, err, old)
+	}
+
+	// the keys are rotated, k1 can still be read
+	s.SetKeyring(testKeyring(t, "k2", "k1"))
+	if got, _, err := s.get(ctx, old.ID()); err != nil || !reflect.DeepEqual(got, old) {
+		t.Fatalf("got %v (%v) after the rotation, but expected %v", got, err, old)
+	}
+	n, err := s.Reencrypt(ctx, 1)
+	if err != nil || n != 2 {
+		t.Fatalf("encrypted %d emails (%v), but expected 2", n, err)
+	}
+	if n, err := s.Reencrypt(ctx, 1); err != nil || n != 0 {
+		t.Fatalf("encrypted %d emails again (%v), but expected none", n, err)
+	}
+
+	// k1 can be removed from the keyring once the emails are encrypted again
+	s.SetKeyring(testKeyring(t, "k2"))
+	for _, want := range []Email{plain, old} {
+		if got, _, err := s.get(ctx, want.ID()); err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v (%v) after reencrypting, but expected %v", got, err, want)
+		}
+	}
+	s.SetKeyring(nil)
+	if _, _, err := s.get(ctx, old.ID()); err == nil {
+		t.Fatal("read an encrypted email without keyring")
+	}
+}
+
+func TestEncryptPublisher(t *testing.T) {
+	k := testKeyring(t, "k1")
+	broker := messaging.NewInMemoryPubSub()
+	var received [][]byte
+	if err := DecryptSubscriber(broker, k).Subscribe(func(b []byte) { received = append(received, b) }); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	var raw [][]byte
+	broker.Subscribe(func(b []byte) { raw = append(raw, b) })
+
+	e := fixtureEmail()
+	e.traceContext = traceparent
+	b, err := Marshal(e)
+	if err != nil {
+		t.Fatalf("failed to marshal email: %v", err)
+	}
+	if err := EncryptPublisher(broker, k).Publish(b); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+	for _, secret := range []string{"ada@example.com", "subject", "body"} {
+		if bytes.Contains(raw[0], []byte(secret)) {
+			t.Fatalf("got %q in the message on the broker", secret)
+		}
+	}
+	if _, err := Unmarshal(raw[0]); err == nil || !strings.Contains(err.Error(), ErrUnsupportedMessage.Error()) {
+		t.Fatalf("got error %v reading the encrypted message, but expected %v", err, ErrUnsupportedMessage)
+	}
+
+	got, err := Unmarshal(received[0])
+	if err != nil {
+		t.Fatalf("failed to unmarshal the decrypted message: %v", err)
+	}
+	want, _ := Unmarshal(b)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, but expected %v", got, want)
+	}
+
+	// the messages of other keys are passed as they are, and the bare emails
+	// written before the envelope are not encrypted
+	if err := EncryptPublisher(broker, testKeyring(t, "k2")).Publish(b); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+	if !bytes.Equal(received[1], raw[1]) {
+		t.Fatal("got a message of an unknown key changed")
+	}
+	v0, err := ioutil.ReadFile(filepath.Join(testFolder, "queued_email_v0.pb"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	if err := EncryptPublisher(broker, k).Publish(v0); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+	if !bytes.Equal(raw[2], v0) || !bytes.Equal(received[2], v0) {
+		t.Fatal("got a bare email changed")
+	}
+}
+
+// batchRecorder records the batches published to it
+type batchRecorder struct {
+	messaging.NilPublisher
+	batches [][][]byte
+}
+
+func (r *batchRecorder) PublishBatch(bs [][]byte) error {
+	r.batches = append(r.batches, bs)
+	return nil
+}
+
+func TestEncryptPublisherBatch(t *testing.T) {
+	k := testKeyring(t, "k1")
+	b, err := Marshal(fixtureEmail())
+	if err != nil {
+		t.Fatalf("failed to marshal email: %v", err)
+	}
+	rec := &batchRecorder{}
+	p := EncryptPublisher(rec, k)
+	if _, ok := p.(messaging.BatchPublisher); !ok {
+		t.Fatal("got a publisher that cannot publish batches")
+	}
+	if err := messaging.PublishAll(p, [][]byte{b, b}); err != nil {
+		t.Fatalf("failed to publish batch: %v", err)
+	}
+	if len(rec.batches) != 1 || len(rec.batches[0]) != 2 {
+		t.Fatalf("got batches %v, but expected one batch of 2 messages", rec.batches)
+	}
+	for _, msg := range rec.batches[0] {
+		if bytes.Contains(msg, []byte("ada@example.com")) {
+			t.Fatal("got a message of the batch that is not encrypted")
+		}
+		decrypted, err := (&decryptSubscriber{keyring: k}).decrypt(msg)
+		if err != nil {
+			t.Fatalf("failed to decrypt message: %v", err)
+		}
+		got, err := Unmarshal(decrypted)
+		if err != nil {
+			t.Fatalf("failed to unmarshal the decrypted message: %v", err)
+		}
+		if want, _ := Unmarshal(b); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, but expected %v", got, want)
+		}
+	}
+	if bytes.Equal(rec.batches[0][0], rec.batches[0][1]) {
+		t.Fatal("got the messages encrypted with the same data key")
+	}
+
+	// the publishers without batches get the messages one by one
+	broker := messaging.NewInMemoryBroker()
+	if err := EncryptPublisher(broker, k).(messaging.BatchPublisher).PublishBatch([][]byte{b, b}); err != nil {
+		t.Fatalf("failed to publish batch: %v", err)
+	}
+	if len(broker.C) != 2 {
+		t.Fatalf("got %d messages, but expected 2", len(broker.C))
+	}
+}
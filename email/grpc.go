@@ -0,0 +1,319 @@
+package email
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/husainaloos/notfy/dto"
+	"github.com/husainaloos/notfy/metrics"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// GRPCAPIInterface is the API the gRPC service is backed by
+type GRPCAPIInterface interface {
+	Queue(context.Context, Email) (Email, error)
+	Get(context.Context, int) (Email, error)
+	List(ctx context.Context, after, limit int) ([]Email, error)
+	Events(ctx context.Context, id int, lastEventID string) (<-chan StatusUpdate, error)
+}
+
+// GRPCService is the EmailService of the gRPC API
+type GRPCService struct {
+	api GRPCAPIInterface
+}
+
+// NewGRPCServer creates a gRPC server of the EmailService backed by the API.
+// The calls are authenticated with the API keys of auth, logged and recorded
+// in m. It should be served on its own port.
+func NewGRPCServer(api GRPCAPIInterface, auth Authenticator, m metrics.Metrics) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			unaryObserveInterceptor(m),
+			unaryAuthInterceptor(auth),
+		),
+		grpc.ChainStreamInterceptor(
+			streamObserveInterceptor(m),
+			streamAuthInterceptor(auth),
+		),
+	)
+	dto.RegisterEmailServiceServer(s, &GRPCService{api})
+	return s
+}
+
+// QueueEmail queues the email. Invalid fields are reported in the BadRequest
+// details of the status.
+func (s *GRPCService) QueueEmail(ctx context.Context, req *dto.QueueEmailRequest) (*dto.Email, error) {
+	model := postEmailModel{
+		From:     req.From,
+		To:       req.To,
+		CC:       req.Cc,
+		BCC:      req.Bcc,
+		Subject:  req.Subject,
+		Body:     req.Body,
+		Priority: req.Priority,
+	}
+	if errs := model.validate(); len(errs) > 0 {
+		return nil, validationStatus(errs)
+	}
+	e, err := New(0, model.From, model.To, model.CC, model.BCC, model.Subject, model.Body)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	priority, _ := ParsePriority(model.Priority)
+	e.SetPriority(priority)
+	e, err = s.api.Queue(ctx, e)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoEmail(e)
+}
+
+// GetEmail gets the email of the tenant
+func (s *GRPCService) GetEmail(ctx context.Context, req *dto.GetEmailRequest) (*dto.Email, error) {
+	e, err := s.api.Get(ctx, int(req.Id))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoEmail(e)
+}
+
+// ListEmails lists the emails of the tenant by id, a page at a time. The page
+// token is the id of the last email of the previous page.
+func (s *GRPCService) ListEmails(ctx context.Context, req *dto.ListEmailsRequest) (*dto.ListEmailsResponse, error) {
+	size := int(req.PageSize)
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+	after := 0
+	if req.PageToken != "" {
+		id, err := strconv.Atoi(req.PageToken)
+		if err != nil || id < 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		after = id
+	}
+	// one more email is listed to know whether there is a next page
+	emails, err := s.api.List(ctx, after, size+1)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &dto.ListEmailsResponse{}
+	if len(emails) > size {
+		emails = emails[:size]
+		res.NextPageToken = strconv.Itoa(emails[size-1].ID())
+	}
+	for _, e := range emails {
+		pe, err := toProtoEmail(e)
+		if err != nil {
+			return nil, err
+		}
+		res.Emails = append(res.Emails, pe)
+	}
+	return res, nil
+}
+
+// WatchEmail streams the status updates of the email until the client goes away
+func (s *GRPCService) WatchEmail(req *dto.WatchEmailRequest, stream dto.EmailService_WatchEmailServer) error {
+	if req.Id <= 0 {
+		return status.Error(codes.InvalidArgument, "id is required")
+	}
+	updates, err := s.api.Events(stream.Context(), int(req.Id), req.LastEventId)
+	if err != nil {
+		return grpcError(err)
+	}
+	for u := range updates {
+		at, err := ptypes.TimestampProto(u.At)
+		if err != nil {
+			return grpcError(err)
+		}
+		err = stream.Send(&dto.StatusUpdate{
+			Id:      u.ID(),
+			EmailId: int64(u.EmailID),
+			Index:   int32(u.Index),
+			Status:  u.Status,
+			At:      at,
+			Detail:  u.Detail,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toProtoEmail(e Email) (*dto.Email, error) {
+	pe := &dto.Email{
+		Id:       int64(e.ID()),
+		From:     e.StringFrom(),
+		To:       e.StringTo(),
+		Cc:       e.StringCC(),
+		Bcc:      e.StringBCC(),
+		Subject:  e.Subject(),
+		Body:     e.Body(),
+		Priority: e.Priority().String(),
+		Batch:    e.Batch(),
+	}
+	for _, se := range e.StatusHistory() {
+		at, err := ptypes.TimestampProto(se.At())
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		pe.History = append(pe.History, &dto.EmailStatus{
+			Status: se.Status().String(),
+			At:     at,
+			Detail: se.Detail(),
+		})
+	}
+	return pe, nil
+}
+
+// grpcInternalMessage is the message of the internal errors the clients get
+const grpcInternalMessage = "internal error"
+
+// grpcError gets the status of an error of the API
+func grpcError(err error) error {
+	switch err {
+	case ErrItemNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case ErrSenderNotAllowed:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case ErrRecipientSuppressed:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case ErrVersionConflict:
+		return status.Error(codes.Aborted, err.Error())
+	case ErrStreamDisabled:
+		return status.Error(codes.Unimplemented, err.Error())
+	case errEmptyTenant:
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	// the other errors are the ones of the storage and the broker, which are
+	// only logged, as the HTTP handlers do
+	logrus.Errorf("api call failed: %v", err)
+	return status.Error(codes.Internal, grpcInternalMessage)
+}
+
+// validationStatus reports every problem with the fields as violations of a
+// BadRequest. The description of a violation starts with its error code.
+func validationStatus(errs validationErrors) error {
+	br := &errdetails.BadRequest{}
+	for _, e := range errs {
+		field := e.Field
+		if e.Index != nil {
+			field += "." + strconv.Itoa(*e.Index)
+		}
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: strconv.Itoa(e.Code) + ": " + e.Message,
+		})
+	}
+	st, err := status.New(codes.InvalidArgument, errs.Error()).WithDetails(br)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, errs.Error())
+	}
+	return st.Err()
+}
+
+// authenticate puts the tenant of the API key of the call in the context. The
+// key is read from the authorization bearer token or the x-api-key metadata.
+func authenticate(ctx context.Context, auth Authenticator) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	key := ""
+	if v := md.Get("authorization"); len(v) > 0 && strings.HasPrefix(v[0], "Bearer ") {
+		key = strings.TrimSpace(strings.TrimPrefix(v[0], "Bearer "))
+	} else if v := md.Get("x-api-key"); len(v) > 0 {
+		key = v[0]
+	}
+	if key == "" {
+		return nil, status.Error(codes.Unauthenticated, "api key is required")
+	}
+	k, err := auth.Authenticate(ctx, key)
+	if err != nil {
+		if err != ErrInvalidAPIKey {
+			logrus.Errorf("failed to authenticate api key: %v", err)
+		}
+		return nil, status.Error(codes.Unauthenticated, errUnauthorized.Message)
+	}
+	return WithTenant(ctx, k.Tenant), nil
+}
+
+func unaryAuthInterceptor(auth Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, auth)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authenticatedStream is a server stream with the context of the tenant
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedStream) Context() context.Context { return s.ctx }
+
+func streamAuthInterceptor(auth Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), auth)
+		if err != nil {
+			return err
+		}
+		return handler(srv, authenticatedStream{ss, ctx})
+	}
+}
+
+// observe logs the call and records it in the metrics
+func observe(m metrics.Metrics, method string, start time.Time, err error) {
+	code := status.Code(err)
+	elapsed := time.Since(start)
+	m.ObserveRPC(method, code.String(), elapsed)
+	log := logrus.WithFields(logrus.Fields{
+		"grpc_method": method,
+		"grpc_code":   code.String(),
+		"elapsed":     float64(elapsed.Nanoseconds()) / 1000000.0,
+	})
+	switch code {
+	case codes.OK:
+		log.Info("call complete")
+	case codes.Internal, codes.Unknown:
+		log.Errorf("call failed: %v", err)
+	default:
+		log.Debugf("call refused: %v", err)
+	}
+}
+
+func unaryObserveInterceptor(m metrics.Metrics) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+		observe(m, info.FullMethod, start, err)
+		return res, err
+	}
+}
+
+func streamObserveInterceptor(m metrics.Metrics) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		observe(m, info.FullMethod, start, err)
+		return err
+	}
+}
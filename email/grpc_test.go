@@ -0,0 +1,270 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/husainaloos/notfy/dto"
+	"github.com/husainaloos/notfy/messaging"
+	"github.com/husainaloos/notfy/metrics"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// rpcMetrics records the calls it is told about
+type rpcMetrics struct {
+	metrics.Nop
+	mu    sync.Mutex
+	calls []string
+}
+
+func (m *rpcMetrics) ObserveRPC(method, code string, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, method+" "+code)
+}
+
+// newGRPCClient serves the gRPC API of a tenant that can send from example.com
+// in memory, and gets a client of it with the API key of the tenant
+func newGRPCClient(t *testing.T, m metrics.Metrics) (dto.EmailServiceClient, *API, *StatusStream, context.Context) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	api := NewAPI(messaging.NilPublisher{}, storage)
+	st, _ := NewStatusStream(messaging.NilPublisher{}, nil, 0)
+	api.SetStatusStream(st)
+	api.AddSenderIdentity(ctx, "acme", "example.com")
+	_, key, err := api.CreateAPIKey(ctx, "acme", "grpc")
+	if err != nil {
+		t.Fatalf("failed to create api key: %v", err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(api, api, m)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+key)
+	return dto.NewEmailServiceClient(conn), api, st, authed
+}
+
+var grpcHello = &dto.QueueEmailRequest{
+	From:    "alerts@example.com",
+	To:      []string{"ada@example.com"},
+	Subject: "hello",
+	Body:    "body",
+}
+
+func TestGRPCAuth(t *testing.T) {
+	c, _, _, _ := newGRPCClient(t, metrics.Nop{})
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{"should refuse a call without a key", ctx},
+		{"should refuse an invalid bearer token", metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer nope")},
+		{"should refuse an invalid x-api-key", metadata.AppendToOutgoingContext(ctx, "x-api-key", "nope")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := c.GetEmail(test.ctx, &dto.GetEmailRequest{Id: 1})
+			if code := status.Code(err); code != codes.Unauthenticated {
+				t.Fatalf("got code %v, but expected %v", code, codes.Unauthenticated)
+			}
+			stream, err := c.WatchEmail(test.ctx, &dto.WatchEmailRequest{Id: 1})
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			if code := status.Code(err); code != codes.Unauthenticated {
+				t.Fatalf("got code %v for the stream, but expected %v", code, codes.Unauthenticated)
+			}
+		})
+	}
+}
+
+func TestGRPCQueueGetAndList(t *testing.T) {
+	m := &rpcMetrics{}
+	c, _, _, ctx := newGRPCClient(t, m)
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		e, err := c.QueueEmail(ctx, grpcHello)
+		if err != nil {
+			t.Fatalf("failed to queue email: %v", err)
+		}
+		if len(e.History) != 1 || e.History[0].Status != "Queued" || e.Priority != "Normal" {
+			t.Fatalf("got email %v, but expected a queued email of normal priority", e)
+		}
+		ids = append(ids, e.Id)
+	}
+
+	got, err := c.GetEmail(ctx, &dto.GetEmailRequest{Id: ids[0]})
+	if err != nil {
+		t.Fatalf("failed to get email: %v", err)
+	}
+	if got.Subject != "hello" || got.From != "<alerts@example.com>" {
+		t.Fatalf("got email %v, but expected the queued email", got)
+	}
+	_, err = c.GetEmail(ctx, &dto.GetEmailRequest{Id: 99})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("got code %v, but expected %v", code, codes.NotFound)
+	}
+
+	// the emails are listed in pages of two
+	var listed []int64
+	token := ""
+	pages := 0
+	for {
+		res, err := c.ListEmails(ctx, &dto.ListEmailsRequest{PageSize: 2, PageToken: token})
+		if err != nil {
+			t.Fatalf("failed to list emails: %v", err)
+		}
+		pages++
+		for _, e := range res.Emails {
+			listed = append(listed, e.Id)
+		}
+		if res.NextPageToken == "" {
+			break
+		}
+		token = res.NextPageToken
+	}
+	if pages != 3 || len(listed) != len(ids) {
+		t.Fatalf("got %v in %d pages, but expected %v in 3 pages", listed, pages, ids)
+	}
+	for i := range ids {
+		if listed[i] != ids[i] {
+			t.Fatalf("got %v, but expected %v", listed, ids)
+		}
+	}
+	_, err = c.ListEmails(ctx, &dto.ListEmailsRequest{PageToken: "garbage"})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Fatalf("got code %v, but expected %v", code, codes.InvalidArgument)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if want := "/dto.EmailService/QueueEmail OK"; m.calls[0] != want {
+		t.Fatalf("got call %q, but expected %q", m.calls[0], want)
+	}
+}
+
+func TestGRPCQueueErrors(t *testing.T) {
+	c, _, _, ctx := newGRPCClient(t, metrics.Nop{})
+	tests := []struct {
+		name   string
+		req    *dto.QueueEmailRequest
+		code   codes.Code
+		fields []string
+	}{
+		{
+			"should report every invalid field",
+			&dto.QueueEmailRequest{To: []string{"ada@example.com", "nope"}, Priority: "urgent"},
+			codes.InvalidArgument,
+			[]string{"from", "to.1", "priority"},
+		},
+		{
+			"should refuse a sender that is not verified",
+			&dto.QueueEmailRequest{From: "a@other.com", To: []string{"ada@example.com"}},
+			codes.PermissionDenied,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := c.QueueEmail(ctx, test.req)
+			st := status.Convert(err)
+			if st.Code() != test.code {
+				t.Fatalf("got code %v, but expected %v", st.Code(), test.code)
+			}
+			var fields []string
+			for _, d := range st.Details() {
+				if br, ok := d.(*errdetails.BadRequest); ok {
+					for _, v := range br.FieldViolations {
+						fields = append(fields, v.Field)
+					}
+				}
+			}
+			if len(fields) != len(test.fields) {
+				t.Fatalf("got violations of %v, but expected %v", fields, test.fields)
+			}
+			for i := range fields {
+				if fields[i] != test.fields[i] {
+					t.Fatalf("got violations of %v, but expected %v", fields, test.fields)
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCWatchEmail(t *testing.T) {
+	c, api, st, ctx := newGRPCClient(t, metrics.Nop{})
+	queued, err := c.QueueEmail(ctx, grpcHello)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.WatchEmail(watchCtx, &dto.WatchEmailRequest{Id: queued.Id})
+	if err != nil {
+		t.Fatalf("failed to watch email: %v", err)
+	}
+	first, err := stream.Recv()
+	if err != nil || first.Status != "Queued" {
+		t.Fatalf("got %v (%v), but expected the queued event first", first, err)
+	}
+
+	e, _, _ := api.storage.get(ctx, int(queued.Id))
+	e.AddStatusEvent(MakeStatusEvent(SentSuccessfully, time.Now()))
+	api.storage.update(ctx, e)
+	st.publish(e, 1)
+	u, err := stream.Recv()
+	if err != nil || u.Status != "SentSuccessfully" || u.Index != 1 {
+		t.Fatalf("got %v (%v), but expected the sent event", u, err)
+	}
+	if u.Id == "" || u.EmailId != queued.Id {
+		t.Fatalf("got update %v, but expected an update of email %d with an id", u, queued.Id)
+	}
+
+	missing, err := c.WatchEmail(ctx, &dto.WatchEmailRequest{Id: queued.Id + 1})
+	if err == nil {
+		_, err = missing.Recv()
+	}
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("got code %v for a missing email, but expected %v", code, codes.NotFound)
+	}
+}
+
+func TestGRPCError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		code    codes.Code
+		message string
+	}{
+		{"should map the errors of the api", ErrItemNotFound, codes.NotFound, ErrItemNotFound.Error()},
+		{"should deny the calls without a tenant", errEmptyTenant, codes.PermissionDenied, errEmptyTenant.Error()},
+		{"should hide the errors of the storage", errors.New("pq: connection refused"), codes.Internal, grpcInternalMessage},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			st := status.Convert(grpcError(test.err))
+			if st.Code() != test.code || st.Message() != test.message {
+				t.Fatalf("got %v %q, but expected %v %q", st.Code(), st.Message(), test.code, test.message)
+			}
+		})
+	}
+}
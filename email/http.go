@@ -3,6 +3,7 @@ package email
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -14,92 +15,194 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	sseRetry     = 3 * time.Second
+	sseHeartbeat = 15 * time.Second
+
+	// maxEmailRequestBytes is the largest request of one email: a body of
+	// MaxBodySize with room for its json escaping and the other fields
+	maxEmailRequestBytes = 2*MaxBodySize + 1<<20
+)
+
 type errModel struct {
 	Message string `json:"message"`
 	Code    int    `json:"code"`
 }
 
 var (
-	errCannotReadBody     = errModel{"cannot ready body", 101}
-	errMalformedJSON      = errModel{"bad json", 102}
-	errBadRequest         = func(e error) errModel { return errModel{fmt.Sprintf("invalid request: %v", e), 103} }
-	errFailedToQueueEmail = errModel{"failed to queue email", 104}
-	errFailedToInitEmail  = errModel{"an error has occured", 105}
-	errStatusCreateFailed = errModel{"failed to create status", 106}
-	errGetEmailFailed     = errModel{"an error has occured", 107}
+	errCannotReadBody      = errModel{"cannot ready body", 101}
+	errMalformedJSON       = errModel{"bad json", 102}
+	errBadRequest          = func(e error) errModel { return errModel{fmt.Sprintf("invalid request: %v", e), 103} }
+	errFailedToQueueEmail  = errModel{"failed to queue email", 104}
+	errFailedToInitEmail   = errModel{"an error has occured", 105}
+	errStatusCreateFailed  = errModel{"failed to create status", 106}
+	errGetEmailFailed      = errModel{"an error has occured", 107}
+	errUnauthorized        = errModel{"unauthorized", 108}
+	errCreateKeyFailed     = errModel{"failed to create api key", 109}
+	errListKeysFailed      = errModel{"failed to list api keys", 110}
+	errRevokeKeyFailed     = errModel{"failed to revoke api key", 111}
+	errSenderNotAllowed    = errModel{"sender is not a verified identity", 112}
+	errIdentityFailed      = errModel{"failed to manage sender identity", 113}
+	errRecipientSuppressed = errModel{"a recipient is suppressed", 114}
+	errSuppressionFailed   = errModel{"failed to manage suppression", 115}
+	errNotDSN              = errModel{"message is not a delivery status notification", 116}
+	errBounceFailed        = errModel{"failed to process bounce", 117}
+	errWebhookFailed       = errModel{"failed to manage webhook", 118}
+	errStreamFailed        = errModel{"failed to stream events", 119}
+	errBatchTooLarge       = errModel{"batch is too large", 120}
+	errValidationFailed    = errModel{"invalid request", 121}
+
+	// the codes of the problems with the fields of a request
+	errFieldRequired     = errModel{"is required", 122}
+	errNoRecipients      = errModel{"email should have at least one recipient", 123}
+	errInvalidAddress    = errModel{"invalid address", 124}
+	errAddressTooLong    = errModel{"address is too long", 125}
+	errTooManyRecipients = errModel{"too many recipients", 126}
+	errSubjectTooLong    = errModel{"subject is too long", 127}
+	errBodyTooLarge      = errModel{"body is too large", 128}
+	errInvalidPriority   = errModel{"invalid priority", 129}
+
+	errIdempotencyInProgress = errModel{"a request with the idempotency key is in progress", 130}
+	errIdempotencyMismatch   = errModel{"idempotency key was used for a different request", 131}
+	errVersionConflict       = errModel{"email was changed since the version was read", 132}
+	errUpdateEmailFailed     = errModel{"failed to update email", 133}
 )
 
 type postEmailModel struct {
-	From    string   `json:"from"`
-	To      []string `json:"to"`
-	CC      []string `json:"cc"`
-	BCC     []string `json:"bcc"`
-	Subject string   `json:"subject"`
-	Body    string   `json:"body"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	CC       []string `json:"cc"`
+	BCC      []string `json:"bcc"`
+	Subject  string   `json:"subject"`
+	Body     string   `json:"body"`
+	Priority string   `json:"priority"`
+}
+
+// putEmailModel replaces the content of the email read at the version
+type putEmailModel struct {
+	postEmailModel
+	Version int `json:"version"`
 }
 
 type getEmailModel struct {
-	ID      int            `json:"id"`
-	From    string         `json:"from"`
-	To      []string       `json:"to"`
-	CC      []string       `json:"cc"`
-	BCC     []string       `json:"bcc"`
-	Subject string         `json:"subject"`
-	Body    string         `json:"body"`
-	History []emailHistory `json:"history"`
+	ID       int            `json:"id"`
+	Version  int            `json:"version"`
+	From     string         `json:"from"`
+	To       []string       `json:"to"`
+	CC       []string       `json:"cc"`
+	BCC      []string       `json:"bcc"`
+	Subject  string         `json:"subject"`
+	Body     string         `json:"body"`
+	Priority string         `json:"priority"`
+	Batch    string         `json:"batch,omitempty"`
+	History  []emailHistory `json:"history"`
 }
 
 type emailHistory struct {
 	Status string    `json:"status"`
 	At     time.Time `json:"at"`
+	Detail string    `json:"detail,omitempty"`
 }
 
 type APIInterface interface {
 	Queue(context.Context, Email) (Email, error)
 	Get(context.Context, int) (Email, error)
+	Update(context.Context, Email) (Email, error)
+	Events(ctx context.Context, id int, lastEventID string) (<-chan StatusUpdate, error)
+	QueueBatch(context.Context, []Email) (string, []BatchResult, error)
+	GetBatchStatus(ctx context.Context, batch string) (BatchStatus, error)
 }
 
 // HTTPHandler is the handler for Email
 type HTTPHandler struct {
-	api APIInterface
+	api         APIInterface
+	auth        Authenticator
+	idempotency IdempotencyStore
 }
 
-// NewHTTPHandler creates a new handler for email reqeusts
-func NewHTTPHandler(emailAPI APIInterface) *HTTPHandler {
-	return &HTTPHandler{emailAPI}
+// NewHTTPHandler creates a new handler for email reqeusts. The requests are
+// authenticated with the API keys of auth, and act on the emails of the
+// tenant of the key.
+func NewHTTPHandler(emailAPI APIInterface, auth Authenticator) *HTTPHandler {
+	return &HTTPHandler{
+		api:         emailAPI,
+		auth:        auth,
+		idempotency: NewMemoryIdempotencyStore(0),
+	}
 }
 
-// Route builds the routing for the email handlers
+// SetIdempotencyStore remembers the idempotency keys in the store instead of
+// the memory of the instance, e.g. to share them between the instances
+func (h *HTTPHandler) SetIdempotencyStore(s IdempotencyStore) {
+	h.idempotency = s
+}
+
+// Route builds the routing for the email handlers. Every route but the
+// OpenAPI document requires an API key.
 func (h *HTTPHandler) Route(r chi.Router) {
-	r.Post("/", h.sendEmailHandler)
-	r.Get("/{id}", h.getEmailHandler)
+	r.Get("/openapi.json", h.openAPIHandler)
+	r.Group(func(r chi.Router) {
+		r.Use(NewAPIKeyAuth(h.auth))
+		r.Post("/", h.idempotent(maxEmailRequestBytes, errBodyTooLarge, h.sendEmailHandler))
+		r.Post("/batch", h.idempotent(MaxBatchBytes, errBatchTooLarge, h.sendBatchHandler))
+		r.Get("/batch/{id}", h.getBatchHandler)
+		r.Get("/events", h.tenantEventsHandler)
+		r.Get("/{id}", h.getEmailHandler)
+		r.Put("/{id}", h.putEmailHandler)
+		r.Get("/{id}/events", h.emailEventsHandler)
+	})
 }
 
 func (h *HTTPHandler) sendEmailHandler(w http.ResponseWriter, r *http.Request) {
 	log := logger.GetLogEntry(r)
-	body, err := ioutil.ReadAll(r.Body)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxEmailRequestBytes))
+	if isTooLarge(err) {
+		writeErr(w, r, errBodyTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
-		h.writeErr(w, r, errCannotReadBody, http.StatusInternalServerError)
+		writeErr(w, r, errCannotReadBody, http.StatusInternalServerError)
 		log.Errorf("failed to read request body: %v", err)
 		return
 	}
 	defer r.Body.Close()
 	var model postEmailModel
 	if err := json.Unmarshal(body, &model); err != nil {
-		h.writeErr(w, r, errMalformedJSON, http.StatusBadRequest)
+		writeErr(w, r, errMalformedJSON, http.StatusBadRequest)
 		log.Debugf("failed to unmarshal json: %v", err)
 		return
 	}
+	if errs := model.validate(); len(errs) > 0 {
+		writeValidationErr(w, r, errs)
+		log.Debugf("email is invalid: %v", errs)
+		return
+	}
 	e, err := New(0, model.From, model.To, model.CC, model.BCC, model.Subject, model.Body)
 	if err != nil {
-		h.writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
+		writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
 		log.Debugf("failed to create email due to validation: %v", err)
 		return
 	}
+	priority, err := ParsePriority(model.Priority)
+	if err != nil {
+		writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
+		log.Debugf("failed to parse priority: %v", err)
+		return
+	}
+	e.SetPriority(priority)
 	e, err = h.api.Queue(r.Context(), e)
 	if err != nil {
-		h.writeErr(w, r, errFailedToQueueEmail, http.StatusInternalServerError)
-		log.Errorf("failed to queue email: %v", err)
+		switch err {
+		case ErrSenderNotAllowed:
+			writeErr(w, r, errSenderNotAllowed, http.StatusForbidden)
+			log.WithField("email_from", model.From).Debug("sender is not allowed")
+		case ErrRecipientSuppressed:
+			writeErr(w, r, errRecipientSuppressed, http.StatusUnprocessableEntity)
+			log.Debug("email has suppressed recipients")
+		default:
+			writeErr(w, r, errFailedToQueueEmail, http.StatusInternalServerError)
+			log.Errorf("failed to queue email: %v", err)
+		}
 		return
 	}
 	log.WithFields(logrus.Fields{
@@ -128,7 +231,7 @@ func (h *HTTPHandler) getEmailHandler(w http.ResponseWriter, r *http.Request) {
 			log.WithField("id", id).Debugf("email not found")
 			return
 		default:
-			h.writeErr(w, r, errGetEmailFailed, http.StatusInternalServerError)
+			writeErr(w, r, errGetEmailFailed, http.StatusInternalServerError)
 			log.Errorf("failed to retreive email: %v", err)
 			return
 		}
@@ -139,11 +242,145 @@ func (h *HTTPHandler) getEmailHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(model)
 }
 
+// putEmailHandler replaces the content of the email. The request carries the
+// version the email was read at, and is refused with a conflict if the email
+// changed since.
+func (h *HTTPHandler) putEmailHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxEmailRequestBytes))
+	if isTooLarge(err) {
+		writeErr(w, r, errBodyTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		writeErr(w, r, errCannotReadBody, http.StatusInternalServerError)
+		log.Errorf("failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+	var model putEmailModel
+	if err := json.Unmarshal(body, &model); err != nil {
+		writeErr(w, r, errMalformedJSON, http.StatusBadRequest)
+		log.Debugf("failed to unmarshal json: %v", err)
+		return
+	}
+	errs := model.validate()
+	if model.Version <= 0 {
+		errs.add("version", nil, errFieldRequired, "")
+	}
+	if len(errs) > 0 {
+		writeValidationErr(w, r, errs)
+		log.Debugf("email is invalid: %v", errs)
+		return
+	}
+	e, err := New(id, model.From, model.To, model.CC, model.BCC, model.Subject, model.Body)
+	if err != nil {
+		writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
+		log.Debugf("failed to create email due to validation: %v", err)
+		return
+	}
+	priority, _ := ParsePriority(model.Priority)
+	e.SetPriority(priority)
+	e.version = model.Version
+	e, err = h.api.Update(r.Context(), e)
+	if err != nil {
+		switch err {
+		case ErrItemNotFound:
+			w.WriteHeader(http.StatusNotFound)
+		case ErrVersionConflict:
+			writeErr(w, r, errVersionConflict, http.StatusConflict)
+			log.WithField("id", id).Debug("email was changed since it was read")
+		case ErrSenderNotAllowed:
+			writeErr(w, r, errSenderNotAllowed, http.StatusForbidden)
+			log.WithField("email_from", model.From).Debug("sender is not allowed")
+		default:
+			writeErr(w, r, errUpdateEmailFailed, http.StatusInternalServerError)
+			log.Errorf("failed to update email: %v", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.buildGetEmailDto(e))
+}
+
+// emailEventsHandler streams the status updates of the email as server-sent events
+func (h *HTTPHandler) emailEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	h.streamEvents(w, r, id)
+}
+
+// tenantEventsHandler streams the status updates of every email of the tenant
+// as server-sent events
+func (h *HTTPHandler) tenantEventsHandler(w http.ResponseWriter, r *http.Request) {
+	h.streamEvents(w, r, 0)
+}
+
+// streamEvents writes the updates as server-sent events until the client goes
+// away. Clients resume with the Last-Event-ID header.
+func (h *HTTPHandler) streamEvents(w http.ResponseWriter, r *http.Request, id int) {
+	log := logger.GetLogEntry(r)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErr(w, r, errStreamFailed, http.StatusInternalServerError)
+		log.Error("response writer cannot flush")
+		return
+	}
+	updates, err := h.api.Events(r.Context(), id, r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		if err == ErrItemNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeErr(w, r, errStreamFailed, http.StatusInternalServerError)
+		log.Errorf("failed to stream events: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry/time.Millisecond)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(u)
+			if err != nil {
+				log.Errorf("failed to marshal status update: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: status\ndata: %s\n\n", u.ID(), b)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
 func (h *HTTPHandler) buildGetEmailDto(e Email) getEmailModel {
 	model := getEmailModel{}
 	model.ID = e.ID()
+	model.Version = e.Version()
 	model.Body = e.Body()
 	model.Subject = e.Subject()
+	model.Priority = e.Priority().String()
+	model.Batch = e.Batch()
 
 	from := e.From()
 	model.From = from.String()
@@ -168,15 +405,31 @@ func (h *HTTPHandler) buildGetEmailDto(e Email) getEmailModel {
 
 	history := make([]emailHistory, 0)
 	for _, v := range e.StatusHistory() {
-		history = append(history, emailHistory{v.Status().String(), v.At()})
+		history = append(history, emailHistory{v.Status().String(), v.At(), v.Detail()})
 	}
 	model.History = history
 	return model
 }
 
-func (h *HTTPHandler) writeErr(w http.ResponseWriter, r *http.Request, e errModel, status int) {
+// isTooLarge reports whether reading a body failed because it is over the
+// limit of its http.MaxBytesReader
+func isTooLarge(err error) bool {
+	var mbe *http.MaxBytesError
+	return errors.As(err, &mbe)
+}
+
+func writeErr(w http.ResponseWriter, r *http.Request, e errModel, status int) {
+	writeErrBody(w, r, e, status)
+}
+
+// writeValidationErr answers with every problem found with the request
+func writeValidationErr(w http.ResponseWriter, r *http.Request, errs validationErrors) {
+	writeErrBody(w, r, validationErrModel{errValidationFailed, errs}, http.StatusBadRequest)
+}
+
+func writeErrBody(w http.ResponseWriter, r *http.Request, body interface{}, status int) {
 	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(e); err != nil {
+	if err := json.NewEncoder(w).Encode(body); err != nil {
 		log := logger.GetLogEntry(r)
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Errorf("failed to encode response: %v", err)
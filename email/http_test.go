@@ -2,8 +2,10 @@ package email
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -28,12 +30,37 @@ type mockAPI struct {
 
 func (api *mockAPI) Queue(ctx context.Context, e Email) (Email, error) { return api.queue(e) }
 func (api *mockAPI) Get(ctx context.Context, id int) (Email, error)    { return api.get(id) }
+func (api *mockAPI) Update(ctx context.Context, e Email) (Email, error) {
+	return Email{}, ErrItemNotFound
+}
+func (api *mockAPI) Events(ctx context.Context, id int, lastEventID string) (<-chan StatusUpdate, error) {
+	return nil, ErrStreamDisabled
+}
+func (api *mockAPI) QueueBatch(ctx context.Context, emails []Email) (string, []BatchResult, error) {
+	results := make([]BatchResult, 0)
+	for _, e := range emails {
+		e, err := api.queue(e)
+		results = append(results, BatchResult{e, err})
+	}
+	return "bat_test", results, nil
+}
+func (api *mockAPI) GetBatchStatus(ctx context.Context, batch string) (BatchStatus, error) {
+	return BatchStatus{}, ErrItemNotFound
+}
+
+// tenantAuth authenticates every key as a key of the tenant
+type tenantAuth string
+
+func (t tenantAuth) Authenticate(ctx context.Context, key string) (APIKey, error) {
+	return APIKey{Tenant: string(t)}, nil
+}
 
 func TestPostEmailHandler(t *testing.T) {
 	email, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
 	var (
 		passQueue = func(Email) (Email, error) { return email, nil }
 		failQueue = func(Email) (Email, error) { return email, errors.New("queue failed") }
+		denyQueue = func(Email) (Email, error) { return Email{}, ErrSenderNotAllowed }
 	)
 	tt := []struct {
 		name   string
@@ -60,7 +87,24 @@ func TestPostEmailHandler(t *testing.T) {
 			body:   `{"from" : "email@gmail.com", "to" : ["fiend@gmail.com"]}`,
 			status: http.StatusInternalServerError,
 		},
-
+		{
+			name:   "should return 403 if the sender is not allowed",
+			queuef: denyQueue,
+			body:   `{"from" : "ceo@gmail.com", "to" : ["fiend@gmail.com"]}`,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "should return bad request if the priority is unknown",
+			queuef: passQueue,
+			body:   `{"from" : "email@gmail.com", "to" : ["fiend@gmail.com"], "priority": "urgent"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should return request entity too large if the request is over the limit",
+			queuef: passQueue,
+			body:   strings.Repeat(" ", maxEmailRequestBytes+1),
+			status: http.StatusRequestEntityTooLarge,
+		},
 		{
 			name:   "should return 200 if message is valid",
 			queuef: passQueue,
@@ -71,7 +115,7 @@ func TestPostEmailHandler(t *testing.T) {
 
 	for _, tst := range tt {
 		t.Run(tst.name, func(t *testing.T) {
-			api := NewHTTPHandler(&mockAPI{tst.queuef, tst.getf})
+			api := NewHTTPHandler(&mockAPI{tst.queuef, tst.getf}, tenantAuth("acme"))
 			w := httptest.NewRecorder()
 			body := strings.NewReader(tst.body)
 			r := httptest.NewRequest(http.MethodPost, "http://localhost", body)
@@ -131,7 +175,7 @@ func TestGetEmailHandler(t *testing.T) {
 
 	for _, test := range tt {
 		t.Run(test.name, func(t *testing.T) {
-			h := NewHTTPHandler(&mockAPI{passQueue, test.getf})
+			h := NewHTTPHandler(&mockAPI{passQueue, test.getf}, tenantAuth("acme"))
 			w := httptest.NewRecorder()
 			r := httptest.NewRequest(http.MethodGet, "http://localhost/emails/"+test.id, nil)
 
@@ -171,3 +215,83 @@ func TestGetEmailHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestSendBatchHandler(t *testing.T) {
+	queued, _ := New(7, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	queuef := func(e Email) (Email, error) {
+		if e.Subject() == "deny" {
+			return Email{}, ErrSenderNotAllowed
+		}
+		if e.Subject() != "Hi Ada" && e.Subject() != "subject" {
+			return Email{}, fmt.Errorf("unexpected subject %q", e.Subject())
+		}
+		return queued, nil
+	}
+	tt := []struct {
+		name       string
+		body       string
+		status     int
+		wantErrors []int
+	}{
+		{
+			name:   "should return bad request for an empty batch",
+			body:   `[]`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should return request entity too large for a batch over the byte limit",
+			body:   "[" + strings.Repeat(" ", MaxBatchBytes) + "]",
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "should return bad request for an invalid template",
+			body:   `{"template": {"from": "a@example.com", "subject": "{{.name"}, "recipients": [{"to": ["b@example.com"]}]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "should report the result of every email of an array",
+			body: `[{"from": "a@example.com", "to": ["b@example.com"], "subject": "subject"},
+				{"from": "bad", "to": ["b@example.com"]},
+				{"from": "a@example.com", "to": ["b@example.com"], "subject": "deny"}]`,
+			status:     http.StatusOK,
+			wantErrors: []int{0, 121, 112},
+		},
+		{
+			name: "should render the template for every recipient",
+			body: `{"template": {"from": "a@example.com", "subject": "Hi {{.name}}", "body": "hello"},
+				"recipients": [{"to": ["ada@example.com"], "data": {"name": "Ada"}}, {"to": ["bob@example.com"]}]}`,
+			status:     http.StatusOK,
+			wantErrors: []int{0, 103},
+		},
+	}
+	for _, tst := range tt {
+		t.Run(tst.name, func(t *testing.T) {
+			api := NewHTTPHandler(&mockAPI{queue: queuef}, tenantAuth("acme"))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "http://localhost/batch", strings.NewReader(tst.body))
+			api.sendBatchHandler(w, r)
+			if w.Code != tst.status {
+				t.Fatalf("sendBatchHandler(): got %d when was expecting %d", w.Code, tst.status)
+			}
+			if tst.wantErrors == nil {
+				return
+			}
+			var res postBatchResultModel
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if len(res.Results) != len(tst.wantErrors) {
+				t.Fatalf("got %d results, but expected %d", len(res.Results), len(tst.wantErrors))
+			}
+			for i, code := range tst.wantErrors {
+				got := 0
+				if res.Results[i].Error != nil {
+					got = res.Results[i].Error.Code
+				}
+				if got != code || res.Results[i].Index != i {
+					t.Fatalf("got result %+v, but expected error code %d at index %d", res.Results[i], code, i)
+				}
+			}
+		})
+	}
+}
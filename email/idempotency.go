@@ -0,0 +1,243 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/husainaloos/notfy/logger"
+)
+
+const (
+	// IdempotencyKeyHeader is the header clients retry requests safely with
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotentReplayedHeader marks the responses that are replayed
+	idempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength    = 255
+	defaultIdempotencyDuration = 24 * time.Hour
+)
+
+var (
+	// ErrIdempotencyInProgress is returned when a request with the key is still being served
+	ErrIdempotencyInProgress = errors.New("a request with the idempotency key is in progress")
+
+	// ErrIdempotencyMismatch is returned when the key was used for a different request
+	ErrIdempotencyMismatch = errors.New("idempotency key was used for a different request")
+)
+
+// IdempotentResponse is the response remembered for an idempotency key
+type IdempotentResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyStore remembers the responses to the requests with an
+// idempotency key, so that retries get the same response instead of queuing
+// the emails again
+type IdempotencyStore interface {
+	// Begin claims the key for the request with the hash. It returns the
+	// response if the request was already served, ErrIdempotencyInProgress if
+	// it is being served, and ErrIdempotencyMismatch if the key was used for a
+	// request with another hash.
+	Begin(ctx context.Context, key, hash string) (*IdempotentResponse, error)
+
+	// Finish remembers the response of the request that claimed the key
+	Finish(ctx context.Context, key string, res IdempotentResponse) error
+
+	// Release lets the key be claimed again, after the request failed
+	Release(ctx context.Context, key string) error
+}
+
+type idempotencyEntry struct {
+	hash      string
+	res       *IdempotentResponse
+	expiresAt time.Time
+}
+
+// idempotencyExpiry is when the entry of a key claimed at a time expires
+type idempotencyExpiry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore in the memory of the
+// instance. Retries that reach another instance are not recognized.
+type MemoryIdempotencyStore struct {
+	mu       sync.Mutex
+	duration time.Duration
+	entries  map[string]idempotencyEntry
+	// expiries are in the order the keys were claimed, which is the order
+	// they expire in, as every key is kept for the same duration
+	expiries []idempotencyExpiry
+	now      func() time.Time
+}
+
+// NewMemoryIdempotencyStore creates a store that remembers the keys for the
+// duration, or a day if the duration is zero
+func NewMemoryIdempotencyStore(duration time.Duration) *MemoryIdempotencyStore {
+	if duration <= 0 {
+		duration = defaultIdempotencyDuration
+	}
+	return &MemoryIdempotencyStore{
+		duration: duration,
+		entries:  make(map[string]idempotencyEntry),
+		now:      time.Now,
+	}
+}
+
+// Begin claims the key for the request with the hash
+func (s *MemoryIdempotencyStore) Begin(ctx context.Context, key, hash string) (*IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.expire(now)
+	e, ok := s.entries[key]
+	switch {
+	case !ok:
+		expiresAt := now.Add(s.duration)
+		s.entries[key] = idempotencyEntry{hash: hash, expiresAt: expiresAt}
+		s.expiries = append(s.expiries, idempotencyExpiry{key, expiresAt})
+		return nil, nil
+	case e.hash != hash:
+		return nil, ErrIdempotencyMismatch
+	case e.res == nil:
+		return nil, ErrIdempotencyInProgress
+	default:
+		return e.res, nil
+	}
+}
+
+// expire forgets the keys that expired by now. Only the expired keys are
+// visited.
+func (s *MemoryIdempotencyStore) expire(now time.Time) {
+	n := 0
+	for ; n < len(s.expiries) && now.After(s.expiries[n].expiresAt); n++ {
+		x := s.expiries[n]
+		// the key may have been released and claimed again since
+		if e, ok := s.entries[x.key]; ok && e.expiresAt.Equal(x.expiresAt) {
+			delete(s.entries, x.key)
+		}
+	}
+	s.expiries = s.expiries[n:]
+}
+
+// Finish remembers the response of the request that claimed the key
+func (s *MemoryIdempotencyStore) Finish(ctx context.Context, key string, res IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entries[key]
+	e.res = &res
+	s.entries[key] = e
+	return nil
+}
+
+// Release lets the key be claimed again
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// recordingWriter writes the response and keeps a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.header = w.ResponseWriter.Header().Clone()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotent serves the requests with an idempotency key at most once per
+// tenant and key, and replays the response to the retries. Responses of
+// server errors are not remembered, so that the retries are served again.
+// Bodies over limit bytes are refused with tooLarge before they are read whole.
+func (h *HTTPHandler) idempotent(limit int64, tooLarge errModel, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		log := logger.GetLogEntry(r).WithField("idempotency_key", key)
+		if len(key) > maxIdempotencyKeyLength {
+			writeErr(w, r, errBadRequest(errors.New("idempotency key is too long")), http.StatusBadRequest)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		if isTooLarge(err) {
+			writeErr(w, r, tooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			writeErr(w, r, errCannotReadBody, http.StatusInternalServerError)
+			log.Errorf("failed to read request body: %v", err)
+			return
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+		tenant, _ := TenantFromContext(r.Context())
+		storeKey := tenant + "\x00" + key
+
+		res, err := h.idempotency.Begin(r.Context(), storeKey, hash)
+		switch err {
+		case nil:
+		case ErrIdempotencyInProgress:
+			writeErr(w, r, errIdempotencyInProgress, http.StatusConflict)
+			return
+		case ErrIdempotencyMismatch:
+			writeErr(w, r, errIdempotencyMismatch, http.StatusUnprocessableEntity)
+			return
+		default:
+			writeErr(w, r, errFailedToQueueEmail, http.StatusInternalServerError)
+			log.Errorf("failed to claim idempotency key: %v", err)
+			return
+		}
+		if res != nil {
+			log.Debug("replaying response")
+			for k, v := range res.Header {
+				w.Header()[k] = v
+			}
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(res.Status)
+			w.Write(res.Body)
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: w}
+		next(rw, r)
+		if rw.status >= http.StatusInternalServerError || rw.status == 0 {
+			err = h.idempotency.Release(r.Context(), storeKey)
+		} else {
+			err = h.idempotency.Finish(r.Context(), storeKey, IdempotentResponse{rw.status, rw.header, rw.body.Bytes()})
+		}
+		if err != nil {
+			log.Errorf("failed to record idempotent response: %v", err)
+		}
+	}
+}
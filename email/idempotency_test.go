@@ -0,0 +1,122 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryIdempotencyStore(time.Hour)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	if res, err := s.Begin(ctx, "k", "h1"); res != nil || err != nil {
+		t.Fatalf("got %v and %v, but expected the key to be claimed", res, err)
+	}
+	if _, err := s.Begin(ctx, "k", "h1"); err != ErrIdempotencyInProgress {
+		t.Fatalf("got error %v, but expected %v", err, ErrIdempotencyInProgress)
+	}
+	s.Finish(ctx, "k", IdempotentResponse{200, nil, []byte("ok")})
+	if res, err := s.Begin(ctx, "k", "h1"); err != nil || res == nil || string(res.Body) != "ok" {
+		t.Fatalf("got %v and %v, but expected the response", res, err)
+	}
+	if _, err := s.Begin(ctx, "k", "h2"); err != ErrIdempotencyMismatch {
+		t.Fatalf("got error %v, but expected %v", err, ErrIdempotencyMismatch)
+	}
+	now = now.Add(2 * time.Hour)
+	if res, err := s.Begin(ctx, "k", "h2"); res != nil || err != nil {
+		t.Fatalf("got %v and %v, but expected the expired key to be claimed again", res, err)
+	}
+
+	// a key released and claimed again expires after its last claim
+	s.Release(ctx, "k")
+	now = now.Add(30 * time.Minute)
+	s.Begin(ctx, "k", "h3")
+	now = now.Add(45 * time.Minute)
+	if _, err := s.Begin(ctx, "other", "h1"); err != nil {
+		t.Fatalf("got error %v, but expected the key to be claimed", err)
+	}
+	if _, err := s.Begin(ctx, "k", "h3"); err != ErrIdempotencyInProgress {
+		t.Fatalf("got error %v, but expected the key claimed again to be kept", err)
+	}
+	if len(s.entries) != 2 || len(s.expiries) != 2 {
+		t.Fatalf("got %d entries and %d expiries, but expected the expired ones to be forgotten", len(s.entries), len(s.expiries))
+	}
+}
+
+func TestIdempotentHandlerServesFailuresAgain(t *testing.T) {
+	email, _ := New(1, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	calls := 0
+	h := NewHTTPHandler(&mockAPI{queue: func(Email) (Email, error) {
+		calls++
+		if calls == 1 {
+			return Email{}, errors.New("broker is down")
+		}
+		return email, nil
+	}}, tenantAuth("acme"))
+	body := `{"from": "from@example.com", "to": ["to@example.com"]}`
+	for i, want := range []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader(body))
+		r.Header.Set(IdempotencyKeyHeader, "key")
+		h.idempotent(maxEmailRequestBytes, errBodyTooLarge, h.sendEmailHandler)(w, r)
+		if w.Code != want {
+			t.Fatalf("request %d: got status %d, but expected %d", i, w.Code, want)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("got %d calls, but expected the failure to be served again and the success replayed", calls)
+	}
+}
+
+func TestIdempotentHandlerReplaysHeaders(t *testing.T) {
+	calls := 0
+	h := &HTTPHandler{idempotency: NewMemoryIdempotencyStore(0)}
+	next := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/emails/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+	}
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader("{}"))
+		r.Header.Set(IdempotencyKeyHeader, "key")
+		h.idempotent(maxEmailRequestBytes, errBodyTooLarge, next)(w, r)
+		if w.Code != http.StatusCreated || w.Body.String() != `{"id":1}` {
+			t.Fatalf("request %d: got %d %q, but expected the created response", i, w.Code, w.Body.String())
+		}
+		if ct, loc := w.Header().Get("Content-Type"), w.Header().Get("Location"); ct != "application/json" || loc != "/emails/1" {
+			t.Fatalf("request %d: got content type %q and location %q, but expected the headers of the response", i, ct, loc)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("got %d calls, but expected the response to be replayed", calls)
+	}
+}
+
+func TestIdempotentHandlerBodyTooLarge(t *testing.T) {
+	h := &HTTPHandler{idempotency: NewMemoryIdempotencyStore(0)}
+	next := func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("got the request served, but expected it to be refused")
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader(`{"body": "too large"}`))
+	r.Header.Set(IdempotencyKeyHeader, "key")
+	h.idempotent(8, errBodyTooLarge, next)(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got status %d, but expected %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	var got errModel
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Code != errBodyTooLarge.Code {
+		t.Fatalf("got body %q, but expected the body too large error", w.Body.String())
+	}
+}
@@ -0,0 +1,43 @@
+package email
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// SenderIdentity is an address, or a whole domain, a tenant is verified to send from
+type SenderIdentity struct {
+	ID     int    `json:"id"`
+	Tenant string `json:"tenant"`
+
+	// Identity is either an address, e.g. "ceo@example.com", or a domain, e.g. "example.com"
+	Identity  string    `json:"identity"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// allows reports whether the address may be sent from with the identity
+func (i SenderIdentity) allows(addr string) bool {
+	addr = strings.ToLower(addr)
+	if strings.Contains(i.Identity, "@") {
+		return addr == i.Identity
+	}
+	return domainOf(addr) == i.Identity
+}
+
+// normalizeIdentity validates the address or domain and lower cases it
+func normalizeIdentity(identity string) (string, error) {
+	identity = strings.ToLower(strings.TrimSpace(identity))
+	if strings.Contains(identity, "@") {
+		a, err := mail.ParseAddress(identity)
+		if err != nil {
+			return "", err
+		}
+		return strings.ToLower(a.Address), nil
+	}
+	if identity == "" || strings.ContainsAny(identity, " <>,;") || !strings.Contains(identity, ".") {
+		return "", fmt.Errorf("invalid domain %q", identity)
+	}
+	return identity, nil
+}
@@ -0,0 +1,160 @@
+package email
+
+import (
+	"sync"
+	"time"
+)
+
+var defaultLaneWeights = map[Priority]int{
+	PriorityHigh:   8,
+	PriorityNormal: 4,
+	PriorityLow:    1,
+}
+
+const defaultLaneCapacity = 1000
+
+// lane is the queue of emails waiting to be sent with one priority
+type lane struct {
+	priority Priority
+	weight   int
+	current  int
+	queue    []Email
+}
+
+// laneScheduler holds the emails waiting to be sent and hands them out with
+// smooth weighted round robin, so every non-empty lane is served in
+// proportion to its weight and no lane is starved. A lane holds at most
+// capacity emails, so the consumer is blocked instead of draining the broker
+// into memory.
+type laneScheduler struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	lanes    map[Priority]*lane
+	order    []*lane
+	capacity int
+	closed   bool
+
+	// delayed is the number of emails that will be pushed back later
+	delayed int
+}
+
+func newLaneScheduler(weights map[Priority]int, capacity int) *laneScheduler {
+	if capacity <= 0 {
+		capacity = defaultLaneCapacity
+	}
+	s := &laneScheduler{lanes: make(map[Priority]*lane), capacity: capacity}
+	s.cond = sync.NewCond(&s.mu)
+	for _, p := range priorities {
+		w, ok := weights[p]
+		if !ok || w <= 0 {
+			w = defaultLaneWeights[p]
+		}
+		l := &lane{priority: p, weight: w}
+		s.lanes[p] = l
+		s.order = append(s.order, l)
+	}
+	return s
+}
+
+// push adds the email to the lane of its priority, and blocks while the lane
+// is full. Emails with an unknown priority go to the normal lane.
+func (s *laneScheduler) push(e Email) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.laneOf(e).queue) >= s.capacity && !s.closed {
+		s.cond.Wait()
+	}
+	s.pushLocked(e)
+}
+
+// pushAfter adds the email back to its lane once the delay has passed. The
+// email was already let in, so it is added even if the lane is full. The
+// scheduler is not drained while emails are delayed.
+func (s *laneScheduler) pushAfter(e Email, d time.Duration) {
+	s.mu.Lock()
+	s.delayed++
+	s.mu.Unlock()
+	time.AfterFunc(d, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.delayed--
+		s.pushLocked(e)
+	})
+}
+
+func (s *laneScheduler) pushLocked(e Email) {
+	l := s.laneOf(e)
+	l.queue = append(l.queue, e)
+	s.cond.Broadcast()
+}
+
+func (s *laneScheduler) laneOf(e Email) *lane {
+	return s.lanes[e.Priority().lane()]
+}
+
+// pop blocks until an email is waiting in any lane and returns the one of the
+// lane whose turn it is. It returns false once the scheduler is closed and empty.
+func (s *laneScheduler) pop() (Email, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		var best *lane
+		total := 0
+		for _, l := range s.order {
+			if len(l.queue) == 0 {
+				continue
+			}
+			l.current += l.weight
+			total += l.weight
+			if best == nil || l.current > best.current {
+				best = l
+			}
+		}
+		if best != nil {
+			best.current -= total
+			// a push may be waiting for room in the lane
+			s.cond.Broadcast()
+			return best.take(), true
+		}
+		if s.drained() {
+			return Email{}, false
+		}
+		s.cond.Wait()
+	}
+}
+
+// popPriority blocks until an email is waiting in the lane of the priority and
+// returns it. It returns false once the scheduler is closed and the lane is empty.
+func (s *laneScheduler) popPriority(p Priority) (Email, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.lanes[p]
+	for len(l.queue) == 0 {
+		if s.drained() {
+			return Email{}, false
+		}
+		s.cond.Wait()
+	}
+	s.cond.Broadcast()
+	return l.take(), true
+}
+
+// close wakes up every waiting pop once the remaining emails are handed out
+func (s *laneScheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// drained reports whether no more emails will be pushed
+func (s *laneScheduler) drained() bool {
+	return s.closed && s.delayed == 0
+}
+
+func (l *lane) take() Email {
+	e := l.queue[0]
+	l.queue[0] = Email{}
+	l.queue = l.queue[1:]
+	return e
+}
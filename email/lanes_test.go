@@ -0,0 +1,102 @@
+package email
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLaneSchedulerWeights(t *testing.T) {
+	s := newLaneScheduler(map[Priority]int{PriorityHigh: 4, PriorityNormal: 2, PriorityLow: 1}, 0)
+	for _, p := range []Priority{PriorityHigh, PriorityNormal, PriorityLow} {
+		for i := 0; i < 10; i++ {
+			e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+			e.SetPriority(p)
+			s.push(e)
+		}
+	}
+
+	got := map[Priority]int{}
+	for i := 0; i < 7; i++ {
+		e, ok := s.pop()
+		if !ok {
+			t.Fatalf("pop() returned false with emails pending")
+		}
+		got[e.Priority()]++
+	}
+	want := map[Priority]int{PriorityHigh: 4, PriorityNormal: 2, PriorityLow: 1}
+	for p, n := range want {
+		if got[p] != n {
+			t.Errorf("got %d %s emails in one round, but expected %d", got[p], p, n)
+		}
+	}
+}
+
+func TestLaneSchedulerServesLowWhenAlone(t *testing.T) {
+	s := newLaneScheduler(nil, 0)
+	e, _ := New(1, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	e.SetPriority(PriorityLow)
+	s.push(e)
+	got, ok := s.pop()
+	if !ok || got.ID() != 1 {
+		t.Fatalf("got email %d (ok=%t), but expected email 1", got.ID(), ok)
+	}
+}
+
+func TestLaneSchedulerPopPriority(t *testing.T) {
+	s := newLaneScheduler(nil, 0)
+	low, _ := New(1, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	low.SetPriority(PriorityLow)
+	high, _ := New(2, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	high.SetPriority(PriorityHigh)
+	s.push(low)
+	s.push(high)
+	got, ok := s.popPriority(PriorityHigh)
+	if !ok || got.ID() != 2 {
+		t.Fatalf("got email %d (ok=%t), but expected email 2", got.ID(), ok)
+	}
+}
+
+func TestLaneSchedulerClose(t *testing.T) {
+	s := newLaneScheduler(nil, 0)
+	done := make(chan bool)
+	go func() {
+		_, ok := s.pop()
+		done <- ok
+	}()
+	s.close()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("pop() returned an email from an empty scheduler")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pop() did not return after close")
+	}
+}
+
+func TestLaneSchedulerCapacity(t *testing.T) {
+	s := newLaneScheduler(nil, 2)
+	for i := 1; i <= 2; i++ {
+		e, _ := New(i, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+		s.push(e)
+	}
+	pushed := make(chan bool)
+	go func() {
+		e, _ := New(3, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+		s.push(e)
+		pushed <- true
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push() did not block on a full lane")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got, ok := s.pop(); !ok || got.ID() != 1 {
+		t.Fatalf("got email %d (ok=%t), but expected email 1", got.ID(), ok)
+	}
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push() did not return once the lane had room")
+	}
+}
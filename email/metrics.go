@@ -0,0 +1,265 @@
+package email
+
+import (
+	"context"
+	"time"
+
+	"github.com/husainaloos/notfy/metrics"
+)
+
+// instrumentedStorage records the latency and the failures of every query of
+// the storage it wraps, by the name of the method
+type instrumentedStorage struct {
+	s Storage
+	m metrics.Metrics
+}
+
+// instrumentedEncryptedStorage also passes the keyring to the storage it
+// wraps, and records its reencryptions
+type instrumentedEncryptedStorage struct {
+	*instrumentedStorage
+	es EncryptedStorage
+}
+
+// SetKeyring sets the keyring of the storage it wraps
+func (is *instrumentedEncryptedStorage) SetKeyring(k *Keyring) {
+	is.es.SetKeyring(k)
+}
+
+// Reencrypt reencrypts the emails of the storage it wraps
+func (is *instrumentedEncryptedStorage) Reencrypt(ctx context.Context, batchSize int) (int, error) {
+	start := time.Now()
+	n, err := is.es.Reencrypt(ctx, batchSize)
+	is.observe("Reencrypt", start, err)
+	return n, err
+}
+
+// InstrumentStorage records the latency and the failures of the queries of s.
+// The returned storage is an EncryptedStorage if s is one.
+func InstrumentStorage(s Storage, m metrics.Metrics) Storage {
+	is := &instrumentedStorage{s, m}
+	if es, ok := s.(EncryptedStorage); ok {
+		return &instrumentedEncryptedStorage{is, es}
+	}
+	return is
+}
+
+func (is *instrumentedStorage) observe(op string, start time.Time, err error) {
+	is.m.ObserveQuery(op, time.Since(start), err)
+}
+
+func (is *instrumentedStorage) insert(ctx context.Context, e Email) (Email, error) {
+	start := time.Now()
+	e, err := is.s.insert(ctx, e)
+	is.observe("insert", start, err)
+	return e, err
+}
+
+func (is *instrumentedStorage) get(ctx context.Context, id int) (Email, bool, error) {
+	start := time.Now()
+	e, ok, err := is.s.get(ctx, id)
+	is.observe("get", start, err)
+	return e, ok, err
+}
+
+func (is *instrumentedStorage) update(ctx context.Context, e Email) (Email, bool, error) {
+	start := time.Now()
+	e, ok, err := is.s.update(ctx, e)
+	if err == ErrVersionConflict {
+		// a conflict is an answer of the storage, not a failure
+		is.observe("update", start, nil)
+		return e, ok, err
+	}
+	is.observe("update", start, err)
+	return e, ok, err
+}
+
+func (is *instrumentedStorage) appendStatusEvents(ctx context.Context, id int, events []StatusEvent) (Email, bool, error) {
+	start := time.Now()
+	e, ok, err := is.s.appendStatusEvents(ctx, id, events)
+	is.observe("appendStatusEvents", start, err)
+	return e, ok, err
+}
+
+func (is *instrumentedStorage) list(ctx context.Context, tenant string, after, limit int) ([]Email, error) {
+	start := time.Now()
+	emails, err := is.s.list(ctx, tenant, after, limit)
+	is.observe("list", start, err)
+	return emails, err
+}
+
+func (is *instrumentedStorage) insertBatch(ctx context.Context, emails []Email) ([]Email, error) {
+	start := time.Now()
+	emails, err := is.s.insertBatch(ctx, emails)
+	is.observe("insertBatch", start, err)
+	return emails, err
+}
+
+func (is *instrumentedStorage) countBatch(ctx context.Context, tenant, batch string) (map[Status]int, error) {
+	start := time.Now()
+	counts, err := is.s.countBatch(ctx, tenant, batch)
+	is.observe("countBatch", start, err)
+	return counts, err
+}
+
+func (is *instrumentedStorage) listExpired(ctx context.Context, cutoffs []retentionCutoff, after, limit int) ([]expiredEmail, error) {
+	start := time.Now()
+	emails, err := is.s.listExpired(ctx, cutoffs, after, limit)
+	is.observe("listExpired", start, err)
+	return emails, err
+}
+
+func (is *instrumentedStorage) redact(ctx context.Context, ids []int) (int, error) {
+	start := time.Now()
+	n, err := is.s.redact(ctx, ids)
+	is.observe("redact", start, err)
+	return n, err
+}
+
+func (is *instrumentedStorage) deleteEmails(ctx context.Context, ids []int) (int, error) {
+	start := time.Now()
+	n, err := is.s.deleteEmails(ctx, ids)
+	is.observe("deleteEmails", start, err)
+	return n, err
+}
+
+func (is *instrumentedStorage) insertAPIKey(ctx context.Context, k APIKey) (APIKey, error) {
+	start := time.Now()
+	k, err := is.s.insertAPIKey(ctx, k)
+	is.observe("insertAPIKey", start, err)
+	return k, err
+}
+
+func (is *instrumentedStorage) getAPIKeyByHash(ctx context.Context, hash string) (APIKey, bool, error) {
+	start := time.Now()
+	k, ok, err := is.s.getAPIKeyByHash(ctx, hash)
+	is.observe("getAPIKeyByHash", start, err)
+	return k, ok, err
+}
+
+func (is *instrumentedStorage) listAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+	start := time.Now()
+	keys, err := is.s.listAPIKeys(ctx, tenant)
+	is.observe("listAPIKeys", start, err)
+	return keys, err
+}
+
+func (is *instrumentedStorage) deleteAPIKey(ctx context.Context, id int) (bool, error) {
+	start := time.Now()
+	ok, err := is.s.deleteAPIKey(ctx, id)
+	is.observe("deleteAPIKey", start, err)
+	return ok, err
+}
+
+func (is *instrumentedStorage) insertSenderIdentity(ctx context.Context, i SenderIdentity) (SenderIdentity, error) {
+	start := time.Now()
+	i, err := is.s.insertSenderIdentity(ctx, i)
+	is.observe("insertSenderIdentity", start, err)
+	return i, err
+}
+
+func (is *instrumentedStorage) listSenderIdentities(ctx context.Context, tenant string) ([]SenderIdentity, error) {
+	start := time.Now()
+	ids, err := is.s.listSenderIdentities(ctx, tenant)
+	is.observe("listSenderIdentities", start, err)
+	return ids, err
+}
+
+func (is *instrumentedStorage) deleteSenderIdentity(ctx context.Context, id int) (bool, error) {
+	start := time.Now()
+	ok, err := is.s.deleteSenderIdentity(ctx, id)
+	is.observe("deleteSenderIdentity", start, err)
+	return ok, err
+}
+
+func (is *instrumentedStorage) upsertSuppression(ctx context.Context, sup Suppression) error {
+	start := time.Now()
+	err := is.s.upsertSuppression(ctx, sup)
+	is.observe("upsertSuppression", start, err)
+	return err
+}
+
+func (is *instrumentedStorage) getSuppressions(ctx context.Context, tenant string, addrs []string) ([]Suppression, error) {
+	start := time.Now()
+	sups, err := is.s.getSuppressions(ctx, tenant, addrs)
+	is.observe("getSuppressions", start, err)
+	return sups, err
+}
+
+func (is *instrumentedStorage) listSuppressions(ctx context.Context) ([]Suppression, error) {
+	start := time.Now()
+	sups, err := is.s.listSuppressions(ctx)
+	is.observe("listSuppressions", start, err)
+	return sups, err
+}
+
+func (is *instrumentedStorage) deleteSuppression(ctx context.Context, tenant, addr string, reason SuppressionReason) (bool, error) {
+	start := time.Now()
+	ok, err := is.s.deleteSuppression(ctx, tenant, addr, reason)
+	is.observe("deleteSuppression", start, err)
+	return ok, err
+}
+
+func (is *instrumentedStorage) insertWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	start := time.Now()
+	w, err := is.s.insertWebhook(ctx, w)
+	is.observe("insertWebhook", start, err)
+	return w, err
+}
+
+func (is *instrumentedStorage) getWebhook(ctx context.Context, id int) (Webhook, bool, error) {
+	start := time.Now()
+	w, ok, err := is.s.getWebhook(ctx, id)
+	is.observe("getWebhook", start, err)
+	return w, ok, err
+}
+
+func (is *instrumentedStorage) listWebhooks(ctx context.Context, tenant string) ([]Webhook, error) {
+	start := time.Now()
+	hooks, err := is.s.listWebhooks(ctx, tenant)
+	is.observe("listWebhooks", start, err)
+	return hooks, err
+}
+
+func (is *instrumentedStorage) deleteWebhook(ctx context.Context, id int) (bool, error) {
+	start := time.Now()
+	ok, err := is.s.deleteWebhook(ctx, id)
+	is.observe("deleteWebhook", start, err)
+	return ok, err
+}
+
+func (is *instrumentedStorage) insertWebhookDelivery(ctx context.Context, d WebhookDelivery) (WebhookDelivery, error) {
+	start := time.Now()
+	d, err := is.s.insertWebhookDelivery(ctx, d)
+	is.observe("insertWebhookDelivery", start, err)
+	return d, err
+}
+
+func (is *instrumentedStorage) listWebhookDeliveries(ctx context.Context, webhookID int) ([]WebhookDelivery, error) {
+	start := time.Now()
+	ds, err := is.s.listWebhookDeliveries(ctx, webhookID)
+	is.observe("listWebhookDeliveries", start, err)
+	return ds, err
+}
+
+func (is *instrumentedStorage) listDueWebhookDeliveries(ctx context.Context, before time.Time, limit int) ([]WebhookDelivery, error) {
+	start := time.Now()
+	ds, err := is.s.listDueWebhookDeliveries(ctx, before, limit)
+	is.observe("listDueWebhookDeliveries", start, err)
+	return ds, err
+}
+
+func (is *instrumentedStorage) claimWebhookDelivery(ctx context.Context, id int) (bool, error) {
+	start := time.Now()
+	ok, err := is.s.claimWebhookDelivery(ctx, id)
+	is.observe("claimWebhookDelivery", start, err)
+	return ok, err
+}
+
+// countStatuses counts the status events of the email from index from onwards
+func countStatuses(m metrics.Metrics, e Email, from int) {
+	history := e.StatusHistory()
+	for i := from; i < len(history); i++ {
+		m.CountEmail(history[i].Status().String())
+	}
+}
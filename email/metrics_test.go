@@ -0,0 +1,113 @@
+package email
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/husainaloos/notfy/messaging"
+	"github.com/husainaloos/notfy/metrics"
+)
+
+// recordingMetrics records the emails and the queries it is told about
+type recordingMetrics struct {
+	metrics.Nop
+	mu       sync.Mutex
+	statuses []string
+	queries  []string
+	errors   []string
+}
+
+func (m *recordingMetrics) CountBrokerError(broker, op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors = append(m.errors, broker+" "+op)
+}
+
+func (m *recordingMetrics) CountEmail(status string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statuses = append(m.statuses, status)
+}
+
+func (m *recordingMetrics) ObserveQuery(op string, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries = append(m.queries, op)
+}
+
+func TestAPIMetrics(t *testing.T) {
+	m := &recordingMetrics{}
+	api := NewAPI(messaging.NilPublisher{}, InstrumentStorage(NewMemoryStorage(), m))
+	api.SetMetrics(m)
+	e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	if _, err := api.Queue(context.Background(), e); err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	if want := []string{"Queued"}; !reflect.DeepEqual(m.statuses, want) {
+		t.Fatalf("got statuses %v, but expected %v", m.statuses, want)
+	}
+	if want := []string{"getSuppressions", "insert"}; !reflect.DeepEqual(m.queries, want) {
+		t.Fatalf("got queries %v, but expected %v", m.queries, want)
+	}
+}
+
+func TestConsumeErrorMetrics(t *testing.T) {
+	m := &recordingMetrics{}
+	broker := messaging.NewInMemoryPubSub()
+	sub := DecryptSubscriber(messaging.InstrumentSubscriber(broker, "redis", m), nil)
+
+	// the deamon counts the messages that are not emails
+	d := &Deamon{lanes: newLaneScheduler(nil, 0), metrics: m}
+	d.classify(messaging.BrokerOf(sub), []byte("garbage"))
+	d.classify(messaging.BrokerOf(sub), recordFixture(t, SchemaVersion, ContentTypeProtobuf))
+	if _, ok := d.lanes.pop(); !ok {
+		t.Fatal("got no email in the lanes, but expected the valid message")
+	}
+
+	// the status stream counts the updates it cannot read
+	st, err := NewStatusStream(broker, sub, 0)
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	st.SetMetrics(m)
+	broker.Publish([]byte("garbage"))
+
+	if want := []string{"redis consume", "redis consume"}; !reflect.DeepEqual(m.errors, want) {
+		t.Fatalf("got broker errors %v, but expected %v", m.errors, want)
+	}
+	if got := messaging.BrokerOf(broker); got != "unknown" {
+		t.Fatalf("got broker %q without instrumentation, but expected unknown", got)
+	}
+}
+
+func TestInstrumentEncryptedStorage(t *testing.T) {
+	m := &recordingMetrics{}
+	if _, ok := InstrumentStorage(NewMemoryStorage(), m).(EncryptedStorage); ok {
+		t.Fatal("got an encrypted storage of a storage that does not encrypt")
+	}
+	s, ok := InstrumentStorage(newTestSQLiteStorage(t), m).(EncryptedStorage)
+	if !ok {
+		t.Fatal("got a storage that cannot be encrypted")
+	}
+	s.SetKeyring(testKeyring(t, "k1"))
+	ctx := context.Background()
+	e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	inserted, err := s.insert(ctx, e)
+	if err != nil {
+		t.Fatalf("failed to insert email: %v", err)
+	}
+	s.SetKeyring(testKeyring(t, "k2", "k1"))
+	if n, err := s.Reencrypt(ctx, 10); n != 1 || err != nil {
+		t.Fatalf("reencrypted %d emails (%v), but expected 1", n, err)
+	}
+	s.SetKeyring(testKeyring(t, "k2"))
+	if _, _, err := s.get(ctx, inserted.ID()); err != nil {
+		t.Fatalf("failed to get the reencrypted email: %v", err)
+	}
+	if want := []string{"insert", "Reencrypt", "get"}; !reflect.DeepEqual(m.queries, want) {
+		t.Fatalf("got queries %v, but expected %v", m.queries, want)
+	}
+}
@@ -0,0 +1,327 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/husainaloos/notfy/logger"
+)
+
+// openAPISpec is the OpenAPI document of the routes of HTTPHandler. The
+// server is filled in with the path the handler is mounted at when served.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "notfy",
+    "version": "1.0.0",
+    "description": "Queues emails and reports their status. Every error response is an Error, whose code is one of ErrorCode."
+  },
+  "security": [{"bearerAuth": []}, {"apiKeyAuth": []}],
+  "paths": {
+    "/": {
+      "post": {
+        "operationId": "queueEmail",
+        "summary": "Queue an email",
+        "parameters": [{"$ref": "#/components/parameters/IdempotencyKey"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PostEmail"}}}
+        },
+        "responses": {
+          "200": {"description": "The queued email", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Email"}}}},
+          "400": {"$ref": "#/components/responses/ValidationError"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/batch": {
+      "post": {
+        "operationId": "queueBatch",
+        "summary": "Queue a batch of emails",
+        "description": "Every valid email of the batch is queued. The result of each email is in the order of the request.",
+        "parameters": [{"$ref": "#/components/parameters/IdempotencyKey"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PostBatch"}}}
+        },
+        "responses": {
+          "200": {"description": "The result of every email", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchResult"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/batch/{id}": {
+      "get": {
+        "operationId": "getBatch",
+        "summary": "Count the emails of a batch by their latest status",
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "The status of the batch", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchStatus"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"description": "The batch is not found"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/events": {
+      "get": {
+        "operationId": "streamEvents",
+        "summary": "Stream the status updates of every email of the tenant",
+        "parameters": [{"$ref": "#/components/parameters/LastEventID"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Events"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}": {
+      "get": {
+        "operationId": "getEmail",
+        "summary": "Get an email and its status history",
+        "parameters": [{"$ref": "#/components/parameters/EmailID"}],
+        "responses": {
+          "200": {"description": "The email", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Email"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"description": "The email is not found"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "operationId": "updateEmail",
+        "summary": "Replace the content of an email",
+        "description": "The history of the email is kept. The version is the one the email was read at; the update is refused with code 132 if the email changed since.",
+        "parameters": [{"$ref": "#/components/parameters/EmailID"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PutEmail"}}}
+        },
+        "responses": {
+          "200": {"description": "The updated email", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Email"}}}},
+          "400": {"$ref": "#/components/responses/ValidationError"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"description": "The email is not found"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/events": {
+      "get": {
+        "operationId": "streamEmailEvents",
+        "summary": "Stream the status updates of an email",
+        "description": "The history of the email is sent first, unless the stream is resumed.",
+        "parameters": [{"$ref": "#/components/parameters/EmailID"}, {"$ref": "#/components/parameters/LastEventID"}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Events"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"description": "The email is not found"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "operationId": "getOpenAPI",
+        "summary": "Get this document",
+        "responses": {"200": {"description": "The OpenAPI document", "content": {"application/json": {}}}}
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearerAuth": {"type": "http", "scheme": "bearer", "description": "The API key of the tenant"},
+      "apiKeyAuth": {"type": "apiKey", "in": "header", "name": "X-API-Key"}
+    },
+    "parameters": {
+      "EmailID": {"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 1}},
+      "IdempotencyKey": {
+        "name": "Idempotency-Key",
+        "in": "header",
+        "description": "Retries with the same key get the response of the first request instead of queuing again. Keys are remembered for a day.",
+        "schema": {"type": "string", "maxLength": 255}
+      },
+      "LastEventID": {
+        "name": "Last-Event-ID",
+        "in": "header",
+        "description": "The id of the last event received, to resume the stream after it",
+        "schema": {"type": "string"}
+      }
+    },
+    "responses": {
+      "Error": {"description": "An error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "ValidationError": {
+        "description": "The request is invalid. Invalid fields are reported with code 121 and every problem found.",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "Events": {
+        "description": "Server-sent events named status, whose data is a StatusUpdate",
+        "content": {"text/event-stream": {"schema": {"type": "string"}}}
+      }
+    },
+    "schemas": {
+      "Priority": {"type": "string", "enum": ["normal", "high", "low"], "default": "normal"},
+      "PostEmail": {
+        "type": "object",
+        "required": ["from"],
+        "properties": {
+          "from": {"type": "string", "maxLength": 100},
+          "to": {"type": "array", "items": {"type": "string", "maxLength": 100}},
+          "cc": {"type": "array", "items": {"type": "string", "maxLength": 100}},
+          "bcc": {"type": "array", "items": {"type": "string", "maxLength": 100}},
+          "subject": {"type": "string", "maxLength": 1000},
+          "body": {"type": "string", "maxLength": 10485760},
+          "priority": {"$ref": "#/components/schemas/Priority"}
+        },
+        "description": "An email needs at least one and at most 50 recipients across to, cc and bcc."
+      },
+      "PutEmail": {
+        "allOf": [
+          {"$ref": "#/components/schemas/PostEmail"},
+          {"type": "object", "required": ["version"], "properties": {"version": {"type": "integer", "minimum": 1}}}
+        ]
+      },
+      "Email": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "version": {"type": "integer", "description": "Incremented on every change of the email"},
+          "from": {"type": "string"},
+          "to": {"type": "array", "items": {"type": "string"}},
+          "cc": {"type": "array", "items": {"type": "string"}},
+          "bcc": {"type": "array", "items": {"type": "string"}},
+          "subject": {"type": "string"},
+          "body": {"type": "string"},
+          "priority": {"type": "string", "enum": ["Normal", "High", "Low"]},
+          "batch": {"type": "string"},
+          "history": {"type": "array", "items": {"$ref": "#/components/schemas/StatusEvent"}}
+        }
+      },
+      "Status": {"type": "string", "enum": ["Queued", "SentSuccessfully", "FailedAttemptToSend", "Dead", "Suppressed", "Bounced"]},
+      "StatusEvent": {
+        "type": "object",
+        "properties": {
+          "status": {"$ref": "#/components/schemas/Status"},
+          "at": {"type": "string", "format": "date-time"},
+          "detail": {"type": "string"}
+        }
+      },
+      "StatusUpdate": {
+        "type": "object",
+        "properties": {
+          "email_id": {"type": "integer"},
+          "tenant": {"type": "string"},
+          "index": {"type": "integer"},
+          "status": {"$ref": "#/components/schemas/Status"},
+          "at": {"type": "string", "format": "date-time"},
+          "detail": {"type": "string"}
+        }
+      },
+      "PostBatch": {
+        "description": "Either the emails of the batch, or a template sent to every recipient. The subject and the body of the template are Go templates executed with the data of the recipient.",
+        "oneOf": [
+          {"type": "array", "maxItems": 5000, "items": {"$ref": "#/components/schemas/PostEmail"}},
+          {
+            "type": "object",
+            "required": ["template", "recipients"],
+            "properties": {
+              "template": {"$ref": "#/components/schemas/PostEmail"},
+              "recipients": {
+                "type": "array",
+                "maxItems": 5000,
+                "items": {
+                  "type": "object",
+                  "properties": {
+                    "to": {"type": "array", "items": {"type": "string"}},
+                    "cc": {"type": "array", "items": {"type": "string"}},
+                    "bcc": {"type": "array", "items": {"type": "string"}},
+                    "data": {"type": "object", "additionalProperties": true}
+                  }
+                }
+              }
+            }
+          }
+        ]
+      },
+      "BatchResult": {
+        "type": "object",
+        "properties": {
+          "batch_id": {"type": "string"},
+          "results": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "index": {"type": "integer"},
+                "id": {"type": "integer"},
+                "error": {"$ref": "#/components/schemas/Error"},
+                "errors": {"type": "array", "items": {"$ref": "#/components/schemas/FieldError"}}
+              }
+            }
+          }
+        }
+      },
+      "BatchStatus": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "total": {"type": "integer"},
+          "statuses": {"type": "object", "additionalProperties": {"type": "integer"}}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["message", "code"],
+        "properties": {
+          "message": {"type": "string"},
+          "code": {"$ref": "#/components/schemas/ErrorCode"},
+          "errors": {"type": "array", "items": {"$ref": "#/components/schemas/FieldError"}}
+        }
+      },
+      "FieldError": {
+        "type": "object",
+        "properties": {
+          "field": {"type": "string"},
+          "index": {"type": "integer", "description": "The position of the item in a list field"},
+          "code": {"$ref": "#/components/schemas/ErrorCode"},
+          "message": {"type": "string"}
+        }
+      },
+      "ErrorCode": {
+        "type": "integer",
+        "description": "101 cannot read body. 102 bad json. 103 invalid request. 104 failed to queue email. 105 internal error. 106 failed to create status. 107 failed to get email. 108 unauthorized. 109 failed to create api key. 110 failed to list api keys. 111 failed to revoke api key. 112 sender is not a verified identity. 113 failed to manage sender identity. 114 a recipient is suppressed. 115 failed to manage suppression. 116 not a delivery status notification. 117 failed to process bounce. 118 failed to manage webhook. 119 failed to stream events. 120 batch is too large. 121 invalid fields. 122 field is required. 123 no recipient. 124 invalid address. 125 address is too long. 126 too many recipients. 127 subject is too long. 128 body is too large. 129 invalid priority. 130 a request with the idempotency key is in progress. 131 idempotency key was used for a different request. 132 email was changed since the version was read. 133 failed to update email.",
+        "enum": [101, 102, 103, 104, 105, 106, 107, 108, 109, 110, 111, 112, 113, 114, 115, 116, 117, 118, 119, 120, 121, 122, 123, 124, 125, 126, 127, 128, 129, 130, 131, 132, 133]
+      }
+    }
+  }
+}`
+
+// openAPIHandler serves the OpenAPI document, with the path the handler is
+// mounted at as its server
+func (h *HTTPHandler) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal([]byte(openAPISpec), &spec); err != nil {
+		writeErr(w, r, errFailedToInitEmail, http.StatusInternalServerError)
+		logger.GetLogEntry(r).Errorf("failed to parse openapi document: %v", err)
+		return
+	}
+	base := strings.TrimSuffix(r.URL.Path, "/openapi.json")
+	if base == "" {
+		base = "/"
+	}
+	spec["servers"] = []map[string]string{{"url": base}}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(spec)
+}
@@ -0,0 +1,61 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+type openAPIDocument struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas struct {
+			ErrorCode struct {
+				Enum []int `json:"enum"`
+			} `json:"ErrorCode"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	r := chi.NewRouter()
+	r.Route("/emails", NewHTTPHandler(&mockAPI{}, tenantAuth("acme")).Route)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/emails/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, but expected %d", w.Code, http.StatusOK)
+	}
+	var doc openAPIDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to unmarshal document: %v", err)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "/emails" {
+		t.Fatalf("got servers %+v, but expected /emails", doc.Servers)
+	}
+
+	routes := chi.NewRouter()
+	NewHTTPHandler(&mockAPI{}, tenantAuth("acme")).Route(routes)
+	chi.Walk(routes, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if _, ok := doc.Paths[route][strings.ToLower(method)]; !ok {
+			t.Errorf("route %s %s is not documented", method, route)
+		}
+		return nil
+	})
+
+	documented := make(map[int]bool)
+	for _, code := range doc.Components.Schemas.ErrorCode.Enum {
+		documented[code] = true
+	}
+	for code := errCannotReadBody.Code; code <= errUpdateEmailFailed.Code; code++ {
+		if !documented[code] {
+			t.Errorf("error code %d is not documented", code)
+		}
+	}
+}
@@ -3,20 +3,22 @@ package email
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/lib/pq"
 )
 
-type pgStatusEvent struct {
-	Status int32     `json:"status"`
-	At     time.Time `json:"at"`
+type PostgresStorage struct {
+	db      *sql.DB
+	keyring *Keyring
 }
 
-type PostgresStorage struct {
-	db *sql.DB
+// querier runs queries on the database or in a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 func NewPostgresStorage(connStr string) (*PostgresStorage, error) {
@@ -24,76 +26,249 @@ func NewPostgresStorage(connStr string) (*PostgresStorage, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &PostgresStorage{db}, nil
+	return &PostgresStorage{db: db}, nil
+}
+
+// SetKeyring encrypts the recipients, the subject and the body of the emails
+// written from now on with the keyring, and decrypts the emails read with it
+func (s *PostgresStorage) SetKeyring(k *Keyring) {
+	s.keyring = k
+}
+
+// Check checks that the database can be reached
+func (s *PostgresStorage) Check(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
 func (s *PostgresStorage) insert(ctx context.Context, e Email) (Email, error) {
-	statusEvents := []pgStatusEvent{}
-	for _, v := range e.StatusHistory() {
-		statusEvents = append(statusEvents, pgStatusEvent{int32(v.Status()), v.At()})
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Email{}, fmt.Errorf("cannot begin transaction: %v", err)
 	}
-	bin, err := json.Marshal(&statusEvents)
+	defer tx.Rollback()
+	f, err := s.keyring.seal(emailFields(e))
 	if err != nil {
-		return Email{}, fmt.Errorf("cannot json.Marshal status: %v", err)
+		return Email{}, fmt.Errorf("cannot encrypt email: %v", err)
 	}
 	emailID := 0
-	query := `INSERT INTO notfy.email ("from", "to", cc, bcc, subject, body, status_events) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING email_id`
-	err = s.db.QueryRowContext(ctx, query, e.StringFrom(), pq.Array(e.StringTo()), pq.Array(e.StringCC()), pq.Array(e.StringBCC()), e.Subject(), e.Body(), bin).Scan(&emailID)
+	query := `INSERT INTO notfy.email ("from", "to", cc, bcc, subject, body, priority, tenant, batch_id, current_status, current_status_at, key_id, data_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING email_id`
+	err = tx.QueryRowContext(ctx, query, e.StringFrom(), pq.Array(f.to), pq.Array(f.cc), pq.Array(f.bcc), f.subject, f.body, e.Priority(), e.Tenant(), e.Batch(), currentStatus(e.StatusHistory()), currentStatusAt(e.StatusHistory()), f.keyID, f.dataKey).Scan(&emailID)
 	if err != nil {
 		return Email{}, err
 	}
+	if err := insertStatusEvents(ctx, tx, emailID, 0, e.StatusHistory()); err != nil {
+		return Email{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Email{}, fmt.Errorf("cannot commit transaction: %v", err)
+	}
 	e.SetID(emailID)
+	e.version = 1
 	return e, nil
 }
 
+const emailColumns = `email_id, "from", "to", cc, bcc, subject, body, priority, tenant, batch_id, version, key_id, data_key`
+
 func (s *PostgresStorage) get(ctx context.Context, id int) (Email, bool, error) {
-	query := `SELECT email_id, "from", "to", cc, bcc, subject, body, status_events FROM notfy.email WHERE email_id = $1`
-	rows, err := s.db.QueryContext(ctx, query, id)
+	emails, err := s.queryEmails(ctx, s.db, `SELECT `+emailColumns+` FROM notfy.email WHERE email_id = $1`, id)
 	if err != nil {
 		return Email{}, true, err
 	}
+	if len(emails) == 0 {
+		return Email{}, false, nil
+	}
+	return emails[0], true, nil
+}
+
+func (s *PostgresStorage) list(ctx context.Context, tenant string, after, limit int) ([]Email, error) {
+	query := `SELECT ` + emailColumns + ` FROM notfy.email WHERE email_id > $1 AND ($2 = '' OR tenant = $2) ORDER BY email_id LIMIT $3`
+	return s.queryEmails(ctx, s.db, query, after, tenant, limit)
+}
+
+// queryEmails gets the emails of a query of emailColumns, with their status events
+func (s *PostgresStorage) queryEmails(ctx context.Context, q querier, query string, args ...interface{}) ([]Email, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	arr := make([]Email, 0)
+	for rows.Next() {
+		e, err := s.scanEmail(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		arr = append(arr, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadStatusEvents(ctx, q, arr); err != nil {
+		return nil, err
+	}
+	return arr, nil
+}
+
+// scanEmail scans a row of emailColumns, and decrypts it
+func (s *PostgresStorage) scanEmail(rows *sql.Rows) (Email, error) {
+	var id, version int
+	var from, subject, body, tenant, batch, keyID string
+	var priority Priority
+	var pqTo, pqCC, pqBCC pq.StringArray
+	var dataKey []byte
+	if err := rows.Scan(&id, &from, &pqTo, &pqCC, &pqBCC, &subject, &body, &priority, &tenant, &batch, &version, &keyID, &dataKey); err != nil {
+		return Email{}, fmt.Errorf("cannot scan row: %v", err)
+	}
+	f, err := s.keyring.open(sensitiveFields{pqTo, pqCC, pqBCC, subject, body, keyID, dataKey})
+	if err != nil {
+		return Email{}, fmt.Errorf("cannot decrypt email %d: %v", id, err)
+	}
+	e, err := New(id, from, f.to, f.cc, f.bcc, f.subject, f.body)
+	if err != nil {
+		return Email{}, fmt.Errorf("cannot build email: %v", err)
+	}
+	e.SetPriority(priority)
+	e.SetTenant(tenant)
+	e.SetBatch(batch)
+	e.version = version
+	return e, nil
+}
+
+// loadStatusEvents adds their status events to the emails, in one query
+func loadStatusEvents(ctx context.Context, q querier, emails []Email) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(emails))
+	index := make(map[int]int)
+	for i, e := range emails {
+		ids[i] = int64(e.ID())
+		index[e.ID()] = i
+	}
+	query := `SELECT email_id, status, at, detail FROM notfy.email_status_event WHERE email_id = ANY($1) ORDER BY email_id, seq`
+	rows, err := q.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
 	for rows.Next() {
 		var id int
-		var from, subject, body string
-		var dbStatusEvent []byte
-		var pqTo, pqCC, pqBCC pq.StringArray
-		if err := rows.Scan(&id, &from, &pqTo, &pqCC, &pqBCC, &subject, &body, &dbStatusEvent); err != nil {
-			return Email{}, true, fmt.Errorf("cannot scan row: %v", err)
+		var status Status
+		var at time.Time
+		var detail string
+		if err := rows.Scan(&id, &status, &at, &detail); err != nil {
+			return fmt.Errorf("cannot scan row: %v", err)
 		}
+		emails[index[id]].AddStatusEvent(MakeStatusEventWithDetail(status, at.UTC(), detail))
+	}
+	return rows.Err()
+}
 
-		se := []pgStatusEvent{}
-		if err := json.Unmarshal(dbStatusEvent, &se); err != nil {
-			return Email{}, true, fmt.Errorf("cannot json.Unmarshal status_events: %v and values is %s and from %s", err, dbStatusEvent, from)
+// insertStatusEvents inserts the events of the email numbered from seq, in one query
+func insertStatusEvents(ctx context.Context, q querier, id, seq int, events []StatusEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	statuses := make([]int64, len(events))
+	ats := make([]string, len(events))
+	details := make([]string, len(events))
+	for i, v := range events {
+		statuses[i] = int64(v.Status())
+		ats[i] = v.At().Format(time.RFC3339Nano)
+		details[i] = v.Detail()
+	}
+	query := `INSERT INTO notfy.email_status_event (email_id, seq, status, at, detail)
+		SELECT $1, $2 + ev.n - 1, ev.status, ev.at, ev.detail
+		FROM unnest($3::smallint[], $4::timestamptz[], $5::text[]) WITH ORDINALITY AS ev (status, at, detail, n)`
+	if _, err := q.ExecContext(ctx, query, id, seq, pq.Array(statuses), pq.Array(ats), pq.Array(details)); err != nil {
+		return fmt.Errorf("cannot insert status events: %v", err)
+	}
+	return nil
+}
+
+// currentStatus gets the status of the last event of the history, or null if
+// there is none
+func currentStatus(history StatusHistory) interface{} {
+	if len(history) == 0 {
+		return nil
+	}
+	return int(history[len(history)-1].Status())
+}
+
+// currentStatusAt gets the time of the last event of the history, or null if
+// there is none
+func currentStatusAt(history StatusHistory) interface{} {
+	if len(history) == 0 {
+		return nil
+	}
+	return history[len(history)-1].At()
+}
+
+func (s *PostgresStorage) update(ctx context.Context, e Email) (Email, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Email{}, true, fmt.Errorf("cannot begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	f, err := s.keyring.seal(emailFields(e))
+	if err != nil {
+		return Email{}, true, fmt.Errorf("cannot encrypt email: %v", err)
+	}
+
+	query := `UPDATE notfy.email SET "from" = $1, "to" = $2, cc = $3, bcc = $4, subject = $5, body = $6, priority = $7, tenant = $8, batch_id = $9,
+		current_status = $10, current_status_at = $11, key_id = $12, data_key = $13, version = version + 1
+		WHERE email_id = $14 AND version = $15`
+	result, err := tx.ExecContext(ctx, query, e.StringFrom(), pq.Array(f.to), pq.Array(f.cc), pq.Array(f.bcc), f.subject, f.body, e.Priority(), e.Tenant(), e.Batch(),
+		currentStatus(e.StatusHistory()), currentStatusAt(e.StatusHistory()), f.keyID, f.dataKey, e.ID(), e.version)
+	if err != nil {
+		return Email{}, true, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return Email{}, true, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	if rows == 0 {
+		// the email is either missing or at another version
+		var version int
+		err := tx.QueryRowContext(ctx, `SELECT version FROM notfy.email WHERE email_id = $1`, e.ID()).Scan(&version)
+		if err == sql.ErrNoRows {
+			return Email{}, false, nil
 		}
-		e, err := New(id, from, pqTo, pqCC, pqBCC, subject, body)
 		if err != nil {
-			return Email{}, true, fmt.Errorf("cannot build email: %v", err)
-		}
-		for _, v := range se {
-			e.AddStatusEvent(MakeStatusEvent(Status(v.Status), v.At))
+			return Email{}, true, err
 		}
-		return e, true, nil
+		return Email{}, true, ErrVersionConflict
 	}
-	return Email{}, false, nil
-}
 
-func (s *PostgresStorage) update(ctx context.Context, e Email) (Email, bool, error) {
-	var se []pgStatusEvent
-	for _, v := range e.StatusHistory() {
-		se = append(se, pgStatusEvent{
-			Status: int32(v.Status()),
-			At:     v.At(),
-		})
+	// the history is replaced along with the rest of the email
+	if _, err := tx.ExecContext(ctx, `DELETE FROM notfy.email_status_event WHERE email_id = $1`, e.ID()); err != nil {
+		return Email{}, true, fmt.Errorf("cannot delete status events: %v", err)
+	}
+	if err := insertStatusEvents(ctx, tx, e.ID(), 0, e.StatusHistory()); err != nil {
+		return Email{}, true, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Email{}, true, fmt.Errorf("cannot commit transaction: %v", err)
 	}
+	e.version++
+	return e, true, nil
+}
 
-	bin, err := json.Marshal(se)
+// appendStatusEvents inserts the events after the last one of the email. The
+// update of the email locks its row, so concurrent appends are numbered one
+// after the other.
+func (s *PostgresStorage) appendStatusEvents(ctx context.Context, id int, events []StatusEvent) (Email, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return Email{}, true, fmt.Errorf("unable to marshal status_events: %v", err)
+		return Email{}, true, fmt.Errorf("cannot begin transaction: %v", err)
 	}
+	defer tx.Rollback()
 
-	query := `UPDATE notfy.email SET "from" = $1, "to" = $2, cc = $3, bcc = $4, subject = $5, body = $6, status_events = $7 WHERE email_id = $8`
-	result, err := s.db.ExecContext(ctx, query, e.StringFrom(), pq.Array(e.StringTo()), pq.Array(e.StringCC()), pq.Array(e.StringBCC()), e.Subject(), e.Body(), bin, e.ID())
+	query := `UPDATE notfy.email SET current_status = COALESCE($1::smallint, current_status), current_status_at = COALESCE($2::timestamptz, current_status_at), version = version + 1
+		WHERE email_id = $3`
+	result, err := tx.ExecContext(ctx, query, currentStatus(events), currentStatusAt(events), id)
 	if err != nil {
 		return Email{}, true, err
 	}
@@ -104,6 +279,511 @@ func (s *PostgresStorage) update(ctx context.Context, e Email) (Email, bool, err
 	if rows == 0 {
 		return Email{}, false, nil
 	}
+	var seq int
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq) + 1, 0) FROM notfy.email_status_event WHERE email_id = $1`, id).Scan(&seq)
+	if err != nil {
+		return Email{}, true, fmt.Errorf("cannot get the next status event: %v", err)
+	}
+	if err := insertStatusEvents(ctx, tx, id, seq, events); err != nil {
+		return Email{}, true, err
+	}
+	emails, err := s.queryEmails(ctx, tx, `SELECT `+emailColumns+` FROM notfy.email WHERE email_id = $1`, id)
+	if err != nil {
+		return Email{}, true, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Email{}, true, fmt.Errorf("cannot commit transaction: %v", err)
+	}
+	return emails[0], true, nil
+}
 
-	return e, true, nil
+// insertBatch allocates the ids of the emails from the sequence, then copies
+// them and their status events into the tables in one transaction
+func (s *PostgresStorage) insertBatch(ctx context.Context, emails []Email) ([]Email, error) {
+	if len(emails) == 0 {
+		return []Email{}, nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT nextval('notfy.email_email_id_seq') FROM generate_series(1, $1)`, len(emails))
+	if err != nil {
+		return nil, fmt.Errorf("cannot allocate ids: %v", err)
+	}
+	ids := make([]int, 0, len(emails))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	arr := make([]Email, 0, len(emails))
+	err = copyIn(ctx, tx, "email", []string{"email_id", "from", "to", "cc", "bcc", "subject", "body", "priority", "tenant", "batch_id", "current_status", "current_status_at", "key_id", "data_key"}, func(exec func(...interface{}) error) error {
+		for i, e := range emails {
+			e.SetID(ids[i])
+			e.version = 1
+			f, err := s.keyring.seal(emailFields(e))
+			if err != nil {
+				return fmt.Errorf("cannot encrypt email: %v", err)
+			}
+			if err := exec(e.ID(), e.StringFrom(), pq.Array(f.to), pq.Array(f.cc), pq.Array(f.bcc), f.subject, f.body, e.Priority(), e.Tenant(), e.Batch(), currentStatus(e.StatusHistory()), currentStatusAt(e.StatusHistory()), f.keyID, f.dataKey); err != nil {
+				return fmt.Errorf("cannot copy email: %v", err)
+			}
+			arr = append(arr, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = copyIn(ctx, tx, "email_status_event", []string{"email_id", "seq", "status", "at", "detail"}, func(exec func(...interface{}) error) error {
+		for _, e := range arr {
+			for seq, v := range e.StatusHistory() {
+				if err := exec(e.ID(), seq, int(v.Status()), v.At(), v.Detail()); err != nil {
+					return fmt.Errorf("cannot copy status event: %v", err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("cannot commit transaction: %v", err)
+	}
+	return arr, nil
+}
+
+// copyIn copies the rows that fill passes to exec into the table of the notfy schema
+func copyIn(ctx context.Context, tx *sql.Tx, table string, columns []string, fill func(exec func(...interface{}) error) error) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyInSchema("notfy", table, columns...))
+	if err != nil {
+		return fmt.Errorf("cannot prepare copy: %v", err)
+	}
+	err = fill(func(args ...interface{}) error {
+		_, err := stmt.ExecContext(ctx, args...)
+		return err
+	})
+	if err != nil {
+		stmt.Close()
+		return err
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return fmt.Errorf("cannot flush copy: %v", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("cannot close copy: %v", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) countBatch(ctx context.Context, tenant, batch string) (map[Status]int, error) {
+	query := `SELECT current_status, count(*) FROM notfy.email
+		WHERE batch_id = $1 AND ($2 = '' OR tenant = $2) AND current_status IS NOT NULL GROUP BY 1`
+	rows, err := s.db.QueryContext(ctx, query, batch, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[Status]int)
+	for rows.Next() {
+		var status Status
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+func (s *PostgresStorage) listExpired(ctx context.Context, cutoffs []retentionCutoff, after, limit int) ([]expiredEmail, error) {
+	args := []interface{}{after, limit}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	query := `SELECT email_id, tenant, current_status, current_status_at, subject = '' AND body = '' FROM notfy.email
+		WHERE email_id > $1 AND ` + cutoffsCondition(cutoffs, arg) + ` ORDER BY email_id LIMIT $2`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]expiredEmail, 0)
+	for rows.Next() {
+		var e expiredEmail
+		if err := rows.Scan(&e.id, &e.tenant, &e.status, &e.at, &e.redacted); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		arr = append(arr, e)
+	}
+	return arr, rows.Err()
+}
+
+func (s *PostgresStorage) redact(ctx context.Context, ids []int) (int, error) {
+	// the details of the status events are emptied with the content
+	query := `WITH details AS (
+			UPDATE notfy.email_status_event SET detail = '' WHERE email_id = ANY($1) AND detail <> ''
+		)
+		UPDATE notfy.email SET subject = '', body = '', version = version + 1
+		WHERE email_id = ANY($1) AND (subject <> '' OR body <> '')`
+	return s.exec(ctx, query, pq.Array(int64s(ids)))
+}
+
+// deleteEmails deletes the emails, and their status events with them
+func (s *PostgresStorage) deleteEmails(ctx context.Context, ids []int) (int, error) {
+	return s.exec(ctx, `DELETE FROM notfy.email WHERE email_id = ANY($1)`, pq.Array(int64s(ids)))
+}
+
+// Reencrypt encrypts the emails that are not encrypted with the primary key
+// of the keyring, batch by batch. It returns how many it encrypted.
+func (s *PostgresStorage) Reencrypt(ctx context.Context, batchSize int) (int, error) {
+	if s.keyring == nil {
+		return 0, ErrNoKeyring
+	}
+	n, after := 0, 0
+	for {
+		query := `SELECT email_id, version, "to", cc, bcc, subject, body, key_id, data_key FROM notfy.email
+			WHERE email_id > $1 AND key_id <> $2 ORDER BY email_id LIMIT $3`
+		rows, err := s.db.QueryContext(ctx, query, after, s.keyring.PrimaryKeyID(), batchSize)
+		if err != nil {
+			return n, err
+		}
+		var batch []reencryptRow
+		for rows.Next() {
+			var r reencryptRow
+			var pqTo, pqCC, pqBCC pq.StringArray
+			if err := rows.Scan(&r.id, &r.version, &pqTo, &pqCC, &pqBCC, &r.fields.subject, &r.fields.body, &r.fields.keyID, &r.fields.dataKey); err != nil {
+				rows.Close()
+				return n, fmt.Errorf("cannot scan row: %v", err)
+			}
+			r.fields.to, r.fields.cc, r.fields.bcc = pqTo, pqCC, pqBCC
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return n, err
+		}
+		if len(batch) == 0 {
+			return n, nil
+		}
+		for _, r := range batch {
+			f, _, err := s.keyring.reencrypt(r.fields)
+			if err != nil {
+				return n, fmt.Errorf("cannot encrypt email %d: %v", r.id, err)
+			}
+			// the version is kept, the email is the same. It is skipped if it
+			// was updated meanwhile, since the update encrypted it.
+			query := `UPDATE notfy.email SET "to" = $1, cc = $2, bcc = $3, subject = $4, body = $5, key_id = $6, data_key = $7 WHERE email_id = $8 AND version = $9`
+			updated, err := s.exec(ctx, query, pq.Array(f.to), pq.Array(f.cc), pq.Array(f.bcc), f.subject, f.body, f.keyID, f.dataKey, r.id, r.version)
+			if err != nil {
+				return n, err
+			}
+			n += updated
+		}
+		after = batch[len(batch)-1].id
+	}
+}
+
+// int64s converts the ids for pq.Array
+func int64s(ids []int) []int64 {
+	arr := make([]int64, len(ids))
+	for i, id := range ids {
+		arr[i] = int64(id)
+	}
+	return arr
+}
+
+// exec runs the query and returns the number of rows it affected
+func (s *PostgresStorage) exec(ctx context.Context, query string, args ...interface{}) (int, error) {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	return int(rows), nil
+}
+
+func (s *PostgresStorage) insertAPIKey(ctx context.Context, k APIKey) (APIKey, error) {
+	query := `INSERT INTO notfy.api_key (tenant, name, key_hash, created_at) VALUES ($1, $2, $3, $4) RETURNING api_key_id`
+	if err := s.db.QueryRowContext(ctx, query, k.Tenant, k.Name, k.Hash, k.CreatedAt).Scan(&k.ID); err != nil {
+		return APIKey{}, err
+	}
+	return k, nil
+}
+
+func (s *PostgresStorage) getAPIKeyByHash(ctx context.Context, hash string) (APIKey, bool, error) {
+	query := `SELECT api_key_id, tenant, name, key_hash, created_at FROM notfy.api_key WHERE key_hash = $1`
+	var k APIKey
+	err := s.db.QueryRowContext(ctx, query, hash).Scan(&k.ID, &k.Tenant, &k.Name, &k.Hash, &k.CreatedAt)
+	if err == sql.ErrNoRows {
+		return APIKey{}, false, nil
+	}
+	if err != nil {
+		return APIKey{}, true, err
+	}
+	k.CreatedAt = k.CreatedAt.UTC()
+	return k, true, nil
+}
+
+func (s *PostgresStorage) listAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+	query := `SELECT api_key_id, tenant, name, key_hash, created_at FROM notfy.api_key WHERE $1 = '' OR tenant = $1 ORDER BY api_key_id`
+	rows, err := s.db.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]APIKey, 0)
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Tenant, &k.Name, &k.Hash, &k.CreatedAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		k.CreatedAt = k.CreatedAt.UTC()
+		arr = append(arr, k)
+	}
+	return arr, rows.Err()
+}
+
+func (s *PostgresStorage) deleteAPIKey(ctx context.Context, id int) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM notfy.api_key WHERE api_key_id = $1`, id)
+	if err != nil {
+		return true, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return true, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	return rows > 0, nil
+}
+
+func (s *PostgresStorage) insertSenderIdentity(ctx context.Context, i SenderIdentity) (SenderIdentity, error) {
+	query := `INSERT INTO notfy.sender_identity (tenant, identity, created_at) VALUES ($1, $2, $3) RETURNING sender_identity_id`
+	if err := s.db.QueryRowContext(ctx, query, i.Tenant, i.Identity, i.CreatedAt).Scan(&i.ID); err != nil {
+		return SenderIdentity{}, err
+	}
+	return i, nil
+}
+
+func (s *PostgresStorage) listSenderIdentities(ctx context.Context, tenant string) ([]SenderIdentity, error) {
+	query := `SELECT sender_identity_id, tenant, identity, created_at FROM notfy.sender_identity WHERE $1 = '' OR tenant = $1 ORDER BY sender_identity_id`
+	rows, err := s.db.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]SenderIdentity, 0)
+	for rows.Next() {
+		var i SenderIdentity
+		if err := rows.Scan(&i.ID, &i.Tenant, &i.Identity, &i.CreatedAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		i.CreatedAt = i.CreatedAt.UTC()
+		arr = append(arr, i)
+	}
+	return arr, rows.Err()
+}
+
+func (s *PostgresStorage) deleteSenderIdentity(ctx context.Context, id int) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM notfy.sender_identity WHERE sender_identity_id = $1`, id)
+	if err != nil {
+		return true, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return true, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	return rows > 0, nil
+}
+
+func (s *PostgresStorage) upsertSuppression(ctx context.Context, sup Suppression) error {
+	var expiresAt *time.Time
+	if !sup.ExpiresAt.IsZero() {
+		expiresAt = &sup.ExpiresAt
+	}
+	query := `INSERT INTO notfy.suppression (tenant, address, reason, detail, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tenant, address, reason) DO UPDATE SET detail = EXCLUDED.detail, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at`
+	_, err := s.db.ExecContext(ctx, query, sup.Tenant, sup.Address, sup.Reason, sup.Detail, sup.CreatedAt, expiresAt)
+	return err
+}
+
+func (s *PostgresStorage) getSuppressions(ctx context.Context, tenant string, addrs []string) ([]Suppression, error) {
+	query := `SELECT tenant, address, reason, detail, created_at, expires_at FROM notfy.suppression
+		WHERE tenant IN ('', $1) AND address = ANY($2)`
+	return s.querySuppressions(ctx, query, tenant, pq.Array(addrs))
+}
+
+func (s *PostgresStorage) listSuppressions(ctx context.Context) ([]Suppression, error) {
+	query := `SELECT tenant, address, reason, detail, created_at, expires_at FROM notfy.suppression ORDER BY tenant, address, reason`
+	return s.querySuppressions(ctx, query)
+}
+
+func (s *PostgresStorage) querySuppressions(ctx context.Context, query string, args ...interface{}) ([]Suppression, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]Suppression, 0)
+	for rows.Next() {
+		var sup Suppression
+		var expiresAt pq.NullTime
+		if err := rows.Scan(&sup.Tenant, &sup.Address, &sup.Reason, &sup.Detail, &sup.CreatedAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		sup.CreatedAt = sup.CreatedAt.UTC()
+		if expiresAt.Valid {
+			sup.ExpiresAt = expiresAt.Time.UTC()
+		}
+		arr = append(arr, sup)
+	}
+	return arr, rows.Err()
+}
+
+func (s *PostgresStorage) deleteSuppression(ctx context.Context, tenant, addr string, reason SuppressionReason) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM notfy.suppression WHERE tenant = $1 AND address = $2 AND reason = $3`, tenant, addr, reason)
+	if err != nil {
+		return true, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return true, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	return rows > 0, nil
+}
+
+func (s *PostgresStorage) insertWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	query := `INSERT INTO notfy.webhook (tenant, url, secret, created_at) VALUES ($1, $2, $3, $4) RETURNING webhook_id`
+	if err := s.db.QueryRowContext(ctx, query, w.Tenant, w.URL, w.Secret, w.CreatedAt).Scan(&w.ID); err != nil {
+		return Webhook{}, err
+	}
+	return w, nil
+}
+
+func (s *PostgresStorage) getWebhook(ctx context.Context, id int) (Webhook, bool, error) {
+	query := `SELECT webhook_id, tenant, url, secret, created_at FROM notfy.webhook WHERE webhook_id = $1`
+	var w Webhook
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&w.ID, &w.Tenant, &w.URL, &w.Secret, &w.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Webhook{}, false, nil
+	}
+	if err != nil {
+		return Webhook{}, false, err
+	}
+	w.CreatedAt = w.CreatedAt.UTC()
+	return w, true, nil
+}
+
+func (s *PostgresStorage) listWebhooks(ctx context.Context, tenant string) ([]Webhook, error) {
+	query := `SELECT webhook_id, tenant, url, secret, created_at FROM notfy.webhook WHERE $1 = '' OR tenant = $1 ORDER BY webhook_id`
+	rows, err := s.db.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]Webhook, 0)
+	for rows.Next() {
+		var w Webhook
+		if err := rows.Scan(&w.ID, &w.Tenant, &w.URL, &w.Secret, &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		w.CreatedAt = w.CreatedAt.UTC()
+		arr = append(arr, w)
+	}
+	return arr, rows.Err()
+}
+
+func (s *PostgresStorage) deleteWebhook(ctx context.Context, id int) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM notfy.webhook WHERE webhook_id = $1`, id)
+	if err != nil {
+		return true, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return true, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	return rows > 0, nil
+}
+
+func (s *PostgresStorage) insertWebhookDelivery(ctx context.Context, d WebhookDelivery) (WebhookDelivery, error) {
+	// the payload is only kept while the delivery is to be retried
+	var payload []byte
+	var nextAttemptAt *time.Time
+	if !d.NextAttemptAt.IsZero() {
+		payload = d.payload
+		nextAttemptAt = &d.NextAttemptAt
+	}
+	query := `INSERT INTO notfy.webhook_delivery (webhook_id, email_id, event, attempt, status_code, error, test, at, payload, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING webhook_delivery_id`
+	err := s.db.QueryRowContext(ctx, query, d.WebhookID, d.EmailID, d.Event, d.Attempt, d.StatusCode, d.Error, d.Test, d.At, payload, nextAttemptAt).Scan(&d.ID)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	return d, nil
+}
+
+func (s *PostgresStorage) listWebhookDeliveries(ctx context.Context, webhookID int) ([]WebhookDelivery, error) {
+	query := `SELECT webhook_delivery_id, webhook_id, email_id, event, attempt, status_code, error, test, at, NULL, next_attempt_at
+		FROM notfy.webhook_delivery WHERE webhook_id = $1 ORDER BY webhook_delivery_id`
+	return s.queryWebhookDeliveries(ctx, query, webhookID)
+}
+
+func (s *PostgresStorage) listDueWebhookDeliveries(ctx context.Context, before time.Time, limit int) ([]WebhookDelivery, error) {
+	query := `SELECT webhook_delivery_id, webhook_id, email_id, event, attempt, status_code, error, test, at, payload, next_attempt_at
+		FROM notfy.webhook_delivery WHERE next_attempt_at <= $1 ORDER BY next_attempt_at, webhook_delivery_id LIMIT $2`
+	return s.queryWebhookDeliveries(ctx, query, before, limit)
+}
+
+func (s *PostgresStorage) queryWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var d WebhookDelivery
+		var errText sql.NullString
+		var nextAttemptAt pq.NullTime
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EmailID, &d.Event, &d.Attempt, &d.StatusCode, &errText, &d.Test, &d.At, &d.payload, &nextAttemptAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		d.Error = errText.String
+		d.At = d.At.UTC()
+		if nextAttemptAt.Valid {
+			d.NextAttemptAt = nextAttemptAt.Time.UTC()
+		}
+		arr = append(arr, d)
+	}
+	return arr, rows.Err()
+}
+
+func (s *PostgresStorage) claimWebhookDelivery(ctx context.Context, id int) (bool, error) {
+	query := `UPDATE notfy.webhook_delivery SET payload = NULL, next_attempt_at = NULL
+		WHERE webhook_delivery_id = $1 AND next_attempt_at IS NOT NULL`
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	return rows > 0, nil
 }
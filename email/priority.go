@@ -0,0 +1,45 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Priority is the lane an email is sent through
+type Priority uint32
+
+//go:generate stringer -type Priority -trimprefix Priority
+const (
+	// PriorityNormal is the zero value, so emails queued before priorities
+	// existed are sent in the normal lane
+	PriorityNormal Priority = iota
+	PriorityHigh
+	PriorityLow
+)
+
+// priorities are the priorities of the lanes, from the highest
+var priorities = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// lane gets the priority of the lane the email is sent through. Unknown
+// priorities go to the normal lane.
+func (p Priority) lane() Priority {
+	for _, v := range priorities {
+		if v == p {
+			return p
+		}
+	}
+	return PriorityNormal
+}
+
+// ParsePriority parses the name of a priority. An empty name is normal priority.
+func ParsePriority(s string) (Priority, error) {
+	switch strings.ToLower(s) {
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	case "low":
+		return PriorityLow, nil
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority %q", s)
+}
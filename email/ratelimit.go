@@ -0,0 +1,261 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// Rate is the number of emails allowed per interval. The zero value is unlimited.
+type Rate struct {
+	Count int
+	Per   time.Duration
+
+	// Burst is the number of emails that can be sent at once. It defaults to Count.
+	Burst int
+}
+
+func (r Rate) unlimited() bool { return r.Count <= 0 || r.Per <= 0 }
+
+// RateLimitConfig is the outbound rate limits of the deamon
+type RateLimitConfig struct {
+	// Global limits every email sent
+	Global Rate
+
+	// Relay limits the emails sent through each SMTP relay
+	Relay Rate
+
+	// SenderDomains limits the emails sent from a domain, e.g. "example.com".
+	// Domains that are not listed use DefaultSenderDomain.
+	SenderDomains       map[string]Rate
+	DefaultSenderDomain Rate
+
+	// RecipientDomains limits the recipients of a domain, e.g. "gmail.com" at
+	// 100 per minute. Domains that are not listed use DefaultRecipientDomain.
+	RecipientDomains       map[string]Rate
+	DefaultRecipientDomain Rate
+}
+
+// LimiterState is a snapshot of a token bucket of the rate limiter
+type LimiterState struct {
+	Key      string  `json:"key"`
+	Tokens   float64 `json:"tokens"`
+	Capacity float64 `json:"capacity"`
+	PerSec   float64 `json:"per_sec"`
+}
+
+type tokenBucket struct {
+	perSec float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(r Rate, now time.Time) *tokenBucket {
+	burst := r.Burst
+	if burst <= 0 {
+		burst = r.Count
+	}
+	return &tokenBucket{
+		perSec: float64(r.Count) / r.Per.Seconds(),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.perSec
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// delay gets how long until n tokens are available
+func (b *tokenBucket) delay(n float64) time.Duration {
+	if n > b.burst {
+		n = b.burst
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.perSec * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if n > b.burst {
+		n = b.burst
+	}
+	b.tokens -= n
+}
+
+// bucketSweepInterval is how often the buckets that are full again are evicted
+const bucketSweepInterval = time.Minute
+
+// rateLimiter holds a token bucket for every limit an email is subject to.
+// An email is only let through when every one of its buckets has tokens.
+// A bucket that is full again is the same as a new one, so it is evicted to
+// keep the buckets of the domains seen once from piling up.
+type rateLimiter struct {
+	mu      sync.Mutex
+	cfg     RateLimitConfig
+	relay   string
+	buckets map[string]*tokenBucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig, relay string) *rateLimiter {
+	return &rateLimiter{
+		cfg:     cfg,
+		relay:   relay,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// reserve takes the tokens the email needs and returns 0, or takes nothing and
+// returns how long to wait before trying again.
+func (l *rateLimiter) reserve(e Email) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.swept) >= bucketSweepInterval {
+		l.sweep(now)
+	}
+	needs := l.needs(e)
+	var delay time.Duration
+	for key, n := range needs {
+		b := l.bucket(key, now)
+		if b == nil {
+			continue
+		}
+		if d := b.delay(n); d > delay {
+			delay = d
+		}
+	}
+	if delay > 0 {
+		return delay
+	}
+	for key, n := range needs {
+		if b := l.bucket(key, now); b != nil {
+			b.take(n)
+		}
+	}
+	return 0
+}
+
+// sweep evicts the buckets that are full again
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= b.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// state gets a snapshot of every bucket, sorted by key
+func (l *rateLimiter) state() []LimiterState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	arr := make([]LimiterState, 0, len(l.buckets))
+	for key, b := range l.buckets {
+		b.refill(now)
+		arr = append(arr, LimiterState{key, b.tokens, b.burst, b.perSec})
+	}
+	sort.Slice(arr, func(i, j int) bool { return arr[i].Key < arr[j].Key })
+	return arr
+}
+
+// needs gets the number of tokens the email takes from each bucket
+func (l *rateLimiter) needs(e Email) map[string]float64 {
+	needs := map[string]float64{
+		"global":           1,
+		"relay:" + l.relay: 1,
+	}
+	from := e.From()
+	needs["sender:"+domainOf(from.Address)] = 1
+	recipients := append(append(e.To(), e.CC()...), e.BCC()...)
+	for _, addr := range recipients {
+		needs["recipient:"+domainOf(addr.Address)]++
+	}
+	return needs
+}
+
+// bucket gets the bucket of the key, creating it on first use. It returns nil
+// if the key is not limited.
+func (l *rateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	if b, ok := l.buckets[key]; ok {
+		b.refill(now)
+		return b
+	}
+	r := l.rateOf(key)
+	if r.unlimited() {
+		return nil
+	}
+	b := newTokenBucket(r, now)
+	l.buckets[key] = b
+	return b
+}
+
+func (l *rateLimiter) rateOf(key string) Rate {
+	kind, name := key, ""
+	if i := strings.Index(key, ":"); i >= 0 {
+		kind, name = key[:i], key[i+1:]
+	}
+	switch kind {
+	case "global":
+		return l.cfg.Global
+	case "relay":
+		return l.cfg.Relay
+	case "sender":
+		if r, ok := l.cfg.SenderDomains[name]; ok {
+			return r
+		}
+		return l.cfg.DefaultSenderDomain
+	case "recipient":
+		if r, ok := l.cfg.RecipientDomains[name]; ok {
+			return r
+		}
+		return l.cfg.DefaultRecipientDomain
+	}
+	return Rate{}
+}
+
+func domainOf(addr string) string {
+	return strings.ToLower(addr[strings.LastIndex(addr, "@")+1:])
+}
+
+// RateLimitHTTPHandler serves the state of the rate limits of a deamon. It
+// should be mounted behind the admin authentication.
+type RateLimitHTTPHandler struct {
+	d *Deamon
+}
+
+// NewRateLimitHTTPHandler creates a new handler for the rate limits of the deamon
+func NewRateLimitHTTPHandler(d *Deamon) *RateLimitHTTPHandler {
+	return &RateLimitHTTPHandler{d}
+}
+
+// Route routes the requests to the handler
+func (h *RateLimitHTTPHandler) Route(r chi.Router) {
+	r.Get("/ratelimits", h.getHandler)
+}
+
+// getHandler lists the buckets that are not full. The limits of the keys
+// that are not listed are not in use.
+func (h *RateLimitHTTPHandler) getHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.d.RateLimits())
+}
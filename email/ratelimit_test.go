@@ -0,0 +1,122 @@
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	gmail, _ := New(0, "from@example.com", []string{"to@gmail.com"}, nil, nil, "subject", "body")
+	yahoo, _ := New(0, "from@example.com", []string{"to@yahoo.com"}, nil, nil, "subject", "body")
+	tests := []struct {
+		name   string
+		cfg    RateLimitConfig
+		emails []Email
+		delays []time.Duration
+	}{
+		{
+			name:   "should not delay when there are no limits",
+			cfg:    RateLimitConfig{},
+			emails: []Email{gmail, gmail, gmail},
+			delays: []time.Duration{0, 0, 0},
+		},
+		{
+			name:   "should delay once the global burst is used",
+			cfg:    RateLimitConfig{Global: Rate{Count: 2, Per: time.Second}},
+			emails: []Email{gmail, yahoo, gmail},
+			delays: []time.Duration{0, 0, 500 * time.Millisecond},
+		},
+		{
+			name: "should limit recipient domains separately",
+			cfg: RateLimitConfig{
+				RecipientDomains: map[string]Rate{"gmail.com": {Count: 1, Per: time.Minute}},
+			},
+			emails: []Email{gmail, yahoo, gmail},
+			delays: []time.Duration{0, 0, time.Minute},
+		},
+		{
+			name:   "should limit every sender domain with the default rate",
+			cfg:    RateLimitConfig{DefaultSenderDomain: Rate{Count: 1, Per: time.Second, Burst: 1}},
+			emails: []Email{gmail, yahoo},
+			delays: []time.Duration{0, time.Second},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Date(2018, 12, 3, 19, 32, 55, 0, time.UTC)
+			l := newRateLimiter(test.cfg, "smtp.example.com:587")
+			l.now = func() time.Time { return now }
+			for i, e := range test.emails {
+				if got := l.reserve(e); got != test.delays[i] {
+					t.Fatalf("email %d: got delay %v, but expected %v", i, got, test.delays[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	now := time.Date(2018, 12, 3, 19, 32, 55, 0, time.UTC)
+	l := newRateLimiter(RateLimitConfig{Relay: Rate{Count: 60, Per: time.Minute, Burst: 1}}, "smtp.example.com:587")
+	l.now = func() time.Time { return now }
+	e, _ := New(0, "from@example.com", []string{"to@gmail.com"}, nil, nil, "subject", "body")
+	if d := l.reserve(e); d != 0 {
+		t.Fatalf("got delay %v, but expected none", d)
+	}
+	if d := l.reserve(e); d != time.Second {
+		t.Fatalf("got delay %v, but expected %v", d, time.Second)
+	}
+	now = now.Add(time.Second)
+	if d := l.reserve(e); d != 0 {
+		t.Fatalf("got delay %v after refill, but expected none", d)
+	}
+
+	state := l.state()
+	if len(state) != 1 || state[0].Key != "relay:smtp.example.com:587" || state[0].Tokens != 0 {
+		t.Fatalf("got state %+v, but expected an empty relay bucket", state)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	now := time.Date(2018, 12, 3, 19, 32, 55, 0, time.UTC)
+	l := newRateLimiter(RateLimitConfig{DefaultRecipientDomain: Rate{Count: 10, Per: time.Minute}}, "smtp.example.com:587")
+	l.now = func() time.Time { return now }
+	for i := 0; i < 100; i++ {
+		e, _ := New(0, "from@example.com", []string{fmt.Sprintf("to@%d.example.com", i)}, nil, nil, "subject", "body")
+		l.reserve(e)
+	}
+	if len(l.buckets) != 100 {
+		t.Fatalf("got %d buckets, but expected 100", len(l.buckets))
+	}
+
+	// the buckets are full again after a minute, and are evicted
+	now = now.Add(bucketSweepInterval)
+	e, _ := New(0, "from@example.com", []string{"to@gmail.com"}, nil, nil, "subject", "body")
+	l.reserve(e)
+	if len(l.buckets) != 1 || l.buckets["recipient:gmail.com"] == nil {
+		t.Fatalf("got buckets %v, but expected only the bucket of gmail.com", l.buckets)
+	}
+}
+
+func TestRateLimitHTTPHandler(t *testing.T) {
+	d := &Deamon{limiter: newRateLimiter(RateLimitConfig{Global: Rate{Count: 10, Per: time.Second}}, "smtp.example.com:587")}
+	e, _ := New(0, "from@example.com", []string{"to@gmail.com"}, nil, nil, "subject", "body")
+	d.limiter.reserve(e)
+
+	r := chi.NewRouter()
+	NewRateLimitHTTPHandler(d).Route(r)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/ratelimits", nil))
+	var got []LimiterState
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(got) != 1 || got[0].Key != "global" || got[0].Capacity != 10 {
+		t.Fatalf("got %+v, but expected the global bucket", got)
+	}
+}
@@ -0,0 +1,316 @@
+package email
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetentionRule is how long the emails of a tenant that reached a final status
+// are kept, counted from that status
+type RetentionRule struct {
+	// Tenant is the tenant of the rule. The rule applies to every tenant if it
+	// is empty.
+	Tenant string
+
+	// Statuses are the final statuses of the rule. The rule applies to every
+	// final status if it is empty.
+	Statuses []Status
+
+	// RedactAfter is when the subject and the body are emptied. They are never
+	// emptied if it is zero.
+	RedactAfter time.Duration
+
+	// DeleteAfter is when the email is deleted. It is never deleted if it is
+	// zero.
+	DeleteAfter time.Duration
+}
+
+func (r RetentionRule) matches(tenant string, status Status) bool {
+	if r.Tenant != "" && r.Tenant != tenant {
+		return false
+	}
+	if len(r.Statuses) == 0 {
+		return true
+	}
+	for _, v := range r.Statuses {
+		if v == status {
+			return true
+		}
+	}
+	return false
+}
+
+// score is how specific the rule is. A rule of the tenant is more specific
+// than a rule of every tenant, and then a rule of the status than a rule of
+// every status.
+func (r RetentionRule) score() int {
+	score := 0
+	if r.Tenant != "" {
+		score += 2
+	}
+	if len(r.Statuses) > 0 {
+		score++
+	}
+	return score
+}
+
+// condition builds the SQL condition of the final emails of the rule, with arg
+// adding an argument to the query and returning its placeholder
+func (r RetentionRule) condition(arg func(interface{}) string) string {
+	statuses := r.Statuses
+	if len(statuses) == 0 {
+		statuses = finalStatuses
+	}
+	in := make([]string, len(statuses))
+	for i, v := range statuses {
+		in[i] = arg(int(v))
+	}
+	cond := "current_status IN (" + strings.Join(in, ", ") + ")"
+	if r.Tenant != "" {
+		cond = "tenant = " + arg(r.Tenant) + " AND " + cond
+	}
+	return cond
+}
+
+// RetentionPolicy are the rules of the emails
+type RetentionPolicy []RetentionRule
+
+// rule gets the most specific rule of the tenant and the status. The first of
+// equally specific rules applies.
+func (p RetentionPolicy) rule(tenant string, status Status) (RetentionRule, bool) {
+	best, found := -1, false
+	var rule RetentionRule
+	for _, r := range p {
+		if !r.matches(tenant, status) {
+			continue
+		}
+		if score := r.score(); score > best {
+			best, rule, found = score, r, true
+		}
+	}
+	return rule, found
+}
+
+// cutoffs gets the cutoffs of the rules that redact or delete emails. The
+// emails another rule applies to instead are left out of the cutoff of a rule.
+func (p RetentionPolicy) cutoffs(now time.Time) []retentionCutoff {
+	var arr []retentionCutoff
+	for i, r := range p {
+		if r.RedactAfter <= 0 && r.DeleteAfter <= 0 {
+			continue
+		}
+		c := retentionCutoff{rule: r}
+		if r.RedactAfter > 0 {
+			c.redactBefore = now.Add(-r.RedactAfter)
+		}
+		if r.DeleteAfter > 0 {
+			c.deleteBefore = now.Add(-r.DeleteAfter)
+		}
+		for j, other := range p {
+			if j == i || (r.Tenant != "" && other.Tenant != "" && r.Tenant != other.Tenant) {
+				continue
+			}
+			if other.score() > r.score() || (other.score() == r.score() && j < i) {
+				c.except = append(c.except, other)
+			}
+		}
+		arr = append(arr, c)
+	}
+	return arr
+}
+
+// retentionCutoff selects the final emails of a rule that are due: the emails
+// whose status happened by deleteBefore, and the emails not redacted yet whose
+// status happened by redactBefore. A zero time selects no email.
+type retentionCutoff struct {
+	rule         RetentionRule
+	except       []RetentionRule
+	redactBefore time.Time
+	deleteBefore time.Time
+}
+
+// matches reports whether the cutoff selects the email
+func (c retentionCutoff) matches(e expiredEmail) bool {
+	if !e.status.final() || !c.rule.matches(e.tenant, e.status) {
+		return false
+	}
+	for _, r := range c.except {
+		if r.matches(e.tenant, e.status) {
+			return false
+		}
+	}
+	if !c.deleteBefore.IsZero() && !e.at.After(c.deleteBefore) {
+		return true
+	}
+	return !c.redactBefore.IsZero() && !e.at.After(c.redactBefore) && !e.redacted
+}
+
+// condition builds the SQL condition of the cutoff, with arg adding an
+// argument to the query and returning its placeholder
+func (c retentionCutoff) condition(arg func(interface{}) string) string {
+	conds := []string{c.rule.condition(arg)}
+	for _, r := range c.except {
+		conds = append(conds, "NOT ("+r.condition(arg)+")")
+	}
+	var due []string
+	if !c.deleteBefore.IsZero() {
+		due = append(due, "current_status_at <= "+arg(c.deleteBefore))
+	}
+	if !c.redactBefore.IsZero() {
+		due = append(due, "(current_status_at <= "+arg(c.redactBefore)+" AND (subject <> '' OR body <> ''))")
+	}
+	conds = append(conds, "("+strings.Join(due, " OR ")+")")
+	return "(" + strings.Join(conds, " AND ") + ")"
+}
+
+// cutoffsCondition builds the SQL condition of the final emails any of the
+// cutoffs selects
+func cutoffsCondition(cutoffs []retentionCutoff, arg func(interface{}) string) string {
+	conds := make([]string, len(cutoffs))
+	for i, c := range cutoffs {
+		conds[i] = c.condition(arg)
+	}
+	return "(" + (RetentionRule{}).condition(arg) + " AND (" + strings.Join(conds, " OR ") + "))"
+}
+
+// expiredEmail is a final email selected by a retention cutoff, without its
+// content
+type expiredEmail struct {
+	id       int
+	tenant   string
+	status   Status
+	at       time.Time
+	redacted bool
+}
+
+// PurgerConfig is the configuration of Purger
+type PurgerConfig struct {
+	Policy RetentionPolicy
+
+	// BatchSize is the number of emails read and purged at once. It defaults
+	// to 500.
+	BatchSize int
+
+	// Interval is the time between the purges of Run. It defaults to an hour.
+	Interval time.Duration
+
+	// DryRun counts the emails that would be purged without purging them
+	DryRun bool
+}
+
+// PurgeResult counts the emails of a purge
+type PurgeResult struct {
+	Redacted int
+	Deleted  int
+}
+
+// Purger redacts and deletes the emails past the retention of their tenant
+// and final status
+type Purger struct {
+	storage   Storage
+	policy    RetentionPolicy
+	batchSize int
+	interval  time.Duration
+	dryRun    bool
+	now       func() time.Time
+}
+
+// NewPurger creates a new instance of Purger
+func NewPurger(s Storage, cfg PurgerConfig) *Purger {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Hour
+	}
+	return &Purger{
+		storage:   s,
+		policy:    cfg.Policy,
+		batchSize: cfg.BatchSize,
+		interval:  cfg.Interval,
+		dryRun:    cfg.DryRun,
+		now:       time.Now,
+	}
+}
+
+// Run purges every interval until the context is done
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := p.Purge(ctx); err != nil && ctx.Err() == nil {
+			logrus.Errorf("cannot purge emails: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge redacts and deletes the emails past their retention, one batch at a
+// time. In a dry run, it only counts them.
+func (p *Purger) Purge(ctx context.Context) (PurgeResult, error) {
+	var result PurgeResult
+	now := p.now()
+	cutoffs := p.policy.cutoffs(now)
+	if len(cutoffs) == 0 {
+		return result, nil
+	}
+	logger := logrus.WithField("dry_run", p.dryRun)
+	after := 0
+	for {
+		emails, err := p.storage.listExpired(ctx, cutoffs, after, p.batchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(emails) == 0 {
+			break
+		}
+		after = emails[len(emails)-1].id
+
+		var redact, del []int
+		for _, e := range emails {
+			rule, ok := p.policy.rule(e.tenant, e.status)
+			if !ok {
+				continue
+			}
+			age := now.Sub(e.at)
+			switch {
+			case rule.DeleteAfter > 0 && age >= rule.DeleteAfter:
+				del = append(del, e.id)
+			case rule.RedactAfter > 0 && age >= rule.RedactAfter && !e.redacted:
+				redact = append(redact, e.id)
+			}
+		}
+		if p.dryRun {
+			result.Redacted += len(redact)
+			result.Deleted += len(del)
+		} else {
+			if len(redact) > 0 {
+				n, err := p.storage.redact(ctx, redact)
+				if err != nil {
+					return result, err
+				}
+				result.Redacted += n
+			}
+			if len(del) > 0 {
+				n, err := p.storage.deleteEmails(ctx, del)
+				if err != nil {
+					return result, err
+				}
+				result.Deleted += n
+			}
+		}
+		logger.Debugf("purged emails up to %d: %d redacted, %d deleted", after, len(redact), len(del))
+		if len(emails) < p.batchSize {
+			break
+		}
+	}
+	logger.Infof("purged emails: %d redacted, %d deleted", result.Redacted, result.Deleted)
+	return result, nil
+}
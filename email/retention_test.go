@@ -0,0 +1,142 @@
+package email
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyRule(t *testing.T) {
+	policy := RetentionPolicy{
+		{DeleteAfter: 1},
+		{Statuses: []Status{Bounced}, DeleteAfter: 2},
+		{Tenant: "acme", DeleteAfter: 3},
+		{Tenant: "acme", Statuses: []Status{Bounced, Dead}, DeleteAfter: 4},
+		{Tenant: "acme", Statuses: []Status{Dead}, DeleteAfter: 5},
+	}
+	tests := []struct {
+		desc   string
+		tenant string
+		status Status
+		expect time.Duration
+	}{
+		{"should apply the rule of every tenant and status", "other", SentSuccessfully, 1},
+		{"should apply the rule of the status", "other", Bounced, 2},
+		{"should apply the rule of the tenant", "acme", SentSuccessfully, 3},
+		{"should apply the rule of the tenant and the status", "acme", Bounced, 4},
+		{"should apply the first of equally specific rules", "acme", Dead, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rule, ok := policy.rule(test.tenant, test.status)
+			if !ok || rule.DeleteAfter != test.expect {
+				t.Errorf("%s: got rule %v (%t), but expected the rule deleting after %v", test.desc, rule, ok, test.expect)
+			}
+		})
+	}
+	if _, ok := (RetentionPolicy{{Tenant: "acme"}}).rule("other", Dead); ok {
+		t.Error("got a rule of another tenant")
+	}
+}
+
+func TestPurgerPurge(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	policy := RetentionPolicy{
+		{RedactAfter: 7 * day, DeleteAfter: 30 * day},
+		{Tenant: "acme", Statuses: []Status{Bounced}, DeleteAfter: 2 * day},
+		{Tenant: "keep"},
+	}
+	type emailArg struct {
+		tenant string
+		status Status
+		age    time.Duration
+	}
+	emails := []emailArg{
+		{"other", SentSuccessfully, day},           // kept
+		{"other", SentSuccessfully, 8 * day},       // redacted
+		{"other", Dead, 31 * day},                  // deleted
+		{"other", FailedAttemptToSend, 100 * day},  // kept, it is not final
+		{"acme", Bounced, 3 * day},                 // deleted
+		{"acme", SentSuccessfully, 8 * day},        // redacted
+		{"keep", SentSuccessfully, 100 * day},      // kept
+		{"other", SentSuccessfully, 7*day - 1},     // kept
+		{"other", Suppressed, 30*day + time.Hour},  // deleted
+		{"other", Bounced, 10 * day},               // redacted
+		{"acme", Dead, 6 * day},                    // kept
+		{"other", SentSuccessfully, 365 * day},     // deleted
+		{"other", Dead, 29 * day},                  // redacted
+		{"acme", Bounced, 2*day - time.Nanosecond}, // kept
+	}
+	setup := func(t *testing.T) (*MemoryStorage, []int) {
+		s := NewMemoryStorage()
+		var ids []int
+		for _, v := range emails {
+			e, _ := New(0, "james@example.com", []string{"john@example.com"}, nil, nil, "subject", "body")
+			e.SetTenant(v.tenant)
+			e.AddStatusEvent(MakeStatusEvent(Queued, now.Add(-v.age-time.Minute)))
+			e.AddStatusEvent(MakeStatusEvent(v.status, now.Add(-v.age)))
+			e, err := s.insert(context.Background(), e)
+			if err != nil {
+				t.Fatalf("failed to insert email: %v", err)
+			}
+			ids = append(ids, e.ID())
+		}
+		return s, ids
+	}
+	newPurger := func(s Storage, dryRun bool) *Purger {
+		p := NewPurger(s, PurgerConfig{Policy: policy, BatchSize: 4, DryRun: dryRun})
+		p.now = func() time.Time { return now }
+		return p
+	}
+
+	t.Run("should only count the emails in a dry run", func(t *testing.T) {
+		s, ids := setup(t)
+		got, err := newPurger(s, true).Purge(context.Background())
+		if err != nil {
+			t.Fatalf("failed to purge: %v", err)
+		}
+		if want := (PurgeResult{Redacted: 4, Deleted: 4}); got != want {
+			t.Fatalf("got %+v, but expected %+v", got, want)
+		}
+		for _, id := range ids {
+			if e, ok, _ := s.get(context.Background(), id); !ok || e.Body() == "" {
+				t.Fatalf("email %d was purged in a dry run", id)
+			}
+		}
+	})
+
+	t.Run("should redact and delete the emails", func(t *testing.T) {
+		s, ids := setup(t)
+		p := newPurger(s, false)
+		got, err := p.Purge(context.Background())
+		if err != nil {
+			t.Fatalf("failed to purge: %v", err)
+		}
+		if want := (PurgeResult{Redacted: 4, Deleted: 4}); got != want {
+			t.Fatalf("got %+v, but expected %+v", got, want)
+		}
+		var redacted, deleted []int
+		for i, id := range ids {
+			e, ok, _ := s.get(context.Background(), id)
+			if !ok {
+				deleted = append(deleted, i)
+			} else if e.Subject() == "" && e.Body() == "" {
+				redacted = append(redacted, i)
+			}
+		}
+		if want := []int{1, 5, 9, 12}; !reflect.DeepEqual(redacted, want) {
+			t.Errorf("got emails %v redacted, but expected %v", redacted, want)
+		}
+		if want := []int{2, 4, 8, 11}; !reflect.DeepEqual(deleted, want) {
+			t.Errorf("got emails %v deleted, but expected %v", deleted, want)
+		}
+
+		// the emails purged already are not purged again
+		got, err = p.Purge(context.Background())
+		if err != nil || got != (PurgeResult{}) {
+			t.Fatalf("got %+v (%v) in the second purge, but expected nothing", got, err)
+		}
+	})
+}
@@ -0,0 +1,889 @@
+package email
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	// the pure Go driver, so that notfy builds without cgo
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are the migrations of the SQLite schema, in order. The
+// number of migrations applied to a database is its user_version.
+var sqliteMigrations = []string{
+	`CREATE TABLE email (
+		email_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		"from" TEXT NOT NULL,
+		"to" TEXT NOT NULL DEFAULT '[]',
+		cc TEXT NOT NULL DEFAULT '[]',
+		bcc TEXT NOT NULL DEFAULT '[]',
+		subject TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL DEFAULT '',
+		priority INTEGER NOT NULL DEFAULT 0,
+		tenant TEXT NOT NULL DEFAULT '',
+		batch_id TEXT NOT NULL DEFAULT '',
+		status_events TEXT NOT NULL DEFAULT '[]',
+		current_status INTEGER,
+		version INTEGER NOT NULL DEFAULT 1
+	);
+	CREATE INDEX email_tenant_id_idx ON email (tenant, email_id);
+	CREATE INDEX email_batch_id_idx ON email (batch_id) WHERE batch_id <> '';
+	CREATE INDEX email_current_status_idx ON email (current_status);
+
+	CREATE TABLE api_key (
+		api_key_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		tenant TEXT NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		key_hash TEXT NOT NULL UNIQUE,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE sender_identity (
+		sender_identity_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		tenant TEXT NOT NULL,
+		identity TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		UNIQUE (tenant, identity)
+	);
+
+	CREATE TABLE suppression (
+		address TEXT NOT NULL,
+		reason INTEGER NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER,
+		PRIMARY KEY (address, reason)
+	);
+
+	CREATE TABLE webhook (
+		webhook_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		tenant TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE webhook_delivery (
+		webhook_delivery_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL REFERENCES webhook (webhook_id) ON DELETE CASCADE,
+		email_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		attempt INTEGER NOT NULL,
+		status_code INTEGER NOT NULL,
+		error TEXT,
+		test INTEGER NOT NULL,
+		at INTEGER NOT NULL
+	);
+	CREATE INDEX webhook_delivery_webhook_id_idx ON webhook_delivery (webhook_id);`,
+
+	// the time of the current status is kept to the second of the last event
+	`ALTER TABLE email ADD COLUMN current_status_at INTEGER;
+	UPDATE email SET current_status_at = CAST(strftime('%s', json_extract(status_events, '$[#-1].at')) AS INTEGER) * 1000000000
+	WHERE current_status IS NOT NULL;
+	DROP INDEX email_current_status_idx;
+	CREATE INDEX email_current_status_idx ON email (current_status, current_status_at);`,
+
+	// the recipients, the subject and the body are stored encrypted, in base64
+	`ALTER TABLE email ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE email ADD COLUMN data_key BLOB;`,
+
+	// the suppressions are scoped to a tenant, or to every tenant without one
+	`CREATE TABLE suppression_tenant (
+		tenant TEXT NOT NULL DEFAULT '',
+		address TEXT NOT NULL,
+		reason INTEGER NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER,
+		PRIMARY KEY (tenant, address, reason)
+	);
+	INSERT INTO suppression_tenant (address, reason, detail, created_at, expires_at)
+	SELECT address, reason, detail, created_at, expires_at FROM suppression;
+	DROP TABLE suppression;
+	ALTER TABLE suppression_tenant RENAME TO suppression;`,
+
+	// the failed deliveries keep their payload until they are retried
+	`ALTER TABLE webhook_delivery ADD COLUMN payload BLOB;
+	ALTER TABLE webhook_delivery ADD COLUMN next_attempt_at INTEGER;
+	CREATE INDEX webhook_delivery_next_attempt_at_idx ON webhook_delivery (next_attempt_at);`,
+}
+
+// sqliteStatusEvent is a status event in the status_events of an email
+type sqliteStatusEvent struct {
+	Status int32     `json:"status"`
+	At     time.Time `json:"at"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// SQLiteStorage stores everything in a SQLite database. It has the semantics
+// of PostgresStorage, but the recipients and the status events of the emails
+// are JSON, and the times are unix nanoseconds.
+type SQLiteStorage struct {
+	db      *sql.DB
+	keyring *Keyring
+}
+
+// NewSQLiteStorage opens the database at the path, or in memory if the path
+// is ":memory:", and migrates it to the latest schema
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has one writer at a time, and every connection to ":memory:" is
+	// another database, so the storage has one connection
+	db.SetMaxOpenConns(1)
+	s := &SQLiteStorage{db: db}
+	for _, pragma := range []string{"PRAGMA foreign_keys = ON", "PRAGMA busy_timeout = 5000"} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("cannot set %q: %v", pragma, err)
+		}
+	}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies the migrations the database does not have yet
+func (s *SQLiteStorage) migrate() error {
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("cannot get the schema version: %v", err)
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("cannot begin transaction: %v", err)
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("cannot apply migration %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("cannot set the schema version: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("cannot commit migration %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Check checks that the database can be reached
+func (s *SQLiteStorage) Check(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// SetKeyring encrypts the recipients, the subject and the body of the emails
+// written from now on with the keyring, and decrypts the emails read with it
+func (s *SQLiteStorage) SetKeyring(k *Keyring) {
+	s.keyring = k
+}
+
+// Close closes the database
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStorage) insert(ctx context.Context, e Email) (Email, error) {
+	return s.insertEmail(ctx, s.db, e)
+}
+
+func (s *SQLiteStorage) insertEmail(ctx context.Context, q querier, e Email) (Email, error) {
+	f, err := s.keyring.seal(emailFields(e))
+	if err != nil {
+		return Email{}, fmt.Errorf("cannot encrypt email: %v", err)
+	}
+	to, cc, bcc, err := marshalRecipients(f)
+	if err != nil {
+		return Email{}, err
+	}
+	events, err := marshalSQLiteStatusEvents(e.StatusHistory())
+	if err != nil {
+		return Email{}, err
+	}
+	query := `INSERT INTO email ("from", "to", cc, bcc, subject, body, priority, tenant, batch_id, status_events, current_status, current_status_at, key_id, data_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := q.ExecContext(ctx, query, e.StringFrom(), to, cc, bcc, f.subject, f.body, int(e.Priority()), e.Tenant(), e.Batch(), events, currentStatus(e.StatusHistory()), sqliteCurrentStatusAt(e.StatusHistory()), f.keyID, f.dataKey)
+	if err != nil {
+		return Email{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Email{}, fmt.Errorf("cannot get the id of the email: %v", err)
+	}
+	e.SetID(int(id))
+	e.version = 1
+	return e, nil
+}
+
+const sqliteEmailColumns = `email_id, "from", "to", cc, bcc, subject, body, priority, tenant, batch_id, status_events, version, key_id, data_key`
+
+func (s *SQLiteStorage) get(ctx context.Context, id int) (Email, bool, error) {
+	return s.getEmail(ctx, s.db, id)
+}
+
+func (s *SQLiteStorage) getEmail(ctx context.Context, q querier, id int) (Email, bool, error) {
+	emails, err := s.queryEmails(ctx, q, `SELECT `+sqliteEmailColumns+` FROM email WHERE email_id = ?`, id)
+	if err != nil {
+		return Email{}, true, err
+	}
+	if len(emails) == 0 {
+		return Email{}, false, nil
+	}
+	return emails[0], true, nil
+}
+
+func (s *SQLiteStorage) list(ctx context.Context, tenant string, after, limit int) ([]Email, error) {
+	query := `SELECT ` + sqliteEmailColumns + ` FROM email WHERE email_id > ?1 AND (?2 = '' OR tenant = ?2) ORDER BY email_id LIMIT ?3`
+	return s.queryEmails(ctx, s.db, query, after, tenant, limit)
+}
+
+// queryEmails gets the emails of a query of sqliteEmailColumns, and decrypts them
+func (s *SQLiteStorage) queryEmails(ctx context.Context, q querier, query string, args ...interface{}) ([]Email, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]Email, 0)
+	for rows.Next() {
+		var id, version int
+		var from, to, cc, bcc, tenant, batch, events string
+		var priority Priority
+		var f sensitiveFields
+		if err := rows.Scan(&id, &from, &to, &cc, &bcc, &f.subject, &f.body, &priority, &tenant, &batch, &events, &version, &f.keyID, &f.dataKey); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		if err := unmarshalRecipients(&f, to, cc, bcc); err != nil {
+			return nil, err
+		}
+		var se []sqliteStatusEvent
+		if err := json.Unmarshal([]byte(events), &se); err != nil {
+			return nil, fmt.Errorf("cannot json.Unmarshal status_events: %v", err)
+		}
+		f, err := s.keyring.open(f)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt email %d: %v", id, err)
+		}
+		e, err := New(id, from, f.to, f.cc, f.bcc, f.subject, f.body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot build email: %v", err)
+		}
+		e.SetPriority(priority)
+		e.SetTenant(tenant)
+		e.SetBatch(batch)
+		e.version = version
+		for _, v := range se {
+			e.AddStatusEvent(MakeStatusEventWithDetail(Status(v.Status), v.At.UTC(), v.Detail))
+		}
+		arr = append(arr, e)
+	}
+	return arr, rows.Err()
+}
+
+// sqliteCurrentStatusAt gets the time of the last event of the history in unix
+// nanoseconds, or null if there is none
+func sqliteCurrentStatusAt(history StatusHistory) interface{} {
+	se, ok := history.latest()
+	if !ok {
+		return nil
+	}
+	return se.At().UnixNano()
+}
+
+func marshalRecipients(f sensitiveFields) (string, string, string, error) {
+	arr := make([]string, 3)
+	for i, list := range [][]string{f.to, f.cc, f.bcc} {
+		b, err := json.Marshal(list)
+		if err != nil {
+			return "", "", "", fmt.Errorf("cannot json.Marshal recipients: %v", err)
+		}
+		arr[i] = string(b)
+	}
+	return arr[0], arr[1], arr[2], nil
+}
+
+func unmarshalRecipients(f *sensitiveFields, to, cc, bcc string) error {
+	for _, v := range []struct {
+		field string
+		dst   *[]string
+	}{{to, &f.to}, {cc, &f.cc}, {bcc, &f.bcc}} {
+		if err := json.Unmarshal([]byte(v.field), v.dst); err != nil {
+			return fmt.Errorf("cannot json.Unmarshal recipients: %v", err)
+		}
+	}
+	return nil
+}
+
+func toSQLiteStatusEvent(v StatusEvent) sqliteStatusEvent {
+	return sqliteStatusEvent{int32(v.Status()), v.At().UTC(), v.Detail()}
+}
+
+func marshalSQLiteStatusEvents(history StatusHistory) (string, error) {
+	se := make([]sqliteStatusEvent, 0, len(history))
+	for _, v := range history {
+		se = append(se, toSQLiteStatusEvent(v))
+	}
+	b, err := json.Marshal(se)
+	if err != nil {
+		return "", fmt.Errorf("cannot json.Marshal status_events: %v", err)
+	}
+	return string(b), nil
+}
+
+func (s *SQLiteStorage) update(ctx context.Context, e Email) (Email, bool, error) {
+	f, err := s.keyring.seal(emailFields(e))
+	if err != nil {
+		return Email{}, true, fmt.Errorf("cannot encrypt email: %v", err)
+	}
+	to, cc, bcc, err := marshalRecipients(f)
+	if err != nil {
+		return Email{}, true, err
+	}
+	events, err := marshalSQLiteStatusEvents(e.StatusHistory())
+	if err != nil {
+		return Email{}, true, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Email{}, true, fmt.Errorf("cannot begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	query := `UPDATE email SET "from" = ?, "to" = ?, cc = ?, bcc = ?, subject = ?, body = ?, priority = ?, tenant = ?, batch_id = ?, status_events = ?,
+		current_status = ?, current_status_at = ?, key_id = ?, data_key = ?, version = version + 1
+		WHERE email_id = ? AND version = ?`
+	result, err := tx.ExecContext(ctx, query, e.StringFrom(), to, cc, bcc, f.subject, f.body, int(e.Priority()), e.Tenant(), e.Batch(), events,
+		currentStatus(e.StatusHistory()), sqliteCurrentStatusAt(e.StatusHistory()), f.keyID, f.dataKey, e.ID(), e.version)
+	if err != nil {
+		return Email{}, true, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return Email{}, true, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	if rows == 0 {
+		// the email is either missing or at another version
+		var version int
+		err := tx.QueryRowContext(ctx, `SELECT version FROM email WHERE email_id = ?`, e.ID()).Scan(&version)
+		if err == sql.ErrNoRows {
+			return Email{}, false, nil
+		}
+		if err != nil {
+			return Email{}, true, err
+		}
+		return Email{}, true, ErrVersionConflict
+	}
+	if err := tx.Commit(); err != nil {
+		return Email{}, true, fmt.Errorf("cannot commit transaction: %v", err)
+	}
+	e.version++
+	return e, true, nil
+}
+
+// appendStatusEvents appends to the JSON in the database, so that concurrent
+// appends do not lose each other's events
+func (s *SQLiteStorage) appendStatusEvents(ctx context.Context, id int, events []StatusEvent) (Email, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Email{}, true, fmt.Errorf("cannot begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	query := `UPDATE email SET current_status = COALESCE(?, current_status), current_status_at = COALESCE(?, current_status_at), version = version + 1 WHERE email_id = ?`
+	result, err := tx.ExecContext(ctx, query, currentStatus(events), sqliteCurrentStatusAt(events), id)
+	if err != nil {
+		return Email{}, true, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return Email{}, true, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	if rows == 0 {
+		return Email{}, false, nil
+	}
+	for _, v := range events {
+		b, err := json.Marshal(toSQLiteStatusEvent(v))
+		if err != nil {
+			return Email{}, true, fmt.Errorf("cannot json.Marshal status event: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE email SET status_events = json_insert(status_events, '$[#]', json(?)) WHERE email_id = ?`, string(b), id); err != nil {
+			return Email{}, true, fmt.Errorf("cannot append status event: %v", err)
+		}
+	}
+	e, _, err := s.getEmail(ctx, tx, id)
+	if err != nil {
+		return Email{}, true, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Email{}, true, fmt.Errorf("cannot commit transaction: %v", err)
+	}
+	return e, true, nil
+}
+
+// insertBatch inserts the emails in one transaction
+func (s *SQLiteStorage) insertBatch(ctx context.Context, emails []Email) ([]Email, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	arr := make([]Email, 0, len(emails))
+	for _, e := range emails {
+		e, err := s.insertEmail(ctx, tx, e)
+		if err != nil {
+			return nil, fmt.Errorf("cannot insert email: %v", err)
+		}
+		arr = append(arr, e)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("cannot commit transaction: %v", err)
+	}
+	return arr, nil
+}
+
+func (s *SQLiteStorage) countBatch(ctx context.Context, tenant, batch string) (map[Status]int, error) {
+	query := `SELECT current_status, count(*) FROM email
+		WHERE batch_id = ?1 AND (?2 = '' OR tenant = ?2) AND current_status IS NOT NULL GROUP BY 1`
+	rows, err := s.db.QueryContext(ctx, query, batch, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[Status]int)
+	for rows.Next() {
+		var status Status
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+func (s *SQLiteStorage) listExpired(ctx context.Context, cutoffs []retentionCutoff, after, limit int) ([]expiredEmail, error) {
+	args := []interface{}{after, limit}
+	arg := func(v interface{}) string {
+		if t, ok := v.(time.Time); ok {
+			v = t.UnixNano()
+		}
+		args = append(args, v)
+		return fmt.Sprintf("?%d", len(args))
+	}
+	query := `SELECT email_id, tenant, current_status, current_status_at, subject = '' AND body = '' FROM email
+		WHERE email_id > ?1 AND ` + cutoffsCondition(cutoffs, arg) + ` ORDER BY email_id LIMIT ?2`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]expiredEmail, 0)
+	for rows.Next() {
+		var e expiredEmail
+		var at int64
+		if err := rows.Scan(&e.id, &e.tenant, &e.status, &at, &e.redacted); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		e.at = time.Unix(0, at)
+		arr = append(arr, e)
+	}
+	return arr, rows.Err()
+}
+
+func (s *SQLiteStorage) redact(ctx context.Context, ids []int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	// the details of the status events are removed with the content
+	query := `UPDATE email SET subject = '', body = '', version = version + 1,
+		status_events = (SELECT json_group_array(json_remove(value, '$.detail')) FROM json_each(email.status_events))
+		WHERE email_id IN (` + placeholders(len(ids), 1) + `) AND (subject <> '' OR body <> '')`
+	return s.exec(ctx, query, ints(ids)...)
+}
+
+func (s *SQLiteStorage) deleteEmails(ctx context.Context, ids []int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return s.exec(ctx, `DELETE FROM email WHERE email_id IN (`+placeholders(len(ids), 1)+`)`, ints(ids)...)
+}
+
+// Reencrypt encrypts the emails that are not encrypted with the primary key
+// of the keyring, batch by batch. It returns how many it encrypted.
+func (s *SQLiteStorage) Reencrypt(ctx context.Context, batchSize int) (int, error) {
+	if s.keyring == nil {
+		return 0, ErrNoKeyring
+	}
+	n, after := 0, 0
+	for {
+		query := `SELECT email_id, version, "to", cc, bcc, subject, body, key_id, data_key FROM email
+			WHERE email_id > ? AND key_id <> ? ORDER BY email_id LIMIT ?`
+		rows, err := s.db.QueryContext(ctx, query, after, s.keyring.PrimaryKeyID(), batchSize)
+		if err != nil {
+			return n, err
+		}
+		var batch []reencryptRow
+		for rows.Next() {
+			var r reencryptRow
+			var to, cc, bcc string
+			if err := rows.Scan(&r.id, &r.version, &to, &cc, &bcc, &r.fields.subject, &r.fields.body, &r.fields.keyID, &r.fields.dataKey); err != nil {
+				rows.Close()
+				return n, fmt.Errorf("cannot scan row: %v", err)
+			}
+			if err := unmarshalRecipients(&r.fields, to, cc, bcc); err != nil {
+				rows.Close()
+				return n, err
+			}
+			batch = append(batch, r)
+		}
+		// the rows hold the only connection until they are closed
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return n, err
+		}
+		if len(batch) == 0 {
+			return n, nil
+		}
+		for _, r := range batch {
+			f, _, err := s.keyring.reencrypt(r.fields)
+			if err != nil {
+				return n, fmt.Errorf("cannot encrypt email %d: %v", r.id, err)
+			}
+			to, cc, bcc, err := marshalRecipients(f)
+			if err != nil {
+				return n, err
+			}
+			// the version is kept, the email is the same. It is skipped if it
+			// was updated meanwhile, since the update encrypted it.
+			query := `UPDATE email SET "to" = ?, cc = ?, bcc = ?, subject = ?, body = ?, key_id = ?, data_key = ? WHERE email_id = ? AND version = ?`
+			updated, err := s.exec(ctx, query, to, cc, bcc, f.subject, f.body, f.keyID, f.dataKey, r.id, r.version)
+			if err != nil {
+				return n, err
+			}
+			n += updated
+		}
+		after = batch[len(batch)-1].id
+	}
+}
+
+// placeholders gets n numbered placeholders, starting at from
+func placeholders(n, from int) string {
+	arr := make([]string, n)
+	for i := range arr {
+		arr[i] = fmt.Sprintf("?%d", from+i)
+	}
+	return strings.Join(arr, ", ")
+}
+
+// ints converts the ids to query arguments
+func ints(ids []int) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+// exec runs the query and returns the number of rows it affected
+func (s *SQLiteStorage) exec(ctx context.Context, query string, args ...interface{}) (int, error) {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	return int(rows), nil
+}
+
+// unixTime gets the time of unix nanoseconds in the database
+func unixTime(n int64) time.Time {
+	return time.Unix(0, n).UTC()
+}
+
+func (s *SQLiteStorage) insertAPIKey(ctx context.Context, k APIKey) (APIKey, error) {
+	query := `INSERT INTO api_key (tenant, name, key_hash, created_at) VALUES (?, ?, ?, ?)`
+	result, err := s.db.ExecContext(ctx, query, k.Tenant, k.Name, k.Hash, k.CreatedAt.UnixNano())
+	if err != nil {
+		return APIKey{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return APIKey{}, fmt.Errorf("cannot get the id of the api key: %v", err)
+	}
+	k.ID = int(id)
+	return k, nil
+}
+
+func (s *SQLiteStorage) getAPIKeyByHash(ctx context.Context, hash string) (APIKey, bool, error) {
+	keys, err := s.queryAPIKeys(ctx, `SELECT api_key_id, tenant, name, key_hash, created_at FROM api_key WHERE key_hash = ?`, hash)
+	if err != nil {
+		return APIKey{}, true, err
+	}
+	if len(keys) == 0 {
+		return APIKey{}, false, nil
+	}
+	return keys[0], true, nil
+}
+
+func (s *SQLiteStorage) listAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+	return s.queryAPIKeys(ctx, `SELECT api_key_id, tenant, name, key_hash, created_at FROM api_key WHERE ?1 = '' OR tenant = ?1 ORDER BY api_key_id`, tenant)
+}
+
+func (s *SQLiteStorage) queryAPIKeys(ctx context.Context, query string, args ...interface{}) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]APIKey, 0)
+	for rows.Next() {
+		var k APIKey
+		var createdAt int64
+		if err := rows.Scan(&k.ID, &k.Tenant, &k.Name, &k.Hash, &createdAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		k.CreatedAt = unixTime(createdAt)
+		arr = append(arr, k)
+	}
+	return arr, rows.Err()
+}
+
+func (s *SQLiteStorage) deleteAPIKey(ctx context.Context, id int) (bool, error) {
+	return s.delete(ctx, `DELETE FROM api_key WHERE api_key_id = ?`, id)
+}
+
+// delete runs the delete query and reports whether it deleted anything
+func (s *SQLiteStorage) delete(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return true, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return true, fmt.Errorf("cannot get the number of rows affected: %v", err)
+	}
+	return rows > 0, nil
+}
+
+func (s *SQLiteStorage) insertSenderIdentity(ctx context.Context, i SenderIdentity) (SenderIdentity, error) {
+	query := `INSERT INTO sender_identity (tenant, identity, created_at) VALUES (?, ?, ?)`
+	result, err := s.db.ExecContext(ctx, query, i.Tenant, i.Identity, i.CreatedAt.UnixNano())
+	if err != nil {
+		return SenderIdentity{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return SenderIdentity{}, fmt.Errorf("cannot get the id of the sender identity: %v", err)
+	}
+	i.ID = int(id)
+	return i, nil
+}
+
+func (s *SQLiteStorage) listSenderIdentities(ctx context.Context, tenant string) ([]SenderIdentity, error) {
+	query := `SELECT sender_identity_id, tenant, identity, created_at FROM sender_identity WHERE ?1 = '' OR tenant = ?1 ORDER BY sender_identity_id`
+	rows, err := s.db.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]SenderIdentity, 0)
+	for rows.Next() {
+		var i SenderIdentity
+		var createdAt int64
+		if err := rows.Scan(&i.ID, &i.Tenant, &i.Identity, &createdAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		i.CreatedAt = unixTime(createdAt)
+		arr = append(arr, i)
+	}
+	return arr, rows.Err()
+}
+
+func (s *SQLiteStorage) deleteSenderIdentity(ctx context.Context, id int) (bool, error) {
+	return s.delete(ctx, `DELETE FROM sender_identity WHERE sender_identity_id = ?`, id)
+}
+
+func (s *SQLiteStorage) upsertSuppression(ctx context.Context, sup Suppression) error {
+	var expiresAt *int64
+	if !sup.ExpiresAt.IsZero() {
+		n := sup.ExpiresAt.UnixNano()
+		expiresAt = &n
+	}
+	query := `INSERT INTO suppression (tenant, address, reason, detail, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (tenant, address, reason) DO UPDATE SET detail = excluded.detail, created_at = excluded.created_at, expires_at = excluded.expires_at`
+	_, err := s.db.ExecContext(ctx, query, sup.Tenant, sup.Address, int(sup.Reason), sup.Detail, sup.CreatedAt.UnixNano(), expiresAt)
+	return err
+}
+
+func (s *SQLiteStorage) getSuppressions(ctx context.Context, tenant string, addrs []string) ([]Suppression, error) {
+	if len(addrs) == 0 {
+		return []Suppression{}, nil
+	}
+	args := []interface{}{tenant}
+	for _, a := range addrs {
+		args = append(args, a)
+	}
+	query := `SELECT tenant, address, reason, detail, created_at, expires_at FROM suppression
+		WHERE tenant IN ('', ?) AND address IN (` + placeholders(len(addrs), 2) + `)`
+	return s.querySuppressions(ctx, query, args...)
+}
+
+func (s *SQLiteStorage) listSuppressions(ctx context.Context) ([]Suppression, error) {
+	query := `SELECT tenant, address, reason, detail, created_at, expires_at FROM suppression ORDER BY tenant, address, reason`
+	return s.querySuppressions(ctx, query)
+}
+
+func (s *SQLiteStorage) querySuppressions(ctx context.Context, query string, args ...interface{}) ([]Suppression, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]Suppression, 0)
+	for rows.Next() {
+		var sup Suppression
+		var createdAt int64
+		var expiresAt sql.NullInt64
+		if err := rows.Scan(&sup.Tenant, &sup.Address, &sup.Reason, &sup.Detail, &createdAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		sup.CreatedAt = unixTime(createdAt)
+		if expiresAt.Valid {
+			sup.ExpiresAt = unixTime(expiresAt.Int64)
+		}
+		arr = append(arr, sup)
+	}
+	return arr, rows.Err()
+}
+
+func (s *SQLiteStorage) deleteSuppression(ctx context.Context, tenant, addr string, reason SuppressionReason) (bool, error) {
+	return s.delete(ctx, `DELETE FROM suppression WHERE tenant = ? AND address = ? AND reason = ?`, tenant, addr, int(reason))
+}
+
+func (s *SQLiteStorage) insertWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	query := `INSERT INTO webhook (tenant, url, secret, created_at) VALUES (?, ?, ?, ?)`
+	result, err := s.db.ExecContext(ctx, query, w.Tenant, w.URL, w.Secret, w.CreatedAt.UnixNano())
+	if err != nil {
+		return Webhook{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Webhook{}, fmt.Errorf("cannot get the id of the webhook: %v", err)
+	}
+	w.ID = int(id)
+	return w, nil
+}
+
+func (s *SQLiteStorage) getWebhook(ctx context.Context, id int) (Webhook, bool, error) {
+	webhooks, err := s.queryWebhooks(ctx, `SELECT webhook_id, tenant, url, secret, created_at FROM webhook WHERE webhook_id = ?`, id)
+	if err != nil {
+		return Webhook{}, false, err
+	}
+	if len(webhooks) == 0 {
+		return Webhook{}, false, nil
+	}
+	return webhooks[0], true, nil
+}
+
+func (s *SQLiteStorage) listWebhooks(ctx context.Context, tenant string) ([]Webhook, error) {
+	return s.queryWebhooks(ctx, `SELECT webhook_id, tenant, url, secret, created_at FROM webhook WHERE ?1 = '' OR tenant = ?1 ORDER BY webhook_id`, tenant)
+}
+
+func (s *SQLiteStorage) queryWebhooks(ctx context.Context, query string, args ...interface{}) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]Webhook, 0)
+	for rows.Next() {
+		var w Webhook
+		var createdAt int64
+		if err := rows.Scan(&w.ID, &w.Tenant, &w.URL, &w.Secret, &createdAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		w.CreatedAt = unixTime(createdAt)
+		arr = append(arr, w)
+	}
+	return arr, rows.Err()
+}
+
+func (s *SQLiteStorage) deleteWebhook(ctx context.Context, id int) (bool, error) {
+	return s.delete(ctx, `DELETE FROM webhook WHERE webhook_id = ?`, id)
+}
+
+func (s *SQLiteStorage) insertWebhookDelivery(ctx context.Context, d WebhookDelivery) (WebhookDelivery, error) {
+	// the payload is only kept while the delivery is to be retried
+	var payload []byte
+	var nextAttemptAt *int64
+	if !d.NextAttemptAt.IsZero() {
+		payload = d.payload
+		n := d.NextAttemptAt.UnixNano()
+		nextAttemptAt = &n
+	}
+	query := `INSERT INTO webhook_delivery (webhook_id, email_id, event, attempt, status_code, error, test, at, payload, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := s.db.ExecContext(ctx, query, d.WebhookID, d.EmailID, d.Event, d.Attempt, d.StatusCode, d.Error, d.Test, d.At.UnixNano(), payload, nextAttemptAt)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return WebhookDelivery{}, fmt.Errorf("cannot get the id of the webhook delivery: %v", err)
+	}
+	d.ID = int(id)
+	return d, nil
+}
+
+func (s *SQLiteStorage) listWebhookDeliveries(ctx context.Context, webhookID int) ([]WebhookDelivery, error) {
+	query := `SELECT webhook_delivery_id, webhook_id, email_id, event, attempt, status_code, error, test, at, NULL, next_attempt_at
+		FROM webhook_delivery WHERE webhook_id = ? ORDER BY webhook_delivery_id`
+	return s.queryWebhookDeliveries(ctx, query, webhookID)
+}
+
+func (s *SQLiteStorage) listDueWebhookDeliveries(ctx context.Context, before time.Time, limit int) ([]WebhookDelivery, error) {
+	query := `SELECT webhook_delivery_id, webhook_id, email_id, event, attempt, status_code, error, test, at, payload, next_attempt_at
+		FROM webhook_delivery WHERE next_attempt_at <= ? ORDER BY next_attempt_at, webhook_delivery_id LIMIT ?`
+	return s.queryWebhookDeliveries(ctx, query, before.UnixNano(), limit)
+}
+
+func (s *SQLiteStorage) queryWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	arr := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var d WebhookDelivery
+		var errText sql.NullString
+		var at int64
+		var nextAttemptAt sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EmailID, &d.Event, &d.Attempt, &d.StatusCode, &errText, &d.Test, &at, &d.payload, &nextAttemptAt); err != nil {
+			return nil, fmt.Errorf("cannot scan row: %v", err)
+		}
+		d.Error = errText.String
+		d.At = unixTime(at)
+		if nextAttemptAt.Valid {
+			d.NextAttemptAt = unixTime(nextAttemptAt.Int64)
+		}
+		arr = append(arr, d)
+	}
+	return arr, rows.Err()
+}
+
+func (s *SQLiteStorage) claimWebhookDelivery(ctx context.Context, id int) (bool, error) {
+	query := `UPDATE webhook_delivery SET payload = NULL, next_attempt_at = NULL
+		WHERE webhook_delivery_id = ? AND next_attempt_at IS NOT NULL`
+	return s.delete(ctx, query, id)
+}
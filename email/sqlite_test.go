@@ -0,0 +1,73 @@
+package email
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSQLiteStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+	s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "notfy.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLiteStorageMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notfy.db")
+	s, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	e, _ := New(0, "myself@myself.com", []string{"to@to.com"}, []string{}, []string{}, "subject", "body")
+	e, err = s.insert(context.Background(), e)
+	if err != nil {
+		t.Fatalf("failed to insert email: %v", err)
+	}
+	s.Close()
+
+	s, err = NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer s.Close()
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatalf("failed to get the schema version: %v", err)
+	}
+	if version != len(sqliteMigrations) {
+		t.Fatalf("got schema version %d, but expected %d", version, len(sqliteMigrations))
+	}
+	if _, ok, err := s.get(context.Background(), e.ID()); !ok || err != nil {
+		t.Fatalf("got %v, %v after reopening, but expected the email", ok, err)
+	}
+}
+
+func TestSQLiteStorageSuppressions(t *testing.T) {
+	s := newTestSQLiteStorage(t)
+	at := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	sup := Suppression{Address: "a@example.com", Reason: ReasonBounce, Detail: "550", CreatedAt: at}
+	if err := s.upsertSuppression(context.Background(), sup); err != nil {
+		t.Fatalf("failed to upsert suppression: %v", err)
+	}
+	sup.Detail = "551"
+	sup.ExpiresAt = at.Add(time.Hour)
+	if err := s.upsertSuppression(context.Background(), sup); err != nil {
+		t.Fatalf("failed to upsert suppression: %v", err)
+	}
+	got, err := s.getSuppressions(context.Background(), "", []string{"a@example.com", "b@example.com"})
+	if err != nil {
+		t.Fatalf("failed to get suppressions: %v", err)
+	}
+	if !reflect.DeepEqual(got, []Suppression{sup}) {
+		t.Fatalf("got %v, but expected %v", got, []Suppression{sup})
+	}
+	if ok, err := s.deleteSuppression(context.Background(), sup.Tenant, sup.Address, sup.Reason); !ok || err != nil {
+		t.Fatalf("got %v, %v, but expected the suppression to be deleted", ok, err)
+	}
+}
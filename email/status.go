@@ -1,6 +1,10 @@
 package email
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 type Status uint32
 
@@ -10,18 +14,60 @@ const (
 	SentSuccessfully
 	FailedAttemptToSend
 	Dead
+	Suppressed
+	Bounced
 )
 
+// finalStatuses are the statuses the deamon does not send an email after
+var finalStatuses = []Status{SentSuccessfully, Dead, Suppressed, Bounced}
+
+// final reports whether the deamon does not send an email after the status
+func (s Status) final() bool {
+	for _, v := range finalStatuses {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseStatus parses the name of a status, e.g. "Bounced", ignoring its case
+func ParseStatus(s string) (Status, error) {
+	for st := Queued; st <= Bounced; st++ {
+		if strings.EqualFold(s, st.String()) {
+			return st, nil
+		}
+	}
+	return Queued, fmt.Errorf("unknown status %q", s)
+}
+
 type StatusEvent struct {
 	status Status
 	at     time.Time
+	detail string
 }
 
 func MakeStatusEvent(status Status, at time.Time) StatusEvent {
-	return StatusEvent{status, at.UTC()}
+	return StatusEvent{status, at.UTC(), ""}
+}
+
+// MakeStatusEventWithDetail makes a status event with a human readable detail,
+// e.g. the reply of the SMTP server. Details are stored and published in
+// plaintext, so they must not carry the addresses of the recipients.
+func MakeStatusEventWithDetail(status Status, at time.Time, detail string) StatusEvent {
+	return StatusEvent{status, at.UTC(), detail}
 }
 
 func (se StatusEvent) Status() Status { return se.status }
 func (se StatusEvent) At() time.Time  { return se.at }
+func (se StatusEvent) Detail() string { return se.detail }
 
 type StatusHistory []StatusEvent
+
+// latest gets the last event of the history, if there is one
+func (h StatusHistory) latest() (StatusEvent, bool) {
+	if len(h) == 0 {
+		return StatusEvent{}, false
+	}
+	return h[len(h)-1], true
+}
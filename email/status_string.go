@@ -4,9 +4,9 @@ package email
 
 import "strconv"
 
-const _Status_name = "QueuedSentSuccessfullyFailedAttemptToSendDead"
+const _Status_name = "QueuedSentSuccessfullyFailedAttemptToSendDeadSuppressedBounced"
 
-var _Status_index = [...]uint8{0, 6, 22, 41, 45}
+var _Status_index = [...]uint8{0, 6, 22, 41, 45, 55, 62}
 
 func (i Status) String() string {
 	if i >= Status(len(_Status_index)-1) {
@@ -2,85 +2,532 @@ package email
 
 import (
 	"context"
-	"fmt"
+	"sort"
+	"sync"
+	"time"
 )
 
 type Storage interface {
 	insert(context.Context, Email) (Email, error)
 	get(context.Context, int) (Email, bool, error)
+	// update replaces the email if it is still at its version, and returns it
+	// with the next version. ErrVersionConflict is returned otherwise.
 	update(context.Context, Email) (Email, bool, error)
+
+	// appendStatusEvents appends the events to the history of the email
+	// whatever its version, and returns the email with the events
+	appendStatusEvents(ctx context.Context, id int, events []StatusEvent) (Email, bool, error)
+
+	// list lists up to limit emails with an id greater than after, by id. The
+	// emails of every tenant are listed if the tenant is empty.
+	list(ctx context.Context, tenant string, after, limit int) ([]Email, error)
+	BatchStorage
+	KeyStorage
+	IdentityStorage
+	SuppressionStorage
+	WebhookStorage
+	RetentionStorage
+}
+
+// BatchStorage stores the emails queued together
+type BatchStorage interface {
+	// insertBatch inserts every email or none, and returns them with their ids
+	insertBatch(context.Context, []Email) ([]Email, error)
+
+	// countBatch counts the emails of the batch by their latest status. The
+	// emails of every tenant are counted if the tenant is empty.
+	countBatch(ctx context.Context, tenant, batch string) (map[Status]int, error)
+}
+
+// RetentionStorage finds the emails past their retention, and redacts or
+// deletes them
+type RetentionStorage interface {
+	// listExpired lists up to limit emails with an id greater than after, by
+	// id, that any of the cutoffs selects
+	listExpired(ctx context.Context, cutoffs []retentionCutoff, after, limit int) ([]expiredEmail, error)
+
+	// redact empties the subject, the body and the details of the status
+	// events of the emails, and returns how many were not empty already
+	redact(ctx context.Context, ids []int) (int, error)
+
+	// deleteEmails deletes the emails and their status history, and returns
+	// how many existed
+	deleteEmails(ctx context.Context, ids []int) (int, error)
+}
+
+// KeyStorage stores the API keys of the tenants
+type KeyStorage interface {
+	insertAPIKey(context.Context, APIKey) (APIKey, error)
+	getAPIKeyByHash(context.Context, string) (APIKey, bool, error)
+	listAPIKeys(ctx context.Context, tenant string) ([]APIKey, error)
+	deleteAPIKey(context.Context, int) (bool, error)
+}
+
+// IdentityStorage stores the sender identities of the tenants
+type IdentityStorage interface {
+	insertSenderIdentity(context.Context, SenderIdentity) (SenderIdentity, error)
+	listSenderIdentities(ctx context.Context, tenant string) ([]SenderIdentity, error)
+	deleteSenderIdentity(context.Context, int) (bool, error)
+}
+
+// SuppressionStorage stores the suppressed addresses, keyed by tenant, address and reason
+type SuppressionStorage interface {
+	// upsertSuppression inserts the suppression, or replaces the one with the same tenant, address and reason
+	upsertSuppression(context.Context, Suppression) error
+	// getSuppressions gets the suppressions of the addresses that apply to
+	// the tenant, i.e. the ones of the tenant and the ones without a tenant
+	getSuppressions(ctx context.Context, tenant string, addrs []string) ([]Suppression, error)
+	listSuppressions(context.Context) ([]Suppression, error)
+	deleteSuppression(ctx context.Context, tenant, addr string, reason SuppressionReason) (bool, error)
 }
 
+// WebhookStorage stores the webhooks of the tenants and the log of their deliveries
+type WebhookStorage interface {
+	insertWebhook(context.Context, Webhook) (Webhook, error)
+	getWebhook(context.Context, int) (Webhook, bool, error)
+	listWebhooks(ctx context.Context, tenant string) ([]Webhook, error)
+	deleteWebhook(context.Context, int) (bool, error)
+	insertWebhookDelivery(context.Context, WebhookDelivery) (WebhookDelivery, error)
+	listWebhookDeliveries(ctx context.Context, webhookID int) ([]WebhookDelivery, error)
+	// listDueWebhookDeliveries lists the failed deliveries to be retried at
+	// or before the time, with their payload, oldest first
+	listDueWebhookDeliveries(ctx context.Context, before time.Time, limit int) ([]WebhookDelivery, error)
+	// claimWebhookDelivery unschedules the retry of the delivery, and reports
+	// whether it was still scheduled, so that only one replica retries it
+	claimWebhookDelivery(ctx context.Context, id int) (bool, error)
+}
+
+// MemoryStorage keeps everything in memory. It is safe for concurrent use,
+// and copies the emails in and out, so that the emails it holds only change
+// through it.
 type MemoryStorage struct {
-	emails []Email
+	mu sync.RWMutex
+
+	// emailIDs are the ids of the emails in order, since they are increasing
+	emails      map[int]Email
+	emailIDs    []int
+	lastEmailID int
+
+	keys           []APIKey
+	lastKeyID      int
+	identities     []SenderIdentity
+	lastIdentityID int
+	suppressions   []Suppression
+	webhooks       []Webhook
+	lastWebhookID  int
+	deliveries     []WebhookDelivery
+	lastDeliveryID int
 }
 
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		emails: make([]Email, 0),
+		emails:       make(map[int]Email),
+		emailIDs:     make([]int, 0),
+		keys:         make([]APIKey, 0),
+		identities:   make([]SenderIdentity, 0),
+		suppressions: make([]Suppression, 0),
+		webhooks:     make([]Webhook, 0),
+		deliveries:   make([]WebhookDelivery, 0),
 	}
 }
 
+// store keeps a copy of the email, without the metadata of the message it was
+// received in
+func (s *MemoryStorage) store(e Email) {
+	c := e.clone()
+	c.traceContext = nil
+	c.messageID = ""
+	c.attempt = 0
+	s.emails[e.ID()] = c
+}
+
 func (s *MemoryStorage) insert(ctx context.Context, e Email) (Email, error) {
-	e.SetID(len(s.emails) + 1)
-	s.emails = append(s.emails, e)
-	return e, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.insertLocked(e), nil
 }
 
-func (s *MemoryStorage) remove(ctx context.Context, e Email) (Email, bool, error) {
-	for i, v := range s.emails {
-		if v.ID() == e.ID() {
-			s.emails = append(s.emails[:i], s.emails[i+1:]...)
-			return v, true, nil
+func (s *MemoryStorage) insertLocked(e Email) Email {
+	s.lastEmailID++
+	e.SetID(s.lastEmailID)
+	e.version = 1
+	s.store(e)
+	s.emailIDs = append(s.emailIDs, e.ID())
+	return e
+}
+
+func (s *MemoryStorage) list(ctx context.Context, tenant string, after, limit int) ([]Email, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	arr := make([]Email, 0)
+	for _, id := range s.emailIDs[sort.SearchInts(s.emailIDs, after+1):] {
+		if len(arr) == limit {
+			break
+		}
+		if e := s.emails[id]; tenant == "" || e.Tenant() == tenant {
+			arr = append(arr, e.clone())
 		}
 	}
+	return arr, nil
+}
 
-	return Email{}, false, nil
+func (s *MemoryStorage) insertBatch(ctx context.Context, emails []Email) ([]Email, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	arr := make([]Email, 0, len(emails))
+	for _, e := range emails {
+		arr = append(arr, s.insertLocked(e))
+	}
+	return arr, nil
+}
+
+func (s *MemoryStorage) countBatch(ctx context.Context, tenant, batch string) (map[Status]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make(map[Status]int)
+	for _, e := range s.emails {
+		if e.Batch() != batch || (tenant != "" && e.Tenant() != tenant) {
+			continue
+		}
+		if n := len(e.statusHistory); n > 0 {
+			counts[e.statusHistory[n-1].Status()]++
+		}
+	}
+	return counts, nil
 }
 
 func (s *MemoryStorage) get(ctx context.Context, id int) (Email, bool, error) {
-	for _, v := range s.emails {
-		if v.ID() == id {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.emails[id]
+	if !ok {
+		return Email{}, false, nil
+	}
+	return e.clone(), true, nil
+}
+
+func (s *MemoryStorage) update(ctx context.Context, e Email) (Email, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.emails[e.ID()]
+	if !ok {
+		return Email{}, false, nil
+	}
+	if v.version != e.version {
+		return Email{}, true, ErrVersionConflict
+	}
+	e.version++
+	s.store(e)
+	return s.emails[e.ID()].clone(), true, nil
+}
+
+func (s *MemoryStorage) appendStatusEvents(ctx context.Context, id int, events []StatusEvent) (Email, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.emails[id]
+	if !ok {
+		return Email{}, false, nil
+	}
+	// the history is copied so that the emails returned before keep theirs
+	v.statusHistory = append(v.StatusHistory(), events...)
+	v.version++
+	s.emails[id] = v
+	return v.clone(), true, nil
+}
+
+func (s *MemoryStorage) listExpired(ctx context.Context, cutoffs []retentionCutoff, after, limit int) ([]expiredEmail, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	arr := make([]expiredEmail, 0)
+	for _, id := range s.emailIDs[sort.SearchInts(s.emailIDs, after+1):] {
+		if len(arr) == limit {
+			break
+		}
+		e := s.emails[id]
+		se, ok := e.StatusHistory().latest()
+		if !ok {
+			continue
+		}
+		v := expiredEmail{id, e.tenant, se.Status(), se.At(), e.subject == "" && e.body == ""}
+		for _, c := range cutoffs {
+			if c.matches(v) {
+				arr = append(arr, v)
+				break
+			}
+		}
+	}
+	return arr, nil
+}
+
+func (s *MemoryStorage) redact(ctx context.Context, ids []int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		e, ok := s.emails[id]
+		if !ok || (e.subject == "" && e.body == "") {
+			continue
+		}
+		e.subject, e.body = "", ""
+		history := make(StatusHistory, len(e.statusHistory))
+		for i, se := range e.statusHistory {
+			history[i] = MakeStatusEvent(se.Status(), se.At())
+		}
+		e.statusHistory = history
+		e.version++
+		s.emails[id] = e
+		n++
+	}
+	return n, nil
+}
+
+func (s *MemoryStorage) deleteEmails(ctx context.Context, ids []int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		if _, ok := s.emails[id]; ok {
+			delete(s.emails, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	emailIDs := make([]int, 0, len(s.emails))
+	for _, id := range s.emailIDs {
+		if _, ok := s.emails[id]; ok {
+			emailIDs = append(emailIDs, id)
+		}
+	}
+	s.emailIDs = emailIDs
+	return n, nil
+}
+
+func (s *MemoryStorage) insertAPIKey(ctx context.Context, k APIKey) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastKeyID++
+	k.ID = s.lastKeyID
+	s.keys = append(s.keys, k)
+	return k, nil
+}
+
+func (s *MemoryStorage) getAPIKeyByHash(ctx context.Context, hash string) (APIKey, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.keys {
+		if v.Hash == hash {
 			return v, true, nil
 		}
 	}
-	return Email{}, false, nil
+	return APIKey{}, false, nil
 }
 
-func (s *MemoryStorage) update(ctx context.Context, e Email) (Email, bool, error) {
-	_, ok, err := s.get(ctx, e.ID())
-	if err != nil || !ok {
-		return Email{}, ok, err
+func (s *MemoryStorage) listAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	arr := make([]APIKey, 0)
+	for _, v := range s.keys {
+		if tenant == "" || v.Tenant == tenant {
+			arr = append(arr, v)
+		}
 	}
+	return arr, nil
+}
 
-	id := e.ID()
-	from := e.From()
-	to := []string{}
-	cc := []string{}
-	bcc := []string{}
-	subject := e.Subject()
-	body := e.Body()
+func (s *MemoryStorage) deleteAPIKey(ctx context.Context, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.keys {
+		if v.ID == id {
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-	for _, r := range e.To() {
-		to = append(to, r.String())
+func (s *MemoryStorage) insertSenderIdentity(ctx context.Context, i SenderIdentity) (SenderIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastIdentityID++
+	i.ID = s.lastIdentityID
+	s.identities = append(s.identities, i)
+	return i, nil
+}
+
+func (s *MemoryStorage) listSenderIdentities(ctx context.Context, tenant string) ([]SenderIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	arr := make([]SenderIdentity, 0)
+	for _, v := range s.identities {
+		if tenant == "" || v.Tenant == tenant {
+			arr = append(arr, v)
+		}
 	}
-	for _, r := range e.CC() {
-		cc = append(cc, r.String())
+	return arr, nil
+}
+
+func (s *MemoryStorage) deleteSenderIdentity(ctx context.Context, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.identities {
+		if v.ID == id {
+			s.identities = append(s.identities[:i], s.identities[i+1:]...)
+			return true, nil
+		}
 	}
-	for _, r := range e.BCC() {
-		bcc = append(bcc, r.String())
+	return false, nil
+}
+
+func (s *MemoryStorage) upsertSuppression(ctx context.Context, sup Suppression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.suppressions {
+		if v.Tenant == sup.Tenant && v.Address == sup.Address && v.Reason == sup.Reason {
+			s.suppressions[i] = sup
+			return nil
+		}
 	}
+	s.suppressions = append(s.suppressions, sup)
+	return nil
+}
 
-	newE, err := New(id, from.String(), to, cc, bcc, subject, body)
-	if err != nil {
-		return Email{}, true, fmt.Errorf("failed to create new email: %v", err)
+func (s *MemoryStorage) getSuppressions(ctx context.Context, tenant string, addrs []string) ([]Suppression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	want := make(map[string]bool)
+	for _, a := range addrs {
+		want[a] = true
 	}
+	arr := make([]Suppression, 0)
+	for _, v := range s.suppressions {
+		if want[v.Address] && (v.Tenant == "" || v.Tenant == tenant) {
+			arr = append(arr, v)
+		}
+	}
+	return arr, nil
+}
 
-	for _, v := range e.StatusHistory() {
-		newE.AddStatusEvent(v)
+func (s *MemoryStorage) listSuppressions(ctx context.Context) ([]Suppression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	arr := make([]Suppression, len(s.suppressions))
+	copy(arr, s.suppressions)
+	return arr, nil
+}
+
+func (s *MemoryStorage) deleteSuppression(ctx context.Context, tenant, addr string, reason SuppressionReason) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.suppressions {
+		if v.Tenant == tenant && v.Address == addr && v.Reason == reason {
+			s.suppressions = append(s.suppressions[:i], s.suppressions[i+1:]...)
+			return true, nil
+		}
 	}
+	return false, nil
+}
 
-	s.remove(ctx, e)
-	s.emails = append(s.emails, newE)
-	return newE, true, nil
+func (s *MemoryStorage) insertWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastWebhookID++
+	w.ID = s.lastWebhookID
+	s.webhooks = append(s.webhooks, w)
+	return w, nil
+}
+
+func (s *MemoryStorage) getWebhook(ctx context.Context, id int) (Webhook, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.webhooks {
+		if v.ID == id {
+			return v, true, nil
+		}
+	}
+	return Webhook{}, false, nil
+}
+
+func (s *MemoryStorage) listWebhooks(ctx context.Context, tenant string) ([]Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	arr := make([]Webhook, 0)
+	for _, v := range s.webhooks {
+		if tenant == "" || v.Tenant == tenant {
+			arr = append(arr, v)
+		}
+	}
+	return arr, nil
+}
+
+func (s *MemoryStorage) deleteWebhook(ctx context.Context, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.webhooks {
+		if v.ID == id {
+			s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+			// the deliveries go with their webhook, as in the databases
+			deliveries := make([]WebhookDelivery, 0, len(s.deliveries))
+			for _, d := range s.deliveries {
+				if d.WebhookID != id {
+					deliveries = append(deliveries, d)
+				}
+			}
+			s.deliveries = deliveries
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStorage) insertWebhookDelivery(ctx context.Context, d WebhookDelivery) (WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastDeliveryID++
+	d.ID = s.lastDeliveryID
+	s.deliveries = append(s.deliveries, d)
+	return d, nil
+}
+
+func (s *MemoryStorage) listWebhookDeliveries(ctx context.Context, webhookID int) ([]WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	arr := make([]WebhookDelivery, 0)
+	for _, v := range s.deliveries {
+		if v.WebhookID == webhookID {
+			v.payload = nil
+			arr = append(arr, v)
+		}
+	}
+	return arr, nil
+}
+
+func (s *MemoryStorage) listDueWebhookDeliveries(ctx context.Context, before time.Time, limit int) ([]WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	arr := make([]WebhookDelivery, 0)
+	for _, v := range s.deliveries {
+		if !v.NextAttemptAt.IsZero() && !v.NextAttemptAt.After(before) {
+			arr = append(arr, v)
+		}
+	}
+	sort.SliceStable(arr, func(i, j int) bool { return arr[i].NextAttemptAt.Before(arr[j].NextAttemptAt) })
+	if len(arr) > limit {
+		arr = arr[:limit]
+	}
+	return arr, nil
+}
+
+func (s *MemoryStorage) claimWebhookDelivery(ctx context.Context, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.deliveries {
+		if v.ID == id && !v.NextAttemptAt.IsZero() {
+			s.deliveries[i].NextAttemptAt = time.Time{}
+			return true, nil
+		}
+	}
+	return false, nil
 }
@@ -3,7 +3,10 @@ package email
 import (
 	"context"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestMemoryStorageInsert(t *testing.T) {
@@ -39,6 +42,7 @@ func TestMemoryStorageInsert(t *testing.T) {
 			if err != nil {
 				t.Errorf("%s: failed to create email: %v", test.desc, err)
 			}
+			expect.version = 1
 
 			got, err := s.insert(context.Background(), email)
 			if test.wantErr && err == nil {
@@ -53,3 +57,185 @@ func TestMemoryStorageInsert(t *testing.T) {
 		})
 	}
 }
+
+func TestMemoryStorageUpdate(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+	e, _ := New(0, "james@example.com", []string{"john@example.com"}, nil, nil, "subject", "body")
+	stored, _ := s.insert(ctx, e)
+
+	first := stored
+	first.AddStatusEvent(MakeStatusEvent(SentSuccessfully, time.Now()))
+	got, ok, err := s.update(ctx, first)
+	if err != nil || !ok {
+		t.Fatalf("ok=%t, err=%v", ok, err)
+	}
+	if got.Version() != 2 {
+		t.Fatalf("got version %d, but expected 2", got.Version())
+	}
+
+	// the second writer read the email before the first one updated it
+	second := stored
+	second.AddStatusEvent(MakeStatusEvent(Dead, time.Now()))
+	if _, _, err := s.update(ctx, second); err != ErrVersionConflict {
+		t.Fatalf("got error %v, but expected %v", err, ErrVersionConflict)
+	}
+	if _, ok, _ := s.update(ctx, Email{id: 99}); ok {
+		t.Fatal("updated a missing email")
+	}
+}
+
+func TestMemoryStorageAppendStatusEvents(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+	e, _ := New(0, "james@example.com", []string{"john@example.com"}, nil, nil, "subject", "body")
+	e.AddStatusEvent(MakeStatusEvent(Queued, time.Now()))
+	stored, _ := s.insert(ctx, e)
+
+	// appends do not conflict, whatever version they were read at
+	s.appendStatusEvents(ctx, stored.ID(), []StatusEvent{MakeStatusEvent(SentSuccessfully, time.Now())})
+	got, ok, err := s.appendStatusEvents(ctx, stored.ID(), []StatusEvent{MakeStatusEvent(Bounced, time.Now())})
+	if err != nil || !ok {
+		t.Fatalf("ok=%t, err=%v", ok, err)
+	}
+	var statuses []Status
+	for _, se := range got.StatusHistory() {
+		statuses = append(statuses, se.Status())
+	}
+	if want := []Status{Queued, SentSuccessfully, Bounced}; !reflect.DeepEqual(statuses, want) || got.Version() != 3 {
+		t.Fatalf("got %v at version %d, but expected %v at version 3", statuses, got.Version(), want)
+	}
+	if len(stored.StatusHistory()) != 1 {
+		t.Fatalf("got %d events in the email read before, but expected 1", len(stored.StatusHistory()))
+	}
+	if _, ok, _ := s.appendStatusEvents(ctx, 99, nil); ok {
+		t.Fatal("appended to a missing email")
+	}
+}
+
+func TestMemoryStorageConcurrentInserts(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+	e, _ := New(0, "james@example.com", []string{"john@example.com"}, nil, nil, "subject", "body")
+	const workers, each = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				if w%2 == 0 {
+					s.insert(ctx, e)
+				} else {
+					s.insertBatch(ctx, []Email{e})
+				}
+				s.list(ctx, "", 0, 10)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	emails, _ := s.list(ctx, "", 0, workers*each+1)
+	if len(emails) != workers*each {
+		t.Fatalf("got %d emails, but expected %d", len(emails), workers*each)
+	}
+	for i, e := range emails {
+		if e.ID() != i+1 {
+			t.Fatalf("got id %d at position %d, but expected %d", e.ID(), i, i+1)
+		}
+	}
+	page, _ := s.list(ctx, "", workers*each-2, 10)
+	if len(page) != 2 || page[0].ID() != workers*each-1 {
+		t.Fatalf("got %d emails after %d, but expected the last 2", len(page), workers*each-2)
+	}
+}
+
+func TestMemoryStorageConcurrentAppends(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+	e, _ := New(0, "james@example.com", []string{"john@example.com"}, nil, nil, "subject", "body")
+	stored, _ := s.insert(ctx, e)
+	const workers, each = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				s.appendStatusEvents(ctx, stored.ID(), []StatusEvent{MakeStatusEvent(FailedAttemptToSend, time.Now())})
+				s.get(ctx, stored.ID())
+				s.countBatch(ctx, "", "")
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, _, _ := s.get(ctx, stored.ID())
+	if n := len(got.StatusHistory()); n != workers*each || got.Version() != workers*each+1 {
+		t.Fatalf("got %d events at version %d, but expected %d at version %d", n, got.Version(), workers*each, workers*each+1)
+	}
+}
+
+func TestMemoryStorageConcurrentUpdates(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+	e, _ := New(0, "james@example.com", []string{"john@example.com"}, nil, nil, "0", "body")
+	stored, _ := s.insert(ctx, e)
+
+	// every worker counts up in the subject, reading again after a conflict
+	const workers, each = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; {
+				e, _, _ := s.get(ctx, stored.ID())
+				n, _ := strconv.Atoi(e.Subject())
+				e.subject = strconv.Itoa(n + 1)
+				_, _, err := s.update(ctx, e)
+				if err == ErrVersionConflict {
+					continue
+				}
+				if err != nil {
+					t.Errorf("failed to update email: %v", err)
+					return
+				}
+				i++
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, _, _ := s.get(ctx, stored.ID())
+	if want := strconv.Itoa(workers * each); got.Subject() != want {
+		t.Fatalf("got count %s, but expected %s", got.Subject(), want)
+	}
+}
+
+func TestMemoryStorageCopies(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+	e, _ := New(0, "james@example.com", []string{"john@example.com"}, nil, nil, "subject", "body")
+	e.AddStatusEvent(MakeStatusEvent(Queued, time.Now()))
+	e.traceContext = map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	stored, _ := s.insert(ctx, e)
+
+	// changing the emails given to or returned by the storage does not change it
+	stored.to[0].Address = "changed@example.com"
+	stored.AddStatusEvent(MakeStatusEvent(Dead, time.Now()))
+	got, _, _ := s.get(ctx, stored.ID())
+	got.from.Address = "changed@example.com"
+	got.statusHistory[0] = MakeStatusEvent(Dead, time.Now())
+
+	got, _, _ = s.get(ctx, stored.ID())
+	if got.To()[0].Address != "john@example.com" || got.From().Address != "james@example.com" {
+		t.Fatalf("got email from %v to %v, but expected the addresses it was inserted with", got.From(), got.To())
+	}
+	if h := got.StatusHistory(); len(h) != 1 || h[0].Status() != Queued {
+		t.Fatalf("got history %v, but expected the queued event only", h)
+	}
+	if got.traceContext != nil {
+		t.Fatalf("got trace context %v, but expected none to be stored", got.traceContext)
+	}
+}
@@ -0,0 +1,215 @@
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/husainaloos/notfy/messaging"
+	"github.com/husainaloos/notfy/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultStreamBacklog = 1000
+	streamBufferSize     = 64
+)
+
+// StatusUpdate is a status event of an email, as streamed to the subscribers
+type StatusUpdate struct {
+	EmailID int       `json:"email_id"`
+	Tenant  string    `json:"tenant"`
+	Index   int       `json:"index"`
+	Status  string    `json:"status"`
+	At      time.Time `json:"at"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+func makeStatusUpdate(e Email, index int) StatusUpdate {
+	se := e.StatusHistory()[index]
+	return StatusUpdate{
+		EmailID: e.ID(),
+		Tenant:  e.Tenant(),
+		Index:   index,
+		Status:  se.Status().String(),
+		At:      se.At(),
+		Detail:  se.Detail(),
+	}
+}
+
+// ID gets the id of the update, which orders the updates by time, then email
+// and index, and is the same on every replica
+func (u StatusUpdate) ID() string {
+	return fmt.Sprintf("%d-%d-%d", u.At.UnixNano(), u.EmailID, u.Index)
+}
+
+// after reports whether the update comes after the update with the id
+func (u StatusUpdate) after(id string) bool {
+	parts := strings.Split(id, "-")
+	if len(parts) != 3 {
+		return true
+	}
+	key := make([]int64, 3)
+	for i, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return true
+		}
+		key[i] = n
+	}
+	own := []int64{u.At.UnixNano(), int64(u.EmailID), int64(u.Index)}
+	for i := range own {
+		if own[i] != key[i] {
+			return own[i] > key[i]
+		}
+	}
+	return false
+}
+
+// streamSub is a subscriber of the stream. Its channel is closed when it
+// falls too far behind, so it can resume with the id of its last update.
+type streamSub struct {
+	c      chan StatusUpdate
+	filter func(StatusUpdate) bool
+	closed bool
+}
+
+// StatusStream fans the status updates of the emails out to the subscribers
+// of every API replica through the messaging backend. It keeps the most
+// recent updates so subscribers can resume where they left off.
+type StatusStream struct {
+	publisher messaging.Publisher
+	local     bool
+	broker    string
+
+	mu      sync.Mutex
+	subs    map[*streamSub]struct{}
+	recent  []StatusUpdate
+	backlog int
+	metrics metrics.Metrics
+}
+
+// NewStatusStream creates a new instance of StatusStream. Updates are published
+// to p and received from s; without s, they are only fanned out locally, e.g.
+// for a deamon that only publishes. backlog is the number of recent updates
+// kept for resuming, and defaults to 1000.
+func NewStatusStream(p messaging.Publisher, s messaging.Subscriber, backlog int) (*StatusStream, error) {
+	if backlog <= 0 {
+		backlog = defaultStreamBacklog
+	}
+	st := &StatusStream{
+		publisher: p,
+		local:     s == nil,
+		subs:      make(map[*streamSub]struct{}),
+		backlog:   backlog,
+		metrics:   metrics.Nop{},
+	}
+	if s != nil {
+		st.broker = messaging.BrokerOf(s)
+		if err := s.Subscribe(st.receive); err != nil {
+			return nil, fmt.Errorf("cannot subscribe to status updates: %v", err)
+		}
+	}
+	return st, nil
+}
+
+// SetMetrics counts the status updates that cannot be decoded with m
+func (st *StatusStream) SetMetrics(m metrics.Metrics) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.metrics = m
+}
+
+// publish publishes the status events of the email from index from onwards.
+// A nil stream does nothing.
+func (st *StatusStream) publish(e Email, from int) {
+	if st == nil {
+		return
+	}
+	for i := from; i < len(e.StatusHistory()); i++ {
+		u := makeStatusUpdate(e, i)
+		b, err := json.Marshal(u)
+		if err != nil {
+			logrus.WithField("email_id", u.EmailID).Errorf("failed to marshal status update: %v", err)
+			continue
+		}
+		if err := st.publisher.Publish(b); err != nil {
+			logrus.WithField("email_id", u.EmailID).Errorf("failed to publish status update: %v", err)
+		}
+		if st.local {
+			st.dispatch(u)
+		}
+	}
+}
+
+func (st *StatusStream) receive(b []byte) {
+	var u StatusUpdate
+	if err := json.Unmarshal(b, &u); err != nil {
+		logrus.WithField("broker", st.broker).Errorf("failed to unmarshal status update: %v", err)
+		st.mu.Lock()
+		st.metrics.CountBrokerError(st.broker, "consume")
+		st.mu.Unlock()
+		return
+	}
+	st.dispatch(u)
+}
+
+// dispatch keeps the update and hands it to the subscribers it passes the
+// filter of. Subscribers that are not keeping up are dropped.
+func (st *StatusStream) dispatch(u StatusUpdate) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.recent = append(st.recent, u)
+	if len(st.recent) > st.backlog {
+		st.recent = st.recent[len(st.recent)-st.backlog:]
+	}
+	for sub := range st.subs {
+		if !sub.filter(u) {
+			continue
+		}
+		select {
+		case sub.c <- u:
+		default:
+			st.closeLocked(sub)
+		}
+	}
+}
+
+// subscribe gets the updates passing the filter from now on, along with the
+// recent ones after lastID. Nothing is replayed when lastID is empty.
+func (st *StatusStream) subscribe(filter func(StatusUpdate) bool, lastID string) (*streamSub, []StatusUpdate) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sub := &streamSub{
+		c:      make(chan StatusUpdate, streamBufferSize),
+		filter: filter,
+	}
+	st.subs[sub] = struct{}{}
+	replay := make([]StatusUpdate, 0)
+	if lastID == "" {
+		return sub, replay
+	}
+	for _, u := range st.recent {
+		if filter(u) && u.after(lastID) {
+			replay = append(replay, u)
+		}
+	}
+	return sub, replay
+}
+
+func (st *StatusStream) unsubscribe(sub *streamSub) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.closeLocked(sub)
+}
+
+func (st *StatusStream) closeLocked(sub *streamSub) {
+	if !sub.closed {
+		sub.closed = true
+		close(sub.c)
+	}
+	delete(st.subs, sub)
+}
@@ -0,0 +1,180 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/husainaloos/notfy/messaging"
+)
+
+func TestStatusUpdateAfter(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	u := StatusUpdate{EmailID: 5, Index: 1, At: at}
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{"should come after an earlier update", StatusUpdate{EmailID: 9, Index: 3, At: at.Add(-time.Second)}.ID(), true},
+		{"should come after a lower email at the same time", StatusUpdate{EmailID: 4, Index: 7, At: at}.ID(), true},
+		{"should come after a lower index of the same email", StatusUpdate{EmailID: 5, Index: 0, At: at}.ID(), true},
+		{"should not come after itself", u.ID(), false},
+		{"should not come after a later update", StatusUpdate{EmailID: 1, Index: 0, At: at.Add(time.Second)}.ID(), false},
+		{"should come after a malformed id", "garbage", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := u.after(test.id); got != test.want {
+				t.Fatalf("got %v, but expected %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEventsFanOutAcrossReplicas(t *testing.T) {
+	backend := messaging.NewInMemoryPubSub()
+	storage := NewMemoryStorage()
+	replicas := make([]*API, 2)
+	for i := range replicas {
+		st, err := NewStatusStream(backend, backend, 0)
+		if err != nil {
+			t.Fatalf("failed to create stream: %v", err)
+		}
+		replicas[i] = NewAPI(messaging.NilPublisher{}, storage)
+		replicas[i].SetStatusStream(st)
+	}
+	ctx, cancel := context.WithCancel(WithTenant(context.Background(), "acme"))
+	defer cancel()
+	replicas[0].AddSenderIdentity(ctx, "acme", "example.com")
+
+	updates, err := replicas[1].Events(ctx, 0, "")
+	if err != nil {
+		t.Fatalf("failed to stream events: %v", err)
+	}
+	others, err := replicas[1].Events(WithTenant(ctx, "globex"), 0, "")
+	if err != nil {
+		t.Fatalf("failed to stream events: %v", err)
+	}
+	if _, err := replicas[1].Events(context.Background(), 0, ""); err == nil {
+		t.Fatal("streamed the events of every tenant without a tenant")
+	}
+	e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	queued, err := replicas[0].Queue(ctx, e)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	select {
+	case u := <-updates:
+		if u.EmailID != queued.ID() || u.Status != "Queued" {
+			t.Fatalf("got update %+v, but expected the queued event of email %d", u, queued.ID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the update")
+	}
+	select {
+	case u := <-others:
+		t.Fatalf("got update %+v of another tenant", u)
+	default:
+	}
+}
+
+func TestEmailEventsHandler(t *testing.T) {
+	storage := NewMemoryStorage()
+	st, _ := NewStatusStream(messaging.NilPublisher{}, nil, 0)
+	api := NewAPI(messaging.NilPublisher{}, storage)
+	api.SetStatusStream(st)
+	api.AddSenderIdentity(context.Background(), "acme", "example.com")
+	r := chi.NewRouter()
+	NewHTTPHandler(api, tenantAuth("acme")).Route(r)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	queued, _ := api.Queue(WithTenant(context.Background(), "acme"), e)
+
+	// the whole history is replayed, then the live updates follow
+	resp, events := openEvents(t, srv.URL+"/1/events", "")
+	first := <-events
+	if first.Status != "Queued" {
+		t.Fatalf("got %+v, but expected the queued event first", first)
+	}
+	queued.AddStatusEvent(MakeStatusEvent(SentSuccessfully, time.Now()))
+	storage.update(context.Background(), queued)
+	st.publish(queued, 1)
+	select {
+	case u := <-events:
+		if u.Status != "SentSuccessfully" || u.Index != 1 {
+			t.Fatalf("got %+v, but expected the sent event", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the live update")
+	}
+	resp.Body.Close()
+
+	// resuming skips what was already seen
+	resp, events = openEvents(t, srv.URL+"/1/events", first.ID())
+	defer resp.Body.Close()
+	if u := <-events; u.Status != "SentSuccessfully" {
+		t.Fatalf("got %+v after resuming, but expected the sent event", u)
+	}
+
+	// the events of the emails of other tenants are not found, and are not
+	// streamed without a key
+	api.Queue(WithAdmin(context.Background()), e)
+	for key, want := range map[string]int{"key": http.StatusNotFound, "": http.StatusUnauthorized} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/2/events", nil)
+		req.Header.Set("X-API-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to get events: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("got status %d with key %q, but expected %d", resp.StatusCode, key, want)
+		}
+	}
+}
+
+// openEvents opens the event stream and decodes its updates in the background
+func openEvents(t *testing.T, url, lastEventID string) (*http.Response, <-chan StatusUpdate) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("X-API-Key", "key")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to open event stream: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %q, but expected text/event-stream", ct)
+	}
+	c := make(chan StatusUpdate, 10)
+	go func() {
+		defer close(c)
+		var id string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				var u StatusUpdate
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &u)
+				if u.ID() != id {
+					continue
+				}
+				c <- u
+			}
+		}
+	}()
+	return resp, c
+}
@@ -0,0 +1,93 @@
+package email
+
+import (
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SuppressionReason is why an address is suppressed
+type SuppressionReason uint32
+
+//go:generate stringer -type SuppressionReason -trimprefix Reason
+const (
+	ReasonBounce SuppressionReason = iota
+	ReasonComplaint
+	ReasonUnsubscribe
+	ReasonManual
+)
+
+var suppressionReasons = []SuppressionReason{ReasonBounce, ReasonComplaint, ReasonUnsubscribe, ReasonManual}
+
+// ParseSuppressionReason parses the name of a reason, e.g. "bounce"
+func ParseSuppressionReason(s string) (SuppressionReason, error) {
+	for _, r := range suppressionReasons {
+		if strings.EqualFold(r.String(), s) {
+			return r, nil
+		}
+	}
+	return ReasonManual, fmt.Errorf("unknown suppression reason %q", s)
+}
+
+// SuppressionPolicy is what happens to emails with suppressed recipients
+type SuppressionPolicy int
+
+const (
+	// DropSuppressed sends the email without the suppressed recipients
+	DropSuppressed SuppressionPolicy = iota
+	// RejectSuppressed refuses the whole email
+	RejectSuppressed
+)
+
+// Suppression is an address no email of the tenant is sent to. Suppressions
+// without a tenant apply to every tenant.
+type Suppression struct {
+	Tenant    string
+	Address   string
+	Reason    SuppressionReason
+	Detail    string
+	CreatedAt time.Time
+
+	// ExpiresAt is when the suppression is lifted. The zero value never expires.
+	ExpiresAt time.Time
+}
+
+func (s Suppression) active(now time.Time) bool {
+	return s.ExpiresAt.IsZero() || now.Before(s.ExpiresAt)
+}
+
+// RecipientError is the error of the SMTP server refusing a recipient
+type RecipientError struct {
+	Address string
+	Err     error
+}
+
+func (e *RecipientError) Error() string {
+	return fmt.Sprintf("recipient %s refused: %v", e.Address, e.Err)
+}
+
+// detail describes the error for the status history, without the address
+func (e *RecipientError) detail() string {
+	return "recipient refused: " + withoutAddress(e.Err.Error(), e.Address)
+}
+
+// permanent reports whether the server refused the recipient with a 5xx reply
+func (e *RecipientError) permanent() bool {
+	tperr, ok := e.Err.(*textproto.Error)
+	return ok && tperr.Code >= 500 && tperr.Code < 600
+}
+
+// withoutAddress replaces the address in a text, such as the reply of a mail
+// server, so that the text can be kept in a status detail
+func withoutAddress(s, addr string) string {
+	if addr == "" {
+		return s
+	}
+	return regexp.MustCompile("(?i)"+regexp.QuoteMeta(addr)).ReplaceAllString(s, "recipient")
+}
+
+func normalizeAddress(addr string) string {
+	return strings.ToLower(strings.TrimSpace(addr))
+}
@@ -0,0 +1,43 @@
+package email
+
+import "context"
+
+type tenantCtxKey struct{}
+
+type adminCtxKey struct{}
+
+// WithTenant returns a context of a request made on behalf of the tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenant)
+}
+
+// TenantFromContext gets the tenant the request is made on behalf of
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantCtxKey{}).(string)
+	return tenant, ok
+}
+
+// WithAdmin returns a context of a request made by an admin, who acts on
+// behalf of every tenant
+func WithAdmin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, adminCtxKey{}, true)
+}
+
+func isAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminCtxKey{}).(bool)
+	return admin
+}
+
+// tenantScope gets the tenant whose emails the request may access, or "" if
+// the request is made by an admin, who may access the emails of every tenant.
+// Requests without a tenant may access none.
+func tenantScope(ctx context.Context) (string, error) {
+	if isAdmin(ctx) {
+		return "", nil
+	}
+	tenant, ok := TenantFromContext(ctx)
+	if !ok || tenant == "" {
+		return "", errEmptyTenant
+	}
+	return tenant, nil
+}
@@ -0,0 +1,2 @@
+<alerts@example.com><ada@example.com>"<cc@example.com>2subject:bodyB
+
\ No newline at end of file
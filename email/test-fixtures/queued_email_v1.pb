@@ -0,0 +1,3 @@
+ 0123456789abcdef0123456789abcdefdto.QueuedEmailapplication/x-protobufacmeF
+traceparent700-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01m<alerts@example.com><ada@example.com>"<cc@example.com>2subject:bodyB
+HRacmeZbat_fixture
\ No newline at end of file
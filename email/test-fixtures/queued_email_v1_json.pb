@@ -0,0 +1,2 @@
+ 0123456789abcdef0123456789abcdefdto.QueuedEmailapplication/jsonacmeF
+traceparent700-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01{"id":"7","from":"\u003calerts@example.com\u003e","to":["\u003cada@example.com\u003e"],"cc":["\u003ccc@example.com\u003e"],"subject":"subject","body":"body","status":[{"at":"1700000000000000000"}],"priority":1,"tenant":"acme","batch":"bat_fixture"}
\ No newline at end of file
@@ -0,0 +1,161 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/husainaloos/notfy/messaging"
+	"github.com/husainaloos/notfy/metrics"
+	"github.com/husainaloos/notfy/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeSMTPClient gets a client talking to a server that accepts every email,
+// and refuses the refused recipients for good
+func fakeSMTPClient(t *testing.T, refused ...string) *Client {
+	server, conn := net.Pipe()
+	isRefused := func(line string) bool {
+		for _, r := range refused {
+			if strings.HasPrefix(line, "RCPT") && strings.Contains(line, "<"+r+">") {
+				return true
+			}
+		}
+		return false
+	}
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		reply := func(s string) { server.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ready")
+		data := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case data:
+				if line == "." {
+					data = false
+					reply("250 queued")
+				}
+			case isRefused(line):
+				reply("550 no such user")
+			case strings.HasPrefix(line, "DATA"):
+				data = true
+				reply("354 go ahead")
+			case strings.HasPrefix(line, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	smtpc, err := smtp.NewClient(conn, "localhost")
+	if err != nil {
+		t.Fatalf("failed to create smtp client: %v", err)
+	}
+	return &Client{smtpc: smtpc}
+}
+
+func TestTraceFromRequestToSend(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	broker := messaging.NewInMemoryBroker()
+	storage := NewMemoryStorage()
+
+	api := NewAPI(broker, storage)
+	api.SetTracerProvider(tp)
+	api.AddSenderIdentity(context.Background(), "acme", "example.com")
+	r := chi.NewRouter()
+	r.Use(tracing.NewMiddleware(tp))
+	NewHTTPHandler(api, tenantAuth("acme")).Route(r)
+	body := `{"from":"from@example.com","to":["to@example.com"],"subject":"s","body":"b"}`
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-API-Key", "key")
+	r.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("got status %d, but expected 200", rec.Code)
+	}
+
+	msg, _ := broker.Consume()
+	email, err := Unmarshal(msg)
+	if err != nil {
+		t.Fatalf("failed to unmarshal email: %v", err)
+	}
+	d := &Deamon{
+		storage: storage,
+		clients: make(chan *Client, 1),
+		limiter: newRateLimiter(RateLimitConfig{}, ""),
+		metrics: metrics.Nop{},
+	}
+	d.SetTracerProvider(tp)
+	d.send(context.Background(), email, fakeSMTPClient(t))
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, s := range exp.GetSpans() {
+		spans[s.Name] = s
+	}
+	tests := []struct {
+		name, parent string
+		kind         trace.SpanKind
+	}{
+		{"HTTP POST /", "", trace.SpanKindServer},
+		{"email.Queue", "HTTP POST /", trace.SpanKindInternal},
+		{"email.publish", "email.Queue", trace.SpanKindProducer},
+		{"email.send", "email.publish", trace.SpanKindConsumer},
+		{"smtp.send", "email.send", trace.SpanKindInternal},
+	}
+	root := spans["HTTP POST /"]
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, ok := spans[test.name]
+			if !ok {
+				t.Fatalf("span not found in %d spans", len(spans))
+			}
+			if s.SpanKind != test.kind {
+				t.Fatalf("got kind %v, but expected %v", s.SpanKind, test.kind)
+			}
+			if s.SpanContext.TraceID() != root.SpanContext.TraceID() {
+				t.Fatalf("span is not in the trace of the request")
+			}
+			if test.parent == "" {
+				return
+			}
+			if got, want := s.Parent.SpanID(), spans[test.parent].SpanContext.SpanID(); got != want {
+				t.Fatalf("got parent %v, but expected the span of %s %v", got, test.parent, want)
+			}
+		})
+	}
+}
+
+func TestTraceContextIsNotStored(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	storage := NewMemoryStorage()
+	api := NewAPI(messaging.NilPublisher{}, storage)
+	api.SetTracerProvider(tp)
+	e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	queued, err := api.Queue(context.Background(), e)
+	if err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	if queued.traceContext != nil {
+		t.Fatalf("got trace context %v on the queued email, but expected none", queued.traceContext)
+	}
+	stored, _, _ := storage.get(context.Background(), queued.ID())
+	if stored.traceContext != nil {
+		t.Fatalf("got trace context %v on the stored email, but expected none", stored.traceContext)
+	}
+}
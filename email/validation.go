@@ -0,0 +1,110 @@
+package email
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxRecipients is the most recipients an email can have across to, cc and bcc
+	MaxRecipients = 50
+
+	// MaxSubjectLength is the longest subject in characters, as stored
+	MaxSubjectLength = 1000
+
+	// MaxBodySize is the largest body in bytes
+	MaxBodySize = 10 << 20
+
+	// MaxAddressLength is the longest address in characters, as stored
+	MaxAddressLength = 100
+)
+
+// fieldError is a problem with one field of a request. Index is the position
+// of the item in a list field, such as one address of "to".
+type fieldError struct {
+	Field   string `json:"field"`
+	Index   *int   `json:"index,omitempty"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// validationErrModel is the errModel of a request with invalid fields, along
+// with every problem found
+type validationErrModel struct {
+	errModel
+	Errors []fieldError `json:"errors"`
+}
+
+// validationErrors are every problem found with a request
+type validationErrors []fieldError
+
+func (errs validationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		field := e.Field
+		if e.Index != nil {
+			field = fmt.Sprintf("%s[%d]", e.Field, *e.Index)
+		}
+		msgs[i] = field + ": " + e.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (errs *validationErrors) add(field string, index *int, e errModel, detail string) {
+	msg := e.Message
+	if detail != "" {
+		msg += ": " + detail
+	}
+	*errs = append(*errs, fieldError{field, index, e.Code, msg})
+}
+
+// validate checks every field of the email and reports every problem at once
+func (m postEmailModel) validate() validationErrors {
+	var errs validationErrors
+	if m.From == "" {
+		errs.add("from", nil, errFieldRequired, "")
+	} else {
+		errs.addAddress("from", nil, m.From)
+	}
+	fields := []struct {
+		name  string
+		addrs []string
+	}{{"to", m.To}, {"cc", m.CC}, {"bcc", m.BCC}}
+	n := 0
+	for _, f := range fields {
+		for i, addr := range f.addrs {
+			i := i
+			errs.addAddress(f.name, &i, addr)
+		}
+		n += len(f.addrs)
+	}
+	if n == 0 {
+		errs.add("to", nil, errNoRecipients, "")
+	} else if n > MaxRecipients {
+		errs.add("to", nil, errTooManyRecipients, fmt.Sprintf("%d recipients is over the limit of %d", n, MaxRecipients))
+	}
+	if l := utf8.RuneCountInString(m.Subject); l > MaxSubjectLength {
+		errs.add("subject", nil, errSubjectTooLong, fmt.Sprintf("%d characters is over the limit of %d", l, MaxSubjectLength))
+	}
+	if l := len(m.Body); l > MaxBodySize {
+		errs.add("body", nil, errBodyTooLarge, fmt.Sprintf("%d bytes is over the limit of %d", l, MaxBodySize))
+	}
+	if _, err := ParsePriority(m.Priority); err != nil {
+		errs.add("priority", nil, errInvalidPriority, err.Error())
+	}
+	return errs
+}
+
+// addAddress checks that the address can be parsed and stored
+func (errs *validationErrors) addAddress(field string, index *int, addr string) {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil {
+		errs.add(field, index, errInvalidAddress, err.Error())
+		return
+	}
+	if l := utf8.RuneCountInString(parsed.String()); l > MaxAddressLength {
+		errs.add(field, index, errAddressTooLong, fmt.Sprintf("%d characters is over the limit of %d", l, MaxAddressLength))
+	}
+}
@@ -0,0 +1,110 @@
+package email
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPostEmailModelValidate(t *testing.T) {
+	one, two := 1, 2
+	valid := postEmailModel{From: "from@example.com", To: []string{"to@example.com"}, Subject: "subject", Body: "body"}
+	tooMany := make([]string, MaxRecipients+1)
+	for i := range tooMany {
+		tooMany[i] = "to@example.com"
+	}
+	tests := []struct {
+		name  string
+		model func(m postEmailModel) postEmailModel
+		want  []fieldError
+	}{
+		{"should accept a valid email", func(m postEmailModel) postEmailModel { return m }, nil},
+		{
+			name:  "should require a sender and a recipient",
+			model: func(m postEmailModel) postEmailModel { m.From, m.To = "", nil; return m },
+			want:  []fieldError{{"from", nil, 122, "is required"}, {"to", nil, 123, "email should have at least one recipient"}},
+		},
+		{
+			name: "should report every invalid address with its index",
+			model: func(m postEmailModel) postEmailModel {
+				m.To = []string{"to@example.com", "bad", "<" + strings.Repeat("a", MaxAddressLength) + "@example.com>"}
+				return m
+			},
+			want: []fieldError{
+				{"to", &one, 124, "invalid address: mail: missing '@' or angle-addr"},
+				{"to", &two, 125, "address is too long: 114 characters is over the limit of 100"},
+			},
+		},
+		{
+			name:  "should count the recipients across to, cc and bcc",
+			model: func(m postEmailModel) postEmailModel { m.To, m.BCC = tooMany[1:], tooMany[:1]; return m },
+			want:  []fieldError{{"to", nil, 126, "too many recipients: 51 recipients is over the limit of 50"}},
+		},
+		{
+			name: "should count the subject in characters",
+			model: func(m postEmailModel) postEmailModel {
+				m.Subject = strings.Repeat("é", MaxSubjectLength)
+				return m
+			},
+			want: nil,
+		},
+		{
+			name: "should limit the subject and the body",
+			model: func(m postEmailModel) postEmailModel {
+				m.Subject = strings.Repeat("a", MaxSubjectLength+1)
+				m.Body = strings.Repeat("a", MaxBodySize+1)
+				return m
+			},
+			want: []fieldError{
+				{"subject", nil, 127, "subject is too long: 1001 characters is over the limit of 1000"},
+				{"body", nil, 128, "body is too large: 10485761 bytes is over the limit of 10485760"},
+			},
+		},
+		{
+			name:  "should report an unknown priority",
+			model: func(m postEmailModel) postEmailModel { m.Priority = "urgent"; return m },
+			want:  []fieldError{{"priority", nil, 129, "invalid priority: unknown priority \"urgent\""}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.model(valid).validate()
+			if len(got) == 0 && len(test.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual([]fieldError(got), test.want) {
+				t.Fatalf("got errors %+v, but expected %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPostEmailHandlerReportsEveryProblem(t *testing.T) {
+	h := NewHTTPHandler(&mockAPI{}, tenantAuth("acme"))
+	w := httptest.NewRecorder()
+	body := `{"from": "bad", "to": ["ok@example.com", "also bad"], "priority": "urgent"}`
+	h.sendEmailHandler(w, httptest.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, but expected %d", w.Code, http.StatusBadRequest)
+	}
+	var res validationErrModel
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if res.Code != errValidationFailed.Code {
+		t.Fatalf("got code %d, but expected %d", res.Code, errValidationFailed.Code)
+	}
+	var fields []string
+	for _, e := range res.Errors {
+		fields = append(fields, e.Field)
+	}
+	if want := []string{"from", "to", "priority"}; !reflect.DeepEqual(fields, want) {
+		t.Fatalf("got errors for %v, but expected %v", fields, want)
+	}
+	if idx := res.Errors[1].Index; idx == nil || *idx != 1 {
+		t.Fatalf("got index %v for to, but expected 1", idx)
+	}
+}
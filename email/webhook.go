@@ -0,0 +1,434 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	webhookSecretPrefix = "whsec_"
+
+	// WebhookSignatureHeader is the header of the HMAC-SHA256 signature of a delivery
+	WebhookSignatureHeader = "X-Notfy-Signature"
+	// WebhookTimestampHeader is the header of the unix time the delivery was signed at
+	WebhookTimestampHeader = "X-Notfy-Timestamp"
+
+	// webhookRetryBatchSize is the number of due deliveries each retry reads
+	webhookRetryBatchSize = 100
+)
+
+// ErrWebhookAddressNotAllowed is returned for the webhooks of loopback,
+// private and link-local addresses, which would let a tenant reach the
+// network of the service
+var ErrWebhookAddressNotAllowed = errors.New("webhook address is not public")
+
+var defaultWebhookBackoff = []time.Duration{
+	10 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+}
+
+// Webhook is a URL a tenant is notified on when the status of its emails changes
+type Webhook struct {
+	ID     int    `json:"id"`
+	Tenant string `json:"tenant"`
+	URL    string `json:"url"`
+
+	// Secret signs the deliveries. It is only returned when the webhook is created.
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one attempt at posting an event to a webhook
+type WebhookDelivery struct {
+	ID         int       `json:"id"`
+	WebhookID  int       `json:"webhook_id"`
+	EmailID    int       `json:"email_id"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	Test       bool      `json:"test"`
+	At         time.Time `json:"at"`
+
+	// NextAttemptAt is when the failed delivery is retried. It is zero once
+	// the delivery is retried, or if it is not to be retried.
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+
+	// payload is the body posted, which is kept to retry the delivery
+	payload []byte
+}
+
+// Succeeded reports whether the receiver answered with a 2xx status
+func (d WebhookDelivery) Succeeded() bool {
+	return d.StatusCode >= 200 && d.StatusCode < 300
+}
+
+// webhookEvent is the body of a delivery
+type webhookEvent struct {
+	EmailID int       `json:"email_id"`
+	Tenant  string    `json:"tenant"`
+	Status  string    `json:"status"`
+	At      time.Time `json:"at"`
+	Detail  string    `json:"detail,omitempty"`
+	Test    bool      `json:"test,omitempty"`
+}
+
+// WebhookConfig is the configuration of WebhookNotifier
+type WebhookConfig struct {
+	// Client posts the deliveries. It defaults to a client with a 10 second
+	// timeout. The dialer of its transport is replaced by one that refuses
+	// the addresses that are not public.
+	Client *http.Client
+
+	// Backoff is the delay before each retry of a failed delivery. Its length
+	// is the number of retries.
+	Backoff []time.Duration
+
+	// Interval is the time between the retries of Run. It defaults to 10 seconds.
+	Interval time.Duration
+
+	// AllowPrivateNetworks lets the webhooks post to loopback, private and
+	// link-local addresses. It is meant for development.
+	AllowPrivateNetworks bool
+}
+
+// WebhookNotifier posts the status events of the emails to the webhooks of
+// their tenant. Deliveries are signed and logged. The failed deliveries are
+// retried with backoff by Run, from the log, so that the retries survive a
+// restart and are shared by the replicas.
+type WebhookNotifier struct {
+	storage      Storage
+	client       *http.Client
+	backoff      []time.Duration
+	interval     time.Duration
+	allowPrivate bool
+	wg           sync.WaitGroup
+	now          func() time.Time
+}
+
+// NewWebhookNotifier creates a new instance of WebhookNotifier
+func NewWebhookNotifier(s Storage, cfg WebhookConfig) *WebhookNotifier {
+	if cfg.Backoff == nil {
+		cfg.Backoff = defaultWebhookBackoff
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	n := &WebhookNotifier{
+		storage:      s,
+		backoff:      cfg.Backoff,
+		interval:     cfg.Interval,
+		allowPrivate: cfg.AllowPrivateNetworks,
+		now:          time.Now,
+	}
+	n.client = n.guardClient(cfg.Client)
+	return n
+}
+
+// guardClient copies the client with a transport that checks the address of
+// the receiver when it is dialed, after it is resolved
+func (n *WebhookNotifier) guardClient(c *http.Client) *http.Client {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if c != nil {
+		*client = *c
+	}
+	transport, ok := client.Transport.(*http.Transport)
+	if client.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok {
+		return client
+	}
+	transport = transport.Clone()
+	// the receiver is dialed directly, so that its address is the one checked
+	transport.Proxy = nil
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   n.checkDial,
+	}
+	transport.DialContext = dialer.DialContext
+	client.Transport = transport
+	return client
+}
+
+// checkDial refuses to connect to the addresses that are not allowed
+func (n *WebhookNotifier) checkDial(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !n.allowed(net.ParseIP(host)) {
+		return ErrWebhookAddressNotAllowed
+	}
+	return nil
+}
+
+// allowed reports whether the webhooks may post to the ip. A nil notifier
+// only allows the public addresses.
+func (n *WebhookNotifier) allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if n != nil && n.allowPrivate {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// checkURL validates an absolute http or https URL, whose host resolves to
+// addresses the webhooks may post to
+func (n *WebhookNotifier) checkURL(ctx context.Context, rawurl string) (string, error) {
+	u, err := parseWebhookURL(rawurl)
+	if err != nil {
+		return "", err
+	}
+	parsed, _ := url.Parse(u)
+	host := parsed.Hostname()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve webhook host %q: %v", host, err)
+	}
+	for _, a := range addrs {
+		if !n.allowed(a.IP) {
+			return "", ErrWebhookAddressNotAllowed
+		}
+	}
+	return u, nil
+}
+
+// Wait blocks until every delivery in progress is done
+func (n *WebhookNotifier) Wait() {
+	n.wg.Wait()
+}
+
+// notify delivers the status events of the email from index from onwards to
+// the webhooks of its tenant, in the background. Each webhook is delivered
+// to on its own, so that a slow receiver does not hold back the others. A
+// nil notifier does nothing.
+func (n *WebhookNotifier) notify(e Email, from int) {
+	if n == nil || e.Tenant() == "" {
+		return
+	}
+	events := e.StatusHistory()
+	if from >= len(events) {
+		return
+	}
+	events = events[from:]
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ctx := context.Background()
+		hooks, err := n.storage.listWebhooks(ctx, e.Tenant())
+		if err != nil {
+			logrus.WithField("email_id", e.ID()).Errorf("failed to list webhooks: %v", err)
+			return
+		}
+		for _, hook := range hooks {
+			n.wg.Add(1)
+			go func(hook Webhook) {
+				defer n.wg.Done()
+				// the first attempts of the events of a webhook are in order
+				for _, se := range events {
+					n.deliver(ctx, hook, webhookEvent{
+						EmailID: e.ID(),
+						Tenant:  e.Tenant(),
+						Status:  se.Status().String(),
+						At:      se.At(),
+						Detail:  se.Detail(),
+					})
+				}
+			}(hook)
+		}
+	}()
+}
+
+// Run retries the failed deliveries that are due every interval until the
+// context is done
+func (n *WebhookNotifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := n.Retry(ctx); err != nil && ctx.Err() == nil {
+			logrus.Errorf("cannot retry webhook deliveries: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Retry retries the failed deliveries that are due in the background, and
+// returns the number of deliveries retried
+func (n *WebhookNotifier) Retry(ctx context.Context) (int, error) {
+	due, err := n.storage.listDueWebhookDeliveries(ctx, n.now(), webhookRetryBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list due deliveries: %v", err)
+	}
+	retried := 0
+	for _, d := range due {
+		ok, err := n.storage.claimWebhookDelivery(ctx, d.ID)
+		if err != nil {
+			return retried, fmt.Errorf("failed to claim delivery: %v", err)
+		}
+		if !ok {
+			// another replica retries it
+			continue
+		}
+		hook, found, err := n.storage.getWebhook(ctx, d.WebhookID)
+		if err != nil {
+			return retried, fmt.Errorf("failed to get webhook: %v", err)
+		}
+		if !found {
+			continue
+		}
+		retried++
+		n.wg.Add(1)
+		go func(d WebhookDelivery) {
+			defer n.wg.Done()
+			n.attempt(context.Background(), hook, WebhookDelivery{
+				WebhookID: d.WebhookID,
+				EmailID:   d.EmailID,
+				Event:     d.Event,
+				Attempt:   d.Attempt + 1,
+				payload:   d.payload,
+			})
+		}(d)
+	}
+	return retried, nil
+}
+
+// test delivers a test event to the webhook once, without retries
+func (n *WebhookNotifier) test(ctx context.Context, hook Webhook) WebhookDelivery {
+	return n.deliver(ctx, hook, webhookEvent{
+		Tenant: hook.Tenant,
+		Status: "Test",
+		At:     time.Now().UTC(),
+		Test:   true,
+	})
+}
+
+// deliver makes the first attempt at posting the event
+func (n *WebhookNotifier) deliver(ctx context.Context, hook Webhook, event webhookEvent) WebhookDelivery {
+	body, err := json.Marshal(event)
+	if err != nil {
+		logrus.WithField("webhook_id", hook.ID).Errorf("failed to marshal webhook event: %v", err)
+		return WebhookDelivery{}
+	}
+	return n.attempt(ctx, hook, WebhookDelivery{
+		WebhookID: hook.ID,
+		EmailID:   event.EmailID,
+		Event:     event.Status,
+		Attempt:   1,
+		Test:      event.Test,
+		payload:   body,
+	})
+}
+
+// attempt posts the payload of the delivery and logs the attempt. A failed
+// attempt is scheduled to be retried after its backoff, until the retries
+// run out. Test deliveries are never retried.
+func (n *WebhookNotifier) attempt(ctx context.Context, hook Webhook, d WebhookDelivery) WebhookDelivery {
+	logger := logrus.WithFields(logrus.Fields{
+		"webhook_id": hook.ID,
+		"email_id":   d.EmailID,
+		"attempt":    d.Attempt,
+	})
+	d.At = n.now().UTC()
+	code, err := n.post(ctx, hook, d.payload)
+	d.StatusCode = code
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if !d.Succeeded() && !d.Test && d.Attempt <= len(n.backoff) {
+		d.NextAttemptAt = d.At.Add(n.backoff[d.Attempt-1])
+	}
+	if logged, err := n.storage.insertWebhookDelivery(ctx, d); err != nil {
+		logger.Errorf("failed to log webhook delivery: %v", err)
+	} else {
+		d = logged
+	}
+	switch {
+	case d.Succeeded():
+		logger.Debug("webhook delivered")
+	case !d.NextAttemptAt.IsZero():
+		logger.WithField("next_attempt_at", d.NextAttemptAt).Warnf("webhook delivery failed: %s", d.Error)
+	default:
+		logger.Errorf("webhook delivery gave up: %s", d.Error)
+	}
+	return d
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, hook Webhook, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("cannot create request: %v", err)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookTimestampHeader, ts)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(hook.Secret, ts, body))
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook gets the signature of a delivery, the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the secret of the webhook
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether the signature of a delivery is valid. Receivers
+// should also reject timestamps that are too old.
+func VerifyWebhook(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
+
+// parseWebhookURL validates an absolute http or https URL
+func parseWebhookURL(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid webhook url %q", rawurl)
+	}
+	return u.String(), nil
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot read random bytes: %v", err)
+	}
+	return webhookSecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
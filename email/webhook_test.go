@@ -0,0 +1,279 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/husainaloos/notfy/messaging"
+)
+
+// webhookReceiver records the events posted to it, failing the first failures requests
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	events   []webhookEvent
+	verified []bool
+	secret   string
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if rcv.failures > 0 {
+		rcv.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	var e webhookEvent
+	json.Unmarshal(body, &e)
+	rcv.events = append(rcv.events, e)
+	rcv.verified = append(rcv.verified, VerifyWebhook(rcv.secret, r.Header.Get(WebhookTimestampHeader), body, r.Header.Get(WebhookSignatureHeader)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		backoff      []time.Duration
+		wantAttempts int
+		wantReceived bool
+	}{
+		{"should deliver on the first attempt", 0, []time.Duration{time.Minute}, 1, true},
+		{"should retry failed deliveries", 2, []time.Duration{time.Minute, time.Minute}, 3, true},
+		{"should give up once the retries run out", 5, []time.Duration{time.Minute}, 2, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rcv := &webhookReceiver{failures: test.failures}
+			srv := httptest.NewServer(rcv)
+			defer srv.Close()
+
+			storage := NewMemoryStorage()
+			api := NewAPI(messaging.NilPublisher{}, storage)
+			cfg := WebhookConfig{Backoff: test.backoff, AllowPrivateNetworks: true}
+			notifier := NewWebhookNotifier(storage, cfg)
+			api.SetWebhookNotifier(notifier)
+			ctx := WithTenant(context.Background(), "acme")
+			api.AddSenderIdentity(ctx, "acme", "example.com")
+			hook, secret, err := api.AddWebhook(ctx, srv.URL)
+			if err != nil {
+				t.Fatalf("failed to add webhook: %v", err)
+			}
+			rcv.secret = secret
+
+			e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+			queued, err := api.Queue(ctx, e)
+			if err != nil {
+				t.Fatalf("failed to queue email: %v", err)
+			}
+			notifier.Wait()
+
+			// the retries are read back from the log, so that a notifier that
+			// did not make the first attempt retries them once they are due
+			retrier := NewWebhookNotifier(storage, cfg)
+			at := time.Now()
+			retrier.now = func() time.Time { return at }
+			for {
+				at = at.Add(time.Hour)
+				n, err := retrier.Retry(context.Background())
+				if err != nil {
+					t.Fatalf("failed to retry deliveries: %v", err)
+				}
+				retrier.Wait()
+				if n == 0 {
+					break
+				}
+			}
+
+			deliveries, err := api.ListWebhookDeliveries(ctx, hook.ID)
+			if err != nil {
+				t.Fatalf("failed to list deliveries: %v", err)
+			}
+			if len(deliveries) != test.wantAttempts {
+				t.Fatalf("got %d deliveries, but expected %d", len(deliveries), test.wantAttempts)
+			}
+			for i, d := range deliveries {
+				if d.Attempt != i+1 || !d.NextAttemptAt.IsZero() {
+					t.Fatalf("got delivery %+v, but expected attempt %d without a retry left", d, i+1)
+				}
+			}
+			last := deliveries[len(deliveries)-1]
+			if last.Succeeded() != test.wantReceived {
+				t.Fatalf("got last delivery succeeded %v, but expected %v", last.Succeeded(), test.wantReceived)
+			}
+			if !test.wantReceived {
+				return
+			}
+			if len(rcv.events) != 1 || rcv.events[0].EmailID != queued.ID() || rcv.events[0].Status != "Queued" {
+				t.Fatalf("got events %+v, but expected the queued event of email %d", rcv.events, queued.ID())
+			}
+			if !rcv.verified[0] {
+				t.Fatal("got an invalid signature")
+			}
+		})
+	}
+}
+
+func TestWebhookRetryIsScheduled(t *testing.T) {
+	rcv := &webhookReceiver{failures: 1}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	storage := NewMemoryStorage()
+	notifier := NewWebhookNotifier(storage, WebhookConfig{Backoff: []time.Duration{time.Hour}, AllowPrivateNetworks: true})
+	hook, _ := storage.insertWebhook(context.Background(), Webhook{Tenant: "acme", URL: srv.URL})
+
+	first := notifier.deliver(context.Background(), hook, webhookEvent{EmailID: 1, Tenant: "acme", Status: "Queued"})
+	if first.Succeeded() || first.NextAttemptAt.Sub(first.At) != time.Hour {
+		t.Fatalf("got delivery %+v, but expected a failure retried in an hour", first)
+	}
+	// the retry is not due yet, so nothing is posted meanwhile
+	if n, err := notifier.Retry(context.Background()); n != 0 || err != nil {
+		t.Fatalf("retried %d deliveries (%v), but expected none before the backoff", n, err)
+	}
+	notifier.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if n, err := notifier.Retry(context.Background()); n != 1 || err != nil {
+		t.Fatalf("retried %d deliveries (%v), but expected 1", n, err)
+	}
+	notifier.Wait()
+	if len(rcv.events) != 1 || rcv.events[0].EmailID != 1 {
+		t.Fatalf("got events %+v, but expected the event of email 1", rcv.events)
+	}
+}
+
+func TestWebhookDeliveriesAreIndependent(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer slow.Close()
+	defer close(release)
+	rcv := &webhookReceiver{}
+	fast := httptest.NewServer(rcv)
+	defer fast.Close()
+
+	storage := NewMemoryStorage()
+	api := NewAPI(messaging.NilPublisher{}, storage)
+	notifier := NewWebhookNotifier(storage, WebhookConfig{AllowPrivateNetworks: true})
+	api.SetWebhookNotifier(notifier)
+	ctx := WithTenant(context.Background(), "acme")
+	api.AddSenderIdentity(ctx, "acme", "example.com")
+	api.AddWebhook(ctx, slow.URL)
+	api.AddWebhook(ctx, fast.URL)
+
+	e, _ := New(0, "from@example.com", []string{"to@example.com"}, nil, nil, "subject", "body")
+	if _, err := api.Queue(ctx, e); err != nil {
+		t.Fatalf("failed to queue email: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rcv.mu.Lock()
+		received := len(rcv.events)
+		rcv.mu.Unlock()
+		if received == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the delivery is held back by a slow webhook")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookAddressNotAllowed(t *testing.T) {
+	api := NewAPI(messaging.NilPublisher{}, NewMemoryStorage())
+	ctx := WithTenant(context.Background(), "acme")
+	tests := []struct {
+		name string
+		url  string
+		want error
+	}{
+		{"should refuse loopback addresses", "http://127.0.0.1:8080/hook", ErrWebhookAddressNotAllowed},
+		{"should refuse loopback hosts", "http://localhost/hook", ErrWebhookAddressNotAllowed},
+		{"should refuse ipv6 loopback addresses", "http://[::1]/hook", ErrWebhookAddressNotAllowed},
+		{"should refuse private addresses", "https://10.1.2.3/hook", ErrWebhookAddressNotAllowed},
+		{"should refuse link-local addresses", "http://169.254.169.254/latest/meta-data", ErrWebhookAddressNotAllowed},
+		{"should accept public addresses", "https://93.184.216.34/hook", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := api.AddWebhook(ctx, test.url); err != test.want {
+				t.Fatalf("got error %v, but expected %v", err, test.want)
+			}
+		})
+	}
+
+	// a host that resolves to another address once it is registered is
+	// refused when it is dialed
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	storage := NewMemoryStorage()
+	hook, _ := storage.insertWebhook(context.Background(), Webhook{Tenant: "acme", URL: srv.URL})
+	d := NewWebhookNotifier(storage, WebhookConfig{}).test(context.Background(), hook)
+	if d.Succeeded() || !strings.Contains(d.Error, ErrWebhookAddressNotAllowed.Error()) {
+		t.Fatalf("got delivery %+v, but expected it refused", d)
+	}
+	if len(rcv.events) != 0 {
+		t.Fatalf("got events %+v, but expected none", rcv.events)
+	}
+}
+
+func TestWebhookTestDelivery(t *testing.T) {
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	storage := NewMemoryStorage()
+	api := NewAPI(messaging.NilPublisher{}, storage)
+	api.SetWebhookNotifier(NewWebhookNotifier(storage, WebhookConfig{AllowPrivateNetworks: true}))
+	ctx := WithTenant(context.Background(), "acme")
+	hook, secret, _ := api.AddWebhook(ctx, srv.URL)
+	rcv.secret = secret
+
+	d, err := api.TestWebhook(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("failed to test webhook: %v", err)
+	}
+	if !d.Succeeded() || !d.Test {
+		t.Fatalf("got delivery %+v, but expected a successful test delivery", d)
+	}
+	if len(rcv.events) != 1 || !rcv.events[0].Test || !rcv.verified[0] {
+		t.Fatalf("got events %+v, but expected one signed test event", rcv.events)
+	}
+	if _, err := api.TestWebhook(WithTenant(context.Background(), "globex"), hook.ID); err != ErrItemNotFound {
+		t.Fatalf("got error %v, but expected %v", err, ErrItemNotFound)
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"email_id":1}`)
+	sig := SignWebhook("secret", "1700000000", body)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		want      bool
+	}{
+		{"should accept the signed delivery", "secret", "1700000000", body, true},
+		{"should refuse another secret", "other", "1700000000", body, false},
+		{"should refuse another timestamp", "secret", "1700000001", body, false},
+		{"should refuse another body", "secret", "1700000000", []byte(`{"email_id":2}`), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := VerifyWebhook(test.secret, test.timestamp, test.body, sig); got != test.want {
+				t.Fatalf("got %v, but expected %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/husainaloos/notfy/logger"
+)
+
+type postWebhookModel struct {
+	URL string `json:"url"`
+}
+
+type getWebhookModel struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	Secret    string    `json:"secret,omitempty"`
+}
+
+// WebhookAPIInterface is the API the tenants manage their webhooks with
+type WebhookAPIInterface interface {
+	AddWebhook(ctx context.Context, url string) (Webhook, string, error)
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	RemoveWebhook(ctx context.Context, id int) error
+	ListWebhookDeliveries(ctx context.Context, id int) ([]WebhookDelivery, error)
+	TestWebhook(ctx context.Context, id int) (WebhookDelivery, error)
+}
+
+// WebhookHTTPHandler is the handler of the webhook requests. It should be
+// mounted behind NewAPIKeyAuth, as the webhooks belong to the tenant of the key.
+type WebhookHTTPHandler struct {
+	api WebhookAPIInterface
+}
+
+// NewWebhookHTTPHandler creates a new handler for webhook requests
+func NewWebhookHTTPHandler(api WebhookAPIInterface) *WebhookHTTPHandler {
+	return &WebhookHTTPHandler{api}
+}
+
+// Route builds the routing for the webhook handlers
+func (h *WebhookHTTPHandler) Route(r chi.Router) {
+	r.Post("/", h.addWebhookHandler)
+	r.Get("/", h.listWebhooksHandler)
+	r.Delete("/{id}", h.removeWebhookHandler)
+	r.Get("/{id}/deliveries", h.listDeliveriesHandler)
+	r.Post("/{id}/test", h.testWebhookHandler)
+}
+
+func (h *WebhookHTTPHandler) addWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeErr(w, r, errCannotReadBody, http.StatusInternalServerError)
+		log.Errorf("failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+	var model postWebhookModel
+	if err := json.Unmarshal(body, &model); err != nil {
+		writeErr(w, r, errMalformedJSON, http.StatusBadRequest)
+		log.Debugf("failed to unmarshal json: %v", err)
+		return
+	}
+	if _, err := parseWebhookURL(model.URL); err != nil {
+		writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
+		return
+	}
+	hook, secret, err := h.api.AddWebhook(r.Context(), model.URL)
+	if err == ErrWebhookAddressNotAllowed {
+		writeErr(w, r, errBadRequest(err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeErr(w, r, errWebhookFailed, http.StatusInternalServerError)
+		log.Errorf("failed to add webhook: %v", err)
+		return
+	}
+	log.WithField("webhook_id", hook.ID).Info("webhook added")
+	m := buildGetWebhookDto(hook)
+	m.Secret = secret
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(m)
+}
+
+func (h *WebhookHTTPHandler) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	hooks, err := h.api.ListWebhooks(r.Context())
+	if err != nil {
+		writeErr(w, r, errWebhookFailed, http.StatusInternalServerError)
+		log.Errorf("failed to list webhooks: %v", err)
+		return
+	}
+	models := make([]getWebhookModel, 0)
+	for _, hook := range hooks {
+		models = append(models, buildGetWebhookDto(hook))
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models)
+}
+
+func (h *WebhookHTTPHandler) removeWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := h.api.RemoveWebhook(r.Context(), id); err != nil {
+		if err == ErrItemNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeErr(w, r, errWebhookFailed, http.StatusInternalServerError)
+		log.Errorf("failed to remove webhook: %v", err)
+		return
+	}
+	log.WithField("webhook_id", id).Info("webhook removed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *WebhookHTTPHandler) listDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	deliveries, err := h.api.ListWebhookDeliveries(r.Context(), id)
+	if err != nil {
+		if err == ErrItemNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeErr(w, r, errWebhookFailed, http.StatusInternalServerError)
+		log.Errorf("failed to list webhook deliveries: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// testWebhookHandler posts a test event to the webhook and answers with the
+// attempt, whether or not the receiver accepted it
+func (h *WebhookHTTPHandler) testWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogEntry(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	d, err := h.api.TestWebhook(r.Context(), id)
+	if err != nil {
+		if err == ErrItemNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeErr(w, r, errWebhookFailed, http.StatusInternalServerError)
+		log.Errorf("failed to test webhook: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(d)
+}
+
+func buildGetWebhookDto(hook Webhook) getWebhookModel {
+	return getWebhookModel{
+		ID:        hook.ID,
+		URL:       hook.URL,
+		CreatedAt: hook.CreatedAt,
+	}
+}
@@ -0,0 +1,191 @@
+// Package health reports whether an instance of notfy can serve, by checking
+// the dependencies it was given
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	defaultTimeout = 2 * time.Second
+	defaultTTL     = 5 * time.Second
+)
+
+// ErrShuttingDown is the readiness error of an instance shutting down
+var ErrShuttingDown = errors.New("shutting down")
+
+// Checker checks that a dependency is reachable
+type Checker interface {
+	Check(context.Context) error
+}
+
+// CheckerFunc is a function that checks a dependency
+type CheckerFunc func(context.Context) error
+
+// Check calls the function
+func (f CheckerFunc) Check(ctx context.Context) error { return f(ctx) }
+
+// Config is the configuration of Health
+type Config struct {
+	// Timeout is how long a check may take before it fails
+	Timeout time.Duration
+
+	// TTL is how long the result of a check is reused, so that probes do not
+	// load the dependencies
+	TTL time.Duration
+}
+
+// Result is the result of checking a dependency
+type Result struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Report is the result of checking every dependency
+type Report struct {
+	Status       string            `json:"status"`
+	Dependencies map[string]Result `json:"dependencies,omitempty"`
+}
+
+type check struct {
+	name    string
+	checker Checker
+}
+
+// Health checks the dependencies of the instance. Checks run in parallel, and
+// their results are cached for the TTL.
+type Health struct {
+	timeout, ttl time.Duration
+	checks       []check
+	shutdown     int32
+
+	mu    sync.Mutex
+	cache map[string]Result
+	now   func() time.Time
+}
+
+// New creates a new instance of Health
+func New(cfg Config) *Health {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultTTL
+	}
+	return &Health{
+		timeout: cfg.Timeout,
+		ttl:     cfg.TTL,
+		cache:   make(map[string]Result),
+		now:     time.Now,
+	}
+}
+
+// Add checks the dependency under the name. Dependencies must be added before
+// the instance serves.
+func (h *Health) Add(name string, c Checker) {
+	h.checks = append(h.checks, check{name, c})
+}
+
+// Shutdown makes the instance not ready, so that no new traffic is sent to it
+// while it drains
+func (h *Health) Shutdown() {
+	atomic.StoreInt32(&h.shutdown, 1)
+}
+
+// Check checks every dependency, reusing the results younger than the TTL
+func (h *Health) Check(ctx context.Context) Report {
+	report := Report{Status: "ok", Dependencies: make(map[string]Result)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c check) {
+			defer wg.Done()
+			res := h.result(ctx, c)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Dependencies[c.name] = res
+			if res.Error != "" {
+				report.Status = "failing"
+			}
+		}(c)
+	}
+	wg.Wait()
+	return report
+}
+
+func (h *Health) result(ctx context.Context, c check) Result {
+	h.mu.Lock()
+	res, ok := h.cache[c.name]
+	h.mu.Unlock()
+	if ok && h.now().Sub(res.CheckedAt) < h.ttl {
+		return res
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	errC := make(chan error, 1)
+	go func() { errC <- c.checker.Check(ctx) }()
+	var err error
+	select {
+	case err = <-errC:
+	case <-ctx.Done():
+		// the check may not honor the context, so it is left behind
+		err = ctx.Err()
+	}
+	res = Result{Status: "ok", CheckedAt: h.now()}
+	if err != nil {
+		res.Status = "failing"
+		res.Error = err.Error()
+	}
+	h.mu.Lock()
+	h.cache[c.name] = res
+	h.mu.Unlock()
+	return res
+}
+
+// Route builds the routing for the health endpoints
+func (h *Health) Route(r chi.Router) {
+	r.Get("/livez", h.livezHandler)
+	r.Get("/healthz", h.healthzHandler)
+	r.Get("/readyz", h.readyzHandler)
+}
+
+// livezHandler reports that the process is up. It does not check the
+// dependencies, so that an outage of one does not restart every instance.
+func (h *Health) livezHandler(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, Report{Status: "ok"})
+}
+
+// healthzHandler reports the result of checking every dependency
+func (h *Health) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, h.Check(r.Context()))
+}
+
+// readyzHandler reports whether the instance can take traffic, which it
+// cannot once it is shutting down
+func (h *Health) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&h.shutdown) == 1 {
+		writeReport(w, Report{Status: ErrShuttingDown.Error()})
+		return
+	}
+	writeReport(w, h.Check(r.Context()))
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status == "ok" {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		db         error
+		shutdown   bool
+		wantStatus int
+		wantDeps   int
+	}{
+		{"should be live without checks", "/livez", errors.New("down"), false, http.StatusOK, 0},
+		{"should be healthy when every check passes", "/healthz", nil, false, http.StatusOK, 2},
+		{"should not be healthy when a check fails", "/healthz", errors.New("down"), false, http.StatusServiceUnavailable, 2},
+		{"should be ready when every check passes", "/readyz", nil, false, http.StatusOK, 2},
+		{"should not be ready when a check fails", "/readyz", errors.New("down"), false, http.StatusServiceUnavailable, 2},
+		{"should not be ready while shutting down", "/readyz", nil, true, http.StatusServiceUnavailable, 0},
+		{"should stay healthy while shutting down", "/healthz", nil, true, http.StatusOK, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := New(Config{})
+			h.Add("postgres", CheckerFunc(func(context.Context) error { return test.db }))
+			h.Add("rabbitmq", CheckerFunc(func(context.Context) error { return nil }))
+			if test.shutdown {
+				h.Shutdown()
+			}
+			r := chi.NewRouter()
+			h.Route(r)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+			if rec.Code != test.wantStatus {
+				t.Fatalf("got status %d, but expected %d", rec.Code, test.wantStatus)
+			}
+			var report Report
+			if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+				t.Fatalf("failed to decode report: %v", err)
+			}
+			if len(report.Dependencies) != test.wantDeps {
+				t.Fatalf("got %d dependencies, but expected %d", len(report.Dependencies), test.wantDeps)
+			}
+			if test.db != nil && test.wantDeps > 0 && report.Dependencies["postgres"].Error != test.db.Error() {
+				t.Fatalf("got postgres result %+v, but expected error %q", report.Dependencies["postgres"], test.db)
+			}
+		})
+	}
+}
+
+func TestHealthCachesResults(t *testing.T) {
+	var calls int32
+	h := New(Config{TTL: time.Minute})
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	h.Add("redis", CheckerFunc(func(context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}))
+	h.Check(context.Background())
+	h.Check(context.Background())
+	if calls != 1 {
+		t.Fatalf("got %d calls within the ttl, but expected 1", calls)
+	}
+	now = now.Add(time.Minute)
+	h.Check(context.Background())
+	if calls != 2 {
+		t.Fatalf("got %d calls after the ttl, but expected 2", calls)
+	}
+}
+
+func TestHealthTimesOutChecks(t *testing.T) {
+	h := New(Config{Timeout: 10 * time.Millisecond})
+	block := make(chan struct{})
+	defer close(block)
+	h.Add("smtp", CheckerFunc(func(context.Context) error {
+		<-block
+		return nil
+	}))
+	report := h.Check(context.Background())
+	if got := report.Dependencies["smtp"].Error; got != context.DeadlineExceeded.Error() {
+		t.Fatalf("got error %q, but expected %q", got, context.DeadlineExceeded)
+	}
+}
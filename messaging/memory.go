@@ -1,5 +1,7 @@
 package messaging
 
+import "sync"
+
 // InMemoryBroker is a broker that runs in memory
 type InMemoryBroker struct{ C chan []byte }
 
@@ -24,3 +26,37 @@ func (b *InMemoryBroker) Close() error {
 func (b *InMemoryBroker) Consume() ([]byte, error) {
 	return <-b.C, nil
 }
+
+// InMemoryPubSub is a broker that runs in memory and delivers every message
+// to every subscriber
+type InMemoryPubSub struct {
+	mu   sync.Mutex
+	subs []SubscribeFunc
+}
+
+// NewInMemoryPubSub creates new instance of InMemoryPubSub
+func NewInMemoryPubSub() *InMemoryPubSub {
+	return &InMemoryPubSub{}
+}
+
+// Publish to every subscriber
+func (b *InMemoryPubSub) Publish(bb []byte) error {
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+	for _, s := range subs {
+		s(bb)
+	}
+	return nil
+}
+
+// Subscribe to every message published from now on
+func (b *InMemoryPubSub) Subscribe(s SubscribeFunc) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs[:len(b.subs):len(b.subs)], s)
+	return nil
+}
+
+// Close does nothing
+func (b *InMemoryPubSub) Close() error { return nil }
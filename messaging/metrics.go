@@ -0,0 +1,78 @@
+package messaging
+
+import "github.com/husainaloos/notfy/metrics"
+
+// instrumentedPublisher counts the failures of the publisher it wraps
+type instrumentedPublisher struct {
+	Publisher
+	broker  string
+	metrics metrics.Metrics
+}
+
+func (p instrumentedPublisher) Publish(b []byte) error {
+	err := p.Publisher.Publish(b)
+	if err != nil {
+		p.metrics.CountBrokerError(p.broker, "publish")
+	}
+	return err
+}
+
+// instrumentedBatchPublisher also counts the failures to publish batches
+type instrumentedBatchPublisher struct {
+	instrumentedPublisher
+	batch BatchPublisher
+}
+
+func (p instrumentedBatchPublisher) PublishBatch(bs [][]byte) error {
+	err := p.batch.PublishBatch(bs)
+	if err != nil {
+		p.metrics.CountBrokerError(p.broker, "publish")
+	}
+	return err
+}
+
+// Instrument counts the failures of p to publish to the broker. The returned
+// publisher is a BatchPublisher if p is one.
+func Instrument(p Publisher, broker string, m metrics.Metrics) Publisher {
+	ip := instrumentedPublisher{p, broker, m}
+	if bp, ok := p.(BatchPublisher); ok {
+		return instrumentedBatchPublisher{ip, bp}
+	}
+	return ip
+}
+
+// instrumentedSubscriber counts the failures of the subscriber it wraps
+type instrumentedSubscriber struct {
+	Subscriber
+	broker  string
+	metrics metrics.Metrics
+}
+
+func (s instrumentedSubscriber) Subscribe(f SubscribeFunc) error {
+	err := s.Subscriber.Subscribe(f)
+	if err != nil {
+		s.metrics.CountBrokerError(s.broker, "consume")
+	}
+	return err
+}
+
+// Broker gets the name of the broker the subscriber consumes from
+func (s instrumentedSubscriber) Broker() string {
+	return s.broker
+}
+
+// InstrumentSubscriber counts the failures of s to consume from the broker.
+// The messages that cannot be decoded are counted by the subscribers, with
+// the name of BrokerOf.
+func InstrumentSubscriber(s Subscriber, broker string, m metrics.Metrics) Subscriber {
+	return instrumentedSubscriber{s, broker, m}
+}
+
+// BrokerOf gets the name of the broker of an instrumented subscriber, or of a
+// subscriber wrapping one with a Broker method, and "unknown" otherwise
+func BrokerOf(s Subscriber) string {
+	if b, ok := s.(interface{ Broker() string }); ok {
+		return b.Broker()
+	}
+	return "unknown"
+}
@@ -23,3 +23,25 @@ func (p ErrPublisher) Publish([]byte) error { return p.Err }
 
 // Close returns nil
 func (p ErrPublisher) Close() error { return nil }
+
+// BatchPublisher is a publisher that can publish many messages at once
+type BatchPublisher interface {
+	Publisher
+
+	// PublishBatch publishes every message, or returns an error
+	PublishBatch([][]byte) error
+}
+
+// PublishAll publishes the messages in one batch if the publisher supports it,
+// or one by one otherwise
+func PublishAll(p Publisher, msgs [][]byte) error {
+	if bp, ok := p.(BatchPublisher); ok {
+		return bp.PublishBatch(msgs)
+	}
+	for _, b := range msgs {
+		if err := p.Publish(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package messaging
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"time"
@@ -8,17 +10,75 @@ import (
 	"github.com/streadway/amqp"
 )
 
+var (
+	// ErrPublishNacked is returned when the broker negatively acknowledges a message
+	ErrPublishNacked = errors.New("message was nacked by the broker")
+	// ErrPublishReturned is returned when the broker cannot route a mandatory message
+	ErrPublishReturned = errors.New("message was returned by the broker")
+	// ErrConfirmTimeout is returned when the broker does not confirm a message in time
+	ErrConfirmTimeout = errors.New("timed out waiting for publish confirmation")
+	// ErrConnectionClosed is returned by Check once the connection to rabbit mq is lost
+	ErrConnectionClosed = errors.New("connection is closed")
+)
+
+const (
+	defaultChannelPoolSize = 10
+	defaultConfirmTimeout  = 5 * time.Second
+)
+
+// RabbitMqConfig is the configuration of RabbitMqConnection
+type RabbitMqConfig struct {
+	ConnStr string
+	Queue   string
+
+	// ChannelPoolSize is the number of idle publishing channels kept open
+	ChannelPoolSize int
+
+	// ConfirmTimeout is how long Publish waits for the broker to confirm a message
+	ConfirmTimeout time.Duration
+}
+
 // RabbitMqConnection is an implementation of Publisher for rabbit mq.
 type RabbitMqConnection struct {
-	conn    *amqp.Connection
-	ch      *amqp.Channel
-	connStr string
-	queue   string
+	conn           *amqp.Connection
+	ch             *amqp.Channel
+	connStr        string
+	queue          string
+	confirmTimeout time.Duration
+	channels       chan *confirmChannel
+}
+
+// publishChannel is the part of amqp.Channel used to publish
+type publishChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// confirmChannel is a channel in confirm mode along with its notification channels
+type confirmChannel struct {
+	ch       publishChannel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	closed   chan *amqp.Error
 }
 
 // NewRabbitMqConnection creates a new instance of RabbitMqConnection
 func NewRabbitMqConnection(connStr, queue string) (*RabbitMqConnection, error) {
-	conn, err := amqp.DialConfig(connStr, amqp.Config{
+	return NewRabbitMqConnectionWithConfig(RabbitMqConfig{
+		ConnStr: connStr,
+		Queue:   queue,
+	})
+}
+
+// NewRabbitMqConnectionWithConfig creates a new instance of RabbitMqConnection from the config
+func NewRabbitMqConnectionWithConfig(cfg RabbitMqConfig) (*RabbitMqConnection, error) {
+	if cfg.ChannelPoolSize <= 0 {
+		cfg.ChannelPoolSize = defaultChannelPoolSize
+	}
+	if cfg.ConfirmTimeout <= 0 {
+		cfg.ConfirmTimeout = defaultConfirmTimeout
+	}
+	conn, err := amqp.DialConfig(cfg.ConnStr, amqp.Config{
 		Dial: func(network, addr string) (net.Conn, error) {
 			return net.DialTimeout(network, addr, 5*time.Second)
 		},
@@ -31,10 +91,12 @@ func NewRabbitMqConnection(connStr, queue string) (*RabbitMqConnection, error) {
 		return nil, fmt.Errorf("cannot open channel: %v", err)
 	}
 	rmc := &RabbitMqConnection{
-		conn:    conn,
-		ch:      ch,
-		connStr: connStr,
-		queue:   queue,
+		conn:           conn,
+		ch:             ch,
+		connStr:        cfg.ConnStr,
+		queue:          cfg.Queue,
+		confirmTimeout: cfg.ConfirmTimeout,
+		channels:       make(chan *confirmChannel, cfg.ChannelPoolSize),
 	}
 
 	if err := rmc.queueDeclare(); err != nil {
@@ -43,15 +105,163 @@ func NewRabbitMqConnection(connStr, queue string) (*RabbitMqConnection, error) {
 	return rmc, nil
 }
 
-// Publish to rabbit mq queue
+// Publish to rabbit mq queue. The message is persistent and mandatory, and
+// Publish only returns nil once the broker has confirmed it.
 func (c *RabbitMqConnection) Publish(b []byte) error {
+	cc, err := c.getChannel()
+	if err != nil {
+		return err
+	}
+	msg := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		Body:         b,
+	}
+	if err := cc.ch.Publish("", c.queue, true, false, msg); err != nil {
+		cc.ch.Close()
+		return fmt.Errorf("cannot publish message: %v", err)
+	}
+	return c.waitConfirms(cc, 1)
+}
+
+// PublishBatch publishes every message on one channel without waiting for each
+// confirmation, and returns nil only once all of them are confirmed
+func (c *RabbitMqConnection) PublishBatch(bs [][]byte) error {
+	if len(bs) == 0 {
+		return nil
+	}
+	cc, err := c.getChannel()
+	if err != nil {
+		return err
+	}
+	// the confirmations are read while publishing, since the channel blocks
+	// the next publish until its confirmation is read
+	confirmed := make(chan error, 1)
+	go func() { confirmed <- c.waitConfirms(cc, len(bs)) }()
+	for _, b := range bs {
+		msg := amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			Body:         b,
+		}
+		if err := cc.ch.Publish("", c.queue, true, false, msg); err != nil {
+			cc.ch.Close()
+			<-confirmed
+			return fmt.Errorf("cannot publish message: %v", err)
+		}
+	}
+	return <-confirmed
+}
+
+// waitConfirms waits for the broker to confirm the last n messages published
+// on the channel. The channel goes back to the pool once they are confirmed,
+// and is closed if its state is no longer known.
+func (c *RabbitMqConnection) waitConfirms(cc *confirmChannel, n int) error {
+	timeout := time.After(c.confirmTimeout)
+	returns := cc.returns
+	returned := false
+	nacked := false
+	for {
+		select {
+		case _, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			// the broker sends the return before the confirmation of the
+			// same message, so keep waiting for the confirmation
+			returned = true
+		case conf, ok := <-cc.confirms:
+			if !ok {
+				return errors.New("channel closed before the message was confirmed")
+			}
+			nacked = nacked || !conf.Ack
+			if n--; n > 0 {
+				// the timeout applies to each confirmation of a batch
+				timeout = time.After(c.confirmTimeout)
+				continue
+			}
+			// the return of the last message may be ready along with its
+			// confirmation, and must not be left for the next publish
+			returned = drainReturns(returns) || returned
+			c.putChannel(cc)
+			if nacked {
+				return ErrPublishNacked
+			}
+			if returned {
+				return ErrPublishReturned
+			}
+			return nil
+		case <-timeout:
+			cc.ch.Close()
+			return ErrConfirmTimeout
+		}
+	}
+}
+
+// drainReturns reads the returns that are ready without waiting, and reports
+// whether there was any
+func drainReturns(returns chan amqp.Return) bool {
+	drained := false
+	for {
+		select {
+		case _, ok := <-returns:
+			if !ok {
+				return drained
+			}
+			drained = true
+		default:
+			return drained
+		}
+	}
+}
+
+// get a publishing channel from the pool or open a new one
+func (c *RabbitMqConnection) getChannel() (*confirmChannel, error) {
+	for {
+		select {
+		case cc := <-c.channels:
+			if cc.isClosed() {
+				continue
+			}
+			return cc, nil
+		default:
+			return c.openChannel()
+		}
+	}
+}
+
+// put the channel back to the pool, or close it if the pool is full
+func (c *RabbitMqConnection) putChannel(cc *confirmChannel) {
+	select {
+	case c.channels <- cc:
+	default:
+		cc.ch.Close()
+	}
+}
+
+func (c *RabbitMqConnection) openChannel() (*confirmChannel, error) {
 	ch, err := c.conn.Channel()
 	if err != nil {
-		return fmt.Errorf("cannot open channel: %v", err)
+		return nil, fmt.Errorf("cannot open channel: %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("cannot put channel in confirm mode: %v", err)
 	}
+	return &confirmChannel{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, 1)),
+		closed:   ch.NotifyClose(make(chan *amqp.Error, 1)),
+	}, nil
+}
 
-	defer ch.Close()
-	return ch.Publish("", c.queue, false, false, amqp.Publishing{Body: b})
+func (cc *confirmChannel) isClosed() bool {
+	select {
+	case <-cc.closed:
+		return true
+	default:
+		return false
+	}
 }
 
 // Consume messages from queue
@@ -63,8 +273,25 @@ func (c *RabbitMqConnection) Consume() ([]byte, error) {
 	return (<-d).Body, nil
 }
 
+// Check checks that the connection to rabbit mq is open. Lost connections are
+// noticed by the heartbeats of the connection.
+func (c *RabbitMqConnection) Check(ctx context.Context) error {
+	if c.conn.IsClosed() {
+		return ErrConnectionClosed
+	}
+	return nil
+}
+
 // Close the rabbit mq connection
 func (c *RabbitMqConnection) Close() error {
+	for done := false; !done; {
+		select {
+		case cc := <-c.channels:
+			cc.ch.Close()
+		default:
+			done = true
+		}
+	}
 	c.ch.Close()
 	return c.conn.Close()
 }
@@ -0,0 +1,112 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// fanoutChannel is the part of amqp.Channel used to fan out messages
+type fanoutChannel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// RabbitMqFanout is an implementation of Publisher and Subscriber for rabbit
+// mq that delivers every message to every subscriber, e.g. to every replica
+// of the API. Each subscription has its own exclusive queue bound to a fanout
+// exchange, which is deleted once the subscriber is gone, so the messages are
+// only delivered to the subscribers that are up.
+type RabbitMqFanout struct {
+	conn     *amqp.Connection
+	ch       fanoutChannel
+	exchange string
+}
+
+// NewRabbitMqFanout creates a new instance of RabbitMqFanout, and declares its
+// exchange
+func NewRabbitMqFanout(connStr, exchange string) (*RabbitMqFanout, error) {
+	conn, err := amqp.DialConfig(connStr, amqp.Config{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, 5*time.Second)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot open channel: %v", err)
+	}
+	f, err := newRabbitMqFanout(ch, exchange)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	f.conn = conn
+	return f, nil
+}
+
+func newRabbitMqFanout(ch fanoutChannel, exchange string) (*RabbitMqFanout, error) {
+	if err := ch.ExchangeDeclare(exchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return nil, fmt.Errorf("cannot declare exchange %s: %v", exchange, err)
+	}
+	return &RabbitMqFanout{ch: ch, exchange: exchange}, nil
+}
+
+// Publish to every queue bound to the exchange. The message is not persistent,
+// since it is only of use to the subscribers that are up.
+func (f *RabbitMqFanout) Publish(b []byte) error {
+	if err := f.ch.Publish(f.exchange, "", false, false, amqp.Publishing{Body: b}); err != nil {
+		return fmt.Errorf("cannot publish message: %v", err)
+	}
+	return nil
+}
+
+// Subscribe to every message published from now on. The queue of the
+// subscription is exclusive to the connection and deleted with it.
+func (f *RabbitMqFanout) Subscribe(s SubscribeFunc) error {
+	q, err := f.ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return fmt.Errorf("cannot declare queue: %v", err)
+	}
+	if err := f.ch.QueueBind(q.Name, "", f.exchange, false, nil); err != nil {
+		return fmt.Errorf("cannot bind queue %s to exchange %s: %v", q.Name, f.exchange, err)
+	}
+	d, err := f.ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("cannot consume queue %s: %v", q.Name, err)
+	}
+	go func(d <-chan amqp.Delivery) {
+		for msg := range d {
+			s(msg.Body)
+		}
+	}(d)
+	return nil
+}
+
+// Check checks that the connection to rabbit mq is open
+func (f *RabbitMqFanout) Check(ctx context.Context) error {
+	if f.conn.IsClosed() {
+		return ErrConnectionClosed
+	}
+	return nil
+}
+
+// Close the channel and the connection, which deletes the queues of the
+// subscriptions
+func (f *RabbitMqFanout) Close() error {
+	f.ch.Close()
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
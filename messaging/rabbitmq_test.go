@@ -0,0 +1,232 @@
+package messaging
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// fakeChannel is a channel of a broker that confirms every message as soon as
+// it is published. Like amqp.Channel, it holds its lock while it hands out a
+// confirmation, so a confirmation that is not read blocks the next publish.
+type fakeChannel struct {
+	mu         sync.Mutex
+	once       sync.Once
+	published  uint64
+	unroutable map[string]bool
+	confirms   chan amqp.Confirmation
+	returns    chan amqp.Return
+	closed     chan *amqp.Error
+}
+
+func newFakeChannel(unroutable ...string) *fakeChannel {
+	f := &fakeChannel{
+		unroutable: make(map[string]bool),
+		confirms:   make(chan amqp.Confirmation, 1),
+		returns:    make(chan amqp.Return, 1),
+		closed:     make(chan *amqp.Error, 1),
+	}
+	for _, b := range unroutable {
+		f.unroutable[b] = true
+	}
+	return f
+}
+
+func (f *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published++
+	if f.unroutable[string(msg.Body)] {
+		f.returns <- amqp.Return{Body: msg.Body}
+	}
+	f.confirms <- amqp.Confirmation{DeliveryTag: f.published, Ack: true}
+	return nil
+}
+
+func (f *fakeChannel) Close() error {
+	f.once.Do(func() {
+		close(f.confirms)
+		close(f.returns)
+		close(f.closed)
+	})
+	return nil
+}
+
+// newFakeRabbitMqConnection creates a connection whose pool holds the channel
+func newFakeRabbitMqConnection(f *fakeChannel) *RabbitMqConnection {
+	c := &RabbitMqConnection{
+		queue:          "emails",
+		confirmTimeout: time.Second,
+		channels:       make(chan *confirmChannel, 1),
+	}
+	c.channels <- &confirmChannel{ch: f, confirms: f.confirms, returns: f.returns, closed: f.closed}
+	return c
+}
+
+func TestRabbitMqConnectionPublishReturned(t *testing.T) {
+	// the return and the confirmation are ready at once, so the result must
+	// not depend on which of them is read first
+	for i := 0; i < 50; i++ {
+		c := newFakeRabbitMqConnection(newFakeChannel("unroutable"))
+		if err := c.Publish([]byte("unroutable")); err != ErrPublishReturned {
+			t.Fatalf("got error %v, but expected %v", err, ErrPublishReturned)
+		}
+		if err := c.Publish([]byte("routable")); err != nil {
+			t.Fatalf("got error %v publishing after a return, but expected no error", err)
+		}
+	}
+}
+
+func TestRabbitMqConnectionPublishBatch(t *testing.T) {
+	tests := []struct {
+		desc   string
+		msgs   []string
+		expect error
+	}{
+		{"should publish every message of the batch", []string{"a", "b", "c", "d", "e"}, nil},
+		{"should fail if a message of the batch is returned", []string{"a", "unroutable", "c"}, ErrPublishReturned},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			f := newFakeChannel("unroutable")
+			c := newFakeRabbitMqConnection(f)
+			var bs [][]byte
+			for _, m := range test.msgs {
+				bs = append(bs, []byte(m))
+			}
+			done := make(chan error, 1)
+			go func() { done <- c.PublishBatch(bs) }()
+			select {
+			case err := <-done:
+				if err != test.expect {
+					t.Fatalf("%s: got error %v, but expected %v", test.desc, err, test.expect)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: batch is blocked on its confirmations", test.desc)
+			}
+			if f.published != uint64(len(bs)) {
+				t.Fatalf("%s: published %d messages, but expected %d", test.desc, f.published, len(bs))
+			}
+		})
+	}
+}
+
+// fakeExchange is a fanout exchange of a broker shared by the channels of
+// several connections
+type fakeExchange struct {
+	mu     sync.Mutex
+	queues map[string]chan amqp.Delivery
+	n      int
+}
+
+// fakeFanoutChannel is a channel to the exchange. It records the queues it
+// declares, to check that they go away with the subscriber.
+type fakeFanoutChannel struct {
+	ex       *fakeExchange
+	declared []amqp.Queue
+	flags    []bool
+}
+
+func (f *fakeFanoutChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	if kind != amqp.ExchangeFanout {
+		return fmt.Errorf("unexpected exchange kind %s", kind)
+	}
+	return nil
+}
+
+func (f *fakeFanoutChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	f.ex.mu.Lock()
+	defer f.ex.mu.Unlock()
+	f.ex.n++
+	q := amqp.Queue{Name: fmt.Sprintf("amq.gen-%d", f.ex.n)}
+	f.declared = append(f.declared, q)
+	f.flags = append(f.flags, !durable && autoDelete && exclusive)
+	return q, nil
+}
+
+func (f *fakeFanoutChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	f.ex.mu.Lock()
+	defer f.ex.mu.Unlock()
+	f.ex.queues[name] = make(chan amqp.Delivery, 10)
+	return nil
+}
+
+func (f *fakeFanoutChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	f.ex.mu.Lock()
+	defer f.ex.mu.Unlock()
+	return f.ex.queues[queue], nil
+}
+
+func (f *fakeFanoutChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	f.ex.mu.Lock()
+	defer f.ex.mu.Unlock()
+	for _, q := range f.ex.queues {
+		q <- amqp.Delivery{Body: msg.Body}
+	}
+	return nil
+}
+
+// Close deletes the queues of the channel, like rabbit mq does with the
+// exclusive queues of a connection
+func (f *fakeFanoutChannel) Close() error {
+	f.ex.mu.Lock()
+	defer f.ex.mu.Unlock()
+	for _, q := range f.declared {
+		close(f.ex.queues[q.Name])
+		delete(f.ex.queues, q.Name)
+	}
+	return nil
+}
+
+func TestRabbitMqFanout(t *testing.T) {
+	ex := &fakeExchange{queues: make(map[string]chan amqp.Delivery)}
+	replicas := make([]*RabbitMqFanout, 2)
+	received := make([]chan []byte, len(replicas))
+	for i := range replicas {
+		f, err := newRabbitMqFanout(&fakeFanoutChannel{ex: ex}, "status")
+		if err != nil {
+			t.Fatalf("failed to create fanout: %v", err)
+		}
+		c := make(chan []byte, 10)
+		if err := f.Subscribe(func(b []byte) { c <- b }); err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		replicas[i], received[i] = f, c
+	}
+	for _, f := range replicas {
+		ch := f.ch.(*fakeFanoutChannel)
+		if len(ch.declared) != 1 || !ch.flags[0] {
+			t.Fatalf("got queues %v, but expected one exclusive auto-delete queue per replica", ch.declared)
+		}
+	}
+
+	// every replica gets the message published by one of them
+	if err := replicas[0].Publish([]byte("update")); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+	for i, c := range received {
+		select {
+		case b := <-c:
+			if string(b) != "update" {
+				t.Fatalf("replica %d: got %q, but expected the update", i, b)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("replica %d: timed out waiting for the update", i)
+		}
+	}
+
+	// the queue of a replica that is gone is deleted with it
+	replicas[1].Close()
+	if err := replicas[0].Publish([]byte("later")); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+	if n := len(ex.queues); n != 1 {
+		t.Fatalf("got %d queues, but expected only the queue of the replica left", n)
+	}
+	if b := <-received[0]; string(b) != "later" {
+		t.Fatalf("got %q, but expected the later update", b)
+	}
+}
@@ -1,6 +1,10 @@
 package messaging
 
-import "github.com/go-redis/redis"
+import (
+	"context"
+
+	"github.com/go-redis/redis"
+)
 
 // Redis is a connection to redis
 type Redis struct {
@@ -38,6 +42,11 @@ func (r *Redis) Subscribe(s SubscribeFunc) error {
 	return nil
 }
 
+// Check checks that redis answers
+func (r *Redis) Check(ctx context.Context) error {
+	return r.client.WithContext(ctx).Ping().Err()
+}
+
 // Close the connection
 func (r *Redis) Close() error {
 	return r.client.Close()
@@ -0,0 +1,65 @@
+// Package metrics is what the components of notfy are instrumented with. The
+// components default to Nop, and are given a Prometheus to be scraped:
+//
+//	p, _ := metrics.NewPrometheus()
+//	r.Use(metrics.NewMiddleware(p))
+//	r.Handle("/metrics", p.Handler())
+package metrics
+
+import "time"
+
+// Metrics records the metrics of notfy. Every method must be safe to call
+// from many goroutines.
+type Metrics interface {
+	// ObserveRequest records an HTTP request by the pattern of its route
+	ObserveRequest(method, route string, status int, elapsed time.Duration)
+
+	// ObserveRPC records a gRPC call by its full method and status code
+	ObserveRPC(method, code string, elapsed time.Duration)
+
+	// CountEmail counts an email reaching the status, e.g. "Queued" or "Dead"
+	CountEmail(status string)
+
+	// ObserveSend records one attempt at sending an email over SMTP
+	ObserveSend(elapsed time.Duration, err error)
+
+	// ObserveAttempts records the number of attempts an email took to be sent
+	// or to be given up on
+	ObserveAttempts(n int)
+
+	// SetPool records the SMTP clients in use and the senders waiting for one
+	SetPool(inUse, waiters int)
+
+	// CountBrokerError counts a failure to "publish" to or "consume" from a broker
+	CountBrokerError(broker, op string)
+
+	// ObserveQuery records a storage query by its operation, e.g. "insert"
+	ObserveQuery(op string, elapsed time.Duration, err error)
+}
+
+// Nop is a Metrics that records nothing
+type Nop struct{}
+
+// ObserveRequest does nothing
+func (Nop) ObserveRequest(method, route string, status int, elapsed time.Duration) {}
+
+// ObserveRPC does nothing
+func (Nop) ObserveRPC(method, code string, elapsed time.Duration) {}
+
+// CountEmail does nothing
+func (Nop) CountEmail(status string) {}
+
+// ObserveSend does nothing
+func (Nop) ObserveSend(elapsed time.Duration, err error) {}
+
+// ObserveAttempts does nothing
+func (Nop) ObserveAttempts(n int) {}
+
+// SetPool does nothing
+func (Nop) SetPool(inUse, waiters int) {}
+
+// CountBrokerError does nothing
+func (Nop) CountBrokerError(broker, op string) {}
+
+// ObserveQuery does nothing
+func (Nop) ObserveQuery(op string, elapsed time.Duration, err error) {}
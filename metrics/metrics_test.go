@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+type request struct {
+	method, route string
+	status        int
+}
+
+type recordingMetrics struct {
+	Nop
+	requests []request
+}
+
+func (m *recordingMetrics) ObserveRequest(method, route string, status int, elapsed time.Duration) {
+	m.requests = append(m.requests, request{method, route, status})
+}
+
+func TestMiddlewareRecordsRoutePattern(t *testing.T) {
+	m := &recordingMetrics{}
+	r := chi.NewRouter()
+	r.Use(NewMiddleware(m))
+	r.Get("/emails/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	tests := []struct {
+		path string
+		want request
+	}{
+		{"/emails/12", request{"GET", "/emails/{id}", http.StatusNotFound}},
+		{"/ok", request{"GET", "/ok", http.StatusOK}},
+		{"/missing", request{"GET", "unmatched", http.StatusNotFound}},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			m.requests = nil
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", test.path, nil))
+			if len(m.requests) != 1 || m.requests[0] != test.want {
+				t.Fatalf("got requests %v, but expected %v", m.requests, test.want)
+			}
+		})
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	p, err := NewPrometheus()
+	if err != nil {
+		t.Fatalf("failed to create prometheus metrics: %v", err)
+	}
+	p.ObserveRequest("GET", "/{id}", 200, time.Millisecond)
+	p.CountEmail("Queued")
+	p.ObserveSend(time.Second, nil)
+	p.ObserveAttempts(2)
+	p.SetPool(3, 1)
+	p.CountBrokerError("rabbitmq", "publish")
+	p.ObserveQuery("insert", time.Millisecond, nil)
+
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	b, _ := ioutil.ReadAll(rec.Body)
+	for _, want := range []string{
+		`notfy_http_requests_total{method="GET",route="/{id}",status="200"} 1`,
+		`notfy_emails_total{status="Queued"} 1`,
+		`notfy_smtp_send_duration_seconds_count{result="ok"} 1`,
+		`notfy_smtp_send_attempts_count 1`,
+		`notfy_smtp_pool_in_use 3`,
+		`notfy_smtp_pool_waiters 1`,
+		`notfy_broker_errors_total{broker="rabbitmq",op="publish"} 1`,
+		`notfy_storage_query_duration_seconds_count{op="insert"} 1`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
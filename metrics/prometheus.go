@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "notfy"
+
+// Prometheus is a Metrics that keeps the metrics in a prometheus registry
+type Prometheus struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	rpcs            *prometheus.CounterVec
+	rpcDuration     *prometheus.HistogramVec
+	emails          *prometheus.CounterVec
+	sendDuration    *prometheus.HistogramVec
+	attempts        prometheus.Histogram
+	poolInUse       prometheus.Gauge
+	poolWaiters     prometheus.Gauge
+	brokerErrors    *prometheus.CounterVec
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+}
+
+// NewPrometheus creates a new instance of Prometheus with its own registry,
+// along with the go runtime and process collectors
+func NewPrometheus() (*Prometheus, error) {
+	p := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of the HTTP requests by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		rpcs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_requests_total",
+			Help:      "gRPC calls by method and code.",
+		}, []string{"method", "code"}),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Latency of the gRPC calls by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		emails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "emails_total",
+			Help:      "Emails reaching each status.",
+		}, []string{"status"}),
+		sendDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "smtp_send_duration_seconds",
+			Help:      "Latency of the SMTP send attempts by result.",
+			Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"result"}),
+		attempts: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "smtp_send_attempts",
+			Help:      "Attempts each email took to be sent or given up on.",
+			Buckets:   []float64{1, 2, 3, 4, 5},
+		}),
+		poolInUse: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "smtp_pool_in_use",
+			Help:      "SMTP clients in use.",
+		}),
+		poolWaiters: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "smtp_pool_waiters",
+			Help:      "Senders waiting for an SMTP client.",
+		}),
+		brokerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "broker_errors_total",
+			Help:      "Failures to publish to or consume from the brokers.",
+		}, []string{"broker", "op"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_query_duration_seconds",
+			Help:      "Latency of the storage queries by operation.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"op"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "storage_errors_total",
+			Help:      "Failed storage queries by operation.",
+		}, []string{"op"}),
+	}
+	collectors := []prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		p.requests, p.requestDuration, p.rpcs, p.rpcDuration, p.emails, p.sendDuration, p.attempts,
+		p.poolInUse, p.poolWaiters, p.brokerErrors, p.queryDuration, p.queryErrors,
+	}
+	for _, c := range collectors {
+		if err := p.registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Handler serves the metrics in the prometheus exposition format
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records an HTTP request by the pattern of its route
+func (p *Prometheus) ObserveRequest(method, route string, status int, elapsed time.Duration) {
+	p.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	p.requestDuration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+// ObserveRPC records a gRPC call by its full method and status code
+func (p *Prometheus) ObserveRPC(method, code string, elapsed time.Duration) {
+	p.rpcs.WithLabelValues(method, code).Inc()
+	p.rpcDuration.WithLabelValues(method).Observe(elapsed.Seconds())
+}
+
+// CountEmail counts an email reaching the status
+func (p *Prometheus) CountEmail(status string) {
+	p.emails.WithLabelValues(status).Inc()
+}
+
+// ObserveSend records one attempt at sending an email over SMTP
+func (p *Prometheus) ObserveSend(elapsed time.Duration, err error) {
+	p.sendDuration.WithLabelValues(result(err)).Observe(elapsed.Seconds())
+}
+
+// ObserveAttempts records the number of attempts an email took
+func (p *Prometheus) ObserveAttempts(n int) {
+	p.attempts.Observe(float64(n))
+}
+
+// SetPool records the SMTP clients in use and the senders waiting for one
+func (p *Prometheus) SetPool(inUse, waiters int) {
+	p.poolInUse.Set(float64(inUse))
+	p.poolWaiters.Set(float64(waiters))
+}
+
+// CountBrokerError counts a failure to publish to or consume from a broker
+func (p *Prometheus) CountBrokerError(broker, op string) {
+	p.brokerErrors.WithLabelValues(broker, op).Inc()
+}
+
+// ObserveQuery records a storage query by its operation
+func (p *Prometheus) ObserveQuery(op string, elapsed time.Duration, err error) {
+	p.queryDuration.WithLabelValues(op).Observe(elapsed.Seconds())
+	if err != nil {
+		p.queryErrors.WithLabelValues(op).Inc()
+	}
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/husainaloos/notfy/tracing"
+
+// NewMiddleware starts a server span for every request, continuing the trace
+// of the traceparent header if there is one. The span is named after the
+// pattern of the route once the request is served.
+func NewMiddleware(tp trace.TracerProvider) func(next http.Handler) http.Handler {
+	tracer := tp.Tracer(instrumentation)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, "HTTP "+r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.URLPath(r.URL.Path),
+				))
+			defer span.End()
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				span.SetName("HTTP " + r.Method + " " + rctx.RoutePattern())
+				span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+			}
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
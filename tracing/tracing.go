@@ -0,0 +1,75 @@
+// Package tracing sets up the OpenTelemetry tracing of notfy, and carries the
+// trace context of an email from the request that queued it to the deamon
+// that sends it
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Propagator is how the trace context is written to and read from requests
+// and queued emails
+var Propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// Config is the configuration of the OTLP exporter
+type Config struct {
+	// Endpoint is the host and port of the OTLP HTTP collector, e.g. "localhost:4318"
+	Endpoint string
+
+	// Insecure sends the spans over plain HTTP
+	Insecure bool
+
+	// ServiceName names the process in the traces, e.g. "notfy-api" or "notfy-deamon"
+	ServiceName string
+
+	// SampleRatio is the share of the traces started here that are sampled.
+	// Zero samples every trace.
+	SampleRatio float64
+}
+
+// NewProvider creates a tracer provider that exports the spans to the OTLP
+// collector of the config. The provider must be shut down to flush the spans.
+func NewProvider(ctx context.Context, cfg Config) (*sdktrace.TracerProvider, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exp, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp exporter: %v", err)
+	}
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	), nil
+}
+
+// Inject gets the trace context of ctx, or nil if ctx has no span
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	Propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract gets a context that continues the trace context tc
+func Extract(ctx context.Context, tc map[string]string) context.Context {
+	if len(tc) == 0 {
+		return ctx
+	}
+	return Propagator.Extract(ctx, propagation.MapCarrier(tc))
+}